@@ -0,0 +1,71 @@
+package main
+
+import "math"
+
+// AggregateSignatures collapses kernels sharing a signature within each cycle into one
+// row before matching, for a per-signature view of cycles that repeat the same kernel
+// (e.g. once per layer); off = one row per kernel instance
+var AggregateSignatures = false
+
+// aggregateCycle returns a copy of r with its kernels collapsed by aggregateBySignature
+func aggregateCycle(r *CycleResult) *CycleResult {
+	agg := *r
+	agg.Kernels = aggregateBySignature(r.Kernels)
+	agg.CycleLength = len(agg.Kernels)
+	agg.KernelsByName = make(map[string]int, len(agg.Kernels))
+	for i, k := range agg.Kernels {
+		agg.KernelsByName[k.Name] = i
+	}
+	return &agg
+}
+
+// aggregateBySignature merges kernels with the same matchSignature, in order of first
+// appearance and named after the first instance. Counts and per-cycle times (avg, min,
+// max, total) are summed so cycle totals are unchanged; stddevs combine as independent
+// variances, and percentiles and idle gaps are weighted by each instance's time
+func aggregateBySignature(kernels []KernelStats) []KernelStats {
+	var out []KernelStats
+	bySig := make(map[string]int)
+	variance := make(map[int]float64)
+	for _, k := range kernels {
+		sig := matchSignature(k.Name)
+		i, ok := bySig[sig]
+		if !ok {
+			i = len(out)
+			bySig[sig] = i
+			agg := k
+			agg.IndexInCycle = i
+			agg.Durations = nil
+			agg.P50, agg.P90, agg.P99 = k.P50*k.AvgDur, k.P90*k.AvgDur, k.P99*k.AvgDur
+			agg.IdleBefore, agg.IdleAfter = k.IdleBefore*k.AvgDur, k.IdleAfter*k.AvgDur
+			out = append(out, agg)
+			variance[i] = k.StdDev * k.StdDev
+			continue
+		}
+		agg := &out[i]
+		agg.Count += k.Count
+		agg.TotalDur += k.TotalDur
+		agg.AvgDur += k.AvgDur
+		agg.MinDur += k.MinDur
+		agg.MaxDur += k.MaxDur
+		agg.P50 += k.P50 * k.AvgDur
+		agg.P90 += k.P90 * k.AvgDur
+		agg.P99 += k.P99 * k.AvgDur
+		agg.IdleBefore += k.IdleBefore * k.AvgDur
+		agg.IdleAfter += k.IdleAfter * k.AvgDur
+		agg.MaxIdleAfter = math.Max(agg.MaxIdleAfter, k.MaxIdleAfter)
+		variance[i] += k.StdDev * k.StdDev
+	}
+	for i := range out {
+		agg := &out[i]
+		agg.StdDev = math.Sqrt(variance[i])
+		if agg.AvgDur > 0 {
+			agg.P50 /= agg.AvgDur
+			agg.P90 /= agg.AvgDur
+			agg.P99 /= agg.AvgDur
+			agg.IdleBefore /= agg.AvgDur
+			agg.IdleAfter /= agg.AvgDur
+		}
+	}
+	return out
+}
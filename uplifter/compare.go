@@ -1,14 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"uplifter/detect"
 )
 
 // CompareMode controls comparison algorithm: "align" or "match"
@@ -16,6 +23,63 @@ import (
 // match = signature-based matching (position-independent)
 var CompareMode = "align"
 
+// ExactOnly pairs only kernels with identical names; everything else is reported
+// as removed/new_only instead of falling back to signature ("similar") matches
+var ExactOnly = false
+
+// KernelFilter limits detailed comparison rows to kernels whose baseline or new name
+// matches (substring, or glob if it has * ? [); totals still cover every kernel
+var KernelFilter = ""
+
+// EmitDeltaShare adds each kernel's share of the total cycle-time change to outputs
+var EmitDeltaShare = false
+
+// HideBelow omits rows whose absolute change is under this percentage from detailed
+// output (totals still cover every kernel; 0 = show all)
+var HideBelow = 0.0
+
+// FlatCSV writes a fused/removed group as one self-contained row per eager kernel
+// tagged with a group_id column, instead of a header row plus indented continuations
+var FlatCSV = false
+
+// csvContinuationIndent prefixes the eager kernel of a group's continuation rows
+const csvContinuationIndent = "  "
+
+// FuzzyThreshold enables a last-resort "fuzzy" match between leftover kernels whose
+// signatures are within this normalized edit distance (0 = disabled)
+var FuzzyThreshold = 0.0
+
+// MissingTimingThreshold is the fraction of zero-duration kernels above which a CSV
+// is treated as having no timing (e.g. an eager trace passed by mistake)
+const MissingTimingThreshold = 0.5
+
+// StrictTiming makes CompareFromCSV fail, instead of warning, when a CSV has no timing
+var StrictTiming = false
+
+// checkTiming warns (or, with StrictTiming, errors) when most of a CSV's kernels have
+// zero duration, since every change computed against it would be meaningless
+func checkTiming(path string, kernels []KernelStats) error {
+	if len(kernels) == 0 {
+		return nil
+	}
+	untimed := 0
+	for _, k := range kernels {
+		if k.AvgDur == 0 {
+			untimed++
+		}
+	}
+	if float64(untimed)/float64(len(kernels)) <= MissingTimingThreshold {
+		return nil
+	}
+	msg := fmt.Sprintf("%s has no timing for %d of %d kernels; durations and changes against it are meaningless",
+		filepath.Base(path), untimed, len(kernels))
+	if StrictTiming {
+		return errors.New(msg)
+	}
+	logErrorf("\n!!! Warning: %s (use -strict to fail instead) !!!\n\n", msg)
+	return nil
+}
+
 // CompareResult holds the comparison between two traces
 type CompareResult struct {
 	EagerName        string
@@ -28,6 +92,42 @@ type CompareResult struct {
 	NewIters         int     // Number of cycle iterations in new
 	BaselineCycleTime float64 // Average cycle time in baseline (µs)
 	NewCycleTime     float64 // Average cycle time in new (µs)
+	ExactCount       int     // Matches with identical kernel names
+	SimilarCount     int     // Matches paired by kernel signature
+	RemovedCount     int     // Baseline kernels with no counterpart in new
+	NewOnlyCount     int     // New kernels with no counterpart in baseline
+	SplitCount       int     // Baseline kernels split into several new kernels
+	FusedCount       int     // New kernels that replace several fused baseline kernels
+	MovedCount       int     // Kernels that only changed position
+	FuzzyCount       int     // Matches paired by name edit distance
+	BaselineLayers   LayerStructure // Layer (sub-cycle) structure of the baseline step
+	NewLayers        LayerStructure // Layer (sub-cycle) structure of the new step
+}
+
+// tallyMatchTypes fills the per-type match counts from Matches
+func (r *CompareResult) tallyMatchTypes() {
+	r.ExactCount, r.SimilarCount, r.RemovedCount, r.NewOnlyCount = 0, 0, 0, 0
+	r.SplitCount, r.FuzzyCount, r.FusedCount, r.MovedCount = 0, 0, 0, 0
+	for _, m := range r.Matches {
+		switch m.MatchType {
+		case "split":
+			r.SplitCount++
+		case "fused":
+			r.FusedCount++
+		case "moved":
+			r.MovedCount++
+		case "fuzzy":
+			r.FuzzyCount++
+		case "exact":
+			r.ExactCount++
+		case "similar":
+			r.SimilarCount++
+		case "removed":
+			r.RemovedCount++
+		case "new_only":
+			r.NewOnlyCount++
+		}
+	}
 }
 
 // KernelMatch represents a matched pair of kernels between two traces
@@ -43,42 +143,44 @@ type KernelMatch struct {
 	EagerMin       float64  // Min duration in eager mode
 	EagerMax       float64  // Max duration in eager mode
 	EagerStdDev    float64  // Std deviation in eager mode
-	MatchType      string   // "exact", "similar", "removed", "new_only"
+	MatchType      string   // "exact", "similar", "fuzzy", "removed", "new_only", "split", "split_part", "fused", "moved"
 	Signature      string   // Common signature used for matching
+	PositionDelta  int      // For "moved": new position minus baseline position (in kernels)
 }
 
 // CompareTraces compares two trace files and produces a kernel-by-kernel comparison
 // trace1 = eager mode (no timing), trace2 = compiled mode (has timing)
-// Uses existing uplifter cycle detection, then matches the results
+// Uses existing uplifter cycle detection, then matches the results. Without fullParse,
+// each trace is read only until EarlyStopReps repetitions are seen
 func CompareTraces(trace1Path, trace2Path string, fullParse bool) (*CompareResult, error) {
 	startTotal := time.Now()
 
 	// Analyze trace 1
-	fmt.Fprintf(os.Stderr, "=== [1/2] Analyzing Trace 1: %s ===\n", filepath.Base(trace1Path))
+	logf("=== [1/2] Analyzing Trace 1: %s ===\n", filepath.Base(trace1Path))
 	start1 := time.Now()
 	result1, err := analyzeTrace(trace1Path, fullParse)
 	if err != nil {
 		return nil, fmt.Errorf("failed to analyze trace 1: %w", err)
 	}
-	fmt.Fprintf(os.Stderr, "Trace 1 done in %v\n", time.Since(start1))
+	logf("Trace 1 done in %v\n", time.Since(start1))
 
 	// Analyze trace 2
-	fmt.Fprintf(os.Stderr, "\n=== [2/2] Analyzing Trace 2: %s ===\n", filepath.Base(trace2Path))
+	logf("\n=== [2/2] Analyzing Trace 2: %s ===\n", filepath.Base(trace2Path))
 	start2 := time.Now()
 	result2, err := analyzeTrace(trace2Path, fullParse)
 	if err != nil {
 		return nil, fmt.Errorf("failed to analyze trace 2: %w", err)
 	}
-	fmt.Fprintf(os.Stderr, "Trace 2 done in %v\n", time.Since(start2))
+	logf("Trace 2 done in %v\n", time.Since(start2))
 
-	fmt.Fprintf(os.Stderr, "\n=== Matching kernels by signature ===\n")
-	fmt.Fprintf(os.Stderr, "Trace 1: %d kernels/cycle, Trace 2: %d kernels/cycle\n",
+	logf("\n=== Matching kernels by signature ===\n")
+	logf("Trace 1: %d kernels/cycle, Trace 2: %d kernels/cycle\n",
 		len(result1.Kernels), len(result2.Kernels))
 
 	// Match kernels between the two cycles using signatures
 	startMatch := time.Now()
 	matches := matchKernelsBySignature(result1, result2)
-	fmt.Fprintf(os.Stderr, "Matching done in %v\n", time.Since(startMatch))
+	logf("Matching done in %v\n", time.Since(startMatch))
 
 	// Calculate total time from trace 2 (the one with timing)
 	var totalTime float64
@@ -86,16 +188,25 @@ func CompareTraces(trace1Path, trace2Path string, fullParse bool) (*CompareResul
 		totalTime += m.CompiledDur
 	}
 
-	fmt.Fprintf(os.Stderr, "Total analysis time: %v\n", time.Since(startTotal))
+	logf("Total analysis time: %v\n", time.Since(startTotal))
 
-	return &CompareResult{
-		EagerName:     filepath.Base(trace1Path),
-		CompiledName:  filepath.Base(trace2Path),
-		EagerCycle:    len(result1.Kernels),
-		CompiledCycle: len(result2.Kernels),
-		Matches:       matches,
-		TotalTime:     totalTime,
-	}, nil
+	result := &CompareResult{
+		EagerName:         filepath.Base(trace1Path),
+		CompiledName:      filepath.Base(trace2Path),
+		EagerCycle:        len(result1.Kernels),
+		CompiledCycle:     len(result2.Kernels),
+		Matches:           matches,
+		TotalTime:         totalTime,
+		BaselineIters:     result1.NumCycles,
+		NewIters:          result2.NumCycles,
+		BaselineCycleTime: result1.AvgCycleTime,
+		NewCycleTime:      result2.AvgCycleTime,
+		BaselineLayers:    detectLayers(result1.Kernels),
+		NewLayers:         detectLayers(result2.Kernels),
+	}
+	result.tallyMatchTypes()
+
+	return result, nil
 }
 
 // analyzeTrace runs the full cycle detection pipeline on a trace file
@@ -103,7 +214,7 @@ func CompareTraces(trace1Path, trace2Path string, fullParse bool) (*CompareResul
 // Returns the sub-cycle (smallest repeating unit) with kernel statistics
 func analyzeTrace(path string, fullParse bool) (*CycleResult, error) {
 	// Step 1: Parse trace file
-	fmt.Fprintf(os.Stderr, "  [Step 1] Parsing trace file...\n")
+	logf("  [Step 1] Parsing trace file...\n")
 	parseStart := time.Now()
 
 	var events []KernelEvent
@@ -122,22 +233,22 @@ func analyzeTrace(path string, fullParse bool) (*CycleResult, error) {
 		return nil, fmt.Errorf("no kernel events found")
 	}
 
-	fmt.Fprintf(os.Stderr, "  [Step 1] Parsed %d kernel events in %v\n", len(events), time.Since(parseStart))
+	logf("  [Step 1] Parsed %d kernel events in %v\n", len(events), time.Since(parseStart))
 
 	// Step 2: Detect cycle
-	fmt.Fprintf(os.Stderr, "  [Step 2] Detecting cycle...\n")
+	logf("  [Step 2] Detecting cycle...\n")
 	cycleStart := time.Now()
-	cycle, err := DetectCycleBySignature(events)
+	cycle, err := detector().DetectCycleBySignature(events)
 	if err != nil {
 		return nil, err
 	}
-	fmt.Fprintf(os.Stderr, "  [Step 2] Cycle detected in %v\n", time.Since(cycleStart))
+	logf("  [Step 2] Cycle detected in %v\n", time.Since(cycleStart))
 
 	// Step 3: Extract cycle statistics
-	fmt.Fprintf(os.Stderr, "  [Step 3] Extracting cycle statistics...\n")
+	logf("  [Step 3] Extracting cycle statistics...\n")
 	extractStart := time.Now()
 	result := ExtractCycle(events, cycle)
-	fmt.Fprintf(os.Stderr, "  [Step 3] Extracted in %v: %d kernels, %d repetitions\n",
+	logf("  [Step 3] Extracted in %v: %d kernels, %d repetitions\n",
 		time.Since(extractStart), result.CycleLength, cycle.NumCycles)
 
 	return result, nil
@@ -147,10 +258,268 @@ func analyzeTrace(path string, fullParse bool) (*CycleResult, error) {
 // align = LCS position-based alignment (for eager vs compiled)
 // match = signature-based matching (for compiled vs compiled)
 func matchKernelsBySignature(eagerResult, compiledResult *CycleResult) []KernelMatch {
+	if AggregateSignatures {
+		eagerResult, compiledResult = aggregateCycle(eagerResult), aggregateCycle(compiledResult)
+		logf("Aggregated by signature: %d baseline, %d new kernels\n",
+			len(eagerResult.Kernels), len(compiledResult.Kernels))
+	}
+	var matches []KernelMatch
 	if CompareMode == "align" {
-		return matchByAlignment(eagerResult, compiledResult)
+		matches = detectFusions(detectSplits(detectMoves(matchByAlignment(eagerResult, compiledResult))))
+	} else {
+		matches = matchBySignature(eagerResult, compiledResult)
+	}
+	if FuzzyThreshold > 0 && !ExactOnly {
+		matches = applyFuzzyMatches(matches, FuzzyThreshold)
+	}
+	return matches
+}
+
+// applyFuzzyMatches pairs leftover new_only and removed kernels whose signatures are
+// within the given normalized edit distance (0..1). The removed row is folded into
+// the new_only row, which becomes a "fuzzy" match.
+func applyFuzzyMatches(matches []KernelMatch, threshold float64) []KernelMatch {
+	dropped := make(map[int]bool)
+	for i := range matches {
+		if matches[i].MatchType != "new_only" {
+			continue
+		}
+		best, bestDist := -1, threshold
+		for j := range matches {
+			if dropped[j] || matches[j].MatchType != "removed" || len(matches[j].EagerKernels) != 1 {
+				continue
+			}
+			if d := normalizedEditDistance(matches[i].Signature, matches[j].Signature); d <= bestDist {
+				best, bestDist = j, d
+			}
+		}
+		if best < 0 {
+			continue
+		}
+
+		ek := matches[best]
+		matches[i].EagerKernels = ek.EagerKernels
+		matches[i].EagerDur = ek.EagerDur
+		matches[i].EagerMin = ek.EagerMin
+		matches[i].EagerMax = ek.EagerMax
+		matches[i].EagerStdDev = ek.EagerStdDev
+		matches[i].MatchType = "fuzzy"
+		dropped[best] = true
+	}
+
+	if len(dropped) == 0 {
+		return matches
+	}
+	var result []KernelMatch
+	for i, m := range matches {
+		if dropped[i] {
+			continue
+		}
+		m.Index = len(result)
+		result = append(result, m)
+	}
+	return result
+}
+
+// normalizedEditDistance returns the Levenshtein distance between a and b
+// divided by the longer length (0 = identical, 1 = nothing in common)
+func normalizedEditDistance(a, b string) float64 {
+	longer := max(len(a), len(b))
+	if longer == 0 {
+		return 0
+	}
+
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(min(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return float64(prev[len(b)]) / float64(longer)
+}
+
+// detectSplits finds one->many splits in aligned matches: a removed baseline kernel
+// next to a run of 2+ new_only kernels with related signatures. The baseline row is
+// marked "split" and the new kernels "split_part" (pointing back at the baseline name).
+func detectSplits(matches []KernelMatch) []KernelMatch {
+	for i := range matches {
+		if matches[i].MatchType != "removed" {
+			continue
+		}
+		eagerSig := matches[i].Signature
+
+		// Collect related new_only kernels directly before and after the removed row
+		var parts []int
+		for j := i - 1; j >= 0 && matches[j].MatchType == "new_only" && signaturesRelated(eagerSig, matches[j].Signature); j-- {
+			parts = append(parts, j)
+		}
+		for j := i + 1; j < len(matches) && matches[j].MatchType == "new_only" && signaturesRelated(eagerSig, matches[j].Signature); j++ {
+			parts = append(parts, j)
+		}
+		if len(parts) < 2 {
+			continue
+		}
+
+		matches[i].MatchType = "split"
+		for _, j := range parts {
+			matches[j].MatchType = "split_part"
+			matches[j].EagerKernels = []string{matches[i].EagerKernels[0]}
+		}
+	}
+	return matches
+}
+
+// detectMoves pairs a removed baseline kernel with a new_only kernel of the same
+// signature elsewhere in the alignment: the kernel just moved, which LCS can only show
+// as a delete plus an insert. The pair becomes one "moved" row at the new position.
+func detectMoves(matches []KernelMatch) []KernelMatch {
+	// Position of each row within its own cycle (rows without that side get -1)
+	eagerPos := make([]int, len(matches))
+	compiledPos := make([]int, len(matches))
+	nextEager, nextCompiled := 0, 0
+	removedBySig := make(map[string][]int)
+	for i, m := range matches {
+		eagerPos[i], compiledPos[i] = -1, -1
+		if m.MatchType != "new_only" {
+			eagerPos[i] = nextEager
+			nextEager++
+		}
+		if m.MatchType != "removed" {
+			compiledPos[i] = nextCompiled
+			nextCompiled++
+		}
+		if m.MatchType == "removed" {
+			removedBySig[m.Signature] = append(removedBySig[m.Signature], i)
+		}
+	}
+
+	absorbed := make([]bool, len(matches))
+	for i := range matches {
+		m := &matches[i]
+		candidates := removedBySig[m.Signature]
+		if m.MatchType != "new_only" || len(candidates) == 0 {
+			continue
+		}
+		from := candidates[0]
+		removedBySig[m.Signature] = candidates[1:]
+		absorbed[from] = true
+
+		old := matches[from]
+		m.EagerKernels = old.EagerKernels
+		m.EagerDur, m.EagerMin, m.EagerMax, m.EagerStdDev = old.EagerDur, old.EagerMin, old.EagerMax, old.EagerStdDev
+		m.MatchType = "moved"
+		m.PositionDelta = compiledPos[i] - eagerPos[from]
+	}
+
+	var merged []KernelMatch
+	for i, m := range matches {
+		if absorbed[i] {
+			continue
+		}
+		m.Index = len(merged)
+		merged = append(merged, m)
+	}
+	return merged
+}
+
+// matchTypeLabel is the match type shown in reports, with the position shift for moved kernels
+func matchTypeLabel(m KernelMatch) string {
+	if m.MatchType == "moved" {
+		return fmt.Sprintf("moved (%+d)", m.PositionDelta)
 	}
-	return matchBySignature(eagerResult, compiledResult)
+	return m.MatchType
+}
+
+// detectFusions finds many->one fusions in aligned matches: a run of 2+ removed
+// baseline kernels next to a new_only kernel of the same category. The run is folded
+// into the new kernel's row, which is marked "fused" and lists every baseline name.
+func detectFusions(matches []KernelMatch) []KernelMatch {
+	absorbed := make([]bool, len(matches))
+	fusible := func(k int, category string) bool {
+		return matches[k].MatchType == "removed" && !absorbed[k] &&
+			categorizeKernel(matches[k].EagerKernels[0]) == category
+	}
+	for j := range matches {
+		if matches[j].MatchType != "new_only" {
+			continue
+		}
+		category := categorizeKernel(matches[j].CompiledKernel)
+
+		// Collect the removed run directly before and after the new kernel
+		start, end := j, j+1
+		for start > 0 && fusible(start-1, category) {
+			start--
+		}
+		for end < len(matches) && fusible(end, category) {
+			end++
+		}
+		if end-start-1 < 2 {
+			continue
+		}
+
+		var names []string
+		var variance float64
+		fused := &matches[j]
+		fused.EagerDur, fused.EagerMin, fused.EagerMax = 0, 0, 0
+		for k := start; k < end; k++ {
+			if k == j {
+				continue
+			}
+			m := matches[k]
+			names = append(names, m.EagerKernels[0])
+			fused.EagerDur += m.EagerDur
+			fused.EagerMin += m.EagerMin
+			fused.EagerMax += m.EagerMax
+			variance += m.EagerStdDev * m.EagerStdDev
+			absorbed[k] = true
+		}
+		fused.EagerKernels = names
+		fused.EagerStdDev = math.Sqrt(variance)
+		fused.MatchType = "fused"
+	}
+
+	var merged []KernelMatch
+	for k, m := range matches {
+		if absorbed[k] {
+			continue
+		}
+		m.Index = len(merged)
+		merged = append(merged, m)
+	}
+	return merged
+}
+
+// signaturesRelated reports whether two kernel signatures share a substantial
+// common prefix (at least 60% of the shorter one, and 4+ characters)
+func signaturesRelated(a, b string) bool {
+	shorter := min(len(a), len(b))
+	if shorter == 0 {
+		return false
+	}
+	common := 0
+	for common < shorter && a[common] == b[common] {
+		common++
+	}
+	return common >= 4 && common*10 >= shorter*6
+}
+
+// alignmentKey returns the value kernels are aligned on: the signature, or the
+// full name when ExactOnly disables signature-based "similar" matches
+func alignmentKey(name string) string {
+	if ExactOnly {
+		return name
+	}
+	return matchSignature(name)
 }
 
 // matchByAlignment uses LCS algorithm for position-based alignment
@@ -160,18 +529,18 @@ func matchByAlignment(eagerResult, compiledResult *CycleResult) []KernelMatch {
 	eager := eagerResult.Kernels
 	compiled := compiledResult.Kernels
 
-	// Build signature arrays
+	// Build signature arrays (raw names when only exact matches are allowed)
 	eagerSigs := make([]string, len(eager))
 	compiledSigs := make([]string, len(compiled))
 	for i, k := range eager {
-		eagerSigs[i] = getKernelSignature(k.Name)
+		eagerSigs[i] = alignmentKey(k.Name)
 	}
 	for i, k := range compiled {
-		compiledSigs[i] = getKernelSignature(k.Name)
+		compiledSigs[i] = alignmentKey(k.Name)
 	}
 
-	// Find best rotation of baseline to maximize LCS
-	// For different-length cycles, double the shorter one to allow wrap-around matching
+	// Find best rotation of baseline to maximize LCS. Every rotation is tried whatever
+	// the two lengths, so cycles that differ after fusion still line up by phase
 	bestRotation := 0
 	bestLCS := computeLCS(eagerSigs, compiledSigs)
 
@@ -191,7 +560,7 @@ func matchByAlignment(eagerResult, compiledResult *CycleResult) []KernelMatch {
 		}
 
 		if bestRotation > 0 {
-			fmt.Fprintf(os.Stderr, "Detected cycle rotation: baseline rotated by %d positions for best alignment\n", bestRotation)
+			logf("Detected cycle rotation: baseline rotated by %d positions for best alignment\n", bestRotation)
 			// Rotate both signatures and kernels
 			eagerSigs = rotateSlice(eagerSigs, bestRotation)
 			eager = rotateKernels(eager, bestRotation)
@@ -345,7 +714,7 @@ func matchBySignature(eagerResult, compiledResult *CycleResult) []KernelMatch {
 
 	for i, k := range eager {
 		entry := eagerEntry{idx: i, kernel: k}
-		sig := getKernelSignature(k.Name)
+		sig := matchSignature(k.Name)
 		eagerBySig[sig] = append(eagerBySig[sig], entry)
 		eagerByName[k.Name] = append(eagerByName[k.Name], entry)
 	}
@@ -355,7 +724,7 @@ func matchBySignature(eagerResult, compiledResult *CycleResult) []KernelMatch {
 	idx := 0
 
 	for _, ck := range compiled {
-		sig := getKernelSignature(ck.Name)
+		sig := matchSignature(ck.Name)
 		var matched *eagerEntry
 		matchType := ""
 
@@ -369,7 +738,7 @@ func matchBySignature(eagerResult, compiledResult *CycleResult) []KernelMatch {
 			}
 		}
 
-		if matched == nil {
+		if matched == nil && !ExactOnly {
 			if entries, exists := eagerBySig[sig]; exists {
 				for i := range entries {
 					if !matchedEagerIdx[entries[i].idx] {
@@ -427,7 +796,7 @@ func matchBySignature(eagerResult, compiledResult *CycleResult) []KernelMatch {
 			EagerMin:       ek.MinDur,
 			EagerMax:       ek.MaxDur,
 			EagerStdDev:    ek.StdDev,
-			Signature:      getKernelSignature(ek.Name),
+			Signature:      matchSignature(ek.Name),
 			MatchType:      "removed",
 		})
 		idx++
@@ -436,6 +805,232 @@ func matchBySignature(eagerResult, compiledResult *CycleResult) []KernelMatch {
 	return matches
 }
 
+// totalDelta returns the summed new-minus-baseline duration over all matches (µs)
+func (r *CompareResult) totalDelta() float64 {
+	delta := 0.0
+	for _, m := range r.Matches {
+		delta += m.CompiledDur - m.EagerDur
+	}
+	return delta
+}
+
+// deltaShare returns the match's share (%) of the total cycle-time change; shares
+// over all matches sum to 100 and can be negative for kernels moving the other way
+func deltaShare(m KernelMatch, totalDelta float64) float64 {
+	if totalDelta == 0 {
+		return 0
+	}
+	return (m.CompiledDur - m.EagerDur) / totalDelta * 100
+}
+
+// kernelNameMatches reports whether name matches a KernelFilter pattern
+func kernelNameMatches(name, pattern string) bool {
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, _ := filepath.Match(pattern, name)
+		return matched
+	}
+	return strings.Contains(name, pattern)
+}
+
+// outputMatches returns the matches to list in detailed output, honoring KernelFilter
+// and HideBelow, plus how many rows HideBelow left out
+func (r *CompareResult) outputMatches() (shown []KernelMatch, hidden int) {
+	if KernelFilter == "" && HideBelow <= 0 {
+		return r.Matches, 0
+	}
+	for _, m := range r.Matches {
+		if KernelFilter != "" {
+			keep := m.CompiledKernel != "." && kernelNameMatches(m.CompiledKernel, KernelFilter)
+			for _, ek := range m.EagerKernels {
+				keep = keep || kernelNameMatches(ek, KernelFilter)
+			}
+			if !keep {
+				continue
+			}
+		}
+		if changeBelowThreshold(m, HideBelow) {
+			hidden++
+			continue
+		}
+		shown = append(shown, m)
+	}
+	return shown, hidden
+}
+
+// changeBelowThreshold reports whether a timed match changed by less than pct percent;
+// rows without timing on both sides (removed, new_only) are never below
+func changeBelowThreshold(m KernelMatch, pct float64) bool {
+	change, ok := changePercent(m)
+	if pct <= 0 || !ok || m.CompiledKernel == "." {
+		return false
+	}
+	return math.Abs(change) < pct
+}
+
+// changePercent returns the new-vs-baseline duration change in percent; ok is false
+// unless both sides have timing
+func changePercent(m KernelMatch) (change float64, ok bool) {
+	if m.EagerDur <= 0 || m.CompiledDur <= 0 {
+		return 0, false
+	}
+	return (m.CompiledDur - m.EagerDur) / m.EagerDur * 100, true
+}
+
+// csvDur formats a duration cell, leaving it blank when there is no timing
+func csvDur(d float64) string {
+	if d <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.3f", d)
+}
+
+// csvTiming formats one side's avg/min/max/stddev cells, all blank when that side
+// has no timing (removed rows on the new side, new_only rows on the baseline side)
+func csvTiming(avg, minDur, maxDur, stddev float64) []string {
+	if avg <= 0 {
+		return []string{"", "", "", ""}
+	}
+	return []string{
+		fmt.Sprintf("%.3f", avg),
+		fmt.Sprintf("%.3f", minDur),
+		fmt.Sprintf("%.3f", maxDur),
+		fmt.Sprintf("%.3f", stddev),
+	}
+}
+
+// csvChange formats a match's percent change, blank when the ratio is undefined
+func csvChange(m KernelMatch) string {
+	change, ok := changePercent(m)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", change)
+}
+
+// cvPercents returns a match's baseline and new coefficient of variation (%), nil for a
+// side without timing; -cv adds them to every comparison format
+func cvPercents(m KernelMatch) (base, new *float64) {
+	if m.EagerDur > 0 {
+		cv := coefficientOfVariation(m.EagerStdDev, m.EagerDur)
+		base = &cv
+	}
+	if m.CompiledKernel != "." && m.CompiledDur > 0 {
+		cv := coefficientOfVariation(m.CompiledStdDev, m.CompiledDur)
+		new = &cv
+	}
+	return base, new
+}
+
+// csvPercent formats an optional percentage, blank when nil
+func csvPercent(p *float64) string {
+	if p == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", *p)
+}
+
+// compareJSON is the JSON document written by WriteCompareJSON
+type compareJSON struct {
+	BaselineName      string            `json:"baseline_name"`
+	NewName           string            `json:"new_name"`
+	BaselineIters     int               `json:"baseline_iterations"`
+	NewIters          int               `json:"new_iterations"`
+	BaselineCycleTime float64           `json:"baseline_cycle_time_us"`
+	NewCycleTime      float64           `json:"new_cycle_time_us"`
+	BaselineTotal     float64           `json:"baseline_total_us"`  // Sum of matched baseline durations
+	NewTotal          float64           `json:"new_total_us"`       // Sum of matched new durations
+	ChangePct         *float64          `json:"change_pct"`         // Total change; null without baseline timing
+	ThresholdPct      float64           `json:"threshold_pct"`      // |change| needed to count as improved/regressed
+	BaselineMemory    float64           `json:"baseline_memory_us"` // Baseline time in memory-movement kernels
+	NewMemory         float64           `json:"new_memory_us"`      // New time in memory-movement kernels
+	MatchCounts       map[string]int    `json:"match_counts"`
+	Matches           []kernelMatchJSON `json:"matches"`
+}
+
+// kernelMatchJSON is one KernelMatch with its change percent precomputed
+type kernelMatchJSON struct {
+	Index          int      `json:"index"`
+	BaselineNames  []string `json:"baseline_kernels"`
+	NewName        string   `json:"new_kernel"`
+	MatchType      string   `json:"match_type"`
+	Signature      string   `json:"signature,omitempty"`
+	PositionDelta  int      `json:"position_delta,omitempty"` // For "moved" rows
+	BaselineDur    float64  `json:"baseline_avg_us"`
+	BaselineMin    float64  `json:"baseline_min_us"`
+	BaselineMax    float64  `json:"baseline_max_us"`
+	BaselineStdDev float64  `json:"baseline_stddev_us"`
+	NewDur         float64  `json:"new_avg_us"`
+	NewMin         float64  `json:"new_min_us"`
+	NewMax         float64  `json:"new_max_us"`
+	NewStdDev      float64  `json:"new_stddev_us"`
+	ChangePct      *float64 `json:"change_pct"`                // null unless both sides are timed
+	Status         string   `json:"status,omitempty"`          // improved, regressed or neutral (timed rows only)
+	BaselineCV     *float64 `json:"baseline_cv_pct,omitempty"` // With -cv, when the baseline is timed
+	NewCV          *float64 `json:"new_cv_pct,omitempty"`      // With -cv, when the new side is timed
+}
+
+// WriteCompareJSON writes every match with its durations, change percent and the totals
+func (r *CompareResult) WriteCompareJSON(w io.Writer) error {
+	doc := compareJSON{
+		BaselineName:      r.EagerName,
+		NewName:           r.CompiledName,
+		BaselineIters:     r.BaselineIters,
+		NewIters:          r.NewIters,
+		BaselineCycleTime: r.BaselineCycleTime,
+		NewCycleTime:      r.NewCycleTime,
+		ThresholdPct:      ChangeThreshold,
+		MatchCounts: map[string]int{
+			"exact":    r.ExactCount,
+			"similar":  r.SimilarCount,
+			"fuzzy":    r.FuzzyCount,
+			"removed":  r.RemovedCount,
+			"new_only": r.NewOnlyCount,
+			"split":    r.SplitCount,
+			"fused":    r.FusedCount,
+			"moved":    r.MovedCount,
+		},
+		Matches: make([]kernelMatchJSON, 0, len(r.Matches)),
+	}
+	doc.BaselineMemory, doc.NewMemory = r.memoryTimes()
+
+	for _, m := range r.Matches {
+		doc.BaselineTotal += m.EagerDur
+		doc.NewTotal += m.CompiledDur
+		jm := kernelMatchJSON{
+			Index:          m.Index,
+			BaselineNames:  m.EagerKernels,
+			NewName:        m.CompiledKernel,
+			MatchType:      m.MatchType,
+			Signature:      m.Signature,
+			PositionDelta:  m.PositionDelta,
+			BaselineDur:    m.EagerDur,
+			BaselineMin:    m.EagerMin,
+			BaselineMax:    m.EagerMax,
+			BaselineStdDev: m.EagerStdDev,
+			NewDur:         m.CompiledDur,
+			NewMin:         m.CompiledMin,
+			NewMax:         m.CompiledMax,
+			NewStdDev:      m.CompiledStdDev,
+			Status:         classifyChange(m),
+		}
+		if change, ok := changePercent(m); ok {
+			jm.ChangePct = &change
+		}
+		if EmitCV {
+			jm.BaselineCV, jm.NewCV = cvPercents(m)
+		}
+		doc.Matches = append(doc.Matches, jm)
+	}
+	if doc.BaselineTotal > 0 {
+		change := (doc.NewTotal - doc.BaselineTotal) / doc.BaselineTotal * 100
+		doc.ChangePct = &change
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
+
 // WriteCompareCSV writes the comparison result to a CSV file
 // Format matches the Excel: eager_kernel | compiled_kernel | duration_us
 func (r *CompareResult) WriteCompareCSV(w io.Writer) error {
@@ -448,30 +1043,71 @@ func (r *CompareResult) WriteCompareCSV(w io.Writer) error {
 		"compiled_kernel",
 		"duration_us",
 		"match_type",
+		"eager_dur_us",
+		"eager_min_us",
+		"eager_max_us",
+		"eager_stddev_us",
+		"new_dur_us",
+		"new_min_us",
+		"new_max_us",
+		"new_stddev_us",
+		"change_pct",
+		"category",
+	}
+	if EmitCV {
+		headers = append(headers, "eager_cv_pct", "new_cv_pct")
+	}
+	if EmitDeltaShare {
+		headers = append(headers, "share_of_change_pct")
+	}
+	if FlatCSV {
+		headers = append(headers, "group_id")
 	}
 	if err := writer.Write(headers); err != nil {
 		return err
 	}
+	totalDelta := r.totalDelta()
+	eagerTotal, newTotal := 0.0, 0.0
+	for _, m := range r.Matches {
+		eagerTotal += m.EagerDur
+		newTotal += m.CompiledDur
+	}
 
 	// Write summary row
+	eagerMemory, newMemory := r.memoryTimes()
 	summaryRow := []string{
-		fmt.Sprintf("Total (%d eager kernels)", r.EagerCycle),
-		fmt.Sprintf("(%d compiled kernels)", r.CompiledCycle),
+		fmt.Sprintf("Total (%d eager kernels%s)", r.EagerCycle, memorySplitLabel(eagerMemory, eagerTotal)),
+		fmt.Sprintf("(%d compiled kernels%s)", r.CompiledCycle, memorySplitLabel(newMemory, newTotal)),
 		fmt.Sprintf("%.3f", r.TotalTime),
 		"",
+		csvDur(eagerTotal), "", "", "",
+		csvDur(newTotal), "", "", "",
+		csvChange(KernelMatch{EagerDur: eagerTotal, CompiledDur: newTotal}),
+		"",
+	}
+	if EmitCV {
+		summaryRow = append(summaryRow, "", "")
+	}
+	if EmitDeltaShare {
+		summaryRow = append(summaryRow, "100.00")
+	}
+	if FlatCSV {
+		summaryRow = append(summaryRow, "")
 	}
 	if err := writer.Write(summaryRow); err != nil {
 		return err
 	}
 
-	// Write kernel rows - one row per match
-	for _, m := range r.Matches {
+	// Write kernel rows - one group per match
+	shown, hidden := r.outputMatches()
+	for group, m := range shown {
+		groupID := strconv.Itoa(group + 1)
 		eagerStr := "(none)"
 		if len(m.EagerKernels) > 0 && m.EagerKernels[0] != "(none)" {
-			eagerStr = m.EagerKernels[0]
+			eagerStr = displayName(m.EagerKernels[0])
 		}
 
-		compiledStr := m.CompiledKernel
+		compiledStr := displayName(m.CompiledKernel)
 		durStr := fmt.Sprintf("%.3f", m.CompiledDur)
 		if m.CompiledKernel == "." {
 			durStr = "" // No duration for fused/removed kernels
@@ -483,17 +1119,50 @@ func (r *CompareResult) WriteCompareCSV(w io.Writer) error {
 			durStr,
 			m.MatchType,
 		}
+		row = append(row, csvTiming(m.EagerDur, m.EagerMin, m.EagerMax, m.EagerStdDev)...)
+		row = append(row, csvTiming(m.CompiledDur, m.CompiledMin, m.CompiledMax, m.CompiledStdDev)...)
+		row = append(row, csvChange(m), matchCategory(m))
+		if EmitCV {
+			baseCV, newCV := cvPercents(m)
+			row = append(row, csvPercent(baseCV), csvPercent(newCV))
+		}
+		if EmitDeltaShare {
+			row = append(row, fmt.Sprintf("%.2f", deltaShare(m, totalDelta)))
+		}
+		if FlatCSV {
+			row = append(row, groupID)
+		}
 		if err := writer.Write(row); err != nil {
 			return err
 		}
 
-		// If multiple eager kernels matched to one compiled, show them on additional rows
+		// If multiple eager kernels matched to one compiled, show them as continuation
+		// rows of the group: indented under it, or (flat) naming the compiled kernel
+		extraType := "removed"
+		if m.MatchType == "fused" {
+			extraType = "fused"
+		}
 		for i := 1; i < len(m.EagerKernels); i++ {
+			eagerName, compiledName := csvContinuationIndent+displayName(m.EagerKernels[i]), "." // Already matched to compiled above
+			if FlatCSV {
+				eagerName, compiledName = displayName(m.EagerKernels[i]), compiledStr
+			}
 			extraRow := []string{
-				m.EagerKernels[i],
-				".", // Already matched to compiled above
+				eagerName,
+				compiledName,
 				"",
-				"removed",
+				extraType,
+				"", "", "", "", "", "", "", "", "",
+				categorizeKernel(m.EagerKernels[i]),
+			}
+			if EmitCV {
+				extraRow = append(extraRow, "", "")
+			}
+			if EmitDeltaShare {
+				extraRow = append(extraRow, "")
+			}
+			if FlatCSV {
+				extraRow = append(extraRow, groupID)
 			}
 			if err := writer.Write(extraRow); err != nil {
 				return err
@@ -501,33 +1170,270 @@ func (r *CompareResult) WriteCompareCSV(w io.Writer) error {
 		}
 	}
 
+	if hidden > 0 {
+		footer := make([]string, len(headers))
+		footer[0] = fmt.Sprintf("(%d rows hidden: |change| < %g%%)", hidden, HideBelow)
+		if err := writer.Write(footer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// categoryDelta is the baseline and new time of one categorizeKernel category
+type categoryDelta struct {
+	category      string
+	baseline, new float64
+}
+
+// categoryRollup totals baseline time by the category of each match's baseline kernel
+// and new time by that of its new kernel, largest categories first
+func (r *CompareResult) categoryRollup() []categoryDelta {
+	byCategory := make(map[string]*categoryDelta)
+	get := func(category string) *categoryDelta {
+		d := byCategory[category]
+		if d == nil {
+			d = &categoryDelta{category: category}
+			byCategory[category] = d
+		}
+		return d
+	}
+	for _, m := range r.Matches {
+		if m.EagerDur > 0 && len(m.EagerKernels) > 0 {
+			get(categorizeKernel(m.EagerKernels[0])).baseline += m.EagerDur
+		}
+		if m.CompiledDur > 0 && m.CompiledKernel != "." {
+			get(categorizeKernel(m.CompiledKernel)).new += m.CompiledDur
+		}
+	}
+
+	rollup := make([]categoryDelta, 0, len(byCategory))
+	for _, d := range byCategory {
+		rollup = append(rollup, *d)
+	}
+	sort.Slice(rollup, func(i, j int) bool {
+		a, b := math.Max(rollup[i].baseline, rollup[i].new), math.Max(rollup[j].baseline, rollup[j].new)
+		if a != b {
+			return a > b
+		}
+		return rollup[i].category < rollup[j].category
+	})
+	return rollup
+}
+
+// memoryTimes is the baseline and new time in memory-movement kernels, from the
+// category rollup
+func (r *CompareResult) memoryTimes() (baselineMemory, newMemory float64) {
+	for _, d := range r.categoryRollup() {
+		if d.category == memoryCategory {
+			return d.baseline, d.new
+		}
+	}
+	return 0, 0
+}
+
+// memorySplitLabel describes how much of total is memory movement vs compute, as
+// "; <memory> µs memory, <compute> µs compute" ("" without timing)
+func memorySplitLabel(memory, total float64) string {
+	if total <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("; %.2f µs memory, %.2f µs compute", memory, total-memory)
+}
+
+// writeMemoryShift prints the memory-movement share of baseline and new time, to show
+// whether a change moved the bottleneck between compute and memory
+func (r *CompareResult) writeMemoryShift(w io.Writer) {
+	baselineTotal, newTotal := 0.0, 0.0
+	for _, m := range r.Matches {
+		baselineTotal += m.EagerDur
+		newTotal += m.CompiledDur
+	}
+	if baselineTotal <= 0 || newTotal <= 0 {
+		return
+	}
+	baselineMemory, newMemory := r.memoryTimes()
+	baselinePct, newPct := percentOf(baselineMemory, baselineTotal), percentOf(newMemory, newTotal)
+	fmt.Fprintf(w, "Memory Share: %.1f%% -> %.1f%% of kernel time (%.2f -> %.2f µs memory, %.2f -> %.2f µs compute)\n",
+		baselinePct, newPct, baselineMemory, newMemory, baselineTotal-baselineMemory, newTotal-newMemory)
+	if (baselinePct > MemoryBoundWarnThreshold) != (newPct > MemoryBoundWarnThreshold) {
+		bound := "compute"
+		if newPct > MemoryBoundWarnThreshold {
+			bound = "memory"
+		}
+		fmt.Fprintf(w, "Note: the bottleneck shifted; the new cycle is now %s-bound\n", bound)
+	}
+}
+
+// writeCategoryRollup prints baseline vs new time per kernel category with the change,
+// e.g. GEMM time down 30% while Attention grew 10%
+func (r *CompareResult) writeCategoryRollup(w io.Writer) {
+	rollup := r.categoryRollup()
+	if len(rollup) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "=== Time by Kernel Category (baseline -> new) ===\n")
+	for _, d := range rollup {
+		change := "(new)"
+		switch {
+		case d.baseline > 0:
+			change = fmt.Sprintf("(%+.1f%%)", (d.new-d.baseline)/d.baseline*100)
+		case d.new == 0:
+			change = ""
+		}
+		fmt.Fprintf(w, "  %-20s: %10.2f -> %10.2f µs %s\n", d.category, d.baseline, d.new, change)
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+// matchCategory is the categorizeKernel category of a match's new kernel, or of its
+// first baseline kernel when there is no new one
+func matchCategory(m KernelMatch) string {
+	if m.CompiledKernel != "." && m.CompiledKernel != "" {
+		return categorizeKernel(m.CompiledKernel)
+	}
+	if len(m.EagerKernels) > 0 {
+		return categorizeKernel(m.EagerKernels[0])
+	}
+	return ""
+}
+
+// StructuralChange is one row of a timing-free structural diff
+type StructuralChange struct {
+	BaselineKernel string
+	NewKernel      string
+	Change         string // "unchanged", "renamed", "added", "removed", "reordered", "split", "fused"
+}
+
+// structuralDiff reduces matches to presence/order changes, ignoring timing.
+// A removed and a new_only kernel with the same signature are the same kernel
+// moved to a different position, so they are reported once as "reordered".
+func (r *CompareResult) structuralDiff() []StructuralChange {
+	// Pair removed/new_only entries by signature
+	removedBySig := make(map[string][]int)
+	for i, m := range r.Matches {
+		if m.MatchType == "removed" {
+			removedBySig[m.Signature] = append(removedBySig[m.Signature], i)
+		}
+	}
+	movedFrom := make(map[int]int) // new_only index -> removed index
+	consumed := make(map[int]bool)
+	for i, m := range r.Matches {
+		if m.MatchType != "new_only" {
+			continue
+		}
+		if candidates := removedBySig[m.Signature]; len(candidates) > 0 {
+			movedFrom[i] = candidates[0]
+			consumed[candidates[0]] = true
+			removedBySig[m.Signature] = candidates[1:]
+		}
+	}
+
+	var changes []StructuralChange
+	for i, m := range r.Matches {
+		baseline := ""
+		if len(m.EagerKernels) > 0 {
+			baseline = m.EagerKernels[0]
+		}
+
+		switch m.MatchType {
+		case "exact":
+			changes = append(changes, StructuralChange{baseline, m.CompiledKernel, "unchanged"})
+		case "similar", "fuzzy":
+			changes = append(changes, StructuralChange{baseline, m.CompiledKernel, "renamed"})
+		case "moved":
+			changes = append(changes, StructuralChange{baseline, m.CompiledKernel, "reordered"})
+		case "removed":
+			if !consumed[i] {
+				changes = append(changes, StructuralChange{baseline, "", "removed"})
+			}
+		case "split":
+			changes = append(changes, StructuralChange{baseline, "", "split"})
+		case "split_part":
+			changes = append(changes, StructuralChange{baseline, m.CompiledKernel, "split"})
+		case "fused":
+			for _, name := range m.EagerKernels {
+				changes = append(changes, StructuralChange{name, m.CompiledKernel, "fused"})
+			}
+			continue
+		case "new_only":
+			if from, ok := movedFrom[i]; ok {
+				changes = append(changes, StructuralChange{r.Matches[from].EagerKernels[0], m.CompiledKernel, "reordered"})
+			} else {
+				changes = append(changes, StructuralChange{"", m.CompiledKernel, "added"})
+			}
+		}
+
+		for j := 1; j < len(m.EagerKernels); j++ {
+			changes = append(changes, StructuralChange{m.EagerKernels[j], "", "removed"})
+		}
+	}
+	return changes
+}
+
+// WriteStructuralCSV writes a timing-free diff of the kernel sequence
+func (r *CompareResult) WriteStructuralCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	if err := writer.Write([]string{"baseline_kernel", "new_kernel", "change"}); err != nil {
+		return err
+	}
+	for _, c := range r.structuralDiff() {
+		if err := writer.Write([]string{c.BaselineKernel, c.NewKernel, c.Change}); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// WriteStructuralSummary writes counts of structural changes (no timing)
+func (r *CompareResult) WriteStructuralSummary(w io.Writer) {
+	counts := make(map[string]int)
+	for _, c := range r.structuralDiff() {
+		counts[c.Change]++
+	}
+
+	fmt.Fprintf(w, "\n=== Structural Comparison Summary ===\n")
+	fmt.Fprintf(w, "Baseline: %s (%d kernels/cycle)\n", r.EagerName, r.EagerCycle)
+	fmt.Fprintf(w, "New:      %s (%d kernels/cycle)\n", r.CompiledName, r.CompiledCycle)
+	fmt.Fprintf(w, "\n")
+	for _, change := range []string{"unchanged", "renamed", "reordered", "split", "fused", "added", "removed"} {
+		fmt.Fprintf(w, "  %-10s %d\n", change+":", counts[change])
+	}
+}
+
 // CompareFromCSV compares two pre-extracted CSV files (much faster than raw traces)
 // csv1 = baseline, csv2 = new
 func CompareFromCSV(csv1Path, csv2Path string) (*CompareResult, error) {
 	startTotal := time.Now()
 
-	fmt.Fprintf(os.Stderr, "=== Reading eager CSV: %s ===\n", filepath.Base(csv1Path))
+	logf("=== Reading eager CSV: %s ===\n", filepath.Base(csv1Path))
 	eagerData, err := readKernelsFromCSV(csv1Path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read eager CSV: %w", err)
 	}
-	fmt.Fprintf(os.Stderr, "Read %d kernels\n", len(eagerData.Kernels))
+	logf("Read %d kernels\n", len(eagerData.Kernels))
+	if err := checkTiming(csv1Path, eagerData.Kernels); err != nil {
+		return nil, err
+	}
 
-	fmt.Fprintf(os.Stderr, "=== Reading compiled CSV: %s ===\n", filepath.Base(csv2Path))
+	logf("=== Reading compiled CSV: %s ===\n", filepath.Base(csv2Path))
 	compiledData, err := readKernelsFromCSV(csv2Path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read compiled CSV: %w", err)
 	}
-	fmt.Fprintf(os.Stderr, "Read %d kernels\n", len(compiledData.Kernels))
+	logf("Read %d kernels\n", len(compiledData.Kernels))
+	if err := checkTiming(csv2Path, compiledData.Kernels); err != nil {
+		return nil, err
+	}
 
 	// Create CycleResult structures for matching
 	eagerResult := &CycleResult{Kernels: eagerData.Kernels, CycleLength: len(eagerData.Kernels)}
 	compiledResult := &CycleResult{Kernels: compiledData.Kernels, CycleLength: len(compiledData.Kernels)}
 
-	fmt.Fprintf(os.Stderr, "\n=== Matching kernels ===\n")
+	logf("\n=== Matching kernels ===\n")
 	matches := matchKernelsBySignature(eagerResult, compiledResult)
 
 	var totalTime float64
@@ -535,9 +1441,9 @@ func CompareFromCSV(csv1Path, csv2Path string) (*CompareResult, error) {
 		totalTime += m.CompiledDur
 	}
 
-	fmt.Fprintf(os.Stderr, "Matching done in %v\n", time.Since(startTotal))
+	logf("Matching done in %v\n", time.Since(startTotal))
 
-	return &CompareResult{
+	result := &CompareResult{
 		EagerName:         filepath.Base(csv1Path),
 		CompiledName:      filepath.Base(csv2Path),
 		EagerCycle:        len(eagerData.Kernels),
@@ -548,32 +1454,127 @@ func CompareFromCSV(csv1Path, csv2Path string) (*CompareResult, error) {
 		NewIters:          compiledData.Iterations,
 		BaselineCycleTime: eagerData.AvgCycleTime,
 		NewCycleTime:      compiledData.AvgCycleTime,
-	}, nil
+		BaselineLayers:    detectLayers(eagerData.Kernels),
+		NewLayers:         detectLayers(compiledData.Kernels),
+	}
+	result.tallyMatchTypes()
+
+	return result, nil
+}
+
+// writeDeltaShareSummary lists the topN kernels contributing most to the total cycle-time change
+func (r *CompareResult) writeDeltaShareSummary(w io.Writer, topN int) {
+	totalDelta := r.totalDelta()
+	fmt.Fprintf(w, "\n=== Top %d Contributors to Total Change (%+.2f µs) ===\n", topN, totalDelta)
+	if totalDelta == 0 {
+		fmt.Fprintf(w, "  (no change)\n")
+		return
+	}
+
+	sorted := make([]KernelMatch, len(r.Matches))
+	copy(sorted, r.Matches)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return deltaShare(sorted[i], totalDelta) > deltaShare(sorted[j], totalDelta)
+	})
+	for i := 0; i < min(topN, len(sorted)); i++ {
+		m := sorted[i]
+		name := m.CompiledKernel
+		if name == "." && len(m.EagerKernels) > 0 {
+			name = m.EagerKernels[0]
+		}
+		fmt.Fprintf(w, "%2d. %6.1f%% (%+.2f µs) %s - %s\n", i+1, deltaShare(m, totalDelta),
+			m.CompiledDur-m.EagerDur, detect.TruncateString(name, 55), m.MatchType)
+	}
+}
+
+// LayerStructure describes a step (one cycle) as repeated layers (sub-cycles)
+type LayerStructure struct {
+	StepKernels  int     // Kernels in the whole step
+	LayerKernels int     // Kernels per layer (0 if no repeating layer found)
+	NumLayers    int     // Full layer repetitions in the step
+	AvgLayerTime float64 // Average summed duration of one layer (µs)
+}
+
+// detectLayers finds the shortest period at which kernel signatures repeat within a
+// step (90% of positions must match), which for transformer traces is one layer
+func detectLayers(kernels []KernelStats) LayerStructure {
+	n := len(kernels)
+	ls := LayerStructure{StepKernels: n}
+	sigs := make([]string, n)
+	for i, k := range kernels {
+		sigs[i] = detect.KernelSignature(k.Name)
+	}
+
+	for p := 2; p <= n/2; p++ {
+		matched := 0
+		for i := 0; i+p < n; i++ {
+			if sigs[i] == sigs[i+p] {
+				matched++
+			}
+		}
+		if float64(matched)/float64(n-p) < 0.9 {
+			continue
+		}
+
+		ls.LayerKernels = p
+		ls.NumLayers = n / p
+		total := 0.0
+		for _, k := range kernels[:ls.NumLayers*p] {
+			total += k.AvgDur
+		}
+		ls.AvgLayerTime = total / float64(ls.NumLayers)
+		break
+	}
+	return ls
+}
+
+// WriteHierarchySummary writes a nested step/layer structure diff
+func (r *CompareResult) WriteHierarchySummary(w io.Writer) {
+	b, n := r.BaselineLayers, r.NewLayers
+	fmt.Fprintf(w, "\n=== Structure Diff (step / layer) ===\n")
+	fmt.Fprintf(w, "Step: %d -> %d kernels (%+d)\n", b.StepKernels, n.StepKernels, n.StepKernels-b.StepKernels)
+	if b.LayerKernels == 0 || n.LayerKernels == 0 {
+		fmt.Fprintf(w, "  Layers: no repeating layer found (baseline %d, new %d kernels/layer)\n", b.LayerKernels, n.LayerKernels)
+		return
+	}
+	fmt.Fprintf(w, "  Layers per step:   %d -> %d (%+d)\n", b.NumLayers, n.NumLayers, n.NumLayers-b.NumLayers)
+	fmt.Fprintf(w, "  Kernels per layer: %d -> %d (%+d)\n", b.LayerKernels, n.LayerKernels, n.LayerKernels-b.LayerKernels)
+	if b.AvgLayerTime > 0 && n.AvgLayerTime > 0 {
+		fmt.Fprintf(w, "  Time per layer:    %.2f -> %.2f µs (%+.1f%%)\n",
+			b.AvgLayerTime, n.AvgLayerTime, (n.AvgLayerTime-b.AvgLayerTime)/b.AvgLayerTime*100)
+	}
+	fmt.Fprintf(w, "  Kernels outside layers: %d -> %d\n",
+		b.StepKernels-b.NumLayers*b.LayerKernels, n.StepKernels-n.NumLayers*n.LayerKernels)
 }
 
-// readKernelsFromCSV reads kernel stats from a CSV file produced by uplifter
 // CSVData holds kernels and metadata from a CSV file
 type CSVData struct {
-	Kernels      []KernelStats
-	Iterations   int
-	AvgCycleTime float64
+	Kernels       []KernelStats
+	PctOfCycle    []float64 // pct_of_cycle per kernel (0 where the column is absent)
+	Iterations    int
+	AvgCycleTime  float64
+	SchemaVersion int // From the "# Schema version" row (0 if absent)
 }
 
+// readKernelsFromCSV reads kernel stats from a cycle CSV, as written by WriteCSV or
+// by hand. "#" metadata rows and blank rows may appear anywhere; the first other row
+// is the header, and the delimiter (comma, tab or semicolon) is taken from it
 func readKernelsFromCSV(path string) (*CSVData, error) {
-	file, err := os.Open(path)
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
 
-	reader := csv.NewReader(file)
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = detectCSVDelimiter(data)
 	reader.FieldsPerRecord = -1 // Allow variable fields for metadata rows
 
 	result := &CSVData{}
 
 	// Read rows, looking for metadata and data
 	var header []string
-	for {
+	for header == nil {
 		record, err := reader.Read()
 		if err == io.EOF {
 			break
@@ -581,29 +1582,33 @@ func readKernelsFromCSV(path string) (*CSVData, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to read CSV row: %w", err)
 		}
-
-		// Skip empty rows
-		if len(record) == 0 {
+		if isBlankCSVRow(record) {
 			continue
 		}
 
 		// Parse metadata rows (start with #)
-		if len(record) >= 2 && strings.HasPrefix(record[0], "#") {
-			key := strings.TrimPrefix(record[0], "# ")
-			switch key {
-			case "Iterations":
-				result.Iterations, _ = strconv.Atoi(record[1])
-			case "Avg cycle time (us)":
-				result.AvgCycleTime, _ = strconv.ParseFloat(record[1], 64)
+		if strings.HasPrefix(strings.TrimSpace(record[0]), "#") {
+			if len(record) >= 2 {
+				value := strings.TrimSpace(record[1])
+				switch strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(record[0]), "#")) {
+				case "Iterations":
+					result.Iterations, _ = strconv.Atoi(value)
+				case "Avg cycle time (us)":
+					result.AvgCycleTime, _ = strconv.ParseFloat(value, 64)
+				case "Schema version":
+					result.SchemaVersion, _ = strconv.Atoi(value)
+				}
 			}
 			continue
 		}
 
-		// Check if this is the header row
-		if len(record) >= 2 && record[0] == "index" {
-			header = record
-			break
-		}
+		header = record
+	}
+
+	// Columns are looked up by name, so unknown or extra columns are ignored
+	if result.SchemaVersion > CSVSchemaVersion {
+		logf("Note: %s uses CSV schema version %d (this build writes %d); unknown columns are ignored\n",
+			path, result.SchemaVersion, CSVSchemaVersion)
 	}
 
 	// Find column indices from header
@@ -612,8 +1617,9 @@ func readKernelsFromCSV(path string) (*CSVData, error) {
 	minDurIdx := -1
 	maxDurIdx := -1
 	stdDevIdx := -1
+	pctIdx := -1
 	for i, col := range header {
-		switch col {
+		switch strings.TrimSpace(col) {
 		case "kernel_name":
 			nameIdx = i
 		case "avg_duration_us":
@@ -624,6 +1630,8 @@ func readKernelsFromCSV(path string) (*CSVData, error) {
 			maxDurIdx = i
 		case "stddev_us":
 			stdDevIdx = i
+		case "pct_of_cycle":
+			pctIdx = i
 		}
 	}
 
@@ -631,6 +1639,18 @@ func readKernelsFromCSV(path string) (*CSVData, error) {
 		return nil, fmt.Errorf("CSV missing required columns (kernel_name, avg_duration_us)")
 	}
 
+	// optional parses a numeric column, 0 if absent or not a number
+	optional := func(record []string, idx int) float64 {
+		if idx < 0 || idx >= len(record) {
+			return 0
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(record[idx]), 64)
+		if err != nil {
+			return 0
+		}
+		return v
+	}
+
 	for {
 		record, err := reader.Read()
 		if err == io.EOF {
@@ -639,68 +1659,106 @@ func readKernelsFromCSV(path string) (*CSVData, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to read CSV row: %w", err)
 		}
+		if isBlankCSVRow(record) || strings.HasPrefix(strings.TrimSpace(record[0]), "#") {
+			continue
+		}
 
 		// Validate both required column indices are within bounds
 		if len(record) <= avgDurIdx || len(record) <= nameIdx {
 			continue
 		}
 
-		avgDur, err := strconv.ParseFloat(record[avgDurIdx], 64)
+		avgDur, err := strconv.ParseFloat(strings.TrimSpace(record[avgDurIdx]), 64)
 		if err != nil {
 			continue // Skip invalid rows
 		}
 
-		k := KernelStats{
+		result.Kernels = append(result.Kernels, KernelStats{
 			Name:   record[nameIdx],
 			AvgDur: avgDur,
-		}
+			MinDur: optional(record, minDurIdx),
+			MaxDur: optional(record, maxDurIdx),
+			StdDev: optional(record, stdDevIdx),
+		})
+		result.PctOfCycle = append(result.PctOfCycle, optional(record, pctIdx))
+	}
 
-		// Parse optional stats if columns exist
-		if minDurIdx >= 0 && minDurIdx < len(record) {
-			if v, err := strconv.ParseFloat(record[minDurIdx], 64); err == nil {
-				k.MinDur = v
-			}
-		}
-		if maxDurIdx >= 0 && maxDurIdx < len(record) {
-			if v, err := strconv.ParseFloat(record[maxDurIdx], 64); err == nil {
-				k.MaxDur = v
-			}
+	return result, nil
+}
+
+// detectCSVDelimiter picks comma, tab or semicolon, whichever occurs most in the first
+// line that is neither blank nor a "#" metadata row (comma on a tie or no such line)
+func detectCSVDelimiter(data []byte) rune {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
-		if stdDevIdx >= 0 && stdDevIdx < len(record) {
-			if v, err := strconv.ParseFloat(record[stdDevIdx], 64); err == nil {
-				k.StdDev = v
+		best, bestCount := ',', strings.Count(line, ",")
+		for _, d := range []rune{'\t', ';'} {
+			if n := strings.Count(line, string(d)); n > bestCount {
+				best, bestCount = d, n
 			}
 		}
-
-		result.Kernels = append(result.Kernels, k)
+		return best
 	}
+	return ','
+}
 
-	return result, nil
+// isBlankCSVRow reports whether every field of record is empty or whitespace
+func isBlankCSVRow(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
 }
 
-// WriteSummary writes a human-readable comparison summary
-func (r *CompareResult) WriteSummary(w io.Writer) {
+// WriteSummary writes a human-readable comparison summary listing the topN longest kernels
+func (r *CompareResult) WriteSummary(w io.Writer, topN int) {
 	fmt.Fprintf(w, "\n=== Trace Comparison Summary ===\n")
 	fmt.Fprintf(w, "Eager:    %s (%d kernels/cycle)\n", r.EagerName, r.EagerCycle)
 	fmt.Fprintf(w, "Compiled: %s (%d kernels/cycle)\n", r.CompiledName, r.CompiledCycle)
 	fmt.Fprintf(w, "\n")
 	fmt.Fprintf(w, "Total Compiled Cycle Time: %.2f µs (%.4f ms)\n", r.TotalTime, r.TotalTime/1000)
+	r.writeMemoryShift(w)
 	fmt.Fprintf(w, "\n")
 
-	// Count match types
-	typeCounts := make(map[string]int)
-	for _, m := range r.Matches {
-		typeCounts[m.MatchType]++
-	}
-
 	fmt.Fprintf(w, "Match Types:\n")
-	for matchType, count := range typeCounts {
-		fmt.Fprintf(w, "  %s: %d\n", matchType, count)
+	fmt.Fprintf(w, "  exact: %d\n", r.ExactCount)
+	fmt.Fprintf(w, "  similar: %d\n", r.SimilarCount)
+	fmt.Fprintf(w, "  removed: %d\n", r.RemovedCount)
+	fmt.Fprintf(w, "  new_only: %d\n", r.NewOnlyCount)
+	if r.SplitCount > 0 {
+		fmt.Fprintf(w, "  split: %d\n", r.SplitCount)
+	}
+	if r.FusedCount > 0 {
+		fmt.Fprintf(w, "  fused: %d\n", r.FusedCount)
+	}
+	if r.MovedCount > 0 {
+		fmt.Fprintf(w, "  moved: %d\n", r.MovedCount)
+	}
+	if r.FuzzyCount > 0 {
+		fmt.Fprintf(w, "  fuzzy: %d\n", r.FuzzyCount)
+	}
+	if KernelFilter != "" || HideBelow > 0 {
+		shown, hidden := r.outputMatches()
+		fmt.Fprintf(w, "Detailed output: %d of %d rows", len(shown), len(r.Matches))
+		if KernelFilter != "" {
+			fmt.Fprintf(w, ", filter %q", KernelFilter)
+		}
+		if hidden > 0 {
+			fmt.Fprintf(w, ", %d hidden with |change| < %g%%", hidden, HideBelow)
+		}
+		fmt.Fprintf(w, "\n")
 	}
 	fmt.Fprintf(w, "\n")
 
+	r.writeCategoryRollup(w)
+
 	// Top kernels by duration
-	fmt.Fprintf(w, "=== Top 10 Kernels by Duration (Compiled) ===\n")
+	fmt.Fprintf(w, "=== Top %d Kernels by Duration (Compiled) ===\n", topN)
 	type kernelEntry struct {
 		compiled  string
 		eager     []string
@@ -728,19 +1786,20 @@ func (r *CompareResult) WriteSummary(w io.Writer) {
 		}
 	}
 
-	for i := 0; i < min(10, len(entries)); i++ {
+	for i := 0; i < min(topN, len(entries)); i++ {
 		e := entries[i]
-		pct := 0.0
-		if r.TotalTime > 0 {
-			pct = (e.dur / r.TotalTime) * 100
-		}
+		pct := percentOf(e.dur, r.TotalTime)
 		fmt.Fprintf(w, "%2d. %.2f µs (%.1f%%) - %s\n", i+1, e.dur, pct, e.matchType)
-		fmt.Fprintf(w, "    Compiled: %s\n", truncateString(e.compiled, 65))
+		fmt.Fprintf(w, "    Compiled: %s\n", detect.TruncateString(e.compiled, 65))
 		if len(e.eager) > 0 && e.eager[0] != "(none)" {
-			fmt.Fprintf(w, "    Eager:    %s\n", truncateString(e.eager[0], 65))
+			fmt.Fprintf(w, "    Eager:    %s\n", detect.TruncateString(e.eager[0], 65))
 		}
 	}
 
+	if EmitDeltaShare {
+		r.writeDeltaShareSummary(w, topN)
+	}
+
 	// Fused kernels (eager kernels that were removed in compiled)
 	fmt.Fprintf(w, "\n=== Fused/Removed Eager Kernels (no compiled equivalent) ===\n")
 	fusedCount := 0
@@ -748,7 +1807,7 @@ func (r *CompareResult) WriteSummary(w io.Writer) {
 		if m.MatchType == "removed" {
 			fusedCount++
 			for _, ek := range m.EagerKernels {
-				fmt.Fprintf(w, "  - %s\n", truncateString(ek, 75))
+				fmt.Fprintf(w, "  - %s\n", detect.TruncateString(ek, 75))
 			}
 		}
 	}
@@ -762,14 +1821,49 @@ func (r *CompareResult) WriteSummary(w io.Writer) {
 	for _, m := range r.Matches {
 		if m.MatchType == "new_only" {
 			compiledOnlyCount++
-			pct := 0.0
-			if r.TotalTime > 0 {
-				pct = (m.CompiledDur / r.TotalTime) * 100
-			}
-			fmt.Fprintf(w, "  %.2f µs (%.1f%%) %s\n", m.CompiledDur, pct, truncateString(m.CompiledKernel, 60))
+			pct := percentOf(m.CompiledDur, r.TotalTime)
+			fmt.Fprintf(w, "  %.2f µs (%.1f%%) %s\n", m.CompiledDur, pct, detect.TruncateString(m.CompiledKernel, 60))
 		}
 	}
 	if compiledOnlyCount == 0 {
 		fmt.Fprintf(w, "  (none)\n")
 	}
+
+	// Split kernels (one eager kernel became several compiled kernels)
+	if r.SplitCount > 0 {
+		fmt.Fprintf(w, "\n=== Split Kernels (one eager -> many compiled) ===\n")
+		for _, m := range r.Matches {
+			if m.MatchType != "split" {
+				continue
+			}
+			var parts []string
+			partsDur := 0.0
+			for _, p := range r.Matches {
+				if p.MatchType == "split_part" && len(p.EagerKernels) > 0 && p.EagerKernels[0] == m.EagerKernels[0] {
+					parts = append(parts, p.CompiledKernel)
+					partsDur += p.CompiledDur
+				}
+			}
+			fmt.Fprintf(w, "  %s (%.2f µs) -> %d kernels (%.2f µs)\n",
+				detect.TruncateString(m.EagerKernels[0], 60), m.EagerDur, len(parts), partsDur)
+			for _, p := range parts {
+				fmt.Fprintf(w, "    - %s\n", detect.TruncateString(p, 70))
+			}
+		}
+	}
+
+	// Fused kernels (several eager kernels became one compiled kernel)
+	if r.FusedCount > 0 {
+		fmt.Fprintf(w, "\n=== Fused Kernels (many eager -> one compiled) ===\n")
+		for _, m := range r.Matches {
+			if m.MatchType != "fused" {
+				continue
+			}
+			fmt.Fprintf(w, "  %d kernels (%.2f µs) -> %s (%.2f µs)\n",
+				len(m.EagerKernels), m.EagerDur, detect.TruncateString(m.CompiledKernel, 60), m.CompiledDur)
+			for _, name := range m.EagerKernels {
+				fmt.Fprintf(w, "    - %s\n", detect.TruncateString(name, 70))
+			}
+		}
+	}
 }
@@ -2,19 +2,20 @@ package main
 
 import (
 	"fmt"
-	"hash/fnv"
-	"os"
-	"sort"
+	"strconv"
 	"strings"
+
+	"uplifter/detect"
 )
 
-// CycleInfo contains information about a detected cycle
-type CycleInfo struct {
-	StartIndex   int   // Index where the first complete cycle starts
-	CycleLength  int   // Number of kernels in one cycle
-	NumCycles    int   // Number of complete cycles found
-	CycleIndices []int // Start indices of each detected cycle
-}
+// Cycle detection lives in the importable uplifter/detect package; the CLI drives it
+// through the flag-set globals below via detector()
+type (
+	KernelEvent  = detect.KernelEvent
+	CycleInfo    = detect.CycleInfo
+	CyclePattern = detect.CyclePattern
+	KmerCycle    = detect.KmerCycle
+)
 
 // KernelStats contains aggregated statistics for a kernel in the cycle
 type KernelStats struct {
@@ -25,942 +26,210 @@ type KernelStats struct {
 	Count        int
 	AvgDur       float64
 	StdDev       float64   // Standard deviation of durations
-	Durations    []float64 // Individual durations for stddev calculation
+	P50          float64   // Median duration
+	P90          float64   // 90th percentile duration
+	P99          float64   // 99th percentile duration
+	Durations    []float64 // Individual durations, in repetition order (cleared after ExtractCycle unless KeepDurations)
 	IndexInCycle int       // Position within the cycle
+	MinAtCycle   int       // Repetition (1-based) that produced MinDur
+	MaxAtCycle   int       // Repetition (1-based) that produced MaxDur
+	Grid         [3]int    // Launch grid dimensions of the first occurrence (zero if unknown)
+	Block        [3]int    // Launch block dimensions of the first occurrence (zero if unknown)
+	Stream       int       // GPU stream of the first occurrence
+	IdleBefore   float64   // Mean gap (µs) from the previous kernel's end to this kernel's start
+	IdleAfter    float64   // Mean gap (µs) from this kernel's end to the next kernel's start
+	MaxIdleAfter float64   // Largest gap (µs) after this kernel
 }
 
 // NormalizeNames controls whether kernel names are normalized before comparison
 var NormalizeNames = false
 
-// PhaseMode controls which phase to detect: "auto", "prefill", or "decode"
-// Detection is based on REPETITION COUNT (model-agnostic):
-// - decode = cycle with MOST repetitions (generates many tokens)
-// - prefill = cycle with FEWER repetitions (processes prompt once)
-var PhaseMode = "auto"
-
-// DetectCycle finds repeating cycles in a sequence of kernel events
-// It uses a rolling hash approach to efficiently find repeating patterns
-func DetectCycle(events []KernelEvent, minCycleLen, maxCycleLen int) (*CycleInfo, error) {
-	if len(events) < minCycleLen*2 {
-		return nil, fmt.Errorf("not enough events (%d) for cycle detection (need at least %d)", len(events), minCycleLen*2)
-	}
-
-	// Create a sequence of hashed kernel names for faster comparison
-	hashes := make([]uint64, len(events))
-	for i, e := range events {
-		if NormalizeNames {
-			hashes[i] = hashStringNormalized(e.Name)
-		} else {
-			hashes[i] = hashString(e.Name)
-		}
-	}
-
-	fmt.Fprintf(os.Stderr, "Searching for cycles (length %d-%d) in %d kernel events...\n", minCycleLen, maxCycleLen, len(events))
-
-	// Try different cycle lengths, starting from minimum
-	for cycleLen := minCycleLen; cycleLen <= maxCycleLen && cycleLen <= len(events)/2; cycleLen++ {
-		info := tryCycleLength(hashes, events, cycleLen)
-		if info != nil && info.NumCycles >= 2 {
-			fmt.Fprintf(os.Stderr, "Found cycle of length %d repeating %d times\n", cycleLen, info.NumCycles)
-			return info, nil
-		}
-
-		// Progress indicator
-		if cycleLen%100 == 0 {
-			fmt.Fprintf(os.Stderr, "\rTrying cycle length %d...", cycleLen)
-		}
-	}
-
-	return nil, fmt.Errorf("no repeating cycle found in range [%d, %d]", minCycleLen, maxCycleLen)
-}
-
-// tryCycleLength checks if the sequence repeats with the given cycle length
-func tryCycleLength(hashes []uint64, events []KernelEvent, cycleLen int) *CycleInfo {
-	n := len(hashes)
-
-	// Try different starting positions (to handle warm-up phase)
-	for startOffset := 0; startOffset < cycleLen && startOffset < n/4; startOffset++ {
-		matches := 0
-		cycleIndices := []int{startOffset}
-
-		// Count how many complete cycles match
-		for pos := startOffset; pos+cycleLen <= n; pos += cycleLen {
-			if pos > startOffset {
-				// Check if this segment matches the first cycle
-				isMatch := true
-				for i := 0; i < cycleLen; i++ {
-					if hashes[startOffset+i] != hashes[pos+i] {
-						isMatch = false
-						break
-					}
-				}
-				if isMatch {
-					matches++
-					cycleIndices = append(cycleIndices, pos)
-				} else {
-					// Allow one mismatch and continue checking
-					break
-				}
-			} else {
-				matches++
-			}
-		}
-
-		// Found a good cycle
-		if matches >= 2 {
-			return &CycleInfo{
-				StartIndex:   startOffset,
-				CycleLength:  cycleLen,
-				NumCycles:    matches,
-				CycleIndices: cycleIndices,
-			}
-		}
-	}
-
-	return nil
-}
-
-// DetectCycleAuto automatically determines cycle length using autocorrelation-like approach
-func DetectCycleAuto(events []KernelEvent) (*CycleInfo, error) {
-	if len(events) < 20 {
-		return nil, fmt.Errorf("not enough events for auto cycle detection")
-	}
-
-	fmt.Fprintf(os.Stderr, "Auto-detecting cycle in %d kernel events...\n", len(events))
-
-	// Find potential cycle length by looking for repeated subsequences
-	// Start by finding the first occurrence of a repeated kernel name
-	firstRepeat := findFirstRepeat(events)
-	if firstRepeat == 0 {
-		return nil, fmt.Errorf("no repeated kernel found")
-	}
-
-	// Search around the first repeat position
-	minLen := max(10, firstRepeat-100)
-	maxLen := min(len(events)/2, firstRepeat+1000)
-
-	return DetectCycle(events, minLen, maxLen)
-}
-
-// CyclePattern represents a detected cycle with its temporal position
-type CyclePattern struct {
-	Info      *CycleInfo
-	Signature string
-	StartPos  int     // First occurrence position in trace
-	EndPos    int     // Last occurrence position in trace
-	CenterPos float64 // Average position (for classification)
-	Anchor    string  // Anchor kernel name
-}
-
-// DetectCycleBySignature uses a signature-based approach
-// It looks for a unique "anchor" kernel that appears periodically
-// and finds the MINIMUM cycle length (smallest repeating unit)
-func DetectCycleBySignature(events []KernelEvent) (*CycleInfo, error) {
-	if len(events) < 20 {
-		return nil, fmt.Errorf("not enough events")
-	}
-
-	// Phase detection: Find ALL cycles, then classify by temporal position
-	var result *CycleInfo
-	var err error
-
-	switch PhaseMode {
-	case "prefill", "decode":
-		result, err = detectPhaseByAllCycles(events, PhaseMode)
-		if err != nil || result == nil {
-			fmt.Fprintf(os.Stderr, "All-cycles detection failed, falling back to standard detection\n")
-			result, err = detectCycleStandard(events, 0)
-		}
-	default: // "auto"
-		result, err = detectCycleStandard(events, 0)
-	}
-
-	return result, err
-}
-
-// detectPhaseByAllCycles finds ALL distinct cycle patterns in the trace,
-// then classifies them by temporal position (earlier = prefill, later = decode)
-func detectPhaseByAllCycles(events []KernelEvent, phase string) (*CycleInfo, error) {
-	fmt.Fprintf(os.Stderr, "Detecting all cycle patterns in %d events...\n", len(events))
-
-	// Find all distinct cycle patterns
-	patterns := findAllCyclePatterns(events)
-
-	if len(patterns) == 0 {
-		return nil, fmt.Errorf("no cycle patterns found")
-	}
-
-	fmt.Fprintf(os.Stderr, "Found %d distinct cycle patterns:\n", len(patterns))
-	for i, p := range patterns {
-		fmt.Fprintf(os.Stderr, "  %d. length=%d, reps=%d, center=%.1f%%, sig=%s\n",
-			i+1, p.Info.CycleLength, p.Info.NumCycles,
-			p.CenterPos/float64(len(events))*100,
-			truncateString(p.Signature, 50))
-	}
-
-	// Sort patterns by center position (earlier first)
-	sort.Slice(patterns, func(i, j int) bool {
-		return patterns[i].CenterPos < patterns[j].CenterPos
-	})
-
-	// Classify: earliest center = prefill, latest center = decode
-	if phase == "prefill" {
-		// Return pattern with earliest center position
-		selected := patterns[0]
-		fmt.Fprintf(os.Stderr, "Selected PREFILL pattern: center=%.1f%%, length=%d, reps=%d\n",
-			selected.CenterPos/float64(len(events))*100,
-			selected.Info.CycleLength, selected.Info.NumCycles)
-		return selected.Info, nil
-	} else { // decode
-		// Return pattern with latest center position
-		selected := patterns[len(patterns)-1]
-		fmt.Fprintf(os.Stderr, "Selected DECODE pattern: center=%.1f%%, length=%d, reps=%d\n",
-			selected.CenterPos/float64(len(events))*100,
-			selected.Info.CycleLength, selected.Info.NumCycles)
-		return selected.Info, nil
-	}
-}
-
-// findAllCyclePatterns finds all distinct cycle patterns in the events
-func findAllCyclePatterns(events []KernelEvent) []CyclePattern {
-	// Count kernel occurrences
-	counts := make(map[string]int)
-	for _, e := range events {
-		counts[e.Name]++
-	}
-
-	// Find anchor candidates
-	type candidate struct {
-		name     string
-		count    int
-		cycleLen int
-	}
-	var candidates []candidate
-	for name, count := range counts {
-		if count >= 5 && count <= len(events)/5 {
-			estimatedCycleLen := len(events) / count
-			candidates = append(candidates, candidate{name, count, estimatedCycleLen})
-		}
-	}
-
-	// Sort by count
-	sort.Slice(candidates, func(i, j int) bool {
-		return candidates[i].count > candidates[j].count
-	})
-
-	// Find all valid cycles and group by signature
-	signatureGroups := make(map[string]*CyclePattern)
-
-	for _, cand := range candidates {
-		positions := findKernelPositions(events, cand.name)
-		if len(positions) < 5 {
-			continue
-		}
+// MinCycleLen and MaxCycleLen bound the cycle lengths (in kernels) accepted by the
+// anchor-based detectors (MaxCycleLen 0 = unbounded)
+var (
+	MinCycleLen = 10
+	MaxCycleLen = 0
+)
 
-		cycleLen := positions[1] - positions[0]
-		if cycleLen < 10 {
-			continue
-		}
+// MatchTolerance is the fraction of positions that must match for a repetition to count
+// (detect.Options.MatchTolerance); the quicker/looser stages derive their thresholds
+// from it. A looser tolerance accepts noisier repetitions, which raises NumCycles.
+var MatchTolerance = 0.95
 
-		// Check consistency
-		isConsistent := true
-		for i := 2; i < len(positions); i++ {
-			diff := positions[i] - positions[i-1]
-			// Relaxed tolerance: 20% instead of 5%
-			if abs(diff-cycleLen) > max(1, cycleLen/5) {
-				isConsistent = false
-				break
-			}
-		}
+// RequireDualAnchor requires a second periodic kernel at a fixed phase offset from
+// the anchor before accepting a cycle (reduces false positives in interleaved traces)
+var RequireDualAnchor = false
 
-		if !isConsistent {
-			continue
-		}
+// ExplainDetection prints extra diagnostics during cycle detection
+var ExplainDetection = false
 
-		// Verify the cycle
-		info := verifyCycle(events, positions[0], cycleLen, len(positions))
-		if info == nil || info.NumCycles < 5 {
-			continue
-		}
+// SeedAnchors lists kernel names known to mark iteration boundaries; they are tried
+// before automatically discovered anchors and win whenever they yield a valid cycle
+var SeedAnchors []string
 
-		// Look for sub-cycles
-		if info.CycleLength > 20 {
-			cycleEvents := events[info.StartIndex : info.StartIndex+info.CycleLength]
-			subCycle := findSubCycle(cycleEvents, events, info)
-			if subCycle != nil {
-				info = subCycle
-			}
-		}
+// WeightDedupByDuration weights the k-mer dedup signature match by kernel duration,
+// so cycles differing only in cheap kernels are merged
+var WeightDedupByDuration = false
 
-		// Get signature for this cycle
-		sig := getCycleSignature(events, info)
+// AnchorKmer pins k-mer detection to this exact kernel-name sequence instead of
+// scoring candidates automatically (empty = automatic)
+var AnchorKmer []string
 
-		// Calculate temporal position
-		startPos := info.StartIndex
-		endPos := info.CycleIndices[len(info.CycleIndices)-1] + info.CycleLength
-		centerPos := float64(startPos+endPos) / 2.0
+// KmerLenMin and KmerLenMax are the k-mer lengths -algo kmer tries; when they differ,
+// every k in the range is swept and the best-covering k is kept
+var (
+	KmerLenMin = 3
+	KmerLenMax = 3
+)
 
-		// Group by signature - keep the one with better stats
-		if existing, ok := signatureGroups[sig]; ok {
-			// Keep the pattern with more repetitions
-			if info.NumCycles > existing.Info.NumCycles {
-				signatureGroups[sig] = &CyclePattern{
-					Info:      info,
-					Signature: sig,
-					StartPos:  startPos,
-					EndPos:    endPos,
-					CenterPos: centerPos,
-					Anchor:    cand.name,
-				}
-			}
+// detector returns a Detector configured from the CLI globals, logging to LogOutput
+// at the current verbosity
+func detector() *detect.Detector {
+	opts := detect.DefaultOptions()
+	opts.Log = logWriter()
+	opts.Verbose = Verbosity >= LogVerbose
+	opts.Explain = ExplainDetection
+	opts.MinCycleLen = MinCycleLen
+	opts.MaxCycleLen = MaxCycleLen
+	opts.MatchTolerance = MatchTolerance
+	opts.RequireDualAnchor = RequireDualAnchor
+	opts.SeedAnchors = SeedAnchors
+	opts.AnchorKmer = AnchorKmer
+	opts.WeightDedupByDuration = WeightDedupByDuration
+	if NormalizeNames {
+		opts.Normalize = normalizeKernelName
+	}
+	return detect.New(opts)
+}
+
+// detectCyclePatterns runs the detector selected by -algo: "anchor" (the default,
+// FindCyclePatterns), "kmer" (DetectCyclesKmer, or DetectCyclesKmerSweep over
+// KmerLenMin..KmerLenMax) or "suffix" (DetectCycleSuffix)
+func detectCyclePatterns(events []KernelEvent, algo string) ([]CyclePattern, error) {
+	switch algo {
+	case "", "anchor":
+		return detector().FindCyclePatterns(events), nil
+	case "kmer":
+		var cycles []KmerCycle
+		if KmerLenMin == KmerLenMax {
+			cycles = detector().DetectCyclesKmer(events, KmerLenMin, MinCycleLen)
 		} else {
-			signatureGroups[sig] = &CyclePattern{
-				Info:      info,
-				Signature: sig,
-				StartPos:  startPos,
-				EndPos:    endPos,
-				CenterPos: centerPos,
-				Anchor:    cand.name,
-			}
-		}
-	}
-
-	// Convert map to slice
-	var patterns []CyclePattern
-	for _, p := range signatureGroups {
-		patterns = append(patterns, *p)
-	}
-
-	// Second pass: merge similar patterns (>80% kernel overlap)
-	patterns = deduplicateSimilarPatterns(events, patterns)
-
-	return patterns
-}
-
-// deduplicateSimilarPatterns merges patterns that have >80% kernel signature overlap
-func deduplicateSimilarPatterns(events []KernelEvent, patterns []CyclePattern) []CyclePattern {
-	if len(patterns) <= 1 {
-		return patterns
-	}
-
-	// Extract kernel signature sets for each pattern
-	type patternSigs struct {
-		pattern CyclePattern
-		sigs    map[string]float64 // kernel sig -> % of cycle
-	}
-	var allPatterns []patternSigs
-
-	for _, p := range patterns {
-		sigs := make(map[string]float64)
-		if p.Info != nil && p.Info.StartIndex+p.Info.CycleLength <= len(events) {
-			for i := 0; i < p.Info.CycleLength; i++ {
-				idx := p.Info.StartIndex + i
-				sig := getKernelSignature(events[idx].Name)
-				// Weight by duration
-				sigs[sig] += events[idx].Duration
-			}
-			// Normalize to percentages
-			total := 0.0
-			for _, v := range sigs {
-				total += v
-			}
-			if total > 0 {
-				for k := range sigs {
-					sigs[k] = sigs[k] / total * 100
-				}
-			}
-		}
-		allPatterns = append(allPatterns, patternSigs{p, sigs})
-	}
-
-	// Group similar patterns
-	type group struct {
-		members []patternSigs
-	}
-	var groups []group
-	used := make(map[int]bool)
-
-	for i := 0; i < len(allPatterns); i++ {
-		if used[i] {
-			continue
+			cycles = detector().DetectCyclesKmerSweep(events, KmerLenMin, KmerLenMax, MinCycleLen)
 		}
-
-		// Start new group
-		g := group{members: []patternSigs{allPatterns[i]}}
-		used[i] = true
-
-		// Find similar patterns
-		for j := i + 1; j < len(allPatterns); j++ {
-			if used[j] {
-				continue
-			}
-
-			// Check length similarity (within 20%)
-			lenI := allPatterns[i].pattern.Info.CycleLength
-			lenJ := allPatterns[j].pattern.Info.CycleLength
-			if abs(lenI-lenJ) > max(lenI, lenJ)/5 {
-				continue
+		var patterns []CyclePattern
+		for _, c := range cycles {
+			info := &CycleInfo{StartIndex: c.StartIndex, CycleLength: c.Length, NumCycles: c.Repetitions}
+			for i := 0; i < c.Repetitions; i++ {
+				info.CycleIndices = append(info.CycleIndices, c.StartIndex+i*c.Length)
 			}
-
-			// Check kernel overlap (weighted Jaccard)
-			sim := computePatternSimilarity(allPatterns[i].sigs, allPatterns[j].sigs)
-			if sim >= 0.80 { // 80% similarity threshold
-				g.members = append(g.members, allPatterns[j])
-				used[j] = true
+			if len(info.CycleIndices) > 0 {
+				patterns = append(patterns, detect.PatternFromInfo(events, info))
 			}
 		}
-
-		groups = append(groups, g)
-	}
-
-	// Pick best representative from each group
-	var result []CyclePattern
-	for _, g := range groups {
-		best := g.members[0]
-		for _, m := range g.members[1:] {
-			// Prefer pattern with more repetitions
-			if m.pattern.Info.NumCycles > best.pattern.Info.NumCycles {
-				best = m
-			}
-		}
-		if len(g.members) > 1 {
-			fmt.Fprintf(os.Stderr, "  Merged %d similar patterns into one (anchor: %s)\n",
-				len(g.members), truncateString(best.pattern.Anchor, 40))
-		}
-		result = append(result, best.pattern)
-	}
-
-	return result
-}
-
-// computePatternSimilarity computes weighted Jaccard similarity between two patterns
-func computePatternSimilarity(a, b map[string]float64) float64 {
-	if len(a) == 0 || len(b) == 0 {
-		return 0
-	}
-
-	allKeys := make(map[string]bool)
-	for k := range a {
-		allKeys[k] = true
-	}
-	for k := range b {
-		allKeys[k] = true
-	}
-
-	minSum, maxSum := 0.0, 0.0
-	for k := range allKeys {
-		aVal := a[k]
-		bVal := b[k]
-		if aVal < bVal {
-			minSum += aVal
-			maxSum += bVal
-		} else {
-			minSum += bVal
-			maxSum += aVal
-		}
-	}
-
-	if maxSum == 0 {
-		return 0
-	}
-	return minSum / maxSum
-}
-
-// findOuterCycleWithSubcycle finds outer cycle and its sub-cycle in one go
-func findOuterCycleWithSubcycle(searchEvents []KernelEvent, allEvents []KernelEvent, offset int) *CycleInfo {
-	outerCycle := findOuterCycle(searchEvents)
-	if outerCycle == nil {
-		return nil
-	}
-
-	// Adjust indices for offset
-	if offset > 0 {
-		outerCycle.StartIndex += offset
-		for i := range outerCycle.CycleIndices {
-			outerCycle.CycleIndices[i] += offset
-		}
-	}
-
-	// Look for sub-cycles
-	if outerCycle.CycleLength > 20 {
-		cycleEvents := allEvents[outerCycle.StartIndex : outerCycle.StartIndex+outerCycle.CycleLength]
-		subCycle := findSubCycle(cycleEvents, allEvents, outerCycle)
-		if subCycle != nil {
-			return subCycle
+		return patterns, nil
+	case "suffix":
+		info := detector().DetectCycleSuffix(events, MinCycleLen, MaxCycleLen)
+		if info == nil {
+			return nil, nil
 		}
+		return []CyclePattern{detect.PatternFromInfo(events, info)}, nil
 	}
-
-	return outerCycle
+	return nil, fmt.Errorf("unknown detection algorithm %q (want anchor, kmer or suffix)", algo)
 }
 
-// getCycleSignature returns a string signature of the cycle's kernel pattern
-// Used to compare if two cycles represent the same or different patterns
-func getCycleSignature(events []KernelEvent, cycle *CycleInfo) string {
-	if cycle == nil || cycle.StartIndex+cycle.CycleLength > len(events) {
-		return ""
-	}
-
-	// Build signature from kernel types in the cycle
-	var sigs []string
-	for i := 0; i < min(cycle.CycleLength, 10); i++ {
-		idx := cycle.StartIndex + i
-		if idx < len(events) {
-			sig := getKernelSignature(events[idx].Name)
-			sigs = append(sigs, sig)
-		}
-	}
-	return strings.Join(sigs, "|")
+// UncoveredRegion is a stretch of the trace not covered by any detected cycle
+type UncoveredRegion struct {
+	StartIndex int     // First event index in the region
+	EndIndex   int     // One past the last event index
+	TotalDur   float64 // Sum of kernel durations in the region (µs)
 }
 
-// detectCycleStandard is the standard cycle detection (used for auto mode)
-func detectCycleStandard(events []KernelEvent, offset int) (*CycleInfo, error) {
-	outerCycle := findOuterCycle(events)
-
-	// Adjust indices if we used an offset
-	if outerCycle != nil && offset > 0 {
-		outerCycle.StartIndex += offset
-		for i := range outerCycle.CycleIndices {
-			outerCycle.CycleIndices[i] += offset
-		}
-	}
-
-	// Look for sub-cycles within the outer cycle
-	if outerCycle != nil && outerCycle.CycleLength > 20 {
-		fmt.Fprintf(os.Stderr, "Found outer cycle: length=%d, repetitions=%d\n",
-			outerCycle.CycleLength, outerCycle.NumCycles)
-		fmt.Fprintf(os.Stderr, "Looking for sub-cycles within outer cycle...\n")
-
-		// Extract one cycle's worth of events
-		cycleEvents := events[outerCycle.StartIndex : outerCycle.StartIndex+outerCycle.CycleLength]
-		subCycle := findSubCycle(cycleEvents, events, outerCycle)
-		if subCycle != nil {
-			fmt.Fprintf(os.Stderr, "Found sub-cycle: length=%d, repetitions=%d\n",
-				subCycle.CycleLength, subCycle.NumCycles)
-			return subCycle, nil
-		}
-	}
-
-	if outerCycle != nil {
-		return outerCycle, nil
-	}
-
-	return DetectCycleAuto(events)
-}
-
-// findOuterCycle finds repeating cycles using exact kernel name matching
-// Phase detection is done by temporal position (caller passes the right portion of trace)
-// This function finds the cycle with MOST repetitions (most reliable pattern)
-func findOuterCycle(events []KernelEvent) *CycleInfo {
-	// Count kernel occurrences
-	counts := make(map[string]int)
-	for _, e := range events {
-		counts[e.Name]++
-	}
-
-	// Find kernels that appear multiple times but not too frequently
-	type candidate struct {
-		name     string
-		count    int
-		cycleLen int
-	}
-	var candidates []candidate
-	for name, count := range counts {
-		if count >= 5 && count <= len(events)/5 { // Require at least 5 occurrences
-			estimatedCycleLen := len(events) / count
-			candidates = append(candidates, candidate{name, count, estimatedCycleLen})
-		}
-	}
-
-	// Sort by count (most repetitions first - most reliable pattern)
-	sort.Slice(candidates, func(i, j int) bool {
-		return candidates[i].count > candidates[j].count
-	})
-
-	// Find valid cycles, collect all of them
-	type validCycle struct {
-		info   *CycleInfo
-		anchor string
-	}
-	var validCycles []validCycle
-
-	for _, cand := range candidates {
-		positions := findKernelPositions(events, cand.name)
-		if len(positions) < 5 {
-			continue
-		}
-
-		cycleLen := positions[1] - positions[0]
-		if cycleLen < 10 {
-			continue
-		}
-
-		isConsistent := true
-		consistentCount := 1
-		for i := 2; i < len(positions); i++ {
-			diff := positions[i] - positions[i-1]
-			// Relaxed tolerance: 20% instead of 5%
-			if abs(diff-cycleLen) > max(1, cycleLen/5) {
-				isConsistent = false
-				break
-			}
-			consistentCount++
-		}
-
-		if isConsistent && consistentCount >= 5 {
-			info := verifyCycle(events, positions[0], cycleLen, len(positions))
-			if info != nil && info.NumCycles >= 5 {
-				validCycles = append(validCycles, validCycle{info, cand.name})
+// findUncoveredRegions returns the gaps between detected cycle repetitions that
+// contain at least minKernels events, in trace order
+func findUncoveredRegions(events []KernelEvent, patterns []CyclePattern, minKernels int) []UncoveredRegion {
+	covered := make([]bool, len(events))
+	for _, p := range patterns {
+		for _, start := range p.Info.CycleIndices {
+			for i := start; i < min(start+p.Info.CycleLength, len(events)); i++ {
+				covered[i] = true
 			}
 		}
 	}
 
-	if len(validCycles) == 0 {
-		return nil
-	}
-
-	// Sort valid cycles by repetition count
-	switch PhaseMode {
-	case "prefill":
-		// Return cycle with FEWEST repetitions
-		sort.Slice(validCycles, func(i, j int) bool {
-			return validCycles[i].info.NumCycles < validCycles[j].info.NumCycles
-		})
-		fmt.Fprintf(os.Stderr, "Found PREFILL cycle: %d reps (anchor: %s)\n",
-			validCycles[0].info.NumCycles, truncateName(validCycles[0].anchor, 40))
-	default: // "decode" or "auto"
-		// Return cycle with MOST repetitions
-		sort.Slice(validCycles, func(i, j int) bool {
-			return validCycles[i].info.NumCycles > validCycles[j].info.NumCycles
-		})
-		fmt.Fprintf(os.Stderr, "Found DECODE cycle: %d reps (anchor: %s)\n",
-			validCycles[0].info.NumCycles, truncateName(validCycles[0].anchor, 40))
-	}
-
-	return validCycles[0].info
-}
-
-// truncateName shortens a string for display
-func truncateName(s string, maxLen int) string {
-	if len(s) <= maxLen {
-		return s
-	}
-	return s[:maxLen-3] + "..."
-}
-
-// findSubCycle looks for repeating patterns within a cycle using kernel type signatures
-func findSubCycle(cycleEvents []KernelEvent, allEvents []KernelEvent, outerCycle *CycleInfo) *CycleInfo {
-	n := len(cycleEvents)
-
-	// Create type signatures for each kernel (simplified names for pattern matching)
-	signatures := make([]string, n)
-	for i, e := range cycleEvents {
-		signatures[i] = getKernelSignature(e.Name)
-	}
-
-	// Find kernels that repeat within the cycle
-	sigCounts := make(map[string][]int) // signature -> positions within cycle
-	for i, sig := range signatures {
-		sigCounts[sig] = append(sigCounts[sig], i)
-	}
-
-	// Look for signatures that appear multiple times at regular intervals
-	var bestSubCycleLen int
-	var bestPositions []int
-
-	for sig, positions := range sigCounts {
-		if len(positions) < 3 {
+	var regions []UncoveredRegion
+	for i := 0; i < len(events); {
+		if covered[i] {
+			i++
 			continue
 		}
-
-		// Check if positions are evenly spaced
-		subCycleLen := positions[1] - positions[0]
-		if subCycleLen < 5 || subCycleLen >= n/2 {
-			continue
-		}
-
-		isConsistent := true
-		for i := 2; i < len(positions); i++ {
-			diff := positions[i] - positions[i-1]
-			if abs(diff-subCycleLen) > max(1, subCycleLen/10) {
-				isConsistent = false
-				break
-			}
-		}
-
-		if isConsistent && (bestSubCycleLen == 0 || subCycleLen < bestSubCycleLen) {
-			// Verify the sub-cycle using signatures
-			if verifySubCycleBySignature(signatures, positions[0], subCycleLen) {
-				bestSubCycleLen = subCycleLen
-				bestPositions = positions
-				fmt.Fprintf(os.Stderr, "  Sub-cycle candidate: length=%d (anchor: %s)\n",
-					subCycleLen, truncateString(sig, 40))
-			}
-		}
-	}
-
-	if bestSubCycleLen > 0 {
-		// Calculate total repetitions across all outer cycles
-		totalReps := len(bestPositions) * outerCycle.NumCycles
-
-		// Build cycle indices across all events
-		var cycleIndices []int
-		for _, outerStart := range outerCycle.CycleIndices {
-			for _, posInCycle := range bestPositions {
-				cycleIndices = append(cycleIndices, outerStart+posInCycle)
-			}
-		}
-
-		return &CycleInfo{
-			StartIndex:   outerCycle.StartIndex + bestPositions[0],
-			CycleLength:  bestSubCycleLen,
-			NumCycles:    totalReps,
-			CycleIndices: cycleIndices,
+		region := UncoveredRegion{StartIndex: i}
+		for ; i < len(events) && !covered[i]; i++ {
+			region.TotalDur += events[i].Duration
 		}
-	}
-
-	return nil
-}
-
-// verifySubCycleBySignature checks if the signature pattern repeats
-func verifySubCycleBySignature(signatures []string, startIdx, cycleLen int) bool {
-	n := len(signatures)
-	matches := 0
-	checks := 0
-
-	for i := startIdx; i+cycleLen < n; i += cycleLen {
-		checks++
-		matchCount := 0
-		for j := 0; j < cycleLen && i+j < n && i+j+cycleLen < n; j++ {
-			if signatures[i+j] == signatures[i+j+cycleLen] {
-				matchCount++
-			}
+		region.EndIndex = i
+		if region.EndIndex-region.StartIndex >= minKernels {
+			regions = append(regions, region)
 		}
-		// Require 80% signature match for sub-cycles (more lenient than exact)
-		if float64(matchCount)/float64(cycleLen) >= 0.80 {
-			matches++
-		}
-	}
-
-	// Need at least 3 matching repetitions
-	return matches >= 3
-}
-
-// getKernelSignature returns a simplified signature for a kernel name
-// This groups similar kernels together for pattern detection and matching
-func getKernelSignature(name string) string {
-	// Strategy: extract the base kernel name by removing:
-	// 1. Template parameters (content in <>)
-	// 2. Configuration suffixes (GROUP_K_, BLOCK_SIZE_, etc. - common in eager mode)
-	// 3. Dimension suffixes (like _32x256, _128x64)
-	// 4. Common config prefixes (like _1tg_, _ps_)
-	// 5. Trailing numbers (like _0, _1)
-
-	sig := name
-
-	// Remove template parameters - find first < and truncate
-	if idx := strings.Index(sig, "<"); idx > 0 {
-		sig = sig[:idx]
 	}
-
-	// Remove configuration suffixes that appear in eager mode but not compiled
-	// These patterns indicate compile-time parameters
-	configPatterns := []string{
-		"_GROUP_K_", "_GROUP_N_", "_GROUP_SIZE_",
-		"_BLOCK_SIZE_", "_SPLITK_BLOCK_SIZE_",
-		"_NUM_KSPLIT_", "_ACTUAL_KSPLIT_", "_MAX_KSPLIT_",
-		"_GRID_MN_", "_GRID_",
-		"_EVEN_K_", "_cache_modifier_",
-	}
-	for _, pattern := range configPatterns {
-		if idx := strings.Index(sig, pattern); idx > 0 {
-			sig = sig[:idx]
-		}
-	}
-
-	// Remove dimension suffixes like _32x256, _128x64, _NxM pattern
-	for i := len(sig) - 1; i >= 0; i-- {
-		if sig[i] == '_' {
-			suffix := sig[i+1:]
-			if isDimensionSuffix(suffix) {
-				sig = sig[:i]
-				break
-			}
-		}
-	}
-
-	// Remove common config suffixes that vary between implementations
-	configSuffixes := []string{"_1tg_ps", "_1tg", "_ps", "_novs", "_vs"}
-	for _, suffix := range configSuffixes {
-		if idx := strings.LastIndex(sig, suffix); idx > 0 {
-			sig = sig[:idx]
-		}
-	}
-
-	// Remove trailing numbers (like _0, _1, _9)
-	for len(sig) > 2 && sig[len(sig)-1] >= '0' && sig[len(sig)-1] <= '9' && sig[len(sig)-2] == '_' {
-		sig = sig[:len(sig)-2]
-	}
-
-	// Clean up any trailing underscores
-	sig = strings.TrimRight(sig, "_")
-
-	// If signature is empty or too short, use a hash
-	if len(sig) < 3 {
-		return fmt.Sprintf("other_%d", hashString(name)%1000)
-	}
-
-	return sig
+	return regions
 }
 
-// isDimensionSuffix checks if a string matches NxM or NUMxNUM pattern (e.g., "32x256")
-func isDimensionSuffix(s string) bool {
-	if len(s) < 3 {
-		return false
+// parseKRange parses a -k-sweep range such as "1-6"
+func parseKRange(s string) (int, int, error) {
+	lo, hi, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected a range like 1-6, got %q", s)
 	}
-	xIdx := strings.Index(s, "x")
-	if xIdx <= 0 || xIdx >= len(s)-1 {
-		return false
+	kMin, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid k range %q: %w", s, err)
 	}
-	// Check that parts before and after 'x' are numbers
-	for i := 0; i < xIdx; i++ {
-		if s[i] < '0' || s[i] > '9' {
-			return false
-		}
+	kMax, err := strconv.Atoi(strings.TrimSpace(hi))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid k range %q: %w", s, err)
 	}
-	for i := xIdx + 1; i < len(s); i++ {
-		if s[i] < '0' || s[i] > '9' {
-			return false
-		}
+	if kMin < 1 || kMax < kMin {
+		return 0, 0, fmt.Errorf("invalid k range %q: need 1 <= min <= max", s)
 	}
-	return true
+	return kMin, kMax, nil
 }
 
-func findKernelPositions(events []KernelEvent, name string) []int {
-	var positions []int
-	for i, e := range events {
-		eName := e.Name
-		if NormalizeNames {
-			eName = normalizeKernelName(eName)
-		}
-		if eName == name {
-			positions = append(positions, i)
-		}
-	}
-	return positions
-}
+// TestKmerCycleDetection runs the k-mer algorithm on events and prints results
+func TestKmerCycleDetection(events []KernelEvent) {
+	logf("\n=== Testing K-mer Cycle Detection ===\n")
 
-func verifyCycle(events []KernelEvent, startIdx, cycleLen, expectedCycles int) *CycleInfo {
-	hashes := make([]uint64, len(events))
-	for i, e := range events {
-		if NormalizeNames {
-			hashes[i] = hashStringNormalized(e.Name)
-		} else {
-			hashes[i] = hashString(e.Name)
-		}
-	}
+	// Try k=3 (3 consecutive kernels as anchor)
+	cycles := detector().DetectCyclesKmer(events, 3, 10)
 
-	cycleIndices := []int{startIdx}
-	matches := 1
-
-	for i := 1; i < expectedCycles; i++ {
-		pos := startIdx + i*cycleLen
-		if pos+cycleLen > len(events) {
-			break
-		}
-
-		// Check match with tolerance for slight variations
-		matchCount := 0
-		for j := 0; j < cycleLen; j++ {
-			if hashes[startIdx+j] == hashes[pos+j] {
-				matchCount++
-			}
-		}
-
-		// Require 95% match
-		if float64(matchCount)/float64(cycleLen) >= 0.95 {
-			matches++
-			cycleIndices = append(cycleIndices, pos)
-		}
+	logf("\nResults:\n")
+	for i, c := range cycles {
+		logf("  Cycle %d: start=%d, length=%d, reps=%d, anchor=%s...\n",
+			i+1, c.StartIndex, c.Length, c.Repetitions, detect.TruncateString(c.AnchorKmer, 30))
 	}
-
-	if matches >= 2 {
-		return &CycleInfo{
-			StartIndex:   startIdx,
-			CycleLength:  cycleLen,
-			NumCycles:    matches,
-			CycleIndices: cycleIndices,
-		}
-	}
-	return nil
 }
 
-func findFirstRepeat(events []KernelEvent) int {
-	seen := make(map[uint64]int)
-	for i, e := range events {
-		var h uint64
-		if NormalizeNames {
-			h = hashStringNormalized(e.Name)
-		} else {
-			h = hashString(e.Name)
-		}
-		if _, exists := seen[h]; exists {
-			return i
-		}
-		seen[h] = i
-	}
-	return 0
-}
+// TestSimpleCycleDetection runs the simple algorithm on events and prints results
+func TestSimpleCycleDetection(events []KernelEvent) {
+	logf("\n=== Testing Simple Cycle Detection ===\n")
 
-func hashString(s string) uint64 {
-	h := fnv.New64a()
-	h.Write([]byte(s))
-	return h.Sum64()
-}
+	cycles := detector().DetectCyclesSimple(events, 10)
 
-// hashStringNormalized hashes a kernel name after normalizing it
-// This strips trailing numbers from triton kernels to group similar kernels
-func hashStringNormalized(s string) uint64 {
-	normalized := normalizeKernelName(s)
-	h := fnv.New64a()
-	h.Write([]byte(normalized))
-	return h.Sum64()
-}
+	logf("\nResults:\n")
+	for i, c := range cycles {
+		logf("  Cycle %d: start=%d, length=%d, reps=%d\n",
+			i+1, c.StartIndex, c.Length, c.Repetitions)
 
-// normalizeKernelName removes variable parts from kernel names
-// e.g., "triton_red_fused_something_123" -> "triton_red_fused_something"
-func normalizeKernelName(name string) string {
-	// For triton kernels, strip trailing _N suffix
-	if len(name) > 7 && name[:7] == "triton_" {
-		// Find last underscore followed by digits
-		lastUnderscore := -1
-		for i := len(name) - 1; i >= 0; i-- {
-			if name[i] == '_' {
-				// Check if everything after is digits
-				allDigits := true
-				for j := i + 1; j < len(name); j++ {
-					if name[j] < '0' || name[j] > '9' {
-						allDigits = false
-						break
-					}
-				}
-				if allDigits && i+1 < len(name) {
-					lastUnderscore = i
-					break
-				}
+		// Print first few kernel names
+		logf("    First 5 kernels: ")
+		for j := 0; j < 5 && j < c.Length; j++ {
+			name := events[c.StartIndex+j].Name
+			if len(name) > 30 {
+				name = name[:30] + "..."
 			}
+			logf("\n      %d: %s", j, name)
 		}
-		if lastUnderscore > 0 {
-			return name[:lastUnderscore]
-		}
-	}
-	return name
-}
-
-func abs(x int) int {
-	if x < 0 {
-		return -x
+		logf("\n")
 	}
-	return x
 }
 
 func max(a, b int) int {
@@ -976,10 +245,3 @@ func min(a, b int) int {
 	}
 	return b
 }
-
-func truncateString(s string, maxLen int) string {
-	if len(s) <= maxLen {
-		return s
-	}
-	return s[:maxLen-3] + "..."
-}
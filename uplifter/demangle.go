@@ -0,0 +1,79 @@
+package main
+
+import "strings"
+
+// DemangleNames shows simplified kernel names (see simplifyKernelName) in outputs;
+// matching always uses the raw names
+var DemangleNames = false
+
+// displayName returns the name to show for a kernel, simplified when DemangleNames is set
+func displayName(name string) string {
+	if !DemangleNames {
+		return name
+	}
+	return simplifyKernelName(name)
+}
+
+// simplifyKernelName turns a demangled C++ kernel symbol into a short readable name:
+// drops a leading "void ", template arguments and the argument list, and collapses
+// namespaces to the leaf templated type (or the last component when none is templated).
+// e.g. "void ck::device::DeviceGemm<F16, 256>::Run(int)" -> "DeviceGemm::Run"
+func simplifyKernelName(name string) string {
+	s := strings.TrimSpace(name)
+	s = strings.TrimPrefix(s, "void ")
+	s = strings.ReplaceAll(s, "(anonymous namespace)::", "")
+
+	type component struct {
+		text      string
+		templated bool
+	}
+	var parts []component
+	var cur strings.Builder
+	templated := false
+	depth := 0
+
+scan:
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '<':
+			depth++
+			templated = true
+		case c == '>':
+			if depth > 0 {
+				depth--
+			}
+		case depth > 0:
+			// Inside template arguments
+		case c == '(':
+			break scan // Argument list
+		case c == ':' && i+1 < len(s) && s[i+1] == ':':
+			parts = append(parts, component{strings.TrimSpace(cur.String()), templated})
+			cur.Reset()
+			templated = false
+			i++
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	parts = append(parts, component{strings.TrimSpace(cur.String()), templated})
+
+	// Keep from the last templated component on; otherwise just the leaf
+	keep := len(parts) - 1
+	for i := len(parts) - 1; i >= 0; i-- {
+		if parts[i].templated {
+			keep = i
+			break
+		}
+	}
+	var names []string
+	for _, p := range parts[keep:] {
+		if p.text != "" {
+			names = append(names, p.text)
+		}
+	}
+	if len(names) == 0 {
+		return name
+	}
+	return strings.Join(names, "::")
+}
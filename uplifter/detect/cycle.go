@@ -0,0 +1,1165 @@
+package detect
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+)
+
+// CycleInfo contains information about a detected cycle
+type CycleInfo struct {
+	StartIndex   int   // Index where the first complete cycle starts
+	CycleLength  int   // Number of kernels in one cycle
+	NumCycles    int   // Number of complete cycles found
+	CycleIndices []int // Start indices of each detected cycle
+}
+
+// cycleLenInRange reports whether cycleLen lies within MinCycleLen..MaxCycleLen
+func (d *Detector) cycleLenInRange(cycleLen int) bool {
+	return cycleLen >= d.opts.MinCycleLen && (d.opts.MaxCycleLen <= 0 || cycleLen <= d.opts.MaxCycleLen)
+}
+
+// maxAnchorCount is the most occurrences an anchor may have: one per MinCycleLen
+// events, capped at one in 5 so very common kernels are still skipped
+func (d *Detector) maxAnchorCount(numEvents int) int {
+	return numEvents / max(1, min(5, d.opts.MinCycleLen))
+}
+
+// stageTolerance returns MatchTolerance loosened by slack, clamped to [0, 1]
+func (d *Detector) stageTolerance(slack float64) float64 {
+	return math.Max(0, math.Min(1, d.opts.MatchTolerance-slack))
+}
+
+// seedRank returns the position of name (a kernelKey) in SeedAnchors, or -1 if it
+// isn't seeded
+func (d *Detector) seedRank(name string) int {
+	for i, seed := range d.seedKeys {
+		if seed == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// sortSeededFirst orders anchor candidates seeded-first (in seed order, as given by
+// seedRank), keeping the existing order otherwise
+func sortSeededFirst[T any](candidates []T, seedRank func(string) int, name func(T) string) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ri, rj := seedRank(name(candidates[i])), seedRank(name(candidates[j]))
+		if ri >= 0 && rj >= 0 {
+			return ri < rj
+		}
+		return ri >= 0 && rj < 0
+	})
+}
+
+// DetectCycle finds repeating cycles in a sequence of kernel events
+// It uses a rolling hash approach to efficiently find repeating patterns
+func (d *Detector) DetectCycle(events []KernelEvent, minCycleLen, maxCycleLen int) (*CycleInfo, error) {
+	if len(events) < minCycleLen*2 {
+		return nil, fmt.Errorf("not enough events (%d) for cycle detection (need at least %d)", len(events), minCycleLen*2)
+	}
+
+	// Create a sequence of hashed kernel names for faster comparison
+	hashes := d.eventHashes(events)
+
+	d.logf("Searching for cycles (length %d-%d) in %d kernel events...\n", minCycleLen, maxCycleLen, len(events))
+
+	// Try different cycle lengths, starting from minimum
+	for cycleLen := minCycleLen; cycleLen <= maxCycleLen && cycleLen <= len(events)/2; cycleLen++ {
+		info := tryCycleLength(hashes, events, cycleLen)
+		if info != nil && info.NumCycles >= 2 {
+			d.logf("Found cycle of length %d repeating %d times\n", cycleLen, info.NumCycles)
+			return info, nil
+		}
+
+		// Progress indicator
+		if cycleLen%100 == 0 {
+			d.logf("\rTrying cycle length %d...", cycleLen)
+		}
+	}
+
+	return nil, fmt.Errorf("no repeating cycle found in range [%d, %d]", minCycleLen, maxCycleLen)
+}
+
+// tryCycleLength checks if the sequence repeats with the given cycle length
+func tryCycleLength(hashes []uint64, events []KernelEvent, cycleLen int) *CycleInfo {
+	n := len(hashes)
+
+	// Try different starting positions (to handle warm-up phase)
+	for startOffset := 0; startOffset < cycleLen && startOffset < n/4; startOffset++ {
+		matches := 0
+		cycleIndices := []int{startOffset}
+
+		// Count how many complete cycles match
+		for pos := startOffset; pos+cycleLen <= n; pos += cycleLen {
+			if pos > startOffset {
+				// Check if this segment matches the first cycle
+				isMatch := true
+				for i := 0; i < cycleLen; i++ {
+					if hashes[startOffset+i] != hashes[pos+i] {
+						isMatch = false
+						break
+					}
+				}
+				if isMatch {
+					matches++
+					cycleIndices = append(cycleIndices, pos)
+				} else {
+					// Allow one mismatch and continue checking
+					break
+				}
+			} else {
+				matches++
+			}
+		}
+
+		// Found a good cycle
+		if matches >= 2 {
+			return &CycleInfo{
+				StartIndex:   startOffset,
+				CycleLength:  cycleLen,
+				NumCycles:    matches,
+				CycleIndices: cycleIndices,
+			}
+		}
+	}
+
+	return nil
+}
+
+// DetectCycleAuto automatically determines cycle length using autocorrelation-like approach
+func (d *Detector) DetectCycleAuto(events []KernelEvent) (*CycleInfo, error) {
+	if len(events) < d.opts.MinCycleLen*2 {
+		return nil, fmt.Errorf("not enough events for auto cycle detection")
+	}
+
+	d.logf("Auto-detecting cycle in %d kernel events...\n", len(events))
+
+	// Find potential cycle length by looking for repeated subsequences
+	// Start by finding the first occurrence of a repeated kernel name
+	firstRepeat := d.findFirstRepeat(events)
+	if firstRepeat == 0 {
+		return nil, fmt.Errorf("no repeated kernel found")
+	}
+
+	// Search around the first repeat position
+	minLen := max(d.opts.MinCycleLen, firstRepeat-100)
+	maxLen := min(len(events)/2, firstRepeat+1000)
+
+	return d.DetectCycle(events, minLen, maxLen)
+}
+
+// CyclePattern represents a detected cycle with its temporal position
+type CyclePattern struct {
+	Info       *CycleInfo
+	Signature  string
+	StartPos   int     // First occurrence position in trace
+	EndPos     int     // Last occurrence position in trace
+	CenterPos  float64 // Average position (for classification)
+	Anchor     string  // Anchor kernel name
+	Confidence float64 // 0-1: share of repetitions that verified times gap regularity
+	// Kernels before StartPos that no repetition covers, and the first few names
+	WarmupEvents  int
+	WarmupKernels []string
+}
+
+// patternConfidence scores a verified cycle: the fraction of repetition slots across
+// its span that passed verification, scaled down by how irregular the gaps between
+// repetitions are (coefficient of variation)
+func patternConfidence(info *CycleInfo) float64 {
+	n := len(info.CycleIndices)
+	if n < 2 || info.CycleLength <= 0 {
+		return 0
+	}
+	span := info.CycleIndices[n-1] + info.CycleLength - info.CycleIndices[0]
+	passed := math.Min(1, float64(n)/(float64(span)/float64(info.CycleLength)))
+
+	gaps := make([]float64, n-1)
+	mean := 0.0
+	for i := 1; i < n; i++ {
+		gaps[i-1] = float64(info.CycleIndices[i] - info.CycleIndices[i-1])
+		mean += gaps[i-1] / float64(n-1)
+	}
+	variance := 0.0
+	for _, g := range gaps {
+		variance += (g - mean) * (g - mean) / float64(n-1)
+	}
+	regularity := math.Max(0, 1-math.Sqrt(variance)/mean)
+
+	return passed * regularity
+}
+
+// DetectCycleBySignature uses a signature-based approach
+// It looks for a unique "anchor" kernel that appears periodically
+// and finds the MINIMUM cycle length (smallest repeating unit)
+func (d *Detector) DetectCycleBySignature(events []KernelEvent) (*CycleInfo, error) {
+	if len(events) < d.opts.MinCycleLen*2 {
+		return nil, fmt.Errorf("not enough events")
+	}
+
+	// Phase detection: Find ALL cycles, then classify by temporal position
+	var result *CycleInfo
+	var err error
+
+	switch d.opts.PhaseMode {
+	case "prefill", "decode":
+		result, err = d.detectPhaseByAllCycles(events, d.opts.PhaseMode)
+		if err != nil || result == nil {
+			d.logf("All-cycles detection failed, falling back to standard detection\n")
+			result, err = d.detectCycleStandard(events, 0)
+		}
+	default: // "auto"
+		result, err = d.detectCycleStandard(events, 0)
+	}
+
+	return result, err
+}
+
+// detectPhaseByAllCycles finds ALL distinct cycle patterns in the trace,
+// then classifies them by temporal position (earlier = prefill, later = decode)
+func (d *Detector) detectPhaseByAllCycles(events []KernelEvent, phase string) (*CycleInfo, error) {
+	d.logf("Detecting all cycle patterns in %d events...\n", len(events))
+
+	// Find all distinct cycle patterns
+	patterns := d.FindCyclePatterns(events)
+
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("no cycle patterns found")
+	}
+
+	d.logf("Found %d distinct cycle patterns:\n", len(patterns))
+	for i, p := range patterns {
+		d.logf("  %d. length=%d, reps=%d, confidence=%.2f, center=%.1f%%, sig=%s\n",
+			i+1, p.Info.CycleLength, p.Info.NumCycles, p.Confidence,
+			p.CenterPos/float64(len(events))*100,
+			TruncateString(p.Signature, 50))
+	}
+
+	// Classify: earliest center = prefill, latest center = decode
+	SortPatternsByPosition(patterns)
+	if phase == "prefill" {
+		// Return pattern with earliest center position
+		selected := patterns[0]
+		d.logf("Selected PREFILL pattern: center=%.1f%%, length=%d, reps=%d\n",
+			selected.CenterPos/float64(len(events))*100,
+			selected.Info.CycleLength, selected.Info.NumCycles)
+		return selected.Info, nil
+	} else { // decode
+		// Return pattern with latest center position
+		selected := patterns[len(patterns)-1]
+		d.logf("Selected DECODE pattern: center=%.1f%%, length=%d, reps=%d\n",
+			selected.CenterPos/float64(len(events))*100,
+			selected.Info.CycleLength, selected.Info.NumCycles)
+		return selected.Info, nil
+	}
+}
+
+// FindCyclePatterns finds all distinct cycle patterns in the events, ranked by
+// RankPatterns
+func (d *Detector) FindCyclePatterns(events []KernelEvent) []CyclePattern {
+	// Count kernel occurrences by the same key the positions and hashes use
+	keys := d.eventKeys(events)
+	positionsByKey := kernelPositions(keys)
+
+	// Find anchor candidates
+	type candidate struct {
+		name     string
+		count    int
+		cycleLen int
+	}
+	var candidates []candidate
+	for name, positions := range positionsByKey {
+		count := len(positions)
+		// Seeded anchors skip the upper frequency bound
+		if count >= 5 && (count <= d.maxAnchorCount(len(events)) || d.seedRank(name) >= 0) {
+			estimatedCycleLen := len(events) / count
+			candidates = append(candidates, candidate{name, count, estimatedCycleLen})
+		}
+	}
+
+	// Sort by count, then name so ties don't depend on map order
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].count != candidates[j].count {
+			return candidates[i].count > candidates[j].count
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	sortSeededFirst(candidates, d.seedRank, func(c candidate) string { return c.name })
+
+	candidateNames := make(map[string]bool, len(candidates))
+	for _, cand := range candidates {
+		candidateNames[cand.name] = true
+	}
+
+	// Find all valid cycles and group by signature
+	signatureGroups := make(map[string]*CyclePattern)
+	lengthCounts := make(map[int]int) // Verified cycle length -> number of anchors yielding it
+	hashes := hashKeys(keys)
+
+	for _, cand := range candidates {
+		positions := positionsByKey[cand.name]
+		if len(positions) < 5 {
+			continue
+		}
+
+		cycleLen := positions[1] - positions[0]
+		if !d.cycleLenInRange(cycleLen) {
+			continue
+		}
+
+		// Check consistency
+		isConsistent := true
+		for i := 2; i < len(positions); i++ {
+			diff := positions[i] - positions[i-1]
+			// Relaxed tolerance: 20% instead of 5%
+			if abs(diff-cycleLen) > max(1, cycleLen/5) {
+				isConsistent = false
+				break
+			}
+		}
+
+		if !isConsistent {
+			d.verbosef("  Anchor %s: inconsistent spacing (length %d)\n", TruncateString(cand.name, 40), cycleLen)
+			continue
+		}
+
+		// Verify the cycle
+		info := d.verifyCycle(hashes, positions[0], cycleLen, len(positions))
+		if info == nil || info.NumCycles < 5 {
+			d.verbosef("  Anchor %s: fewer than 5 verified repetitions (length %d)\n", TruncateString(cand.name, 40), cycleLen)
+			continue
+		}
+		d.verbosef("  Anchor %s: length=%d, reps=%d\n", TruncateString(cand.name, 40), info.CycleLength, info.NumCycles)
+
+		if d.opts.RequireDualAnchor && !hasSecondAnchor(keys, info, cand.name, candidateNames) {
+			continue
+		}
+		lengthCounts[info.CycleLength]++
+
+		// Look for sub-cycles
+		if info.CycleLength > 20 {
+			cycleEvents := events[info.StartIndex : info.StartIndex+info.CycleLength]
+			subCycle := d.findSubCycle(cycleEvents, events, info)
+			if subCycle != nil {
+				info = subCycle
+			}
+		}
+
+		// Get signature for this cycle
+		sig := getCycleSignature(events, info)
+
+		// Calculate temporal position
+		startPos := info.StartIndex
+		endPos := info.CycleIndices[len(info.CycleIndices)-1] + info.CycleLength
+		centerPos := float64(startPos+endPos) / 2.0
+
+		// Group by signature - keep the one with better stats
+		if existing, ok := signatureGroups[sig]; ok {
+			// Keep the pattern with more repetitions (a seeded anchor always wins)
+			seeded, existingSeeded := d.seedRank(cand.name) >= 0, d.seedRank(existing.Anchor) >= 0
+			if (seeded && !existingSeeded) || (seeded == existingSeeded && info.NumCycles > existing.Info.NumCycles) {
+				signatureGroups[sig] = &CyclePattern{
+					Info:          info,
+					Signature:     sig,
+					StartPos:      startPos,
+					EndPos:        endPos,
+					CenterPos:     centerPos,
+					Anchor:        cand.name,
+					Confidence:    patternConfidence(info),
+					WarmupEvents:  startPos,
+					WarmupKernels: WarmupKernels(events, startPos),
+				}
+			}
+		} else {
+			signatureGroups[sig] = &CyclePattern{
+				Info:          info,
+				Signature:     sig,
+				StartPos:      startPos,
+				EndPos:        endPos,
+				CenterPos:     centerPos,
+				Anchor:        cand.name,
+				Confidence:    patternConfidence(info),
+				WarmupEvents:  startPos,
+				WarmupKernels: WarmupKernels(events, startPos),
+			}
+		}
+	}
+
+	// Convert map to slice, in a fixed order so merging below is deterministic
+	var patterns []CyclePattern
+	for _, p := range signatureGroups {
+		patterns = append(patterns, *p)
+	}
+	SortPatternsByPosition(patterns)
+
+	if d.opts.Explain {
+		d.printLengthHistogram(lengthCounts)
+	}
+
+	// Second pass: merge similar patterns (>80% kernel overlap)
+	patterns = d.deduplicateSimilarPatterns(events, patterns)
+
+	RankPatterns(patterns)
+	return patterns
+}
+
+// RankPatterns orders patterns by most repetitions, then highest confidence, with the
+// signature breaking ties so the ranking is the same on every run
+func RankPatterns(patterns []CyclePattern) {
+	sort.SliceStable(patterns, func(i, j int) bool {
+		a, b := patterns[i], patterns[j]
+		if a.Info.NumCycles != b.Info.NumCycles {
+			return a.Info.NumCycles > b.Info.NumCycles
+		}
+		if a.Confidence != b.Confidence {
+			return a.Confidence > b.Confidence
+		}
+		return a.Signature < b.Signature
+	})
+}
+
+// SortPatternsByPosition orders patterns by center, then cycle length, then signature,
+// so the order (and _cycle_N numbering) is the same on every run over the same trace
+func SortPatternsByPosition(patterns []CyclePattern) {
+	sort.SliceStable(patterns, func(i, j int) bool {
+		a, b := patterns[i], patterns[j]
+		if a.CenterPos != b.CenterPos {
+			return a.CenterPos < b.CenterPos
+		}
+		if a.Info.CycleLength != b.Info.CycleLength {
+			return a.Info.CycleLength < b.Info.CycleLength
+		}
+		return a.Signature < b.Signature
+	})
+}
+
+// printLengthHistogram shows how many anchors verified a cycle at each length;
+// one dominant length means fixed-size steps, a spread means variable-length cycles
+func (d *Detector) printLengthHistogram(lengthCounts map[int]int) {
+	lengths := make([]int, 0, len(lengthCounts))
+	total := 0
+	for l, c := range lengthCounts {
+		lengths = append(lengths, l)
+		total += c
+	}
+	sort.Ints(lengths)
+
+	d.logf("Valid cycles by length (%d anchors, %d distinct lengths):\n", total, len(lengths))
+	for _, l := range lengths {
+		c := lengthCounts[l]
+		d.logf("  %6d kernels: %4d %s\n", l, c, percentBar(float64(c)/float64(total)*100, 40))
+	}
+}
+
+// percentBar renders pct (0-100) as a block bar of up to width characters
+func percentBar(pct float64, width int) string {
+	n := int(math.Round(pct / 100 * float64(width)))
+	n = max(0, min(n, width))
+	if n == 0 && pct > 0 {
+		n = 1 // Keep tiny shares visible
+	}
+	return strings.Repeat("█", n)
+}
+
+// hasSecondAnchor checks that another periodic candidate kernel (besides the anchor)
+// sits at the same offset within at least 90% of the verified cycle repetitions
+func hasSecondAnchor(keys []string, info *CycleInfo, anchor string, candidateNames map[string]bool) bool {
+	if len(info.CycleIndices) == 0 {
+		return false
+	}
+
+	// Offsets of candidate kernels that occur exactly once in the first cycle
+	first := info.CycleIndices[0]
+	offsets := make(map[string]int)
+	seen := make(map[string]int)
+	for i := 0; i < info.CycleLength && first+i < len(keys); i++ {
+		name := keys[first+i]
+		if name == anchor || !candidateNames[name] {
+			continue
+		}
+		seen[name]++
+		offsets[name] = i
+	}
+
+	required := len(info.CycleIndices) * 9 / 10
+	for name, off := range offsets {
+		if seen[name] != 1 {
+			continue
+		}
+		hits := 0
+		for _, start := range info.CycleIndices {
+			if start+off < len(keys) && keys[start+off] == name {
+				hits++
+			}
+		}
+		if hits >= max(required, 2) {
+			return true
+		}
+	}
+	return false
+}
+
+// deduplicateSimilarPatterns merges patterns that have >80% kernel signature overlap
+func (d *Detector) deduplicateSimilarPatterns(events []KernelEvent, patterns []CyclePattern) []CyclePattern {
+	if len(patterns) <= 1 {
+		return patterns
+	}
+
+	// Extract kernel signature sets for each pattern
+	type patternSigs struct {
+		pattern CyclePattern
+		sigs    map[string]float64 // kernel sig -> % of cycle
+	}
+	var allPatterns []patternSigs
+
+	for _, p := range patterns {
+		sigs := make(map[string]float64)
+		if p.Info != nil && p.Info.StartIndex+p.Info.CycleLength <= len(events) {
+			for i := 0; i < p.Info.CycleLength; i++ {
+				idx := p.Info.StartIndex + i
+				sig := KernelSignature(events[idx].Name)
+				// Weight by duration
+				sigs[sig] += events[idx].Duration
+			}
+			// Normalize to percentages
+			total := 0.0
+			for _, v := range sigs {
+				total += v
+			}
+			if total > 0 {
+				for k := range sigs {
+					sigs[k] = sigs[k] / total * 100
+				}
+			}
+		}
+		allPatterns = append(allPatterns, patternSigs{p, sigs})
+	}
+
+	// Group similar patterns
+	type group struct {
+		members []patternSigs
+	}
+	var groups []group
+	used := make(map[int]bool)
+
+	for i := 0; i < len(allPatterns); i++ {
+		if used[i] {
+			continue
+		}
+
+		// Start new group
+		g := group{members: []patternSigs{allPatterns[i]}}
+		used[i] = true
+
+		// Find similar patterns
+		for j := i + 1; j < len(allPatterns); j++ {
+			if used[j] {
+				continue
+			}
+
+			// Check length similarity (within 20%)
+			lenI := allPatterns[i].pattern.Info.CycleLength
+			lenJ := allPatterns[j].pattern.Info.CycleLength
+			if abs(lenI-lenJ) > max(lenI, lenJ)/5 {
+				continue
+			}
+
+			// Check kernel overlap (weighted Jaccard)
+			sim := computePatternSimilarity(allPatterns[i].sigs, allPatterns[j].sigs)
+			if sim >= 0.80 { // 80% similarity threshold
+				g.members = append(g.members, allPatterns[j])
+				used[j] = true
+			}
+		}
+
+		groups = append(groups, g)
+	}
+
+	// Pick best representative from each group
+	var result []CyclePattern
+	for _, g := range groups {
+		best := g.members[0]
+		for _, m := range g.members[1:] {
+			// Prefer a seeded anchor, then the pattern with more repetitions
+			seeded, bestSeeded := d.seedRank(m.pattern.Anchor) >= 0, d.seedRank(best.pattern.Anchor) >= 0
+			if (seeded && !bestSeeded) || (seeded == bestSeeded && m.pattern.Info.NumCycles > best.pattern.Info.NumCycles) {
+				best = m
+			}
+		}
+		if len(g.members) > 1 {
+			d.logf("  Merged %d similar patterns into one (anchor: %s)\n",
+				len(g.members), TruncateString(best.pattern.Anchor, 40))
+		}
+		result = append(result, best.pattern)
+	}
+
+	return result
+}
+
+// computePatternSimilarity computes weighted Jaccard similarity between two patterns
+func computePatternSimilarity(a, b map[string]float64) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	allKeys := make(map[string]bool)
+	for k := range a {
+		allKeys[k] = true
+	}
+	for k := range b {
+		allKeys[k] = true
+	}
+
+	minSum, maxSum := 0.0, 0.0
+	for k := range allKeys {
+		aVal := a[k]
+		bVal := b[k]
+		if aVal < bVal {
+			minSum += aVal
+			maxSum += bVal
+		} else {
+			minSum += bVal
+			maxSum += aVal
+		}
+	}
+
+	if maxSum == 0 {
+		return 0
+	}
+	return minSum / maxSum
+}
+
+// findOuterCycleWithSubcycle finds outer cycle and its sub-cycle in one go
+func (d *Detector) findOuterCycleWithSubcycle(searchEvents []KernelEvent, allEvents []KernelEvent, offset int) *CycleInfo {
+	outerCycle := d.findOuterCycle(searchEvents)
+	if outerCycle == nil {
+		return nil
+	}
+
+	// Adjust indices for offset
+	if offset > 0 {
+		outerCycle.StartIndex += offset
+		for i := range outerCycle.CycleIndices {
+			outerCycle.CycleIndices[i] += offset
+		}
+	}
+
+	// Look for sub-cycles
+	if outerCycle.CycleLength > 20 {
+		cycleEvents := allEvents[outerCycle.StartIndex : outerCycle.StartIndex+outerCycle.CycleLength]
+		subCycle := d.findSubCycle(cycleEvents, allEvents, outerCycle)
+		if subCycle != nil {
+			return subCycle
+		}
+	}
+
+	return outerCycle
+}
+
+// getCycleSignature returns a string signature of the cycle's kernel pattern
+// Used to compare if two cycles represent the same or different patterns
+func getCycleSignature(events []KernelEvent, cycle *CycleInfo) string {
+	if cycle == nil || cycle.StartIndex+cycle.CycleLength > len(events) {
+		return ""
+	}
+
+	// Build signature from kernel types in the cycle
+	var sigs []string
+	for i := 0; i < min(cycle.CycleLength, 10); i++ {
+		idx := cycle.StartIndex + i
+		if idx < len(events) {
+			sig := KernelSignature(events[idx].Name)
+			sigs = append(sigs, sig)
+		}
+	}
+	return strings.Join(sigs, "|")
+}
+
+// detectCycleStandard is the standard cycle detection (used for auto mode)
+func (d *Detector) detectCycleStandard(events []KernelEvent, offset int) (*CycleInfo, error) {
+	outerCycle := d.findOuterCycle(events)
+
+	// Adjust indices if we used an offset
+	if outerCycle != nil && offset > 0 {
+		outerCycle.StartIndex += offset
+		for i := range outerCycle.CycleIndices {
+			outerCycle.CycleIndices[i] += offset
+		}
+	}
+
+	// Look for sub-cycles within the outer cycle
+	if outerCycle != nil && outerCycle.CycleLength > 20 {
+		d.logf("Found outer cycle: length=%d, repetitions=%d\n",
+			outerCycle.CycleLength, outerCycle.NumCycles)
+		d.logf("Looking for sub-cycles within outer cycle...\n")
+
+		// Extract one cycle's worth of events
+		cycleEvents := events[outerCycle.StartIndex : outerCycle.StartIndex+outerCycle.CycleLength]
+		subCycle := d.findSubCycle(cycleEvents, events, outerCycle)
+		if subCycle != nil {
+			d.logf("Found sub-cycle: length=%d, repetitions=%d\n",
+				subCycle.CycleLength, subCycle.NumCycles)
+			return subCycle, nil
+		}
+	}
+
+	if outerCycle != nil {
+		return outerCycle, nil
+	}
+
+	return d.DetectCycleAuto(events)
+}
+
+// findOuterCycle finds repeating cycles using exact kernel name matching
+// Phase detection is done by temporal position (caller passes the right portion of trace)
+// This function finds the cycle with MOST repetitions (most reliable pattern)
+func (d *Detector) findOuterCycle(events []KernelEvent) *CycleInfo {
+	// Count kernel occurrences by the same key the positions and hashes use
+	keys := d.eventKeys(events)
+	positionsByKey := kernelPositions(keys)
+
+	// Find kernels that appear multiple times but not too frequently
+	type candidate struct {
+		name     string
+		count    int
+		cycleLen int
+	}
+	var candidates []candidate
+	var rejected anchorRejections
+	for name, positions := range positionsByKey {
+		count := len(positions)
+		if count >= 5 && (count <= d.maxAnchorCount(len(events)) || d.seedRank(name) >= 0) { // Require at least 5 occurrences
+			estimatedCycleLen := len(events) / count
+			candidates = append(candidates, candidate{name, count, estimatedCycleLen})
+		} else if count < 5 {
+			rejected.tooFew++
+		} else {
+			rejected.tooFrequent++
+		}
+	}
+
+	// Sort by count (most repetitions first - most reliable pattern)
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].count > candidates[j].count
+	})
+	sortSeededFirst(candidates, d.seedRank, func(c candidate) string { return c.name })
+
+	// Find valid cycles, collect all of them
+	type validCycle struct {
+		info   *CycleInfo
+		anchor string
+	}
+	var validCycles []validCycle
+	hashes := hashKeys(keys)
+
+	for _, cand := range candidates {
+		positions := positionsByKey[cand.name]
+		if len(positions) < 5 {
+			rejected.tooFew++
+			continue
+		}
+
+		cycleLen := positions[1] - positions[0]
+		if !d.cycleLenInRange(cycleLen) {
+			rejected.outOfRange++
+			continue
+		}
+
+		isConsistent := true
+		consistentCount := 1
+		for i := 2; i < len(positions); i++ {
+			diff := positions[i] - positions[i-1]
+			// Relaxed tolerance: 20% instead of 5%
+			if abs(diff-cycleLen) > max(1, cycleLen/5) {
+				isConsistent = false
+				break
+			}
+			consistentCount++
+		}
+
+		if !isConsistent || consistentCount < 5 {
+			rejected.inconsistent++
+			continue
+		}
+		info := d.verifyCycle(hashes, positions[0], cycleLen, len(positions))
+		if info != nil && info.NumCycles >= 5 {
+			validCycles = append(validCycles, validCycle{info, cand.name})
+			if d.seedRank(cand.name) >= 0 {
+				break // Seeded anchors come first; no need to search further
+			}
+		} else {
+			rejected.fewReps++
+		}
+	}
+
+	if len(validCycles) == 0 {
+		d.printRejections(rejected, len(positionsByKey))
+		return nil
+	}
+
+	// A seeded anchor that verified takes precedence over discovered ones
+	if d.seedRank(validCycles[0].anchor) >= 0 {
+		d.logf("Using seeded anchor: %s (%d reps)\n",
+			TruncateString(validCycles[0].anchor, 40), validCycles[0].info.NumCycles)
+		return validCycles[0].info
+	}
+
+	// Sort valid cycles by repetition count
+	switch d.opts.PhaseMode {
+	case "prefill":
+		// Return cycle with FEWEST repetitions
+		sort.Slice(validCycles, func(i, j int) bool {
+			return validCycles[i].info.NumCycles < validCycles[j].info.NumCycles
+		})
+		d.logf("Found PREFILL cycle: %d reps (anchor: %s)\n",
+			validCycles[0].info.NumCycles, TruncateString(validCycles[0].anchor, 40))
+	default: // "decode" or "auto"
+		// Return cycle with MOST repetitions
+		sort.Slice(validCycles, func(i, j int) bool {
+			return validCycles[i].info.NumCycles > validCycles[j].info.NumCycles
+		})
+		d.logf("Found DECODE cycle: %d reps (anchor: %s)\n",
+			validCycles[0].info.NumCycles, TruncateString(validCycles[0].anchor, 40))
+	}
+
+	return validCycles[0].info
+}
+
+// anchorRejections tallies why findOuterCycle discarded anchor candidates
+type anchorRejections struct {
+	tooFew       int // Fewer than 5 occurrences
+	tooFrequent  int // More than 1 in min(5, MinCycleLen) events
+	outOfRange   int // Spacing between first occurrences outside MinCycleLen..MaxCycleLen
+	inconsistent int // Spacing varies more than 20% between occurrences
+	fewReps      int // Fewer than 5 repetitions passed verification
+}
+
+// printRejections writes the rejection breakdown to the log
+func (d *Detector) printRejections(r anchorRejections, total int) {
+	d.logf("No outer cycle found: %d distinct kernels considered as anchors\n", total)
+	d.logf("  %d rejected: fewer than 5 occurrences\n", r.tooFew)
+	d.logf("  %d rejected: too frequent (more than 1 in %d events)\n", r.tooFrequent, max(1, min(5, d.opts.MinCycleLen)))
+	if d.opts.MaxCycleLen > 0 {
+		d.logf("  %d rejected: cycle length outside %d-%d kernels\n", r.outOfRange, d.opts.MinCycleLen, d.opts.MaxCycleLen)
+	} else {
+		d.logf("  %d rejected: cycle length under %d kernels\n", r.outOfRange, d.opts.MinCycleLen)
+	}
+	d.logf("  %d rejected: inconsistent spacing (>20%% variation)\n", r.inconsistent)
+	d.logf("  %d rejected: fewer than 5 verified repetitions\n", r.fewReps)
+}
+
+// findSubCycle looks for repeating patterns within a cycle using kernel type signatures
+func (d *Detector) findSubCycle(cycleEvents []KernelEvent, allEvents []KernelEvent, outerCycle *CycleInfo) *CycleInfo {
+	n := len(cycleEvents)
+
+	// Create type signatures for each kernel (simplified names for pattern matching)
+	signatures := make([]string, n)
+	for i, e := range cycleEvents {
+		signatures[i] = KernelSignature(e.Name)
+	}
+
+	// Find kernels that repeat within the cycle
+	sigCounts := make(map[string][]int) // signature -> positions within cycle
+	for i, sig := range signatures {
+		sigCounts[sig] = append(sigCounts[sig], i)
+	}
+
+	// Look for signatures that appear multiple times at regular intervals
+	var bestSubCycleLen int
+	var bestPositions []int
+
+	for sig, positions := range sigCounts {
+		if len(positions) < 3 {
+			continue
+		}
+
+		// Check if positions are evenly spaced
+		subCycleLen := positions[1] - positions[0]
+		if subCycleLen < 5 || subCycleLen >= n/2 {
+			continue
+		}
+
+		isConsistent := true
+		for i := 2; i < len(positions); i++ {
+			diff := positions[i] - positions[i-1]
+			if abs(diff-subCycleLen) > max(1, subCycleLen/10) {
+				isConsistent = false
+				break
+			}
+		}
+
+		if isConsistent && (bestSubCycleLen == 0 || subCycleLen < bestSubCycleLen) {
+			// Verify the sub-cycle using signatures
+			if d.verifySubCycleBySignature(signatures, positions[0], subCycleLen) {
+				bestSubCycleLen = subCycleLen
+				bestPositions = positions
+				d.verbosef("  Sub-cycle candidate: length=%d (anchor: %s)\n",
+					subCycleLen, TruncateString(sig, 40))
+			}
+		}
+	}
+
+	if bestSubCycleLen > 0 {
+		// Calculate total repetitions across all outer cycles
+		totalReps := len(bestPositions) * outerCycle.NumCycles
+
+		// Build cycle indices across all events
+		var cycleIndices []int
+		for _, outerStart := range outerCycle.CycleIndices {
+			for _, posInCycle := range bestPositions {
+				cycleIndices = append(cycleIndices, outerStart+posInCycle)
+			}
+		}
+
+		return &CycleInfo{
+			StartIndex:   outerCycle.StartIndex + bestPositions[0],
+			CycleLength:  bestSubCycleLen,
+			NumCycles:    totalReps,
+			CycleIndices: cycleIndices,
+		}
+	}
+
+	return nil
+}
+
+// verifySubCycleBySignature checks if the signature pattern repeats
+func (d *Detector) verifySubCycleBySignature(signatures []string, startIdx, cycleLen int) bool {
+	n := len(signatures)
+	matches := 0
+	checks := 0
+
+	for i := startIdx; i+cycleLen < n; i += cycleLen {
+		checks++
+		matchCount := 0
+		for j := 0; j < cycleLen && i+j < n && i+j+cycleLen < n; j++ {
+			if signatures[i+j] == signatures[i+j+cycleLen] {
+				matchCount++
+			}
+		}
+		// Signature match for sub-cycles is more lenient than exact (80% by default)
+		if float64(matchCount)/float64(cycleLen) >= d.stageTolerance(0.15) {
+			matches++
+		}
+	}
+
+	// Need at least 3 matching repetitions
+	return matches >= 3
+}
+
+// KernelSignature returns a simplified signature for a kernel name
+// This groups similar kernels together for pattern detection and matching
+func KernelSignature(name string) string {
+	// Strategy: extract the base kernel name by removing:
+	// 1. Template parameters (content in <>)
+	// 2. Configuration suffixes (GROUP_K_, BLOCK_SIZE_, etc. - common in eager mode)
+	// 3. Dimension suffixes (like _32x256, _128x64)
+	// 4. Common config prefixes (like _1tg_, _ps_)
+	// 5. Trailing numbers (like _0, _1)
+
+	sig := name
+
+	// Remove template parameters - find first < and truncate
+	if idx := strings.Index(sig, "<"); idx > 0 {
+		sig = sig[:idx]
+	}
+
+	// Remove configuration suffixes that appear in eager mode but not compiled
+	// These patterns indicate compile-time parameters
+	configPatterns := []string{
+		"_GROUP_K_", "_GROUP_N_", "_GROUP_SIZE_",
+		"_BLOCK_SIZE_", "_SPLITK_BLOCK_SIZE_",
+		"_NUM_KSPLIT_", "_ACTUAL_KSPLIT_", "_MAX_KSPLIT_",
+		"_GRID_MN_", "_GRID_",
+		"_EVEN_K_", "_cache_modifier_",
+	}
+	for _, pattern := range configPatterns {
+		if idx := strings.Index(sig, pattern); idx > 0 {
+			sig = sig[:idx]
+		}
+	}
+
+	// Remove dimension suffixes like _32x256, _128x64, _NxM pattern
+	for i := len(sig) - 1; i >= 0; i-- {
+		if sig[i] == '_' {
+			suffix := sig[i+1:]
+			if isDimensionSuffix(suffix) {
+				sig = sig[:i]
+				break
+			}
+		}
+	}
+
+	// Remove common config suffixes that vary between implementations
+	configSuffixes := []string{"_1tg_ps", "_1tg", "_ps", "_novs", "_vs"}
+	for _, suffix := range configSuffixes {
+		if idx := strings.LastIndex(sig, suffix); idx > 0 {
+			sig = sig[:idx]
+		}
+	}
+
+	// Remove trailing numbers (like _0, _1, _9)
+	for len(sig) > 2 && sig[len(sig)-1] >= '0' && sig[len(sig)-1] <= '9' && sig[len(sig)-2] == '_' {
+		sig = sig[:len(sig)-2]
+	}
+
+	// Clean up any trailing underscores
+	sig = strings.TrimRight(sig, "_")
+
+	// If signature is empty or too short, use a hash
+	if len(sig) < 3 {
+		return fmt.Sprintf("other_%d", hashString(name)%1000)
+	}
+
+	return sig
+}
+
+// isDimensionSuffix checks if a string matches NxM or NUMxNUM pattern (e.g., "32x256")
+func isDimensionSuffix(s string) bool {
+	if len(s) < 3 {
+		return false
+	}
+	xIdx := strings.Index(s, "x")
+	if xIdx <= 0 || xIdx >= len(s)-1 {
+		return false
+	}
+	// Check that parts before and after 'x' are numbers
+	for i := 0; i < xIdx; i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	for i := xIdx + 1; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// kernelPositions returns the event indices of each distinct key, in trace order
+func kernelPositions(keys []string) map[string][]int {
+	positions := make(map[string][]int)
+	for i, key := range keys {
+		positions[key] = append(positions[key], i)
+	}
+	return positions
+}
+
+// verifyCycle counts repetitions of the cycle at startIdx over precomputed name hashes
+func (d *Detector) verifyCycle(hashes []uint64, startIdx, cycleLen, expectedCycles int) *CycleInfo {
+
+	cycleIndices := []int{startIdx}
+	matches := 1
+
+	for i := 1; i < expectedCycles; i++ {
+		pos := startIdx + i*cycleLen
+		if pos+cycleLen > len(hashes) {
+			break
+		}
+
+		// Check match with tolerance for slight variations
+		matchCount := 0
+		for j := 0; j < cycleLen; j++ {
+			if hashes[startIdx+j] == hashes[pos+j] {
+				matchCount++
+			}
+		}
+
+		// Require MatchTolerance (95% by default)
+		if float64(matchCount)/float64(cycleLen) >= d.stageTolerance(0) {
+			matches++
+			cycleIndices = append(cycleIndices, pos)
+		}
+	}
+
+	if matches >= 2 {
+		return &CycleInfo{
+			StartIndex:   startIdx,
+			CycleLength:  cycleLen,
+			NumCycles:    matches,
+			CycleIndices: cycleIndices,
+		}
+	}
+	return nil
+}
+
+func (d *Detector) findFirstRepeat(events []KernelEvent) int {
+	seen := make(map[uint64]int)
+	for i, h := range d.eventHashes(events) {
+		if _, exists := seen[h]; exists {
+			return i
+		}
+		seen[h] = i
+	}
+	return 0
+}
+
+// eventHashes hashes each event's kernel name (normalized if Options.Normalize is set), so
+// callers verifying many candidate cycles hash the trace once
+func (d *Detector) eventHashes(events []KernelEvent) []uint64 {
+	return hashKeys(d.eventKeys(events))
+}
+
+// eventKeys returns each event's kernelKey, calling Options.Normalize once per distinct
+// name rather than once per comparison
+func (d *Detector) eventKeys(events []KernelEvent) []string {
+	keys := make([]string, len(events))
+	memo := make(map[string]string)
+	for i, e := range events {
+		key, ok := memo[e.Name]
+		if !ok {
+			key = d.kernelKey(e.Name)
+			memo[e.Name] = key
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+// hashKeys hashes each key, once per distinct key
+func hashKeys(keys []string) []uint64 {
+	hashes := make([]uint64, len(keys))
+	memo := make(map[string]uint64)
+	for i, key := range keys {
+		h, ok := memo[key]
+		if !ok {
+			h = hashString(key)
+			memo[key] = h
+		}
+		hashes[i] = h
+	}
+	return hashes
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// kernelKey is the form of a kernel name the detectors compare: mapped through
+// Options.Normalize when set (grouping e.g. numbered triton variants), raw otherwise
+func (d *Detector) kernelKey(name string) string {
+	if d.opts.Normalize != nil {
+		return d.opts.Normalize(name)
+	}
+	return name
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// TruncateString shortens s to maxLen bytes, ending in "..." when cut
+func TruncateString(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	return s[:maxLen-3] + "..."
+}
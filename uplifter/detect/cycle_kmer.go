@@ -0,0 +1,543 @@
+package detect
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+// KmerCycle represents a cycle found using k-mer detection
+type KmerCycle struct {
+	StartIndex  int
+	Length      int
+	Repetitions int
+	AnchorKmer  string // The k-mer used as anchor
+	K           int    // Length of the anchor k-mer
+}
+
+// DetectCyclesKmer finds cycles using k-mer (kernel sequence) anchors
+// Instead of using single kernels as anchors, we use sequences of k consecutive kernels
+// This handles cases where the same kernel appears multiple times per cycle
+func (d *Detector) DetectCyclesKmer(events []KernelEvent, k int, minCycleLen int) []KmerCycle {
+	cycles, _ := d.detectCyclesKmer(events, k, minCycleLen)
+	return cycles
+}
+
+// detectCyclesKmer is DetectCyclesKmer that also returns how many cycles
+// deduplicateCycles merged away
+func (d *Detector) detectCyclesKmer(events []KernelEvent, k int, minCycleLen int) ([]KmerCycle, int) {
+	if len(d.opts.AnchorKmer) > 0 {
+		return d.detectCyclesFromAnchorKmer(events, d.opts.AnchorKmer, minCycleLen)
+	}
+
+	var cycles []KmerCycle
+	n := len(events)
+
+	if n < minCycleLen*2+k {
+		return cycles, 0
+	}
+
+	d.logf("K-mer cycle detection (k=%d) on %d events...\n", k, n)
+
+	// Step 1: Create k-mers and track their positions
+	type kmerInfo struct {
+		hash      uint64
+		positions []int
+		signature string // First 50 chars for display
+	}
+	kmers := make(map[uint64]*kmerInfo)
+	hashes := d.eventHashes(events)
+
+	for i := 0; i <= n-k; i++ {
+		hash := hashKmer(hashes, i, k)
+		if info, exists := kmers[hash]; exists {
+			info.positions = append(info.positions, i)
+		} else {
+			sig := events[i].Name
+			if len(sig) > 50 {
+				sig = sig[:50]
+			}
+			kmers[hash] = &kmerInfo{
+				hash:      hash,
+				positions: []int{i},
+				signature: sig,
+			}
+		}
+	}
+
+	d.verbosef("  Created %d unique %d-mers\n", len(kmers), k)
+
+	// Step 2: Find k-mers with regular intervals (good anchors)
+	type anchorCandidate struct {
+		hash      uint64
+		cycleLen  int
+		positions []int
+		signature string
+		score     int // Number of consistent intervals
+	}
+	var candidates []anchorCandidate
+
+	for _, info := range kmers {
+		if len(info.positions) < 5 {
+			continue // Need at least 5 occurrences
+		}
+
+		// Check if positions have consistent intervals
+		cycleLen := info.positions[1] - info.positions[0]
+		if cycleLen < minCycleLen || (d.opts.MaxCycleLen > 0 && cycleLen > d.opts.MaxCycleLen) {
+			continue
+		}
+
+		consistent := 0
+		for i := 2; i < len(info.positions); i++ {
+			diff := info.positions[i] - info.positions[i-1]
+			// Allow 10% tolerance
+			if abs(diff-cycleLen) <= max(1, cycleLen/10) {
+				consistent++
+			}
+		}
+
+		// Require at least 80% consistent intervals
+		if float64(consistent)/float64(len(info.positions)-1) >= 0.8 {
+			candidates = append(candidates, anchorCandidate{
+				hash:      info.hash,
+				cycleLen:  cycleLen,
+				positions: info.positions,
+				signature: info.signature,
+				score:     consistent,
+			})
+		}
+	}
+
+	d.verbosef("  Found %d anchor candidates with regular intervals\n", len(candidates))
+
+	if len(candidates) == 0 {
+		return cycles, 0
+	}
+
+	// Step 3: Sort by score (most consistent first) and group by cycle length
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+
+	// Group candidates by similar cycle lengths
+	var usedRanges []eventRange // Event ranges already claimed by found cycles
+
+	for _, cand := range candidates {
+		// Cheap check before verifying: a start inside a claimed range always overlaps
+		if (eventRange{cand.positions[0], cand.positions[0] + 1}).overlapsAny(usedRanges) {
+			continue
+		}
+
+		// Verify this is a real cycle
+		reps := d.verifyKmerCycle(hashes, cand.positions[0], cand.cycleLen)
+		if reps >= 5 {
+			// Skip if the verified range overlaps with already found cycles
+			r := eventRange{cand.positions[0], cand.positions[0] + cand.cycleLen*reps}
+			if r.overlapsAny(usedRanges) {
+				continue
+			}
+
+			cycles = append(cycles, KmerCycle{
+				StartIndex:  cand.positions[0],
+				Length:      cand.cycleLen,
+				Repetitions: reps,
+				AnchorKmer:  cand.signature,
+				K:           k,
+			})
+			usedRanges = append(usedRanges, r)
+
+			d.logf("  Found cycle: length=%d, reps=%d, anchor=%s...\n",
+				cand.cycleLen, reps, TruncateString(cand.signature, 40))
+		}
+	}
+
+	// Sort cycles by start position
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i].StartIndex < cycles[j].StartIndex
+	})
+
+	// Deduplicate: group cycles by length and merge similar patterns
+	found := len(cycles)
+	cycles = d.deduplicateCycles(events, cycles)
+
+	d.logf("Found %d distinct cycles after deduplication\n", len(cycles))
+	return cycles, found - len(cycles)
+}
+
+// DetectCyclesKmerSweep runs DetectCyclesKmer for every k in [kMin, kMax] and keeps
+// the k whose cycles repeat most faithfully: the highest match quality (see
+// cycleMatchQuality) weighted by the events each cycle covers, then the fewest
+// cycles merged by deduplication, then the most events covered, then the smaller k.
+// Cycles other k values find outside the chosen k's ranges are added, and
+// deduplicateCycles runs across the combined set
+func (d *Detector) DetectCyclesKmerSweep(events []KernelEvent, kMin, kMax, minCycleLen int) []KmerCycle {
+	type sweepResult struct {
+		k          int
+		cycles     []KmerCycle
+		duplicates int
+		coverage   int
+		quality    float64
+	}
+	hashes := d.eventHashes(events)
+	var results []sweepResult
+	for k := kMin; k <= kMax; k++ {
+		cycles, duplicates := d.detectCyclesKmer(events, k, minCycleLen)
+		res := sweepResult{k: k, cycles: cycles, duplicates: duplicates}
+		var weighted float64
+		for _, c := range cycles {
+			covered := c.Length * c.Repetitions
+			res.coverage += covered
+			weighted += cycleMatchQuality(hashes, c) * float64(covered)
+		}
+		if res.coverage > 0 {
+			res.quality = weighted / float64(res.coverage)
+		}
+		results = append(results, res)
+	}
+
+	best := 0
+	for i, res := range results {
+		b := results[best]
+		switch {
+		case res.quality != b.quality:
+			if res.quality > b.quality {
+				best = i
+			}
+		case res.duplicates != b.duplicates:
+			if res.duplicates < b.duplicates {
+				best = i
+			}
+		case res.coverage > b.coverage:
+			best = i
+		}
+	}
+
+	d.logf("K-mer sweep:\n")
+	for i, res := range results {
+		marker := ""
+		if i == best {
+			marker = "  <- chosen"
+		}
+		d.logf("  k=%d: %d cycles (%d duplicates) covering %d of %d events, match %.1f%%%s\n",
+			res.k, len(res.cycles), res.duplicates, res.coverage, len(events), res.quality*100, marker)
+	}
+
+	combined := append([]KmerCycle(nil), results[best].cycles...)
+	var used []eventRange
+	for _, c := range combined {
+		used = append(used, eventRange{c.StartIndex, c.StartIndex + c.Length*c.Repetitions})
+	}
+	for i, res := range results {
+		if i == best {
+			continue
+		}
+		for _, c := range res.cycles {
+			r := eventRange{c.StartIndex, c.StartIndex + c.Length*c.Repetitions}
+			if !r.overlapsAny(used) {
+				combined = append(combined, c)
+				used = append(used, r)
+			}
+		}
+	}
+	sort.Slice(combined, func(i, j int) bool {
+		return combined[i].StartIndex < combined[j].StartIndex
+	})
+	combined = d.deduplicateCycles(events, combined)
+	d.logf("Found %d distinct cycles across k=%d-%d\n", len(combined), kMin, kMax)
+	return combined
+}
+
+// cycleMatchQuality is the fraction of kernels in each repetition after the first that
+// match the first repetition position by position, averaged over the repetitions (1 for
+// a single repetition)
+func cycleMatchQuality(hashes []uint64, c KmerCycle) float64 {
+	if c.Repetitions < 2 {
+		return 1
+	}
+	first := hashes[c.StartIndex : c.StartIndex+c.Length]
+	matches := 0
+	for rep := 1; rep < c.Repetitions; rep++ {
+		start := c.StartIndex + rep*c.Length
+		for j, h := range hashes[start : start+c.Length] {
+			if h == first[j] {
+				matches++
+			}
+		}
+	}
+	return float64(matches) / float64(c.Length*(c.Repetitions-1))
+}
+
+// eventRange is a half-open [start, end) range of event indices
+type eventRange struct {
+	start, end int
+}
+
+// overlapsAny reports whether r intersects any of the given ranges
+func (r eventRange) overlapsAny(ranges []eventRange) bool {
+	for _, o := range ranges {
+		if r.start < o.end && o.start < r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// detectCyclesFromAnchorKmer derives cycles from the positions of a user-provided
+// kernel sequence, using the most common spacing between occurrences as the cycle length
+func (d *Detector) detectCyclesFromAnchorKmer(events []KernelEvent, anchor []string, minCycleLen int) ([]KmerCycle, int) {
+	var cycles []KmerCycle
+	k := len(anchor)
+	signature := strings.Join(anchor, ",")
+
+	d.logf("K-mer cycle detection with pinned anchor (k=%d) on %d events...\n", k, len(events))
+
+	hashes := d.eventHashes(events)
+	anchorHashes := make([]uint64, k)
+	for j, name := range anchor {
+		anchorHashes[j] = hashString(d.kernelKey(name))
+	}
+	var positions []int
+	for i := 0; i+k <= len(events); i++ {
+		matched := true
+		for j := 0; j < k; j++ {
+			if hashes[i+j] != anchorHashes[j] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			positions = append(positions, i)
+		}
+	}
+
+	d.logf("  Anchor k-mer occurs %d times\n", len(positions))
+	if len(positions) < 2 {
+		return cycles, 0
+	}
+
+	// Most common spacing is the cycle length
+	gapCounts := make(map[int]int)
+	for i := 1; i < len(positions); i++ {
+		if gap := positions[i] - positions[i-1]; gap >= minCycleLen {
+			gapCounts[gap]++
+		}
+	}
+	cycleLen, bestCount := 0, 0
+	for gap, count := range gapCounts {
+		if count > bestCount || (count == bestCount && gap < cycleLen) {
+			cycleLen, bestCount = gap, count
+		}
+	}
+	if cycleLen == 0 {
+		d.logf("  No anchor spacing >= %d kernels\n", minCycleLen)
+		return cycles, 0
+	}
+
+	// Each run of regularly spaced occurrences is a separate cycle region
+	coveredUntil := -1
+	for i, pos := range positions {
+		if pos < coveredUntil || i+1 >= len(positions) || positions[i+1]-pos != cycleLen {
+			continue
+		}
+		reps := d.verifyKmerCycle(hashes, pos, cycleLen)
+		if reps < 2 {
+			continue
+		}
+		cycles = append(cycles, KmerCycle{
+			StartIndex:  pos,
+			Length:      cycleLen,
+			Repetitions: reps,
+			AnchorKmer:  signature,
+			K:           k,
+		})
+		coveredUntil = pos + cycleLen*reps
+		d.logf("  Found cycle: length=%d, reps=%d, start=%d\n", cycleLen, reps, pos)
+	}
+
+	found := len(cycles)
+	cycles = d.deduplicateCycles(events, cycles)
+	d.logf("Found %d distinct cycles after deduplication\n", len(cycles))
+	return cycles, found - len(cycles)
+}
+
+// deduplicateCycles removes duplicate cycle patterns
+func (d *Detector) deduplicateCycles(events []KernelEvent, cycles []KmerCycle) []KmerCycle {
+	if len(cycles) == 0 {
+		return cycles
+	}
+
+	// Group cycles by similar length (within 20%)
+	type cycleGroup struct {
+		cycles    []KmerCycle
+		signature string    // Kernel sequence signature
+		durations []float64 // Durations of the signature kernels
+	}
+	var groups []cycleGroup
+
+	for _, c := range cycles {
+		// Create signature from first 10 kernels
+		sig := getCycleSignatureSimple(events, c.StartIndex, c.Length)
+
+		// Find matching group
+		found := false
+		for i := range groups {
+			// Check if lengths are similar (within 20%)
+			existingLen := groups[i].cycles[0].Length
+			if abs(c.Length-existingLen) <= max(existingLen/5, 2) {
+				// Check if signatures match (could be rotated)
+				matched := false
+				if d.opts.WeightDedupByDuration {
+					matched = signaturesMatchWeighted(groups[i].signature, groups[i].durations, sig)
+				} else {
+					matched = signaturesMatch(groups[i].signature, sig)
+				}
+				if matched {
+					groups[i].cycles = append(groups[i].cycles, c)
+					found = true
+					break
+				}
+			}
+		}
+
+		if !found {
+			groups = append(groups, cycleGroup{
+				cycles:    []KmerCycle{c},
+				signature: sig,
+				durations: getCycleDurationsSimple(events, c.StartIndex, c.Length),
+			})
+		}
+	}
+
+	// For each group, keep the cycle with most repetitions
+	var result []KmerCycle
+	for _, g := range groups {
+		best := g.cycles[0]
+		for _, c := range g.cycles[1:] {
+			if c.Repetitions > best.Repetitions {
+				best = c
+			}
+		}
+		result = append(result, best)
+	}
+
+	// Sort by center position (temporal order)
+	sort.Slice(result, func(i, j int) bool {
+		centerI := result[i].StartIndex + result[i].Length*result[i].Repetitions/2
+		centerJ := result[j].StartIndex + result[j].Length*result[j].Repetitions/2
+		return centerI < centerJ
+	})
+
+	return result
+}
+
+// getCycleSignatureSimple creates a simple signature from kernel names
+func getCycleSignatureSimple(events []KernelEvent, start, length int) string {
+	var parts []string
+	count := min(10, length) // Use first 10 kernels
+	for i := 0; i < count; i++ {
+		name := events[start+i].Name
+		// Simplify: take first 30 chars
+		if len(name) > 30 {
+			name = name[:30]
+		}
+		parts = append(parts, name)
+	}
+	return strings.Join(parts, "|")
+}
+
+// getCycleDurationsSimple returns the durations of the kernels used by getCycleSignatureSimple
+func getCycleDurationsSimple(events []KernelEvent, start, length int) []float64 {
+	count := min(10, length)
+	durations := make([]float64, count)
+	for i := 0; i < count; i++ {
+		durations[i] = events[start+i].Duration
+	}
+	return durations
+}
+
+// signaturesMatchWeighted is signaturesMatch with each kernel of sig1 weighted by its duration
+func signaturesMatchWeighted(sig1 string, durations1 []float64, sig2 string) bool {
+	parts1 := strings.Split(sig1, "|")
+	parts2 := strings.Split(sig2, "|")
+	if len(durations1) != len(parts1) {
+		return signaturesMatch(sig1, sig2)
+	}
+
+	present := make(map[string]bool, len(parts2))
+	for _, p := range parts2 {
+		present[p] = true
+	}
+
+	var matched, total float64
+	for i, p1 := range parts1 {
+		total += durations1[i]
+		if present[p1] {
+			matched += durations1[i]
+		}
+	}
+
+	// Fall back to count-based matching when there's no timing
+	if total == 0 {
+		return signaturesMatch(sig1, sig2)
+	}
+	return matched/total >= 0.8
+}
+
+// signaturesMatch checks if two signatures represent the same cycle (possibly rotated)
+func signaturesMatch(sig1, sig2 string) bool {
+	// Strict check: at least 80% of kernels must match
+	parts1 := strings.Split(sig1, "|")
+	parts2 := strings.Split(sig2, "|")
+
+	matches := 0
+	for _, p1 := range parts1 {
+		for _, p2 := range parts2 {
+			if p1 == p2 {
+				matches++
+				break
+			}
+		}
+	}
+
+	// Require 80% match for deduplication
+	threshold := len(parts1) * 8 / 10
+	if threshold < 1 {
+		threshold = 1
+	}
+	return matches >= threshold
+}
+
+// hashKmer creates a hash for k consecutive kernels from their eventHashes
+func hashKmer(hashes []uint64, start, k int) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, x := range hashes[start : start+k] {
+		binary.LittleEndian.PutUint64(buf[:], x)
+		h.Write(buf[:])
+	}
+	return h.Sum64()
+}
+
+// verifyKmerCycle counts how many times the cycle repeats with 90% match, over
+// eventHashes
+func (d *Detector) verifyKmerCycle(hashes []uint64, start, length int) int {
+	n := len(hashes)
+	reps := 1
+
+	for pos := start + length; pos+length <= n; pos += length {
+		matches := 0
+		for j := 0; j < length; j++ {
+			if hashes[start+j] == hashes[pos+j] {
+				matches++
+			}
+		}
+		if float64(matches)/float64(length) >= d.stageTolerance(0.05) {
+			reps++
+		} else {
+			break
+		}
+	}
+	return reps
+}
@@ -0,0 +1,113 @@
+package detect
+
+// Quick checks for callers that stop reading a trace as soon as a cycle shows up
+// (early-stop parsing, streaming extraction): no staged search, just an anchor kernel
+// at a common gap and a positional match against the first repetition
+
+// quickSlack is how far below MatchTolerance a quick repetition match may fall
+const quickSlack = 0.05
+
+// DetectCycleQuick looks for a kernel recurring at a common gap in [minCycle, maxCycle]
+// and verifies it with VerifyCycleQuick; it returns nil until at least 5 repetitions
+// are present
+func (d *Detector) DetectCycleQuick(events []KernelEvent, minCycle, maxCycle int) *CycleInfo {
+	if len(events) < minCycle*3 {
+		return nil
+	}
+
+	keys := d.eventKeys(events)
+	hashes := hashKeys(keys)
+
+	// Find the most promising anchor (appears at regular intervals)
+	for _, positions := range kernelPositions(keys) {
+		if len(positions) < 5 {
+			continue
+		}
+
+		// Find the most common gap
+		gapCounts := make(map[int]int)
+		for i := 1; i < len(positions); i++ {
+			if gap := positions[i] - positions[i-1]; gap >= minCycle && gap <= maxCycle {
+				gapCounts[gap]++
+			}
+		}
+
+		for gap, count := range gapCounts {
+			if count >= 4 { // At least 4 consistent repetitions
+				info := d.verifyCycleQuick(hashes, gap, positions[0])
+				if info != nil && info.NumCycles >= 5 {
+					return info
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// VerifyCycleQuick counts the back-to-back repetitions of the cycleLen kernels at
+// startIdx that match the first one (MatchTolerance less 5 points); nil below 5
+func (d *Detector) VerifyCycleQuick(events []KernelEvent, cycleLen, startIdx int) *CycleInfo {
+	return d.verifyCycleQuick(d.eventHashes(events), cycleLen, startIdx)
+}
+
+func (d *Detector) verifyCycleQuick(hashes []uint64, cycleLen, startIdx int) *CycleInfo {
+	if startIdx+cycleLen*3 > len(hashes) {
+		return nil
+	}
+
+	first := hashes[startIdx : startIdx+cycleLen]
+	cycleIndices := []int{startIdx}
+	for pos := startIdx + cycleLen; pos+cycleLen <= len(hashes); pos += cycleLen {
+		if !d.quickMatch(first, hashes[pos:pos+cycleLen]) {
+			break
+		}
+		cycleIndices = append(cycleIndices, pos)
+	}
+
+	if len(cycleIndices) < 5 {
+		return nil
+	}
+	return &CycleInfo{
+		StartIndex:   startIdx,
+		CycleLength:  cycleLen,
+		NumCycles:    len(cycleIndices),
+		CycleIndices: cycleIndices,
+	}
+}
+
+// quickMatch reports whether enough positions of rep hold the same kernel as reference
+func (d *Detector) quickMatch(reference, rep []uint64) bool {
+	matchCount := 0
+	for i, h := range rep {
+		if h == reference[i] {
+			matchCount++
+		}
+	}
+	return float64(matchCount)/float64(len(reference)) >= d.stageTolerance(quickSlack)
+}
+
+// RepetitionMatcher returns VerifyCycleQuick's per-repetition test against reference,
+// for callers that see one repetition at a time; each call takes a slice as long as
+// reference
+func (d *Detector) RepetitionMatcher(reference []KernelEvent) func(rep []KernelEvent) bool {
+	memo := make(map[string]uint64)
+	hash := func(name string) uint64 {
+		h, ok := memo[name]
+		if !ok {
+			h = hashString(d.kernelKey(name))
+			memo[name] = h
+		}
+		return h
+	}
+	ref := make([]uint64, len(reference))
+	for i, e := range reference {
+		ref[i] = hash(e.Name)
+	}
+	rep := make([]uint64, len(reference))
+	return func(events []KernelEvent) bool {
+		for i, e := range events {
+			rep[i] = hash(e.Name)
+		}
+		return d.quickMatch(ref, rep)
+	}
+}
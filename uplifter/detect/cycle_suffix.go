@@ -0,0 +1,174 @@
+package detect
+
+import "sort"
+
+// suffixSamples are the points (as fractions of the trace) whose suffixes are matched
+// against themselves; sampling the middle keeps warmup and tail from deciding the period
+var suffixSamples = []float64{0.25, 0.5, 0.75}
+
+// suffixMaxCandidates caps how many candidate periods get a full coverage scan
+const suffixMaxCandidates = 64
+
+// suffixBulkSlack is how much less of the trace (as a fraction) the chosen period may
+// explain than the best candidate, so the shortest near-best period wins
+const suffixBulkSlack = 0.05
+
+// zFunction returns z where z[i] is the length of the longest common prefix of s and
+// s[i:] (z[0] = len(s))
+func zFunction(s []uint64) []int {
+	n := len(s)
+	z := make([]int, n)
+	if n == 0 {
+		return z
+	}
+	z[0] = n
+	for i, l, r := 1, 0, 0; i < n; i++ {
+		if i < r {
+			z[i] = min(r-i, z[i-l])
+		}
+		for i+z[i] < n && s[z[i]] == s[i+z[i]] {
+			z[i]++
+		}
+		if i+z[i] > r {
+			l, r = i, i+z[i]
+		}
+	}
+	return z
+}
+
+// periodCoverage is the fraction of positions i whose kernel equals the one p later,
+// i.e. how much of the trace period p explains
+func periodCoverage(hashes []uint64, p int) float64 {
+	if p >= len(hashes) {
+		return 0
+	}
+	same := 0
+	for i := 0; i+p < len(hashes); i++ {
+		if hashes[i] == hashes[i+p] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(hashes)-p)
+}
+
+// DetectCycleSuffix finds the shortest period that explains the bulk of the trace
+// without relying on an anchor kernel, so it works when every kernel appears several
+// times per cycle. A Z-function over the hashed-name sequence from a few sample points
+// gives candidate periods (at least three exact repetitions); the shortest one whose
+// coverage is within suffixBulkSlack of the best is then extended from its sample,
+// one MatchTolerance-checked repetition at a time in both directions
+func (d *Detector) DetectCycleSuffix(events []KernelEvent, minCycleLen, maxCycleLen int) *CycleInfo {
+	n := len(events)
+	minCycleLen = max(minCycleLen, 1)
+	if maxCycleLen <= 0 || maxCycleLen > n/3 {
+		maxCycleLen = n / 3
+	}
+	if minCycleLen > maxCycleLen {
+		return nil
+	}
+
+	hashes := d.eventHashes(events)
+
+	// Candidate period -> the sample it repeats from for longest
+	type candidate struct {
+		period, sample, run int
+	}
+	found := make(map[int]candidate)
+	for _, frac := range suffixSamples {
+		sample := int(float64(n) * frac)
+		z := zFunction(hashes[sample:])
+		perSample := 0
+		for p := minCycleLen; p <= maxCycleLen && p < len(z) && perSample < suffixMaxCandidates; p++ {
+			if z[p] < 2*p {
+				continue
+			}
+			perSample++
+			if c, ok := found[p]; !ok || z[p] > c.run {
+				found[p] = candidate{period: p, sample: sample, run: z[p]}
+			}
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	candidates := make([]candidate, 0, len(found))
+	for _, c := range found {
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].period < candidates[j].period })
+	if len(candidates) > suffixMaxCandidates {
+		candidates = candidates[:suffixMaxCandidates]
+	}
+
+	coverage := make([]float64, len(candidates))
+	best := 0.0
+	for i, c := range candidates {
+		coverage[i] = periodCoverage(hashes, c.period)
+		if coverage[i] > best {
+			best = coverage[i]
+		}
+	}
+	var chosen candidate
+	for i, c := range candidates {
+		if coverage[i] >= best-suffixBulkSlack {
+			chosen = c
+			break
+		}
+	}
+	if d.opts.Explain {
+		d.logf("Suffix detection: %d candidate periods, best coverage %.1f%%, chose period %d (%.1f%%)\n",
+			len(candidates), best*100, chosen.period, periodCoverage(hashes, chosen.period)*100)
+	}
+
+	return d.extendPeriod(hashes, chosen.sample, chosen.period)
+}
+
+// extendPeriod grows a cycle of length p from the repetition at start, backwards and
+// forwards, while each repetition matches it in at least MatchTolerance of positions
+func (d *Detector) extendPeriod(hashes []uint64, start, p int) *CycleInfo {
+	reference := hashes[start : start+p]
+	matches := func(pos int) bool {
+		same := 0
+		for i, h := range reference {
+			if hashes[pos+i] == h {
+				same++
+			}
+		}
+		return float64(same)/float64(p) >= d.opts.MatchTolerance
+	}
+
+	first := start
+	for first-p >= 0 && matches(first-p) {
+		first -= p
+	}
+	var indices []int
+	for pos := first; pos+p <= len(hashes) && (pos == first || matches(pos)); pos += p {
+		indices = append(indices, pos)
+	}
+	if len(indices) < 3 {
+		return nil
+	}
+	return &CycleInfo{
+		StartIndex:   first,
+		CycleLength:  p,
+		NumCycles:    len(indices),
+		CycleIndices: indices,
+	}
+}
+
+// PatternFromInfo wraps a cycle found by a non-anchor detector as a CyclePattern, so
+// it goes through the same output path as FindCyclePatterns
+func PatternFromInfo(events []KernelEvent, info *CycleInfo) CyclePattern {
+	endPos := info.CycleIndices[len(info.CycleIndices)-1] + info.CycleLength
+	return CyclePattern{
+		Info:          info,
+		Signature:     getCycleSignature(events, info),
+		StartPos:      info.StartIndex,
+		EndPos:        endPos,
+		CenterPos:     float64(info.StartIndex+endPos) / 2.0,
+		Confidence:    patternConfidence(info),
+		WarmupEvents:  info.StartIndex,
+		WarmupKernels: WarmupKernels(events, info.StartIndex),
+	}
+}
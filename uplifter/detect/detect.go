@@ -0,0 +1,115 @@
+// Package detect finds repeating kernel cycles (model iterations, decode steps) in a
+// sequence of GPU kernel events. It holds no global state: a Detector carries its
+// options and the writer diagnostics go to, so several can run side by side
+package detect
+
+import (
+	"fmt"
+	"io"
+)
+
+// KernelEvent represents a GPU kernel execution event from the trace
+type KernelEvent struct {
+	Name      string  `json:"name"`
+	Category  string  `json:"cat"`
+	Phase     string  `json:"ph"`
+	Timestamp float64 `json:"ts"`
+	Duration  float64 `json:"dur"`
+	Pid       int     `json:"pid"`
+	Tid       int     `json:"tid"`
+	Seq       int     `json:"-"` // Position in the traceEvents array (or non-blank NDJSON line), malformed entries included; stable tie-breaker for equal ts
+
+	// Launch configuration from args (zero when the trace does not record it)
+	GridX, GridY, GridZ    int   `json:"-"`
+	BlockX, BlockY, BlockZ int   `json:"-"`
+	Stream                 int   `json:"-"`
+	CorrelationID          int64 `json:"-"`
+}
+
+// Options configures a Detector; start from DefaultOptions
+type Options struct {
+	// Log receives progress and diagnostics (nil discards them)
+	Log io.Writer
+	// Verbose also writes per-candidate detail to Log
+	Verbose bool
+	// Explain writes extra diagnostics: the cycle-length histogram and why anchors
+	// were rejected
+	Explain bool
+
+	// MinCycleLen and MaxCycleLen bound the cycle lengths (in kernels) accepted by the
+	// anchor-based detectors (MaxCycleLen 0 = unbounded)
+	MinCycleLen int
+	MaxCycleLen int
+	// MatchTolerance is the fraction of positions that must match for a repetition to
+	// count; the quicker/looser stages derive their thresholds from it
+	MatchTolerance float64
+	// PhaseMode selects what DetectCycleBySignature returns: "auto" (the main cycle),
+	// "prefill" (earliest pattern) or "decode" (latest pattern)
+	PhaseMode string
+	// RequireDualAnchor requires a second periodic kernel at a fixed phase offset from
+	// the anchor before accepting a cycle
+	RequireDualAnchor bool
+	// SeedAnchors lists kernel names known to mark iteration boundaries; they are
+	// tried before automatically discovered anchors
+	SeedAnchors []string
+	// Normalize, when set, maps kernel names before they are compared (e.g. to group
+	// numbered triton variants)
+	Normalize func(name string) string
+
+	// AnchorKmer pins k-mer detection to this exact kernel-name sequence
+	AnchorKmer []string
+	// WeightDedupByDuration weights the k-mer dedup signature match by kernel duration
+	WeightDedupByDuration bool
+}
+
+// DefaultOptions returns the options the uplifter CLI uses without flags, with
+// diagnostics discarded
+func DefaultOptions() Options {
+	return Options{
+		MinCycleLen:    10,
+		MatchTolerance: 0.95,
+		PhaseMode:      "auto",
+	}
+}
+
+// Detector runs cycle detection with fixed options
+type Detector struct {
+	opts     Options
+	seedKeys []string // SeedAnchors through kernelKey
+}
+
+// New returns a Detector using opts
+func New(opts Options) *Detector {
+	if opts.Log == nil {
+		opts.Log = io.Discard
+	}
+	d := &Detector{opts: opts}
+	for _, seed := range opts.SeedAnchors {
+		d.seedKeys = append(d.seedKeys, d.kernelKey(seed))
+	}
+	return d
+}
+
+// logf writes progress and summary output to Options.Log
+func (d *Detector) logf(format string, args ...any) {
+	fmt.Fprintf(d.opts.Log, format, args...)
+}
+
+// verbosef writes detection detail shown only with Options.Verbose
+func (d *Detector) verbosef(format string, args ...any) {
+	if d.opts.Verbose {
+		fmt.Fprintf(d.opts.Log, format, args...)
+	}
+}
+
+// warmupNameLimit is how many leading warmup kernel names are kept for reporting
+const warmupNameLimit = 5
+
+// WarmupKernels returns the names of the first few kernels before start
+func WarmupKernels(events []KernelEvent, start int) []string {
+	names := make([]string, 0, min(start, warmupNameLimit))
+	for _, e := range events[:min(min(start, warmupNameLimit), len(events))] {
+		names = append(names, e.Name)
+	}
+	return names
+}
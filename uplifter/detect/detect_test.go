@@ -0,0 +1,376 @@
+package detect
+
+import (
+	"bytes"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestPatternConfidence verifies gaps and skipped repetitions lower the confidence score
+func TestPatternConfidence(t *testing.T) {
+	regular := &CycleInfo{CycleLength: 10, CycleIndices: []int{0, 10, 20, 30, 40}}
+	if c := patternConfidence(regular); c != 1 {
+		t.Errorf("Expected confidence 1 for back-to-back repetitions, got %v", c)
+	}
+
+	gappy := &CycleInfo{CycleLength: 10, CycleIndices: []int{0, 10, 40, 50, 60}}
+	if c := patternConfidence(gappy); c <= 0 || c >= 1 {
+		t.Errorf("Expected confidence in (0, 1) with skipped repetitions, got %v", c)
+	}
+}
+
+// TestZFunction verifies zFunction against a hand-computed example
+func TestZFunction(t *testing.T) {
+	if z := zFunction([]uint64{1, 2, 1, 2, 1}); !slices.Equal(z, []int{5, 0, 3, 0, 1}) {
+		t.Errorf("zFunction = %v, want [5 0 3 0 1]", z)
+	}
+}
+
+// TestDetectorLog verifies each Detector writes diagnostics only to its own Options.Log,
+// so detectors can run concurrently, and that a nil Log discards them
+func TestDetectorLog(t *testing.T) {
+	var events []KernelEvent
+	for rep := 0; rep < 20; rep++ {
+		for i := 0; i < 12; i++ {
+			events = append(events, KernelEvent{Name: "kernel_" + strconv.Itoa(i), Duration: float64(1 + i)})
+		}
+	}
+
+	logs := make([]bytes.Buffer, 4)
+	results := make([][]CyclePattern, len(logs)+1)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			opts := DefaultOptions()
+			if i < len(logs) {
+				opts.Log = &logs[i]
+			}
+			results[i] = New(opts).FindCyclePatterns(events)
+		}()
+	}
+	wg.Wait()
+
+	for i, log := range logs {
+		if log.Len() == 0 {
+			t.Errorf("detector %d wrote nothing to its log", i)
+		}
+		if log.String() != logs[0].String() {
+			t.Errorf("detector %d logged differently from detector 0:\n%s\nvs\n%s", i, log.String(), logs[0].String())
+		}
+	}
+	for i, patterns := range results {
+		if len(patterns) != 1 || patterns[0].Info.CycleLength != 12 {
+			t.Fatalf("detector %d found %+v, want one 12-kernel pattern", i, patterns)
+		}
+	}
+}
+
+// TestNormalizedKeys verifies anchors are counted by their normalized name, and that
+// Options.Normalize runs once per distinct name rather than per comparison
+func TestNormalizedKeys(t *testing.T) {
+	// Every repetition renames its kernels (k3_0, k3_1, ...), so raw names never repeat
+	var events []KernelEvent
+	for rep := 0; rep < 20; rep++ {
+		for i := 0; i < 12; i++ {
+			events = append(events, KernelEvent{Name: "k" + strconv.Itoa(i) + "_" + strconv.Itoa(rep), Duration: 1})
+		}
+	}
+	calls := 0
+	opts := DefaultOptions()
+	opts.Normalize = func(name string) string {
+		calls++
+		return name[:strings.LastIndexByte(name, '_')]
+	}
+	d := New(opts)
+
+	for _, tc := range []struct {
+		name   string
+		detect func() int
+	}{
+		{"FindCyclePatterns", func() int {
+			patterns := d.FindCyclePatterns(events)
+			if len(patterns) == 0 {
+				return 0
+			}
+			return patterns[0].Info.CycleLength
+		}},
+		{"DetectCyclesKmer", func() int {
+			cycles := d.DetectCyclesKmer(events, 3, 10)
+			if len(cycles) == 0 {
+				return 0
+			}
+			return cycles[0].Length
+		}},
+		{"DetectCyclesSimple", func() int {
+			cycles := d.DetectCyclesSimple(events, 10)
+			if len(cycles) == 0 {
+				return 0
+			}
+			return cycles[0].Length
+		}},
+	} {
+		calls = 0
+		if got := tc.detect(); got != 12 {
+			t.Errorf("%s: cycle length %d, want 12", tc.name, got)
+		}
+		if calls > 2*len(events) {
+			t.Errorf("%s: Normalize ran %d times for %d distinct names", tc.name, calls, len(events))
+		}
+	}
+}
+
+// TestQuickChecksUseOptions verifies the early-stop and streaming checks use the
+// Detector's MatchTolerance (less 5 points) rather than a fixed threshold
+func TestQuickChecksUseOptions(t *testing.T) {
+	// Two of 20 positions change every repetition: a 90% match
+	var events []KernelEvent
+	for rep := 0; rep < 10; rep++ {
+		for i := 0; i < 20; i++ {
+			name := "k" + strconv.Itoa(i)
+			if i >= 18 {
+				name += "_" + strconv.Itoa(rep)
+			}
+			events = append(events, KernelEvent{Name: name, Duration: 1})
+		}
+	}
+
+	for _, tc := range []struct {
+		tolerance float64
+		want      bool
+	}{{0.95, true}, {1, false}} {
+		opts := DefaultOptions()
+		opts.MatchTolerance = tc.tolerance
+		d := New(opts)
+		info := d.DetectCycleQuick(events, 10, 100)
+		if got := info != nil && info.CycleLength == 20; got != tc.want {
+			t.Errorf("tolerance %v: DetectCycleQuick = %+v, want found %v", tc.tolerance, info, tc.want)
+		}
+		if got := d.RepetitionMatcher(events[:20])(events[20:40]); got != tc.want {
+			t.Errorf("tolerance %v: RepetitionMatcher = %v, want %v", tc.tolerance, got, tc.want)
+		}
+	}
+}
+
+// TestKmerSweepPrefersMatchQuality verifies the sweep keeps a larger k's exact cycle
+// over the near-repeating half cycle that k=1 finds
+func TestKmerSweepPrefersMatchQuality(t *testing.T) {
+	// The second half of each 40-kernel cycle differs from the first at two of 20
+	// positions, so half cycles still pass verification but only k >= 10 sees every
+	// k-mer once per cycle
+	var events []KernelEvent
+	for rep := 0; rep < 15; rep++ {
+		for i := 0; i < 40; i++ {
+			name := "k" + strconv.Itoa(i%20)
+			if i == 20 || i == 30 {
+				name += "_alt"
+			}
+			events = append(events, KernelEvent{Name: name, Duration: 1})
+		}
+	}
+
+	d := New(DefaultOptions())
+	if k1 := d.DetectCyclesKmer(events, 1, 10); len(k1) != 1 || k1[0].Length != 20 {
+		t.Fatalf("k=1 found %+v, want the 20-kernel half cycle", k1)
+	}
+	cycles := d.DetectCyclesKmerSweep(events, 1, 10, 10)
+	if len(cycles) != 1 || cycles[0].Length != 40 || cycles[0].K != 10 {
+		t.Errorf("sweep found %+v, want one 40-kernel cycle from k=10", cycles)
+	}
+}
+
+func TestEventRangeOverlap(t *testing.T) {
+	claimed := []eventRange{{0, 400}, {1200, 2000}}
+	tests := []struct {
+		r    eventRange
+		want bool
+	}{
+		{eventRange{400, 900}, false},   // adjacent, same 1000-event bucket as {0, 400}
+		{eventRange{900, 1200}, false},  // fills the gap exactly
+		{eventRange{900, 1500}, true},   // starts in an unclaimed bucket, runs into {1200, 2000}
+		{eventRange{1999, 2400}, true},  // last claimed event
+		{eventRange{2000, 3000}, false}, // end is exclusive
+	}
+	for _, tt := range tests {
+		if got := tt.r.overlapsAny(claimed); got != tt.want {
+			t.Errorf("%+v.overlapsAny(%v) = %v, want %v", tt.r, claimed, got, tt.want)
+		}
+	}
+}
+
+// TestKmerAdjacentCycles verifies two different cycles that meet inside one
+// 1000-event span are both kept
+func TestKmerAdjacentCycles(t *testing.T) {
+	var events []KernelEvent
+	for _, block := range []struct {
+		prefix      string
+		length, rep int
+	}{{"a", 20, 20}, {"b", 30, 20}} {
+		for rep := 0; rep < block.rep; rep++ {
+			for i := 0; i < block.length; i++ {
+				events = append(events, KernelEvent{Name: block.prefix + strconv.Itoa(i), Duration: 1})
+			}
+		}
+	}
+
+	cycles := New(DefaultOptions()).DetectCyclesKmer(events, 3, 10)
+	if len(cycles) != 2 || cycles[0].Length != 20 || cycles[1].Length != 30 {
+		t.Errorf("found %+v, want a 20-kernel cycle followed by a 30-kernel cycle", cycles)
+	}
+}
+
+func TestHasSecondAnchor(t *testing.T) {
+	// Five repetitions of an 8-kernel cycle anchored by "a"
+	build := func(rep func(r int) []string) ([]string, *CycleInfo) {
+		var keys []string
+		info := &CycleInfo{CycleLength: 8}
+		for r := 0; r < 5; r++ {
+			info.CycleIndices = append(info.CycleIndices, len(keys))
+			keys = append(keys, rep(r)...)
+		}
+		info.NumCycles = len(info.CycleIndices)
+		return keys, info
+	}
+	fixed := func(int) []string { return []string{"a", "x", "y", "b", "z", "w", "v", "u"} }
+	drifting := func(r int) []string {
+		rep := []string{"a", "x", "y", "z", "w", "v", "u", "t"}
+		rep[1+r%7] = "b"
+		return rep
+	}
+	twice := func(int) []string { return []string{"a", "b", "y", "b", "z", "w", "v", "u"} }
+
+	tests := []struct {
+		name       string
+		rep        func(r int) []string
+		candidates []string
+		want       bool
+	}{
+		{"phase-locked second anchor", fixed, []string{"a", "b"}, true},
+		{"only the anchor is periodic", fixed, []string{"a"}, false},
+		{"second kernel drifts", drifting, []string{"a", "b"}, false},
+		{"second kernel repeats within the cycle", twice, []string{"a", "b"}, false},
+	}
+	for _, tt := range tests {
+		keys, info := build(tt.rep)
+		candidates := make(map[string]bool)
+		for _, name := range tt.candidates {
+			candidates[name] = true
+		}
+		if got := hasSecondAnchor(keys, info, "a", candidates); got != tt.want {
+			t.Errorf("%s: hasSecondAnchor = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestOuterCycleRejections verifies a failed findOuterCycle logs why each anchor
+// candidate was rejected
+func TestOuterCycleRejections(t *testing.T) {
+	unique := 0
+	filler := func() string {
+		unique++
+		return "filler_" + strconv.Itoa(unique)
+	}
+	names := func(build func(add func(string))) []KernelEvent {
+		var events []KernelEvent
+		build(func(name string) { events = append(events, KernelEvent{Name: name, Duration: 1}) })
+		return events
+	}
+
+	tests := []struct {
+		name   string
+		events []KernelEvent
+		want   string
+	}{
+		{"too few", names(func(add func(string)) {
+			for rep := 0; rep < 4; rep++ {
+				for i := 0; i < 12; i++ {
+					add("k" + strconv.Itoa(i))
+				}
+			}
+		}), "12 rejected: fewer than 5 occurrences"},
+		{"too frequent", names(func(add func(string)) {
+			for i := 0; i < 100; i++ {
+				add("memcpy")
+			}
+		}), "1 rejected: too frequent"},
+		{"short cycle", names(func(add func(string)) {
+			for rep := 0; rep < 10; rep++ {
+				for i := 0; i < 6; i++ {
+					add("k" + strconv.Itoa(i))
+				}
+			}
+		}), "6 rejected: cycle length under 10 kernels"},
+		{"inconsistent spacing", names(func(add func(string)) {
+			for _, gap := range []int{12, 18, 12, 28, 15, 1} {
+				add("anchor")
+				for i := 1; i < gap; i++ {
+					add(filler())
+				}
+			}
+		}), "1 rejected: inconsistent spacing"},
+		{"unverified repetitions", names(func(add func(string)) {
+			for rep := 0; rep < 6; rep++ {
+				add("anchor")
+				for i := 1; i < 12; i++ {
+					add(filler())
+				}
+			}
+		}), "1 rejected: fewer than 5 verified repetitions"},
+	}
+	for _, tt := range tests {
+		var log bytes.Buffer
+		opts := DefaultOptions()
+		opts.Log = &log
+		if info := New(opts).findOuterCycle(tt.events); info != nil {
+			t.Errorf("%s: found %+v, want no cycle", tt.name, info)
+			continue
+		}
+		if !strings.Contains(log.String(), tt.want) {
+			t.Errorf("%s: log missing %q:\n%s", tt.name, tt.want, log.String())
+		}
+	}
+}
+
+// TestSeedAnchors verifies findOuterCycle anchors on the first seeded kernel that
+// yields a cycle, in seed order, and falls back to discovery when none does
+func TestSeedAnchors(t *testing.T) {
+	var events []KernelEvent
+	for rep := 0; rep < 20; rep++ {
+		for i := 0; i < 12; i++ {
+			events = append(events, KernelEvent{Name: "k" + strconv.Itoa(i), Duration: 1})
+		}
+	}
+
+	tests := []struct {
+		seeds     []string
+		wantStart int // -1: any anchor
+		wantLog   bool
+	}{
+		{nil, -1, false},
+		{[]string{"k7"}, 7, true},
+		{[]string{"k3", "k7"}, 3, true},
+		{[]string{"missing", "k5"}, 5, true},
+		{[]string{"missing"}, -1, false},
+	}
+	for _, tt := range tests {
+		var log bytes.Buffer
+		opts := DefaultOptions()
+		opts.Log = &log
+		opts.SeedAnchors = tt.seeds
+		info := New(opts).findOuterCycle(events)
+		if info == nil || info.CycleLength != 12 {
+			t.Errorf("seeds %v: found %+v, want a 12-kernel cycle", tt.seeds, info)
+			continue
+		}
+		if tt.wantStart >= 0 && info.StartIndex != tt.wantStart {
+			t.Errorf("seeds %v: cycle starts at %d, want %d", tt.seeds, info.StartIndex, tt.wantStart)
+		}
+		if got := strings.Contains(log.String(), "Using seeded anchor"); got != tt.wantLog {
+			t.Errorf("seeds %v: seeded anchor logged = %v, want %v", tt.seeds, got, tt.wantLog)
+		}
+	}
+}
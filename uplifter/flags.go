@@ -0,0 +1,232 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Flag groups shared by the commands: each add* function registers one group on a
+// FlagSet and returns its values, and apply validates them and sets the globals, so
+// the help text and checks of a flag are written once
+
+// logFlags are -quiet and -verbose, accepted by every command
+type logFlags struct {
+	quiet, verbose *bool
+}
+
+func addLogFlags(fs *flag.FlagSet) logFlags {
+	return logFlags{
+		quiet:   fs.Bool("quiet", false, "Only print errors"),
+		verbose: fs.Bool("verbose", false, "Also print per-candidate detection detail (implies -explain)"),
+	}
+}
+
+func (f logFlags) apply() error {
+	return setLogLevel(*f.quiet, *f.verbose)
+}
+
+// parseFlags select which trace events become kernels, bound cycle detection and add
+// cycle CSV columns; shared by the default mode and kmer
+type parseFlags struct {
+	nameFromArg       *string
+	emitCV            *bool
+	launchArgs        *bool
+	demangle          *bool
+	maxEvents         *int
+	categories        *string
+	phase             *string
+	async             *bool
+	pid, tid          *int
+	normalize         *bool
+	normalizeSuffixes *string
+	minCycle          *int
+	maxCycle          *int
+	tolerance         *float64
+	kmerLen           *int
+	kSweep            *string
+}
+
+func addParseFlags(fs *flag.FlagSet) *parseFlags {
+	return &parseFlags{
+		nameFromArg:       fs.String("name-from-arg", "", "Take kernel names from this args key (e.g. 'kernel') instead of the event name"),
+		emitCV:            fs.Bool("cv", false, "Add a coefficient of variation (stddev/avg %) column to CSV output"),
+		launchArgs:        fs.Bool("launch-args", false, "Add grid, block and stream columns (from trace args) to CSV output"),
+		demangle:          fs.Bool("demangle", false, "Add a display_name column with simplified C++ kernel names (kernel_name stays raw)"),
+		maxEvents:         fs.Int("max-events", 0, "Fail if the trace contains more than this many events (0 = unlimited)"),
+		categories:        fs.String("category", "kernel", "Comma-separated event categories treated as kernels (e.g. 'kernel,gpu,hip_kernel')"),
+		phase:             fs.String("phase", "X", "Event phase treated as a kernel slice"),
+		async:             fs.Bool("async", false, "Also build kernels from async begin/end (ph b/e) pairs matched by pid, tid, id and name"),
+		pid:               fs.Int("pid", -1, "Only keep kernels with this pid (-1 = all)"),
+		tid:               fs.Int("tid", -1, "Only keep kernels with this tid, e.g. one GPU of a multi-device trace (-1 = all)"),
+		normalize:         fs.Bool("normalize", false, "Compare kernel names with variable suffixes stripped (see -normalize-suffixes) during detection"),
+		normalizeSuffixes: fs.String("normalize-suffixes", "triton", "Comma-separated suffix rules for -normalize: triton, version, numeric, hash, or a custom suffix regex"),
+		minCycle:          fs.Int("min-cycle", 10, "Minimum cycle length in kernels"),
+		maxCycle:          fs.Int("max-cycle", 0, "Maximum cycle length in kernels (0 = unbounded)"),
+		tolerance:         fs.Float64("tolerance", 0.95, "Fraction of kernels that must match for a repetition to count (looser stages use up to 0.15 less); lower values raise repetition counts"),
+		kmerLen:           fs.Int("k", 3, "K-mer detector: number of consecutive kernels per anchor k-mer; raise it when short sequences repeat within a cycle"),
+		kSweep:            fs.String("k-sweep", "", "K-mer detector: try every k in this range (e.g. '1-6') and keep the k whose cycles repeat most exactly (ties: fewer duplicates, more events covered)"),
+	}
+}
+
+func (f *parseFlags) apply() error {
+	if *f.kmerLen < 1 {
+		return fmt.Errorf("-k must be at least 1")
+	}
+	kMin, kMax := *f.kmerLen, *f.kmerLen
+	if *f.kSweep != "" {
+		var err error
+		if kMin, kMax, err = parseKRange(*f.kSweep); err != nil {
+			return fmt.Errorf("-k-sweep: %w", err)
+		}
+	}
+	rules, err := parseNormalizeRules(*f.normalizeSuffixes)
+	if err != nil {
+		return err
+	}
+
+	NameFromArg = *f.nameFromArg
+	EmitCV = *f.emitCV
+	EmitLaunchArgs = *f.launchArgs
+	DemangleNames = *f.demangle
+	MaxEvents = *f.maxEvents
+	KernelCategories = nil
+	for _, cat := range strings.Split(*f.categories, ",") {
+		KernelCategories = append(KernelCategories, strings.TrimSpace(cat))
+	}
+	KernelPhase = *f.phase
+	PairAsyncEvents = *f.async
+	FilterPid = *f.pid
+	FilterTid = *f.tid
+	NormalizeNames = *f.normalize
+	NormalizeRules = rules
+	MinCycleLen = *f.minCycle
+	MaxCycleLen = *f.maxCycle
+	MatchTolerance = *f.tolerance
+	KmerLenMin, KmerLenMax = kMin, kMax
+	return nil
+}
+
+// applyEarlyStopReps validates -early-stop-reps and sets EarlyStopReps (0 when the
+// command's flag for reading whole traces is set)
+func applyEarlyStopReps(reps int, disabled bool) error {
+	if reps < 1 {
+		return fmt.Errorf("-early-stop-reps must be at least 1")
+	}
+	EarlyStopReps = reps
+	if disabled {
+		EarlyStopReps = 0
+	}
+	return nil
+}
+
+// applyKernelCategories loads a -kernel-categories rules file into CustomCategories
+func applyKernelCategories(path string) error {
+	if path == "" {
+		return nil
+	}
+	rules, err := loadCategoryRules(path)
+	if err != nil {
+		return err
+	}
+	CustomCategories = rules
+	return nil
+}
+
+// compareFlagValues are the matching and output flags shared by the compare commands.
+// addCompareFlags registers the ones every command takes; the optional groups stay
+// nil, and are left alone by apply, unless their add method is called
+type compareFlagValues struct {
+	logFlags
+	mode      *string
+	threshold *float64
+	exactOnly *bool
+	demangle  *bool
+	vendorMap *string
+	colors    *string
+
+	// addCSVInputFlags
+	emitCV           *bool
+	strict           *bool
+	fuzzy            *float64
+	noiseSigmas      *float64
+	kernelCategories *string
+	aggregate        *bool
+
+	// addReportFlags
+	summaryOnly *bool
+	topN        *int
+	flat        *bool
+}
+
+func addCompareFlags(fs *flag.FlagSet, defaultMode string) *compareFlagValues {
+	return &compareFlagValues{
+		logFlags:  addLogFlags(fs),
+		mode:      fs.String("mode", defaultMode, "Comparison mode: 'align' (position-based with rotation) or 'match' (signature-based, position-independent)"),
+		threshold: fs.Float64("threshold", 5, "Percent change beyond which a kernel counts as improved/regressed"),
+		exactOnly: fs.Bool("exact-only", false, "Only pair kernels with identical names (no signature-based 'similar' matches)"),
+		demangle:  fs.Bool("demangle", false, "Show simplified C++ kernel names (no 'void', namespaces, templates or arguments); matching still uses raw names"),
+		vendorMap: fs.String("vendor-map", "", "Normalize kernel names before matching with canonical=regex rules from this file, or 'builtin' for common CUDA/ROCm equivalents (default: off)"),
+		colors:    fs.String("colors", "", "XLSX: override fill colors as style=#RRGGBB pairs, e.g. improved=#1B9E77,regressed=#D95F02 (styles: header, exact, similar, removed, new_only, moved, improved, regressed, neutral)"),
+	}
+}
+
+// addCSVInputFlags registers the flags for commands that compare cycle CSVs pairwise
+func (c *compareFlagValues) addCSVInputFlags(fs *flag.FlagSet) {
+	c.emitCV = fs.Bool("cv", false, "Add coefficient of variation (stddev/avg %) columns")
+	c.strict = fs.Bool("strict", false, "Fail instead of warning when most kernels in a CSV have zero duration (no timing)")
+	c.fuzzy = fs.Float64("fuzzy", 0, "Pair leftover kernels whose signatures are within this normalized edit distance, e.g. 0.2 (0 = off)")
+	c.noiseSigmas = fs.Float64("noise-sigma", 2, "XLSX: only color a change improved/regressed if it exceeds this many combined stddevs (0 = -threshold only)")
+	c.kernelCategories = fs.String("kernel-categories", "", "File of category=substring rules checked before the built-in kernel categories (category column)")
+	c.aggregate = fs.Bool("aggregate", false, "Collapse kernels sharing a signature within each cycle into one row (summed time) before matching, for a per-signature delta view")
+}
+
+// addReportFlags registers the flags for commands that print a summary and write one
+// comparison
+func (c *compareFlagValues) addReportFlags(fs *flag.FlagSet) {
+	c.summaryOnly = fs.Bool("summary-only", false, "Print the summary and skip writing the detailed comparison (ignores -output)")
+	c.topN = fs.Int("top", DefaultTopN, "Number of kernels to list in the summary's top-kernels sections")
+	c.flat = fs.Bool("flat", false, "CSV: one row per eager kernel with a group_id column, instead of indented continuation rows for fused groups")
+}
+
+func (c *compareFlagValues) apply() error {
+	if err := c.logFlags.apply(); err != nil {
+		return err
+	}
+	if c.topN != nil && *c.topN < 1 {
+		return fmt.Errorf("-top must be at least 1")
+	}
+
+	CompareMode = *c.mode
+	ChangeThreshold = *c.threshold
+	ExactOnly = *c.exactOnly
+	DemangleNames = *c.demangle
+	if *c.vendorMap != "" {
+		rules, err := loadVendorMap(*c.vendorMap)
+		if err != nil {
+			return err
+		}
+		VendorMap = rules
+	}
+	if *c.colors != "" {
+		overrides, err := parseColorOverrides(*c.colors)
+		if err != nil {
+			return fmt.Errorf("-colors: %w", err)
+		}
+		XLSXColors = overrides
+	}
+	if c.emitCV != nil {
+		EmitCV = *c.emitCV
+		StrictTiming = *c.strict
+		FuzzyThreshold = *c.fuzzy
+		NoiseSigmas = *c.noiseSigmas
+		AggregateSignatures = *c.aggregate
+		if err := applyKernelCategories(*c.kernelCategories); err != nil {
+			return err
+		}
+	}
+	if c.flat != nil {
+		FlatCSV = *c.flat
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"uplifter/detect"
+)
+
+// WriteFolded writes the cycle as folded stacks for flamegraph.pl or speedscope: one
+// "category;signature <µs>" line per kernel signature, with the summed average
+// durations rounded to whole microseconds as the sample count
+func (r *CycleResult) WriteFolded(w io.Writer) error {
+	totals := make(map[string]float64)
+	for _, k := range r.Kernels {
+		stack := foldedFrame(categorizeKernel(k.Name)) + ";" + foldedFrame(detect.KernelSignature(k.Name))
+		totals[stack] += k.AvgDur
+	}
+
+	stacks := make([]string, 0, len(totals))
+	for stack := range totals {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	for _, stack := range stacks {
+		samples := int64(math.Round(totals[stack]))
+		if samples <= 0 {
+			continue // Sub-microsecond kernels would be zero-width frames
+		}
+		if _, err := fmt.Fprintf(w, "%s %d\n", stack, samples); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// foldedFrame keeps a frame name from splitting the stack (';') or the line ('\n')
+func foldedFrame(name string) string {
+	return strings.NewReplacer(";", ":", "\n", " ").Replace(name)
+}
@@ -2,7 +2,10 @@ module uplifter
 
 go 1.24.0
 
-require github.com/xuri/excelize/v2 v2.10.0
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/xuri/excelize/v2 v2.10.0
+)
 
 require (
 	github.com/richardlehane/mscfb v1.0.4 // indirect
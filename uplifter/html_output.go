@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+)
+
+// htmlStyle and htmlSortScript are inlined so the report is a single self-contained file
+const htmlStyle = `body{font-family:-apple-system,Segoe UI,Helvetica,Arial,sans-serif;margin:2em;color:#222}
+h1{font-size:1.4em}h2{font-size:1.1em;margin-top:1.5em}
+.stats td{padding:2px 12px 2px 0}
+table.kernels{border-collapse:collapse;width:100%;font-size:0.9em}
+table.kernels th{background:#4472C4;color:#fff;cursor:pointer;padding:4px 8px;text-align:left;user-select:none}
+table.kernels td{border-bottom:1px solid #ddd;padding:3px 8px}
+table.kernels td.num{text-align:right;font-variant-numeric:tabular-nums}
+table.kernels td.name{word-break:break-all}
+.bar-row{display:flex;align-items:center;margin:3px 0}
+.bar-label{width:180px;flex:none}
+.bar{background:#4472C4;height:14px;margin-right:8px}`
+
+const htmlSortScript = `document.querySelectorAll("table.kernels th").forEach(function(th, col) {
+  th.addEventListener("click", function() {
+    var body = th.closest("table").tBodies[0];
+    var asc = th.dataset.dir !== "asc";
+    th.dataset.dir = asc ? "asc" : "desc";
+    Array.from(body.rows).sort(function(a, b) {
+      var x = a.cells[col].dataset.v || a.cells[col].textContent;
+      var y = b.cells[col].dataset.v || b.cells[col].textContent;
+      var d = (isNaN(x) || isNaN(y)) ? x.localeCompare(y) : x - y;
+      return asc ? d : -d;
+    }).forEach(function(r) { body.appendChild(r); });
+  });
+});`
+
+// WriteHTML writes a self-contained HTML report: summary stats, the kernel-type
+// distribution as bars and a click-to-sort kernel table
+func (r *CycleResult) WriteHTML(w io.Writer) error {
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\">\n<title>Uplifter cycle report</title>\n")
+	fmt.Fprintf(w, "<style>%s</style>\n</head><body>\n", htmlStyle)
+
+	fmt.Fprintf(w, "<h1>Cycle Analysis</h1>\n<table class=\"stats\">\n")
+	fmt.Fprintf(w, "<tr><td>Cycle length</td><td>%d kernels</td></tr>\n", r.CycleLength)
+	fmt.Fprintf(w, "<tr><td>Repetitions</td><td>%d</td></tr>\n", r.NumCycles)
+	fmt.Fprintf(w, "<tr><td>Average cycle time</td><td>%.2f µs (%.4f ms)</td></tr>\n", r.AvgCycleTime, r.AvgCycleTime/1000)
+	if r.MinCycleTime > 0 {
+		fmt.Fprintf(w, "<tr><td>Best-observed cycle time</td><td>%.2f µs</td></tr>\n", r.MinCycleTime)
+	}
+	fmt.Fprintf(w, "<tr><td>Idle time between kernels</td><td>%.2f µs per cycle</td></tr>\n", r.AvgIdleTime)
+	fmt.Fprintf(w, "<tr><td>Total measured time</td><td>%.2f µs</td></tr>\n", r.TotalCycleTime)
+	fmt.Fprintf(w, "</table>\n")
+
+	fmt.Fprintf(w, "<h2>Kernel Type Distribution</h2>\n")
+	for _, t := range kernelTypeDistribution(r.Kernels) {
+		pct := percentOf(t.dur, r.AvgCycleTime)
+		fmt.Fprintf(w, "<div class=\"bar-row\"><span class=\"bar-label\">%s</span><div class=\"bar\" style=\"width:%.1f%%\"></div>%d kernels, %.2f µs (%.1f%%)</div>\n",
+			html.EscapeString(t.name), pct*0.6, t.count, t.dur, pct)
+	}
+
+	fmt.Fprintf(w, "<h2>Kernels</h2>\n<p>Click a column header to sort.</p>\n<table class=\"kernels\">\n")
+	fmt.Fprintf(w, "<thead><tr><th>#</th><th>Kernel</th><th>Avg (µs)</th><th>Min (µs)</th><th>Max (µs)</th><th>StdDev (µs)</th><th>%% of cycle</th></tr></thead>\n<tbody>\n")
+	for _, k := range r.Kernels {
+		pct := percentOf(k.AvgDur, r.AvgCycleTime)
+		fmt.Fprintf(w, "<tr><td class=\"num\">%d</td><td class=\"name\">%s</td><td class=\"num\">%.3f</td><td class=\"num\">%.3f</td><td class=\"num\">%.3f</td><td class=\"num\">%.3f</td><td class=\"num\">%.2f</td></tr>\n",
+			k.IndexInCycle, html.EscapeString(k.Name), k.AvgDur, k.MinDur, k.MaxDur, k.StdDev, pct)
+	}
+	fmt.Fprintf(w, "</tbody></table>\n")
+
+	_, err := fmt.Fprintf(w, "<script>\n%s\n</script>\n</body></html>\n", htmlSortScript)
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultParseWorkers is the default -parse-workers; each parser holds a 64MB read
+// buffer (openDecompressed), so this stays small rather than following the CPU count
+const DefaultParseWorkers = 4
+
+// ParseWorkers bounds how many traces -input-dir parses at once
+var ParseWorkers = DefaultParseWorkers
+
+// isTraceFile reports whether a file name looks like a trace ParseKernelEvents reads:
+// .json, .ndjson or .jsonl, optionally compressed with .gz or .zst
+func isTraceFile(name string) bool {
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".zst")
+	return strings.HasSuffix(name, ".json") || isNDJSON(name)
+}
+
+// listTraceFiles returns the trace files directly inside dir, sorted by name
+func listTraceFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		if !e.IsDir() && isTraceFile(e.Name()) {
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// traceParse is one file's share of a ParseTraceDir run
+type traceParse struct {
+	events  []KernelEvent
+	elapsed time.Duration
+	err     error
+}
+
+// ParseTraceDir parses every trace in dir with up to workers concurrent parsers and
+// concatenates their events in filename order. Each file's Seq values are shifted past
+// the previous file's largest, so Seq stays unique and ordered across files.
+// Per-file parse times and the total event count are logged; any file failing to
+// parse fails the whole run
+func ParseTraceDir(dir string, workers int) ([]KernelEvent, error) {
+	paths, err := listTraceFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading input directory: %w", err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no trace files (.json, .json.gz, .json.zst, .ndjson, .jsonl) in %s", dir)
+	}
+	workers = max(min(workers, len(paths)), 1)
+	logf("Parsing %d trace files from %s with %d workers\n", len(paths), dir, workers)
+
+	start := time.Now()
+	results := make([]traceParse, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fileStart := time.Now()
+				events, err := ParseKernelEvents(paths[i])
+				results[i] = traceParse{events: events, elapsed: time.Since(fileStart), err: err}
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	total := 0
+	for i, r := range results {
+		if r.err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", filepath.Base(paths[i]), r.err)
+		}
+		total += len(r.events)
+	}
+
+	events := make([]KernelEvent, 0, total)
+	offset := 0
+	for i, r := range results {
+		logf("  %s: %d kernel events in %v\n", filepath.Base(paths[i]), len(r.events), r.elapsed)
+		next := offset
+		for _, e := range r.events {
+			e.Seq += offset
+			next = max(next, e.Seq+1)
+			events = append(events, e)
+		}
+		offset = next
+	}
+	logf("Parsed %d kernel events from %d files in %v\n", total, len(paths), time.Since(start))
+	return events, nil
+}
@@ -1,12 +1,28 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/xuri/excelize/v2"
+
+	"uplifter/detect"
 )
 
 // Integration tests to verify cycle detection and comparison functionality
@@ -174,9 +190,9 @@ func TestGetKernelSignature(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := getKernelSignature(tt.input)
+			got := detect.KernelSignature(tt.input)
 			if got != tt.expected {
-				t.Errorf("getKernelSignature(%q) = %q, want %q", tt.input, got, tt.expected)
+				t.Errorf("detect.KernelSignature(%q) = %q, want %q", tt.input, got, tt.expected)
 			}
 		})
 	}
@@ -188,8 +204,8 @@ func TestSignatureMatchesSimilarKernels(t *testing.T) {
 	kernel1 := "void ck::kernel_gemm<int, float, 32>"
 	kernel2 := "void ck::kernel_gemm<long, double, 64>"
 	
-	sig1 := getKernelSignature(kernel1)
-	sig2 := getKernelSignature(kernel2)
+	sig1 := detect.KernelSignature(kernel1)
+	sig2 := detect.KernelSignature(kernel2)
 	
 	if sig1 != sig2 {
 		t.Errorf("Expected same signature for similar kernels, got %q vs %q", sig1, sig2)
@@ -280,3 +296,2474 @@ func getTestDataPath(filename string) string {
 	return filepath.Join("testdata", filename)
 }
 
+
+// TestParseKernelEventsWithBOM verifies a BOM-prefixed trace with leading whitespace parses
+func TestParseKernelEventsWithBOM(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bom.json")
+	data := append([]byte{0xEF, 0xBB, 0xBF}, []byte("\n  {\"traceEvents\": [{\"name\": \"k\", \"cat\": \"kernel\", \"ph\": \"X\", \"ts\": 1, \"dur\": 2}]}")...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := ParseKernelEvents(path)
+	if err != nil {
+		t.Fatalf("ParseKernelEvents failed: %v", err)
+	}
+	if len(events) != 1 || events[0].Name != "k" {
+		t.Errorf("Expected one kernel named k, got %+v", events)
+	}
+}
+
+// TestStructuralDiffReordered verifies a moved kernel is reported once as reordered
+func TestStructuralDiffReordered(t *testing.T) {
+	r := &CompareResult{Matches: []KernelMatch{
+		{EagerKernels: []string{"gemm"}, CompiledKernel: ".", Signature: "gemm", MatchType: "removed"},
+		{EagerKernels: []string{"norm"}, CompiledKernel: "norm", Signature: "norm", MatchType: "exact"},
+		{EagerKernels: []string{""}, CompiledKernel: "gemm", Signature: "gemm", MatchType: "new_only"},
+		{EagerKernels: []string{""}, CompiledKernel: "silu", Signature: "silu", MatchType: "new_only"},
+	}}
+
+	changes := r.structuralDiff()
+	got := make([]string, len(changes))
+	for i, c := range changes {
+		got[i] = c.Change
+	}
+	want := []string{"unchanged", "reordered", "added"}
+	if len(got) != len(want) {
+		t.Fatalf("structuralDiff changes = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("structuralDiff changes = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+// TestDetectSplits verifies a removed kernel next to related new kernels is grouped as a split
+func TestDetectSplits(t *testing.T) {
+	matches := []KernelMatch{
+		{EagerKernels: []string{"fused_moe"}, CompiledKernel: ".", Signature: "fused_moe", MatchType: "removed"},
+		{EagerKernels: []string{""}, CompiledKernel: "fused_moe_gate", Signature: "fused_moe_gate", MatchType: "new_only"},
+		{EagerKernels: []string{""}, CompiledKernel: "fused_moe_down", Signature: "fused_moe_down", MatchType: "new_only"},
+		{EagerKernels: []string{""}, CompiledKernel: "rmsnorm", Signature: "rmsnorm", MatchType: "new_only"},
+	}
+
+	got := countMatchTypes(detectSplits(matches))
+	if got["split"] != 1 || got["split_part"] != 2 || got["new_only"] != 1 {
+		t.Errorf("detectSplits match types = %v, want 1 split, 2 split_part, 1 new_only", got)
+	}
+}
+
+func TestApplyFuzzyMatches(t *testing.T) {
+	matches := []KernelMatch{
+		{EagerKernels: []string{"gemm_bf16_tile128"}, EagerDur: 10, Signature: "gemm_bf16_tile128", MatchType: "removed"},
+		{CompiledKernel: "gemm_bf16_tile256", CompiledDur: 8, Signature: "gemm_bf16_tile256", MatchType: "new_only"},
+		{CompiledKernel: "softmax_fwd", CompiledDur: 2, Signature: "softmax_fwd", MatchType: "new_only"},
+	}
+
+	result := applyFuzzyMatches(matches, 0.2)
+	if len(result) != 2 {
+		t.Fatalf("expected 2 rows after fuzzy pairing, got %d", len(result))
+	}
+	if result[0].MatchType != "fuzzy" || result[0].EagerDur != 10 || result[0].CompiledKernel != "gemm_bf16_tile256" {
+		t.Errorf("expected fuzzy gemm pair, got %+v", result[0])
+	}
+	if result[1].MatchType != "new_only" {
+		t.Errorf("expected softmax to stay new_only, got %s", result[1].MatchType)
+	}
+}
+
+func TestDetectCyclesFromAnchorKmer(t *testing.T) {
+	// Warmup noise, then 6 reps of a 12-kernel cycle where "attn" repeats but "attn,gemm_out" is unique
+	var events []KernelEvent
+	for i := 0; i < 7; i++ {
+		events = append(events, KernelEvent{Name: "warmup_" + strconv.Itoa(i)})
+	}
+	cycle := []string{"norm", "attn", "gemm_qkv", "attn", "gemm_out", "act", "norm", "mlp_up", "mlp_down", "add", "attn", "copy"}
+	for rep := 0; rep < 6; rep++ {
+		for _, name := range cycle {
+			events = append(events, KernelEvent{Name: name})
+		}
+	}
+
+	defer func() { AnchorKmer = nil }()
+	AnchorKmer = []string{"attn", "gemm_out"}
+
+	cycles := detector().DetectCyclesKmer(events, 3, 10)
+	if len(cycles) != 1 {
+		t.Fatalf("expected 1 cycle, got %d", len(cycles))
+	}
+	if cycles[0].Length != len(cycle) || cycles[0].StartIndex != 10 || cycles[0].Repetitions < 5 {
+		t.Errorf("unexpected cycle %+v", cycles[0])
+	}
+}
+
+// TestParseKernelEventsBareArray verifies a top-level event array parses in both entry points
+func TestParseKernelEventsBareArray(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bare.json")
+	data := []byte("\n [{\"name\": \"k\", \"cat\": \"kernel\", \"ph\": \"X\", \"ts\": 1, \"dur\": 2}, {\"name\": \"cpu\", \"cat\": \"cpu_op\", \"ph\": \"X\", \"ts\": 2, \"dur\": 1}]")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := ParseKernelEvents(path)
+	if err != nil {
+		t.Fatalf("ParseKernelEvents failed: %v", err)
+	}
+	if len(events) != 1 || events[0].Name != "k" {
+		t.Errorf("Expected one kernel named k, got %+v", events)
+	}
+
+	count := 0
+	if err := ParseKernelEventsWithCallback(path, func(KernelEvent) bool { count++; return true }); err != nil {
+		t.Fatalf("ParseKernelEventsWithCallback failed: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected callback for one kernel, got %d", count)
+	}
+}
+
+// TestParseKernelEventsNDJSON verifies line-delimited events parse with blank and truncated lines
+func TestParseKernelEventsNDJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.ndjson")
+	data := "{\"name\": \"a\", \"cat\": \"kernel\", \"ph\": \"X\", \"ts\": 1, \"dur\": 2}\n\n" +
+		"{\"name\": \"b\", \"cat\": \"kernel\", \"ph\": \"X\", \"ts\": 3, \"dur\": 2}\n" +
+		"{\"name\": \"c\", \"cat\": \"kern"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := ParseKernelEvents(path)
+	if err != nil {
+		t.Fatalf("ParseKernelEvents failed: %v", err)
+	}
+	if len(events) != 2 || events[0].Name != "a" || events[1].Name != "b" {
+		t.Errorf("Expected kernels a and b, got %+v", events)
+	}
+}
+
+// TestParseKernelEventsZstd verifies a .json.zst trace decompresses and parses
+func TestParseKernelEventsZstd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.json.zst")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := zstd.NewWriter(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc.Write([]byte("{\"traceEvents\": [{\"name\": \"k\", \"cat\": \"kernel\", \"ph\": \"X\", \"ts\": 1, \"dur\": 2}]}"))
+	enc.Close()
+	file.Close()
+
+	events, err := ParseKernelEvents(path)
+	if err != nil {
+		t.Fatalf("ParseKernelEvents failed: %v", err)
+	}
+	if len(events) != 1 || events[0].Name != "k" {
+		t.Errorf("Expected one kernel named k, got %+v", events)
+	}
+}
+
+// TestParseKernelEventsAsyncPairs verifies b/e pairs become kernels and unmatched begins are dropped
+func TestParseKernelEventsAsyncPairs(t *testing.T) {
+	PairAsyncEvents = true
+	defer func() { PairAsyncEvents = false }()
+
+	path := filepath.Join(t.TempDir(), "async.json")
+	data := `{"traceEvents": [
+		{"name": "a", "cat": "kernel", "ph": "b", "id": 1, "ts": 10},
+		{"name": "b", "cat": "kernel", "ph": "b", "id": "0x2", "ts": 12},
+		{"name": "b", "cat": "kernel", "ph": "e", "id": "0x2", "ts": 15},
+		{"name": "a", "cat": "kernel", "ph": "e", "id": 1, "ts": 20},
+		{"name": "c", "cat": "kernel", "ph": "b", "id": 3, "ts": 21}
+	]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := ParseKernelEvents(path)
+	if err != nil {
+		t.Fatalf("ParseKernelEvents failed: %v", err)
+	}
+	if len(events) != 2 || events[0].Name != "a" || events[1].Name != "b" {
+		t.Fatalf("Expected kernels a then b, got %+v", events)
+	}
+	if events[0].Duration != 10 || events[1].Duration != 3 {
+		t.Errorf("Expected durations 10 and 3, got %v and %v", events[0].Duration, events[1].Duration)
+	}
+
+	// The callback path (streaming, early stop) must deliver the same begin order,
+	// although b's end arrives before a's
+	var streamed []string
+	if err := ParseKernelEventsWithCallback(path, func(e KernelEvent) bool {
+		streamed = append(streamed, e.Name)
+		return true
+	}); err != nil {
+		t.Fatalf("ParseKernelEventsWithCallback failed: %v", err)
+	}
+	if !slices.Equal(streamed, []string{"a", "b"}) {
+		t.Errorf("callback delivered %v, want [a b]", streamed)
+	}
+}
+
+// BenchmarkFindAllCyclePatterns measures detection on a 200k-event trace with 100 anchor candidates
+func BenchmarkFindAllCyclePatterns(b *testing.B) {
+	var events []KernelEvent
+	for rep := 0; rep < 2000; rep++ {
+		for k := 0; k < 100; k++ {
+			events = append(events, KernelEvent{Name: "kernel_" + strconv.Itoa(k), Duration: 1})
+		}
+	}
+	LogOutput = io.Discard
+	defer func() { LogOutput = os.Stderr }()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		detector().FindCyclePatterns(events)
+	}
+}
+
+// TestPercentile verifies linear interpolation between ranks
+func TestPercentile(t *testing.T) {
+	values := []float64{10, 20, 30, 40, 400}
+	if p := percentile(values, 50); p != 30 {
+		t.Errorf("Expected p50 30, got %v", p)
+	}
+	if p := percentile(values, 90); math.Abs(p-256) > 1e-9 {
+		t.Errorf("Expected p90 256, got %v", p)
+	}
+	if p := percentile(nil, 99); p != 0 {
+		t.Errorf("Expected 0 for no values, got %v", p)
+	}
+}
+
+// TestOptimalAssignmentBeatsGreedy verifies a case where greedy best-pair-first matching is suboptimal
+func TestOptimalAssignmentBeatsGreedy(t *testing.T) {
+	// Greedy takes (0,0)=0.9 and strands row 1; optimal is (0,1)+(1,0)+(2,2) = 2.15
+	similarity := [][]float64{
+		{0.9, 0.8, 0.0},
+		{0.85, 0.0, 0.0},
+		{0.0, 0.0, 0.5},
+	}
+	got := optimalAssignment(similarity)
+	want := []int{1, 0, 2}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected assignment %v, got %v", want, got)
+		}
+	}
+
+	// Rectangular: more rows than columns leaves one row unassigned
+	got = optimalAssignment([][]float64{{0.3}, {0.7}})
+	if got[0] != -1 || got[1] != 0 {
+		t.Errorf("Expected [-1 0], got %v", got)
+	}
+}
+
+// TestDetectFusionsMergesRemovedRun verifies removed kernels around a same-category new kernel are folded into one fused row
+func TestDetectFusionsMergesRemovedRun(t *testing.T) {
+	matches := []KernelMatch{
+		{EagerKernels: []string{"embedding_fwd"}, CompiledKernel: "embedding_fwd", MatchType: "exact"},
+		{EagerKernels: []string{"rms_norm_kernel"}, CompiledKernel: ".", EagerDur: 3, MatchType: "removed"},
+		{EagerKernels: []string{""}, CompiledKernel: "fused_add_rms_norm", CompiledDur: 4, MatchType: "new_only"},
+		{EagerKernels: []string{"layer_norm_fwd"}, CompiledKernel: ".", EagerDur: 2, MatchType: "removed"},
+		{EagerKernels: []string{"Cijk_gemm"}, CompiledKernel: ".", EagerDur: 9, MatchType: "removed"},
+	}
+	got := detectFusions(matches)
+	if len(got) != 3 {
+		t.Fatalf("expected 3 rows after fusion, got %d: %+v", len(got), got)
+	}
+	fused := got[1]
+	if fused.MatchType != "fused" || len(fused.EagerKernels) != 2 || fused.EagerDur != 5 || fused.Index != 1 {
+		t.Errorf("unexpected fused row: %+v", fused)
+	}
+	if got[2].MatchType != "removed" || got[2].EagerKernels[0] != "Cijk_gemm" {
+		t.Errorf("GEMM of another category should stay removed: %+v", got[2])
+	}
+}
+
+func TestSimplifyKernelName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Cijk_Ailk_Bljk_BBS_BH_MT128x128x64", "Cijk_Ailk_Bljk_BBS_BH_MT128x128x64"},
+		{"void ck::tensor_operation::device::DeviceGemm<ck::half_t, ck::Tuple<>, 256>::Run(ck::Argument const&, int)", "DeviceGemm::Run"},
+		{"void at::native::elementwise_kernel<128, 4, at::native::gpu_kernel_impl<at::native::CUDAFunctor_add<float> >(at::TensorIteratorBase&)::{lambda(int)#1}>(int, at::native::gpu_kernel_impl<>)", "elementwise_kernel"},
+		{"void at::native::(anonymous namespace)::fused_dropout_kernel(float*, unsigned int)", "fused_dropout_kernel"},
+		{"ck::kernel_gemm_xdl_cshuffle_v3", "kernel_gemm_xdl_cshuffle_v3"},
+		{"triton_poi_fused_add_mul_0", "triton_poi_fused_add_mul_0"},
+		{"  void rms_norm_kernel<c10::BFloat16, 8>(c10::BFloat16*, float)  ", "rms_norm_kernel"},
+	}
+	for _, tt := range tests {
+		if got := simplifyKernelName(tt.name); got != tt.want {
+			t.Errorf("simplifyKernelName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVendorMapAlignsCrossVendorGemm(t *testing.T) {
+	rules, err := loadVendorMap("builtin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	VendorMap = rules
+	defer func() { VendorMap = nil }()
+
+	nvidia := &CycleResult{Kernels: []KernelStats{{Name: "ampere_sgemm_128x64_tn", AvgDur: 10}, {Name: "rms_norm_kernel", AvgDur: 2}}}
+	amd := &CycleResult{Kernels: []KernelStats{{Name: "Cijk_Ailk_Bljk_SB_MT128x64x16", AvgDur: 12}, {Name: "rms_norm_kernel", AvgDur: 2}}}
+	got := countMatchTypes(matchByAlignment(nvidia, amd))
+	if got["similar"] != 1 || got["exact"] != 1 {
+		t.Errorf("match types with builtin vendor map = %v, want 1 similar, 1 exact", got)
+	}
+}
+
+func TestGemmShape(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"gemm_bf16_M4096_N4096_K512", "M=4096 N=4096 K=512", true},
+		{"Cijk_Ailk_Bljk_BBS_BH_MT128x128x64_MI16x16x1_SN", "tile 128x128x64", true},
+		{"ampere_sgemm_128x64_tn", "tile 128x64", true},
+		{"Cijk_Alik_Bljk_HHS_BH_UserArgs", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := gemmShape(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("gemmShape(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestWriteHTMLEscapesKernelNames(t *testing.T) {
+	r := &CycleResult{CycleLength: 2, NumCycles: 3, AvgCycleTime: 30, Kernels: []KernelStats{
+		{Name: "void gemm<float, 4>(float*)", AvgDur: 20, IndexInCycle: 0},
+		{Name: "rms_norm_kernel", AvgDur: 10, IndexInCycle: 1},
+	}}
+	var buf bytes.Buffer
+	if err := r.WriteHTML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "void gemm&lt;float, 4&gt;(float*)") || strings.Contains(out, "gemm<float") {
+		t.Errorf("kernel name not escaped in HTML output")
+	}
+	if !strings.Contains(out, "<table class=\"kernels\">") || !strings.Contains(out, "<script>") {
+		t.Errorf("HTML report missing kernel table or sort script")
+	}
+}
+
+func TestKeepDurations(t *testing.T) {
+	var events []KernelEvent
+	for rep := 0; rep < 3; rep++ {
+		for i, name := range []string{"a", "b"} {
+			events = append(events, KernelEvent{Name: name, Duration: float64(10*i + 3 - rep)})
+		}
+	}
+	info := &CycleInfo{CycleLength: 2, NumCycles: 3, CycleIndices: []int{0, 2, 4}}
+
+	if r := ExtractCycle(events, info); r.Kernels[0].Durations != nil {
+		t.Errorf("durations kept without KeepDurations")
+	}
+
+	KeepDurations = true
+	defer func() { KeepDurations = false }()
+	r := ExtractCycle(events, info)
+	var buf bytes.Buffer
+	if err := r.WriteDurationsCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 7 || rows[6][1] != "b" || rows[6][2] != "3" || rows[6][3] != "11.000" {
+		t.Errorf("unexpected durations CSV: %v", rows)
+	}
+}
+
+// TestRunCycleDetectionReturnsErrors verifies pipeline failures surface as errors rather than exiting
+func TestRunCycleDetectionReturnsErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := RunCycleDetection(DetectOptions{InputFile: filepath.Join(dir, "missing.json"), Mode: "all"}); err == nil {
+		t.Errorf("expected an error for a missing trace")
+	}
+
+	path := filepath.Join(dir, "flat.json")
+	data := "{\"traceEvents\": [{\"name\": \"a\", \"cat\": \"kernel\", \"ph\": \"X\", \"ts\": 1, \"dur\": 2}]}"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := RunCycleDetection(DetectOptions{InputFile: path, Mode: "all"}); err != ErrNoCyclePatterns {
+		t.Errorf("RunCycleDetection on a single kernel = %v, want ErrNoCyclePatterns", err)
+	}
+}
+
+// TestParseKernelEventsCtxCancel verifies a cancelled context stops a streaming parse mid-trace
+func TestParseKernelEventsCtxCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.json")
+	var sb strings.Builder
+	sb.WriteString("{\"traceEvents\": [")
+	for i := 0; i < 3*ctxCheckInterval; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, "{\"name\": \"k%d\", \"cat\": \"kernel\", \"ph\": \"X\", \"ts\": %d, \"dur\": 1}", i%7, i*2)
+	}
+	sb.WriteString("]}")
+	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	count := 0
+	err := ParseKernelEventsWithCallbackCtx(ctx, path, func(KernelEvent) bool {
+		count++
+		cancel()
+		return true
+	})
+	if !errors.Is(err, context.Canceled) || count > ctxCheckInterval {
+		t.Errorf("cancelled parse returned %v after %d kernels, want context.Canceled within %d", err, count, ctxCheckInterval)
+	}
+
+	if _, err := ParseKernelEventsCtx(ctx, path); !errors.Is(err, context.Canceled) {
+		t.Errorf("ParseKernelEventsCtx with a cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+// TestExtractCycleIgnoresTruncatedCycle verifies a repetition cut off by the end of the
+// trace doesn't lower the average cycle time
+func TestExtractCycleIgnoresTruncatedCycle(t *testing.T) {
+	var events []KernelEvent
+	for rep := 0; rep < 3; rep++ {
+		for _, name := range []string{"a", "b", "c"} {
+			events = append(events, KernelEvent{Name: name, Duration: 10})
+		}
+	}
+	events = events[:8] // Third repetition ends after "b"
+	info := &CycleInfo{CycleLength: 3, NumCycles: 3, CycleIndices: []int{0, 3, 6}}
+
+	r := ExtractCycle(events, info)
+	if r.AvgCycleTime != 30 || r.TotalCycleTime != 60 {
+		t.Errorf("AvgCycleTime = %v, TotalCycleTime = %v, want 30 and 60 from the two complete cycles", r.AvgCycleTime, r.TotalCycleTime)
+	}
+}
+
+// TestZeroDurationCycleHasNoNaN verifies untimed cycles report 0% rather than NaN/Inf
+func TestZeroDurationCycleHasNoNaN(t *testing.T) {
+	var events []KernelEvent
+	for rep := 0; rep < 2; rep++ {
+		events = append(events, KernelEvent{Name: "a"}, KernelEvent{Name: "b"})
+	}
+	r := ExtractCycle(events, &CycleInfo{CycleLength: 2, NumCycles: 2, CycleIndices: []int{0, 2}})
+
+	var csvOut, summary bytes.Buffer
+	if err := r.WriteCSV(&csvOut); err != nil {
+		t.Fatal(err)
+	}
+	r.WriteSummary(&summary, DefaultTopN)
+	for name, out := range map[string]string{"CSV": csvOut.String(), "summary": summary.String()} {
+		if strings.Contains(out, "NaN") || strings.Contains(out, "Inf") {
+			t.Errorf("%s output contains NaN/Inf:\n%s", name, out)
+		}
+	}
+}
+
+// TestWriteCompareCSVBothSides verifies baseline and new timing columns, blank on the missing side
+func TestWriteCompareCSVBothSides(t *testing.T) {
+	r := &CompareResult{Matches: []KernelMatch{
+		{EagerKernels: []string{"gemm"}, CompiledKernel: "gemm", EagerDur: 10, EagerMin: 9, EagerMax: 11, EagerStdDev: 0.5,
+			CompiledDur: 8, CompiledMin: 7, CompiledMax: 9, CompiledStdDev: 0.4, MatchType: "exact"},
+		{EagerKernels: []string{"copy"}, CompiledKernel: ".", EagerDur: 2, EagerMin: 2, EagerMax: 2, MatchType: "removed"},
+		{EagerKernels: []string{""}, CompiledKernel: "fused", CompiledDur: 3, CompiledMin: 3, CompiledMax: 3, MatchType: "new_only"},
+	}}
+	var buf bytes.Buffer
+	if err := r.WriteCompareCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	col := make(map[string]int)
+	for i, h := range rows[0] {
+		col[h] = i
+	}
+	gemm, removed, added := rows[2], rows[3], rows[4]
+	if gemm[col["eager_stddev_us"]] != "0.500" || gemm[col["new_min_us"]] != "7.000" || gemm[col["change_pct"]] != "-20.00" {
+		t.Errorf("unexpected timed row: %v", gemm)
+	}
+	if removed[col["eager_dur_us"]] != "2.000" || removed[col["new_dur_us"]] != "" || removed[col["new_stddev_us"]] != "" {
+		t.Errorf("removed row should have baseline timing only: %v", removed)
+	}
+	if added[col["eager_max_us"]] != "" || added[col["new_max_us"]] != "3.000" || added[col["change_pct"]] != "" {
+		t.Errorf("new_only row should have new timing only: %v", added)
+	}
+}
+
+// TestDetectMovesPairsRelocatedKernel verifies a removed/new_only pair with one signature becomes a moved row
+func TestDetectMovesPairsRelocatedKernel(t *testing.T) {
+	matches := []KernelMatch{
+		{EagerKernels: []string{"rope"}, CompiledKernel: ".", EagerDur: 4, Signature: "rope", MatchType: "removed"},
+		{EagerKernels: []string{"norm"}, CompiledKernel: "norm", Signature: "norm", MatchType: "exact"},
+		{EagerKernels: []string{"gemm"}, CompiledKernel: "gemm", Signature: "gemm", MatchType: "exact"},
+		{EagerKernels: []string{""}, CompiledKernel: "rope", CompiledDur: 3, Signature: "rope", MatchType: "new_only"},
+	}
+	got := detectMoves(matches)
+	if len(got) != 3 {
+		t.Fatalf("expected 3 rows, got %d: %+v", len(got), got)
+	}
+	moved := got[2]
+	if moved.MatchType != "moved" || moved.PositionDelta != 2 || moved.EagerDur != 4 || moved.EagerKernels[0] != "rope" || moved.Index != 2 {
+		t.Errorf("unexpected moved row: %+v", moved)
+	}
+}
+
+// TestCycleTimeCV verifies per-repetition cycle times are kept and their variation measured
+func TestCycleTimeCV(t *testing.T) {
+	var events []KernelEvent
+	for _, d := range []float64{10, 10, 10, 30} {
+		events = append(events, KernelEvent{Name: "a", Duration: d}, KernelEvent{Name: "b", Duration: d})
+	}
+	r := ExtractCycle(events, &CycleInfo{CycleLength: 2, NumCycles: 4, CycleIndices: []int{0, 2, 4, 6}})
+	if len(r.CycleTimes) != 4 || r.CycleTimes[3] != 60 {
+		t.Fatalf("CycleTimes = %v, want 4 repetitions ending with 60", r.CycleTimes)
+	}
+	if r.CycleTimeCV <= CycleTimeCVWarnThreshold {
+		t.Errorf("CycleTimeCV = %.1f, expected above the %.0f%% warning threshold", r.CycleTimeCV, CycleTimeCVWarnThreshold)
+	}
+}
+
+// TestStreamExtractCycleMatchesExtractCycle verifies the two-pass streaming extractor
+// gives the same statistics as the in-memory one on the run it finds
+func TestStreamExtractCycleMatchesExtractCycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.json")
+	var sb strings.Builder
+	sb.WriteString("{\"traceEvents\": [")
+	ts := 0.0
+	write := func(name string, dur float64) {
+		if ts > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, "{\"name\": \"%s\", \"cat\": \"kernel\", \"ph\": \"X\", \"ts\": %g, \"dur\": %g}", name, ts+1, dur)
+		ts += dur + 1 + float64(int(ts)%3)
+	}
+	for i := 0; i < 3; i++ {
+		write(fmt.Sprintf("warmup%d", i), 50)
+	}
+	for rep := 0; rep < 60; rep++ {
+		for i := 0; i < 12; i++ {
+			write(fmt.Sprintf("k%d", i), float64(1+(rep*7+i*3)%5)+float64(i)/4)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		write("tail", 9)
+	}
+	sb.WriteString("]}")
+	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := StreamExtractCycle(path, 10, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := ParseKernelEvents(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ExtractCycle(events, detector().VerifyCycleQuick(events, got.CycleLength, got.StartIndex))
+
+	if got.CycleLength != 12 || got.NumCycles != want.NumCycles || got.EndIndex != want.EndIndex {
+		t.Fatalf("stream: length %d, %d reps, end %d; in-memory: length %d, %d reps, end %d",
+			got.CycleLength, got.NumCycles, got.EndIndex, want.CycleLength, want.NumCycles, want.EndIndex)
+	}
+	if !floatClose(got.AvgCycleTime, want.AvgCycleTime, 1e-9) || !floatClose(got.AvgIdleTime, want.AvgIdleTime, 1e-9) {
+		t.Errorf("stream cycle/idle time %v/%v, in-memory %v/%v", got.AvgCycleTime, got.AvgIdleTime, want.AvgCycleTime, want.AvgIdleTime)
+	}
+	for i, k := range got.Kernels {
+		w := want.Kernels[i]
+		if k.Name != w.Name || k.Count != w.Count || k.MinAtCycle != w.MinAtCycle ||
+			!floatClose(k.AvgDur, w.AvgDur, 1e-9) || !floatClose(k.StdDev, w.StdDev, 1e-9) ||
+			!floatClose(k.P90, w.P90, 1e-9) || !floatClose(k.IdleBefore, w.IdleBefore, 1e-9) ||
+			!floatClose(k.IdleAfter, w.IdleAfter, 1e-9) {
+			t.Errorf("position %d: stream %+v, in-memory %+v", i, k, w)
+		}
+	}
+}
+
+// TestWriteFolded verifies kernels are summed per category;signature stack
+func TestWriteFolded(t *testing.T) {
+	r := &CycleResult{Kernels: []KernelStats{
+		{Name: "rms_norm_kernel", AvgDur: 10.4},
+		{Name: "Cijk_Alik_MT128x128x32", AvgDur: 100.2},
+		{Name: "rms_norm_kernel", AvgDur: 10.4},
+		{Name: "tiny_copy", AvgDur: 0.2},
+	}}
+	var buf bytes.Buffer
+	if err := r.WriteFolded(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "GEMM/BLAS;Cijk_Alik_MT128x128x32 100\nNormalization;rms_norm_kernel 21\n"
+	if buf.String() != want {
+		t.Errorf("WriteFolded =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+// TestWriteSpeedscope verifies kernels are laid out in order with idle gaps and
+// repeated names share a frame
+func TestWriteSpeedscope(t *testing.T) {
+	r := &CycleResult{CycleLength: 3, NumCycles: 5, Kernels: []KernelStats{
+		{Name: "a", AvgDur: 10, IdleBefore: 4},
+		{Name: "b", AvgDur: 5, IdleBefore: 2},
+		{Name: "a", AvgDur: 10, IdleBefore: 1},
+	}}
+	var buf bytes.Buffer
+	if err := r.WriteSpeedscope(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var file speedscopeFile
+	if err := json.Unmarshal(buf.Bytes(), &file); err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Shared.Frames) != 2 || len(file.Profiles) != 1 {
+		t.Fatalf("got %d frames, %d profiles; want 2, 1", len(file.Shared.Frames), len(file.Profiles))
+	}
+	p := file.Profiles[0]
+	want := []speedscopeEvent{{"O", 0, 0}, {"C", 0, 10}, {"O", 1, 12}, {"C", 1, 17}, {"O", 0, 18}, {"C", 0, 28}}
+	if p.Type != "evented" || p.EndValue != 28 || !slices.Equal(p.Events, want) {
+		t.Errorf("profile %s ending %v with events %v, want evented ending 28 with %v", p.Type, p.EndValue, p.Events, want)
+	}
+}
+
+// TestAggregateBySignature verifies repeated signatures collapse into one row whose
+// time is the sum of its instances
+func TestAggregateBySignature(t *testing.T) {
+	kernels := []KernelStats{
+		{Name: "attn_fwd", AvgDur: 10, MinDur: 9, StdDev: 3, Count: 4, P50: 10},
+		{Name: "gemm_a", AvgDur: 30, MinDur: 28, Count: 4, P50: 30},
+		{Name: "attn_fwd", AvgDur: 30, MinDur: 25, StdDev: 4, Count: 4, P50: 30},
+	}
+	got := aggregateBySignature(kernels)
+	if len(got) != 2 || got[0].Name != "attn_fwd" || got[1].IndexInCycle != 1 {
+		t.Fatalf("aggregateBySignature = %+v, want attn_fwd then gemm_a", got)
+	}
+	a := got[0]
+	if a.AvgDur != 40 || a.MinDur != 34 || a.Count != 8 || a.StdDev != 5 || a.P50 != 25 {
+		t.Errorf("attn_fwd aggregated to avg %v, min %v, count %d, stddev %v, p50 %v; want 40, 34, 8, 5, 25",
+			a.AvgDur, a.MinDur, a.Count, a.StdDev, a.P50)
+	}
+}
+
+// TestReadKernelsFromCSVRoundTrip verifies WriteCSV output, metadata rows and all,
+// reads back through the comparison reader, in any supported delimiter
+func TestReadKernelsFromCSVRoundTrip(t *testing.T) {
+	r := &CycleResult{CycleLength: 2, NumCycles: 7, AvgCycleTime: 40, KernelsByName: map[string]int{}, Kernels: []KernelStats{
+		{Name: "gemm, fused", AvgDur: 30, MinDur: 28, MaxDur: 33, StdDev: 1.5, Count: 7},
+		{Name: "norm", AvgDur: 10, MinDur: 9, MaxDur: 11, StdDev: 0.5, Count: 7},
+	}}
+	var buf bytes.Buffer
+	if err := r.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	semicolon := strings.NewReplacer(",", ";").Replace(strings.ReplaceAll(buf.String(), "gemm, fused", "gemm_fused"))
+
+	for name, content := range map[string]string{"comma": buf.String(), "semicolon": "\n# note\n" + semicolon} {
+		path := filepath.Join(t.TempDir(), name+".csv")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		data, err := readKernelsFromCSV(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if data.Iterations != 7 || data.AvgCycleTime != 40 || data.SchemaVersion != CSVSchemaVersion || len(data.Kernels) != 2 {
+			t.Fatalf("%s: read %d iterations, avg %v, schema %d, %d kernels", name,
+				data.Iterations, data.AvgCycleTime, data.SchemaVersion, len(data.Kernels))
+		}
+		k := data.Kernels[0]
+		if !strings.HasPrefix(k.Name, "gemm") || k.AvgDur != 30 || k.MinDur != 28 || k.MaxDur != 33 || k.StdDev != 1.5 || data.PctOfCycle[0] != 75 {
+			t.Errorf("%s: first kernel read as %+v (pct %v)", name, k, data.PctOfCycle[0])
+		}
+	}
+}
+
+// TestCustomCategories verifies -kernel-categories rules take precedence over the
+// built-in patterns
+func TestCustomCategories(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "categories.txt")
+	if err := os.WriteFile(path, []byte("# team kernels\nMoE=moe_align\nCustom Norm = fused_add_rms_norm\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := loadCategoryRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	CustomCategories = rules
+	defer func() { CustomCategories = nil }()
+
+	for name, want := range map[string]string{
+		"moe_align_block_size_kernel": "MoE",
+		"Fused_Add_RMS_Norm_kernel":   "Custom Norm",
+		"rms_norm_kernel":             "Normalization",
+	} {
+		if got := categorizeKernel(name); got != want {
+			t.Errorf("categorizeKernel(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestCycleSpanAndUtilization verifies the wall-clock span covers idle gaps, and that a
+// trace without timestamps reports no utilization
+func TestCycleSpanAndUtilization(t *testing.T) {
+	var events []KernelEvent
+	for rep := 0; rep < 2; rep++ {
+		base := float64(rep * 100)
+		events = append(events,
+			KernelEvent{Name: "a", Timestamp: base + 10, Duration: 20},
+			KernelEvent{Name: "b", Timestamp: base + 40, Duration: 20})
+	}
+	info := &CycleInfo{CycleLength: 2, NumCycles: 2, CycleIndices: []int{0, 2}}
+	r := ExtractCycle(events, info)
+	if r.AvgCycleSpan != 50 || r.GPUUtilization != 80 {
+		t.Errorf("span %v, utilization %v; want 50, 80", r.AvgCycleSpan, r.GPUUtilization)
+	}
+
+	for i := range events {
+		events[i].Timestamp = 0
+	}
+	if r := ExtractCycle(events, info); r.AvgCycleSpan != 0 || r.GPUUtilization != 0 {
+		t.Errorf("timing-less trace: span %v, utilization %v; want 0, 0", r.AvgCycleSpan, r.GPUUtilization)
+	}
+}
+
+// TestCompareMulti verifies each config is lined up against the baseline rows, with
+// missing kernels left at zero and config-only kernels appended
+func TestCompareMulti(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, rows string) string {
+		path := filepath.Join(dir, name+".csv")
+		if err := os.WriteFile(path, []byte("index,kernel_name,avg_duration_us\n"+rows), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	base := write("base", "0,rms_norm,10\n1,gemm_a,40\n2,softmax,5\n")
+	opt1 := write("opt1", "0,rms_norm,8\n1,gemm_a,30\n2,softmax,5\n3,new_fused_op,7\n")
+	opt2 := write("opt2", "0,gemm_a,35\n1,softmax,4\n2,new_fused_op,6\n3,new_fused_op,2\n")
+
+	CompareMode = "match"
+	defer func() { CompareMode = "align" }()
+	r, err := CompareMulti([]string{"base", "opt1", "opt2"}, []string{base, opt1, opt2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MultiCompareRow{
+		{"rms_norm", []float64{10, 8, 0}},
+		{"gemm_a", []float64{40, 30, 35}},
+		{"softmax", []float64{5, 5, 4}},
+		// Both configs add new_fused_op: one shared row, plus one for opt2's second copy
+		{"new_fused_op", []float64{0, 7, 6}},
+		{"new_fused_op", []float64{0, 0, 2}},
+	}
+	if len(r.Rows) != len(want) {
+		t.Fatalf("got %d rows %+v, want %d", len(r.Rows), r.Rows, len(want))
+	}
+	for i, w := range want {
+		if r.Rows[i].Kernel != w.Kernel || !slices.Equal(r.Rows[i].Durations, w.Durations) {
+			t.Errorf("row %d = %+v, want %+v", i, r.Rows[i], w)
+		}
+	}
+	if totals := r.Totals(); !slices.Equal(totals, []float64{55, 50, 47}) {
+		t.Errorf("Totals() = %v, want [55 50 47]", totals)
+	}
+}
+
+// TestCategoryRollup verifies baseline and new time are totalled per category, with
+// removed and new-only kernels counted on their own side
+func TestCategoryRollup(t *testing.T) {
+	r := &CompareResult{Matches: []KernelMatch{
+		{EagerKernels: []string{"Cijk_gemm_a"}, EagerDur: 100, CompiledKernel: "Cijk_gemm_b", CompiledDur: 70, MatchType: "similar"},
+		{EagerKernels: []string{"paged_attention_v1"}, EagerDur: 50, CompiledKernel: "paged_attention_v2", CompiledDur: 55, MatchType: "similar"},
+		{EagerKernels: []string{"rms_norm"}, EagerDur: 5, CompiledKernel: ".", MatchType: "removed"},
+		{EagerKernels: []string{"(none)"}, CompiledKernel: "triton_poi_fused", CompiledDur: 8, MatchType: "new_only"},
+	}}
+	var buf bytes.Buffer
+	r.writeCategoryRollup(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"GEMM/BLAS           :     100.00 ->      70.00 µs (-30.0%)",
+		"Attention           :      50.00 ->      55.00 µs (+10.0%)",
+		"Normalization       :       5.00 ->       0.00 µs (-100.0%)",
+		"Triton              :       0.00 ->       8.00 µs (new)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("rollup missing %q:\n%s", want, out)
+		}
+	}
+}
+
+// TestFindCycleFiles verifies discovery survives gaps, sorts numerically, finds
+// -name-by-signature files and skips companion files like _durations.csv
+func TestFindCycleFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"run_cycle_1.csv", "run_cycle_2.csv", "run_cycle_4.csv", "run_cycle_10.csv",
+		"run_cycle_1_durations.csv", "other_cycle_3.csv"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := findCycleFiles(filepath.Join(dir, "run"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, f := range files {
+		keys = append(keys, f.key)
+	}
+	if !slices.Equal(keys, []string{"1", "2", "4", "10"}) {
+		t.Errorf("findCycleFiles found cycles %v, want [1 2 4 10]", keys)
+	}
+
+	// -name-by-signature files, with a key collision told apart by start index
+	for _, name := range []string{"sig_0badf00d.csv", "sig_3fa2b1c0_at900.csv", "sig_3fa2b1c0.csv",
+		"sig_3fa2b1c0_at120.csv", "sig_3fa2b1c0_durations.csv", "sig_manifest.csv"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err = findCycleFiles(filepath.Join(dir, "sig"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range files {
+		got = append(got, f.key+"="+filepath.Base(f.path))
+	}
+	want := []string{"0badf00d=sig_0badf00d.csv", "3fa2b1c0=sig_3fa2b1c0.csv",
+		"3fa2b1c0-2=sig_3fa2b1c0_at120.csv", "3fa2b1c0-3=sig_3fa2b1c0_at900.csv"}
+	if !slices.Equal(got, want) {
+		t.Errorf("findCycleFiles found %v, want %v", got, want)
+	}
+}
+
+// TestDetectCycleSuffix verifies the suffix detector finds the period of a trace with
+// no unique anchor kernel and agrees with the k-mer detector
+func TestDetectCycleSuffix(t *testing.T) {
+	// 24 kernels per cycle over 6 names, each appearing 4 times
+	cycle := strings.Split("a b c d e f a c b e d f b a d c f e c a e f b d", " ")
+	var events []KernelEvent
+	for _, name := range []string{"init", "load", "a", "warm", "b", "init", "c"} {
+		events = append(events, KernelEvent{Name: name, Duration: 1})
+	}
+	for rep := 0; rep < 40; rep++ {
+		for _, name := range cycle {
+			events = append(events, KernelEvent{Name: name, Duration: 1})
+		}
+	}
+	for _, name := range []string{"a", "b", "save", "sync", "done"} {
+		events = append(events, KernelEvent{Name: name, Duration: 1})
+	}
+	LogOutput = io.Discard
+	defer func() { LogOutput = os.Stderr }()
+
+	info := detector().DetectCycleSuffix(events, 10, 0)
+	if info == nil {
+		t.Fatal("DetectCycleSuffix found no cycle")
+	}
+	if info.CycleLength != len(cycle) {
+		t.Errorf("CycleLength = %d, want %d", info.CycleLength, len(cycle))
+	}
+	if info.NumCycles < 39 {
+		t.Errorf("NumCycles = %d, want at least 39", info.NumCycles)
+	}
+	kmer := detector().DetectCyclesKmer(events, 3, 10)
+	if len(kmer) == 0 || kmer[0].Length != info.CycleLength {
+		t.Errorf("k-mer detector found %+v, want length %d like the suffix detector", kmer, info.CycleLength)
+	}
+
+	if _, err := detectCyclePatterns(events, "fourier"); err == nil {
+		t.Error("Expected an error for an unknown -algo")
+	}
+}
+
+// TestDetectCyclesKmerSweep verifies the sweep recovers a cycle that k=1 misses because
+// every kernel repeats within it
+func TestDetectCyclesKmerSweep(t *testing.T) {
+	cycle := strings.Split("a b c d e f a c b e d f b a d c f e c a e f b d", " ")
+	var events []KernelEvent
+	for rep := 0; rep < 30; rep++ {
+		for _, name := range cycle {
+			events = append(events, KernelEvent{Name: name, Duration: 1})
+		}
+	}
+	LogOutput = io.Discard
+	defer func() { LogOutput = os.Stderr }()
+
+	for _, c := range detector().DetectCyclesKmer(events, 1, 10) {
+		if c.Length == len(cycle) {
+			t.Fatalf("k=1 unexpectedly found the %d-kernel cycle", len(cycle))
+		}
+	}
+	cycles := detector().DetectCyclesKmerSweep(events, 1, 4, 10)
+	if len(cycles) != 1 || cycles[0].Length != len(cycle) || cycles[0].K < 2 {
+		t.Errorf("sweep found %+v, want one %d-kernel cycle from k >= 2", cycles, len(cycle))
+	}
+
+	if _, _, err := parseKRange("4-2"); err == nil {
+		t.Error("Expected an error for a descending k range")
+	}
+}
+
+// TestDetectorsHonorNormalizeNames verifies the k-mer and simple detectors treat
+// numbered triton variants as one kernel when NormalizeNames is set
+func TestDetectorsHonorNormalizeNames(t *testing.T) {
+	var events []KernelEvent
+	for rep := 0; rep < 20; rep++ {
+		for k := 0; k < 12; k++ {
+			name := "gemm_" + strconv.Itoa(k)
+			if k%4 == 0 {
+				// Variant suffix changes every repetition
+				name = fmt.Sprintf("triton_poi_fused_%d_%d", k, rep)
+			}
+			events = append(events, KernelEvent{Name: name, Duration: 1})
+		}
+	}
+	LogOutput = io.Discard
+	defer func() { LogOutput = os.Stderr }()
+	defer func() { NormalizeNames = false }()
+
+	NormalizeNames = false
+	if c := detector().DetectCyclesSimple(events, 10); len(c) != 0 {
+		t.Errorf("Without normalization, simple detector found %+v, want none", c)
+	}
+
+	NormalizeNames = true
+	kmer := detector().DetectCyclesKmer(events, 3, 10)
+	if len(kmer) != 1 || kmer[0].Length != 12 || kmer[0].Repetitions < 19 {
+		t.Errorf("k-mer detector found %+v, want one 12-kernel cycle x19+", kmer)
+	}
+	simple := detector().DetectCyclesSimple(events, 10)
+	if len(simple) != 1 || simple[0].Length != 12 || simple[0].Repetitions != 20 {
+		t.Errorf("simple detector found %+v, want one 12-kernel cycle x20", simple)
+	}
+}
+
+// TestNormalizeKernelName verifies the default triton rule and the optional suffix rules
+func TestNormalizeKernelName(t *testing.T) {
+	defer func(prev []normalizeRule) { NormalizeRules = prev }(NormalizeRules)
+
+	defaults := map[string]string{
+		"triton_red_fused_add_123": "triton_red_fused_add",
+		"triton_poi_fused_1_2":     "triton_poi_fused_1",
+		"triton_per_fused_sum":     "triton_per_fused_sum",
+		"gemm_kernel_128":          "gemm_kernel_128",
+	}
+	for in, want := range defaults {
+		if got := normalizeKernelName(in); got != want {
+			t.Errorf("default normalizeKernelName(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	rules, err := parseNormalizeRules("triton,version,numeric,hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	NormalizeRules = rules
+	broad := map[string]string{
+		"triton_red_fused_add_123": "triton_red_fused_add",
+		"attn_fwd_v2":              "attn_fwd",
+		"gemm_kernel_128":          "gemm_kernel",
+		"fused_mlp_128_v3":         "fused_mlp",
+		"custom_rmsnorm_abc123":    "custom_rmsnorm",
+		"gemm_bf16":                "gemm_bf16",
+		"gemm_f16":                 "gemm_f16",
+		"gemm_f32":                 "gemm_f32",
+		"attn_fa2":                 "attn_fa2",
+		"reduce_deadbeef":          "reduce_deadbeef",
+		"decode_facade":            "decode_facade",
+		"_42":                      "_42",
+	}
+	for in, want := range broad {
+		if got := normalizeKernelName(in); got != want {
+			t.Errorf("normalizeKernelName(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	NormalizeRules, err = parseNormalizeRules(`_cfg\d+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := normalizeKernelName("moe_gate_cfg7"); got != "moe_gate" {
+		t.Errorf("custom rule: got %q, want moe_gate", got)
+	}
+	if _, err := parseNormalizeRules("(unclosed"); err == nil {
+		t.Error("Expected an error for an invalid suffix regex")
+	}
+}
+
+// TestLabelPhases verifies phases mode keeps every significant pattern in temporal order
+func TestLabelPhases(t *testing.T) {
+	pattern := func(length, reps int, center float64) CyclePattern {
+		return CyclePattern{Info: &CycleInfo{CycleLength: length, NumCycles: reps}, CenterPos: center}
+	}
+	patterns := []CyclePattern{
+		pattern(40, 100, 7000), // verify loop
+		pattern(300, 5, 500),   // prefill
+		pattern(12, 2, 5000),   // too small to be significant
+		pattern(25, 150, 3000), // draft loop
+	}
+
+	phases := labelPhases(patterns, 10000)
+	var got []string
+	for _, p := range phases {
+		got = append(got, fmt.Sprintf("%s:%d", p.Label, p.Pattern.Info.CycleLength))
+	}
+	if want := []string{"prefill:300", "decode-1:25", "decode-2:40"}; !slices.Equal(got, want) {
+		t.Errorf("labelPhases = %v, want %v", got, want)
+	}
+
+	if phases := labelPhases(patterns[:1], 10000); len(phases) != 1 || phases[0].Label != "decode-1" {
+		t.Errorf("single pattern labeled %+v, want decode-1", phases)
+	}
+}
+
+// TestOutputManifest verifies default mode lists every written cycle CSV in the manifest
+func TestOutputManifest(t *testing.T) {
+	var events []KernelEvent
+	ts := 0.0
+	for rep := 0; rep < 20; rep++ {
+		for i := 0; i < 10; i++ {
+			events = append(events, KernelEvent{Name: "k" + strconv.Itoa(i), Timestamp: ts, Duration: 2})
+			ts += 3
+		}
+	}
+	info := &CycleInfo{StartIndex: 0, CycleLength: 10, NumCycles: 20}
+	for rep := 0; rep < 20; rep++ {
+		info.CycleIndices = append(info.CycleIndices, rep*10)
+	}
+	patterns := []CyclePattern{detect.PatternFromInfo(events, info)}
+
+	base := filepath.Join(t.TempDir(), "trace")
+	outputAllPatterns(events, patterns, base, false)
+
+	data, err := os.ReadFile(base + "_manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest outputManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.TotalEvents != 200 || len(manifest.Cycles) != 1 {
+		t.Fatalf("manifest = %+v, want 200 events and one cycle", manifest)
+	}
+	c := manifest.Cycles[0]
+	if c.File != base+"_cycle_1.csv" || c.Length != 10 || c.Repetitions != 20 || c.AvgCycleTime != 20 || c.CenterPct != 50 {
+		t.Errorf("manifest entry = %+v", c)
+	}
+	if _, err := os.Stat(c.File); err != nil {
+		t.Errorf("manifest lists %s, which was not written: %v", c.File, err)
+	}
+}
+
+// TestLogLevels verifies -quiet keeps only errors and -verbose adds detection detail
+func TestLogLevels(t *testing.T) {
+	var buf bytes.Buffer
+	LogOutput = &buf
+	defer func() {
+		LogOutput = os.Stderr
+		Verbosity = LogNormal
+		ExplainDetection = false
+	}()
+
+	emit := func() string {
+		buf.Reset()
+		logf("progress\n")
+		logVerbosef("detail\n")
+		logErrorf("error\n")
+		fmt.Fprint(logWriter(), "summary\n")
+		return buf.String()
+	}
+
+	for _, tc := range []struct {
+		quiet, verbose bool
+		want           string
+	}{
+		{false, false, "progress\nerror\nsummary\n"},
+		{true, false, "error\n"},
+		{false, true, "progress\ndetail\nerror\nsummary\n"},
+	} {
+		if err := setLogLevel(tc.quiet, tc.verbose); err != nil {
+			t.Fatal(err)
+		}
+		if got := emit(); got != tc.want {
+			t.Errorf("quiet=%v verbose=%v: got %q, want %q", tc.quiet, tc.verbose, got, tc.want)
+		}
+	}
+	if !ExplainDetection {
+		t.Error("Expected -verbose to turn on ExplainDetection")
+	}
+	if err := setLogLevel(true, true); err == nil {
+		t.Error("Expected an error for -quiet with -verbose")
+	}
+}
+
+// TestPatternOrderIsDeterministic verifies repeated detection numbers cycles identically
+func TestPatternOrderIsDeterministic(t *testing.T) {
+	var events []KernelEvent
+	for rep := 0; rep < 10; rep++ {
+		for i := 0; i < 15; i++ {
+			events = append(events, KernelEvent{Name: "prefill_" + strconv.Itoa(i), Duration: float64(10 + i)})
+		}
+	}
+	for rep := 0; rep < 30; rep++ {
+		for i := 0; i < 12; i++ {
+			events = append(events, KernelEvent{Name: "decode_" + strconv.Itoa(i), Duration: float64(1 + i%3)})
+		}
+	}
+	LogOutput = io.Discard
+	defer func() { LogOutput = os.Stderr }()
+
+	describe := func() string {
+		var sb strings.Builder
+		patterns := detector().FindCyclePatterns(events)
+		dir := t.TempDir()
+		outputAllPatterns(events, patterns, filepath.Join(dir, "run"), false)
+		detect.SortPatternsByPosition(patterns)
+		for i := range patterns {
+			data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("run_cycle_%d.csv", i+1)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintf(&sb, "%d:%s\n%s", i+1, patterns[i].Signature, data)
+		}
+		return sb.String()
+	}
+
+	first := describe()
+	if !strings.HasPrefix(first, "1:prefill") || !strings.Contains(first, "\n2:decode") {
+		t.Fatalf("expected prefill as cycle 1 and decode as cycle 2, got:\n%s", first)
+	}
+	for run := 0; run < 10; run++ {
+		if got := describe(); got != first {
+			t.Fatalf("run %d numbered cycles differently:\n%s\nvs\n%s", run+2, got, first)
+		}
+	}
+}
+
+// TestEarlyStopReps verifies ParseWithEarlyStop reads until EarlyStopReps repetitions
+// are seen, and reads everything when early stop is off
+func TestEarlyStopReps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.json")
+	var sb strings.Builder
+	sb.WriteString("{\"traceEvents\": [")
+	for i := 0; i < 30000; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, "{\"name\": \"k%d\", \"cat\": \"kernel\", \"ph\": \"X\", \"ts\": %d, \"dur\": 1}", i%20, i*2)
+	}
+	sb.WriteString("]}")
+	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	LogOutput = io.Discard
+	defer func() {
+		LogOutput = os.Stderr
+		EarlyStopReps = DefaultEarlyStopReps
+	}()
+
+	for _, tc := range []struct {
+		reps, want int
+	}{
+		{DefaultEarlyStopReps, 10000}, // First check already sees 500 repetitions
+		{990, 20000},                  // ~500 at the first check, ~1000 at the second
+		{0, 30000},
+	} {
+		EarlyStopReps = tc.reps
+		events, err := ParseWithEarlyStop(path, 10, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != tc.want {
+			t.Errorf("EarlyStopReps=%d: parsed %d events, want %d", tc.reps, len(events), tc.want)
+		}
+	}
+}
+
+// TestWarmupKernelsReported verifies kernels before the cycle start are counted, named
+// and mentioned in the summary
+func TestWarmupKernelsReported(t *testing.T) {
+	var events []KernelEvent
+	for i := 0; i < 7; i++ {
+		events = append(events, KernelEvent{Name: "warmup_" + strconv.Itoa(i), Duration: 100})
+	}
+	info := &CycleInfo{StartIndex: 7, CycleLength: 3, NumCycles: 4}
+	for rep := 0; rep < 4; rep++ {
+		for _, name := range []string{"a", "b", "c"} {
+			events = append(events, KernelEvent{Name: name, Duration: 1})
+		}
+		info.CycleIndices = append(info.CycleIndices, 7+rep*3)
+	}
+
+	r := ExtractCycle(events, info)
+	if r.WarmupEvents != 7 || !slices.Equal(r.WarmupKernels, []string{"warmup_0", "warmup_1", "warmup_2", "warmup_3", "warmup_4"}) {
+		t.Errorf("WarmupEvents = %d, WarmupKernels = %v", r.WarmupEvents, r.WarmupKernels)
+	}
+	var summary bytes.Buffer
+	r.WriteSummary(&summary, DefaultTopN)
+	if want := "Skipped 7 warmup kernels before cycle start (first: warmup_0, warmup_1, warmup_2, warmup_3, warmup_4, ...)"; !strings.Contains(summary.String(), want) {
+		t.Errorf("summary missing %q:\n%s", want, summary.String())
+	}
+
+	if p := detect.PatternFromInfo(events, info); p.WarmupEvents != 7 || len(p.WarmupKernels) != 5 {
+		t.Errorf("pattern warmup = %d %v", p.WarmupEvents, p.WarmupKernels)
+	}
+	if line := warmupLine(0, nil); line != "" {
+		t.Errorf("warmupLine(0) = %q, want empty", line)
+	}
+}
+
+// TestCompareMissingTiming verifies a CSV whose kernels have no duration only warns by
+// default and fails CompareFromCSV under StrictTiming
+func TestCompareMissingTiming(t *testing.T) {
+	write := func(name string, dur float64) string {
+		r := &CycleResult{CycleLength: 3, NumCycles: 5, KernelsByName: map[string]int{}}
+		for _, k := range []string{"gemm", "norm", "attn"} {
+			r.Kernels = append(r.Kernels, KernelStats{Name: k, AvgDur: dur, MinDur: dur, MaxDur: dur, Count: 5})
+			r.AvgCycleTime += dur
+		}
+		var buf bytes.Buffer
+		if err := r.WriteCSV(&buf); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	timed, untimed := write("timed.csv", 10), write("untimed.csv", 0)
+
+	defer func() { StrictTiming = false }()
+	StrictTiming = false
+	if _, err := CompareFromCSV(timed, untimed); err != nil {
+		t.Errorf("without -strict: %v", err)
+	}
+	StrictTiming = true
+	if _, err := CompareFromCSV(timed, timed); err != nil {
+		t.Errorf("timed CSVs under -strict: %v", err)
+	}
+	if _, err := CompareFromCSV(timed, untimed); err == nil || !strings.Contains(err.Error(), "untimed.csv") {
+		t.Errorf("untimed CSV under -strict: err = %v, want one naming untimed.csv", err)
+	}
+}
+
+// TestCompareTraceOneStep verifies CompareTraces detects and matches cycles straight
+// from two raw traces, and its result writes through the compare-csv output path
+func TestCompareTraceOneStep(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, slowDur int) string {
+		var sb strings.Builder
+		sb.WriteString("{\"traceEvents\": [")
+		for i := 0; i < 600; i++ {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			dur := 10
+			if i%6 == 2 {
+				dur = slowDur
+			}
+			fmt.Fprintf(&sb, "{\"name\": \"k%d\", \"cat\": \"kernel\", \"ph\": \"X\", \"ts\": %d, \"dur\": %d}", i%6, i*100, dur)
+		}
+		sb.WriteString("]}")
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	base, opt := write("base.json", 40), write("opt.json", 20)
+	LogOutput = io.Discard
+	defer func() { LogOutput = os.Stderr }()
+
+	result, err := CompareTraces(base, opt, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The detector may report the cycle as several 6-kernel periods
+	n := result.EagerCycle
+	if n == 0 || n%6 != 0 || result.CompiledCycle != n || result.ExactCount != n {
+		t.Fatalf("cycles %d/%d with %d exact matches, want equal multiples of 6, all exact",
+			result.EagerCycle, result.CompiledCycle, result.ExactCount)
+	}
+	if want := float64(n / 6); result.BaselineCycleTime != 90*want || result.NewCycleTime != 70*want {
+		t.Errorf("cycle times %v -> %v, want %v -> %v", result.BaselineCycleTime, result.NewCycleTime, 90*want, 70*want)
+	}
+
+	out := filepath.Join(dir, "cmp.csv")
+	if err := writeCompareOutput(result, out); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(out); err != nil || !strings.Contains(string(data), "k2,k2,20.000") {
+		t.Errorf("comparison CSV missing k2 row (err %v):\n%s", err, data)
+	}
+}
+
+// TestAlignRotationUnequalLengths verifies align mode finds the rotation between
+// cycles of different lengths: a 10-kernel baseline against an 8-kernel new cycle
+// (two kernels fused away) that starts 3 positions later, and the reverse
+func TestAlignRotationUnequalLengths(t *testing.T) {
+	names := []string{"embed", "rmsnorm", "qkv_proj", "rope", "attention", "out_proj", "residual", "gate_up", "silu", "down_proj"}
+	long := &CycleResult{}
+	for _, name := range names {
+		long.Kernels = append(long.Kernels, KernelStats{Name: name, AvgDur: 10})
+	}
+	short := &CycleResult{}
+	for i := 3; i < 3+len(names); i++ {
+		if name := names[i%len(names)]; name != "silu" && name != "rope" {
+			short.Kernels = append(short.Kernels, KernelStats{Name: name, AvgDur: 8})
+		}
+	}
+	LogOutput = io.Discard
+	defer func() { LogOutput = os.Stderr }()
+
+	for _, tc := range []struct {
+		name              string
+		baseline, newer   *CycleResult
+		missing, extraNew int
+	}{
+		{"10 vs 8", long, short, 2, 0},
+		{"8 vs 10", short, long, 0, 2},
+	} {
+		got := countMatchTypes(matchByAlignment(tc.baseline, tc.newer))
+		if got["exact"] != 8 || got["removed"] != tc.missing || got["new_only"] != tc.extraNew {
+			t.Errorf("%s: match types %v, want 8 exact, %d removed, %d new_only", tc.name, got, tc.missing, tc.extraNew)
+		}
+	}
+}
+
+// TestWriteCompareCSVGroups verifies a fused match renders as a header row plus indented
+// continuation rows, and with FlatCSV as self-contained rows sharing a group_id
+func TestWriteCompareCSVGroups(t *testing.T) {
+	r := &CompareResult{Matches: []KernelMatch{
+		{EagerKernels: []string{"gemm"}, CompiledKernel: "gemm", EagerDur: 10, CompiledDur: 8, MatchType: "exact"},
+		{EagerKernels: []string{"add", "mul", "relu"}, CompiledKernel: "fused_pointwise", EagerDur: 6, CompiledDur: 2, MatchType: "fused"},
+	}}
+	read := func() [][]string {
+		var buf bytes.Buffer
+		if err := r.WriteCompareCSV(&buf); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+
+	rows := read()
+	if len(rows) != 6 || rows[0][len(rows[0])-1] == "group_id" {
+		t.Fatalf("grouped CSV has %d rows, header %v", len(rows), rows[0])
+	}
+	if rows[3][0] != "add" || rows[4][0] != "  mul" || rows[5][0] != "  relu" || rows[4][1] != "." {
+		t.Errorf("fused group rows: %v / %v / %v", rows[3], rows[4], rows[5])
+	}
+
+	FlatCSV = true
+	defer func() { FlatCSV = false }()
+	rows = read()
+	last := len(rows[0]) - 1
+	if rows[0][last] != "group_id" || rows[1][last] != "" || rows[2][last] != "1" {
+		t.Fatalf("flat header/total/first rows: %v / %v / %v", rows[0], rows[1], rows[2])
+	}
+	for _, row := range rows[3:] {
+		if row[last] != "2" || row[1] != "fused_pointwise" || strings.HasPrefix(row[0], " ") {
+			t.Errorf("flat fused row %v, want group 2 naming fused_pointwise, not indented", row)
+		}
+	}
+}
+
+// TestMemoryComputeSplit verifies a cycle's time splits into memory-movement and compute
+// kernels, a memcpy-heavy cycle is flagged, and a comparison reports the shift
+func TestMemoryComputeSplit(t *testing.T) {
+	var events []KernelEvent
+	info := &CycleInfo{CycleLength: 3, NumCycles: 4}
+	for rep := 0; rep < 4; rep++ {
+		info.CycleIndices = append(info.CycleIndices, len(events))
+		events = append(events,
+			KernelEvent{Name: "gemm", Duration: 10},
+			KernelEvent{Name: "copy_kernel", Duration: 30},
+			KernelEvent{Name: "transpose_kernel", Duration: 20})
+	}
+	r := ExtractCycle(events, info)
+	if r.MemoryTime != 50 || r.ComputeTime != 10 {
+		t.Errorf("MemoryTime = %v, ComputeTime = %v, want 50 and 10", r.MemoryTime, r.ComputeTime)
+	}
+	var summary bytes.Buffer
+	r.WriteSummary(&summary, DefaultTopN)
+	for _, want := range []string{"Memory vs Compute: 50.00 µs memory (83.3%), 10.00 µs compute (16.7%)", "likely bandwidth-bound"} {
+		if !strings.Contains(summary.String(), want) {
+			t.Errorf("summary missing %q:\n%s", want, summary.String())
+		}
+	}
+
+	cmp := &CompareResult{Matches: []KernelMatch{
+		{EagerKernels: []string{"gemm"}, CompiledKernel: "gemm", EagerDur: 40, CompiledDur: 10, MatchType: "exact"},
+		{EagerKernels: []string{"copy_kernel"}, CompiledKernel: "copy_kernel", EagerDur: 20, CompiledDur: 20, MatchType: "exact"},
+	}}
+	if base, updated := cmp.memoryTimes(); base != 20 || updated != 20 {
+		t.Errorf("memoryTimes = %v, %v, want 20, 20", base, updated)
+	}
+	summary.Reset()
+	cmp.WriteSummary(&summary, DefaultTopN)
+	for _, want := range []string{"Memory Share: 33.3% -> 66.7%", "now memory-bound"} {
+		if !strings.Contains(summary.String(), want) {
+			t.Errorf("comparison summary missing %q:\n%s", want, summary.String())
+		}
+	}
+
+	// The CSV summary row and the XLSX header row carry the same split
+	var out bytes.Buffer
+	if err := cmp.WriteCompareCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := records[1][:2]; !slices.Equal(got, []string{
+		"Total (0 eager kernels; 20.00 µs memory, 40.00 µs compute)",
+		"(0 compiled kernels; 20.00 µs memory, 10.00 µs compute)"}) {
+		t.Errorf("CSV summary row = %q", got)
+	}
+	path := filepath.Join(t.TempDir(), "cmp.xlsx")
+	if err := cmp.WriteCompareXLSX(path); err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for cell, want := range map[string]string{"A2": "20.00 µs memory, 40.00 µs compute", "F2": "20.00 µs memory, 10.00 µs compute"} {
+		if got, _ := f.GetCellValue(f.GetSheetName(0), cell); !strings.Contains(got, want) {
+			t.Errorf("XLSX %s = %q, want it to contain %q", cell, got, want)
+		}
+	}
+}
+
+// TestXLSXLegendAndColors verifies -colors overrides are validated and that the
+// comparison workbook's Legend sheet shows the active colors and thresholds
+func TestXLSXLegendAndColors(t *testing.T) {
+	if _, err := parseColorOverrides("bogus=#000000"); err == nil {
+		t.Error("unknown style accepted")
+	}
+	if _, err := parseColorOverrides("improved=green"); err == nil {
+		t.Error("non-hex color accepted")
+	}
+	overrides, err := parseColorOverrides("improved=1b9e77, regressed=#D95F02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	XLSXColors, ChangeThreshold = overrides, 3
+	defer func() { XLSXColors, ChangeThreshold = map[string]string{}, 5 }()
+
+	r := &CompareResult{Matches: []KernelMatch{
+		{EagerKernels: []string{"gemm"}, CompiledKernel: "gemm", EagerDur: 10, CompiledDur: 8, MatchType: "exact"},
+	}}
+	path := filepath.Join(t.TempDir(), "cmp.xlsx")
+	if err := r.WriteCompareXLSX(path); err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := f.GetRows(legendSheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	colors := make(map[string]string)
+	var meaning, threshold string
+	for _, row := range rows {
+		if len(row) >= 2 {
+			colors[row[0]] = row[1]
+		}
+		if len(row) >= 3 && row[0] == "improved" {
+			meaning = row[2]
+		}
+		if len(row) >= 2 && row[0] == "Change threshold (%)" {
+			threshold = row[1]
+		}
+	}
+	if colors["improved"] != "#1B9E77" || colors["regressed"] != "#D95F02" || colors["exact"] != "#E2EFDA" {
+		t.Errorf("legend colors = %v", colors)
+	}
+	if !strings.Contains(meaning, "more than 3%") || threshold != "3" {
+		t.Errorf("legend threshold: meaning %q, value %q", meaning, threshold)
+	}
+
+	// The swatch itself uses the overridden fill
+	styleID, err := f.GetCellStyle(legendSheet, "B7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	style, err := f.GetStyle(styleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(style.Fill.Color) == 0 || !strings.EqualFold(strings.TrimPrefix(style.Fill.Color[0], "#"), "1B9E77") {
+		t.Errorf("improved swatch fill = %v", style.Fill.Color)
+	}
+}
+
+// TestParseTraceDir verifies -input-dir parses every trace file in a directory with
+// several workers and concatenates their events in filename order
+func TestParseTraceDir(t *testing.T) {
+	dir := t.TempDir()
+	for f, name := range []string{"c.json", "a.json", "b.json"} {
+		var sb strings.Builder
+		sb.WriteString("{\"traceEvents\": [")
+		// Non-kernel events take traceEvents positions too: a.json's kernels start at Seq 3
+		if name == "a.json" {
+			sb.WriteString("{\"name\": \"aten::mm\", \"cat\": \"cpu_op\", \"ph\": \"X\", \"ts\": 0, \"dur\": 5},")
+			sb.WriteString("{\"name\": \"aten::add\", \"cat\": \"cpu_op\", \"ph\": \"X\", \"ts\": 1, \"dur\": 5},")
+			sb.WriteString("{\"name\": \"aten::mul\", \"cat\": \"cpu_op\", \"ph\": \"X\", \"ts\": 2, \"dur\": 5},")
+		}
+		for i := 0; i < 120; i++ {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			fmt.Fprintf(&sb, "{\"name\": \"k%d\", \"cat\": \"kernel\", \"ph\": \"X\", \"ts\": %d, \"dur\": %d}", i%12, i*10, f+1)
+		}
+		sb.WriteString("]}")
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(sb.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a trace"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	LogOutput = io.Discard
+	defer func() { LogOutput = os.Stderr }()
+
+	events, err := ParseTraceDir(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 360 {
+		t.Fatalf("parsed %d events, want 360", len(events))
+	}
+	// a.json (dur 2), b.json (dur 3), c.json (dur 1), in that order
+	for i, want := range []float64{2, 3, 1} {
+		if d := events[i*120].Duration; d != want {
+			t.Errorf("file %d starts with duration %v, want %v", i, d, want)
+		}
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Seq <= events[i-1].Seq {
+			t.Fatalf("Seq not increasing across files: event %d has %d after %d", i, events[i].Seq, events[i-1].Seq)
+		}
+	}
+
+	if _, err := ParseTraceDir(t.TempDir(), 2); err == nil {
+		t.Error("empty directory accepted")
+	}
+	if err := RunCycleDetection(DetectOptions{InputDir: dir, OutputBase: filepath.Join(dir, "out"), Mode: "all"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out_cycle_1.csv")); err != nil {
+		t.Errorf("combined detection wrote no cycle CSV: %v", err)
+	}
+}
+
+// TestSeqCountsMalformedEvents verifies Seq is the traceEvents array position, counting
+// elements that fail to decode, in both the slice and the callback parse paths
+func TestSeqCountsMalformedEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.json")
+	trace := `{"traceEvents": [
+		{"name": "a", "cat": "kernel", "ph": "X", "ts": 0, "dur": 1},
+		{"name": "bad", "cat": "kernel", "ph": "X", "ts": "not a number", "dur": 1},
+		{"name": "b", "cat": "kernel", "ph": "X", "ts": 10, "dur": 1}
+	]}`
+	if err := os.WriteFile(path, []byte(trace), 0644); err != nil {
+		t.Fatal(err)
+	}
+	events, err := ParseKernelEvents(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamed []KernelEvent
+	if err := ParseKernelEventsWithCallback(path, func(e KernelEvent) bool {
+		streamed = append(streamed, e)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for name, got := range map[string][]KernelEvent{"slice": events, "callback": streamed} {
+		if len(got) != 2 || got[0].Seq != 0 || got[1].Name != "b" || got[1].Seq != 2 {
+			t.Errorf("%s path: %+v, want a at Seq 0 and b at Seq 2", name, got)
+		}
+	}
+}
+
+// TestParseKernelEventsStdinNDJSON verifies NDJSON on stdin is recognised by content
+// and that parsing leaves stdin open
+func TestParseKernelEventsStdinNDJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stdin")
+	data := "{\"name\": \"k{1}\", \"cat\": \"kernel\", \"ph\": \"X\", \"ts\": 1, \"dur\": 2}\n" +
+		"{\"name\": \"k2\", \"cat\": \"kernel\", \"ph\": \"X\", \"ts\": 3, \"dur\": 2}\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	saved := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = saved }()
+
+	events, err := ParseKernelEvents(StdinInput)
+	if err != nil {
+		t.Fatalf("ParseKernelEvents(stdin) failed: %v", err)
+	}
+	if len(events) != 2 || events[0].Name != "k{1}" || events[1].Name != "k2" {
+		t.Errorf("Expected kernels k{1} and k2, got %+v", events)
+	}
+	if _, err := stdin.Stat(); err != nil {
+		t.Errorf("stdin was closed by the parser: %v", err)
+	}
+
+	if sniffNDJSON(bufio.NewReader(strings.NewReader(`{"traceEvents": [{"name": "k"}]}`))) {
+		t.Error("a single JSON document was sniffed as NDJSON")
+	}
+}
+
+// TestPatternRanking verifies detected patterns are listed by most repetitions, then
+// confidence, then signature, while files stay numbered by position
+func TestPatternRanking(t *testing.T) {
+	var events []KernelEvent
+	for rep := 0; rep < 10; rep++ {
+		for i := 0; i < 15; i++ {
+			events = append(events, KernelEvent{Name: "prefill_" + strconv.Itoa(i), Duration: float64(10 + i)})
+		}
+	}
+	for rep := 0; rep < 30; rep++ {
+		for i := 0; i < 12; i++ {
+			events = append(events, KernelEvent{Name: "decode_" + strconv.Itoa(i), Duration: float64(1 + i%3)})
+		}
+	}
+	LogOutput = io.Discard
+	defer func() { LogOutput = os.Stderr }()
+
+	patterns := detector().FindCyclePatterns(events)
+	if len(patterns) < 2 || !strings.HasPrefix(patterns[0].Signature, "decode") {
+		t.Fatalf("expected the 30-rep decode pattern first, got %d patterns", len(patterns))
+	}
+	for i := 1; i < len(patterns); i++ {
+		if patterns[i].Info.NumCycles > patterns[i-1].Info.NumCycles {
+			t.Errorf("pattern %d has more repetitions than pattern %d", i+1, i)
+		}
+	}
+	outputAllPatterns(events, patterns, filepath.Join(t.TempDir(), "run"), false)
+	if !strings.HasPrefix(patterns[0].Signature, "decode") {
+		t.Error("outputAllPatterns reordered the caller's patterns")
+	}
+
+	ranked := []CyclePattern{
+		{Signature: "c", Confidence: 0.5, Info: &CycleInfo{NumCycles: 5}},
+		{Signature: "b", Confidence: 0.9, Info: &CycleInfo{NumCycles: 5}},
+		{Signature: "d", Confidence: 1, Info: &CycleInfo{NumCycles: 3}},
+		{Signature: "a", Confidence: 0.9, Info: &CycleInfo{NumCycles: 5}},
+		{Signature: "e", Confidence: 0.1, Info: &CycleInfo{NumCycles: 8}},
+	}
+	detect.RankPatterns(ranked)
+	var order []string
+	for _, p := range ranked {
+		order = append(order, p.Signature)
+	}
+	if want := []string{"e", "a", "b", "c", "d"}; !slices.Equal(order, want) {
+		t.Errorf("ranked %v, want %v", order, want)
+	}
+}
+
+// TestWriteCompareMarkdownEscaping verifies table cells escape HTML and pipes while
+// the backticked regressions list shows names verbatim
+func TestWriteCompareMarkdownEscaping(t *testing.T) {
+	r := &CompareResult{EagerName: "base", CompiledName: "new", Matches: []KernelMatch{
+		{Index: 0, EagerKernels: []string{"gemm<float, 128>", "a|b"}, EagerDur: 10,
+			CompiledKernel: "fused<half> & co", CompiledDur: 20, MatchType: "similar"},
+	}}
+	var buf bytes.Buffer
+	if err := r.WriteCompareMarkdown(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"1. `fused<half> & co`: 10.00 → 20.00 µs",
+		"| 0 | gemm&lt;float, 128&gt;<br>a\\|b | fused&lt;half&gt; &amp; co |",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("markdown missing %q:\n%s", want, out)
+		}
+	}
+	if got := markdownCode("a`b"); got != "`` a`b ``" {
+		t.Errorf("markdownCode(a`b) = %q, want \"`` a`b ``\"", got)
+	}
+}
+
+// TestCompareCVColumns verifies -cv adds coefficient of variation to the CSV, JSON and
+// Markdown outputs, blank for the side without timing
+func TestCompareCVColumns(t *testing.T) {
+	EmitCV = true
+	defer func() { EmitCV = false }()
+	r := &CompareResult{EagerName: "base", CompiledName: "new", Matches: []KernelMatch{
+		{Index: 0, EagerKernels: []string{"gemm"}, EagerDur: 10, EagerStdDev: 1,
+			CompiledKernel: "gemm", CompiledDur: 20, CompiledStdDev: 5, MatchType: "exact"},
+		{Index: 1, EagerKernels: []string{"norm"}, EagerDur: 4, EagerStdDev: 2, CompiledKernel: ".", MatchType: "removed"},
+	}}
+
+	var csvBuf bytes.Buffer
+	if err := r.WriteCompareCSV(&csvBuf); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&csvBuf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	col := slices.Index(records[0], "eager_cv_pct")
+	if col < 0 || records[0][col+1] != "new_cv_pct" {
+		t.Fatalf("CSV header missing eager_cv_pct/new_cv_pct: %v", records[0])
+	}
+	if got := records[2][col : col+2]; !slices.Equal(got, []string{"10.00", "25.00"}) {
+		t.Errorf("gemm CV = %v, want [10.00 25.00]", got)
+	}
+	if got := records[3][col : col+2]; !slices.Equal(got, []string{"50.00", ""}) {
+		t.Errorf("removed norm CV = %v, want [50.00 \"\"]", got)
+	}
+
+	var jsonBuf bytes.Buffer
+	if err := r.WriteCompareJSON(&jsonBuf); err != nil {
+		t.Fatal(err)
+	}
+	var doc compareJSON
+	if err := json.Unmarshal(jsonBuf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if m := doc.Matches[1]; m.BaselineCV == nil || *m.BaselineCV != 50 || m.NewCV != nil {
+		t.Errorf("removed norm JSON CV = %v/%v, want 50/null", m.BaselineCV, m.NewCV)
+	}
+
+	var md bytes.Buffer
+	if err := r.WriteCompareMarkdown(&md); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(md.String(), "| Match | Baseline CV | New CV |") || !strings.Contains(md.String(), "| 10.0% | 25.0% |") {
+		t.Errorf("Markdown missing CV columns:\n%s", md.String())
+	}
+}
+
+func TestDetectCyclePatternsKmerLen(t *testing.T) {
+	cycle := strings.Split("a b c d e f a c b e d f b a d c f e c a e f b d", " ")
+	var events []KernelEvent
+	for rep := 0; rep < 30; rep++ {
+		for _, name := range cycle {
+			events = append(events, KernelEvent{Name: name, Duration: 1})
+		}
+	}
+	var buf bytes.Buffer
+	LogOutput = &buf
+	Verbosity = LogVerbose
+	defer func() {
+		LogOutput = os.Stderr
+		Verbosity = LogNormal
+		KmerLenMin, KmerLenMax = 3, 3
+	}()
+
+	for _, tc := range []struct {
+		kMin, kMax int
+		want       []int
+	}{{5, 5, []int{5}}, {1, 4, []int{1, 2, 3, 4}}} {
+		buf.Reset()
+		KmerLenMin, KmerLenMax = tc.kMin, tc.kMax
+		patterns, err := detectCyclePatterns(events, "kmer")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(patterns) == 0 || patterns[0].Info.CycleLength != len(cycle) {
+			t.Errorf("k %d-%d: got %d patterns, want a cycle of length %d", tc.kMin, tc.kMax, len(patterns), len(cycle))
+		}
+		for k := 1; k <= 6; k++ {
+			ran := strings.Contains(buf.String(), fmt.Sprintf("unique %d-mers", k))
+			if want := slices.Contains(tc.want, k); ran != want {
+				t.Errorf("k %d-%d: ran k=%d = %v, want %v", tc.kMin, tc.kMax, k, ran, want)
+			}
+		}
+	}
+}
+
+func TestSharedFlagGroups(t *testing.T) {
+	defer func() {
+		MatchTolerance, MinCycleLen, KmerLenMin, KmerLenMax = 0.95, 10, 3, 3
+		KernelCategories, FilterTid = []string{"kernel"}, -1
+		CompareMode, ChangeThreshold, ExactOnly, FlatCSV = "align", 5, false, false
+		EarlyStopReps = DefaultEarlyStopReps
+	}()
+
+	fs := flag.NewFlagSet("detect", flag.ContinueOnError)
+	parse := addParseFlags(fs)
+	if err := fs.Parse([]string{"-tolerance", "0.8", "-min-cycle", "4", "-k-sweep", "2-5", "-category", "kernel, gpu", "-tid", "7"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := parse.apply(); err != nil {
+		t.Fatal(err)
+	}
+	if MatchTolerance != 0.8 || MinCycleLen != 4 || KmerLenMin != 2 || KmerLenMax != 5 || FilterTid != 7 ||
+		!slices.Equal(KernelCategories, []string{"kernel", "gpu"}) {
+		t.Errorf("parse flags set tolerance %v, min-cycle %d, k %d-%d, tid %d, categories %q",
+			MatchTolerance, MinCycleLen, KmerLenMin, KmerLenMax, FilterTid, KernelCategories)
+	}
+
+	fs = flag.NewFlagSet("detect", flag.ContinueOnError)
+	parse = addParseFlags(fs)
+	fs.Parse([]string{"-k", "0"})
+	if err := parse.apply(); err == nil {
+		t.Error("-k 0 accepted")
+	}
+
+	fs = flag.NewFlagSet("compare", flag.ContinueOnError)
+	shared := addCompareFlags(fs, "match")
+	shared.addReportFlags(fs)
+	if err := fs.Parse([]string{"-threshold", "2", "-exact-only", "-flat"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := shared.apply(); err != nil {
+		t.Fatal(err)
+	}
+	if CompareMode != "match" || ChangeThreshold != 2 || !ExactOnly || !FlatCSV || *shared.topN != DefaultTopN {
+		t.Errorf("compare flags set mode %q, threshold %v, exact-only %v, flat %v, top %d",
+			CompareMode, ChangeThreshold, ExactOnly, FlatCSV, *shared.topN)
+	}
+	if fs.Lookup("cv") != nil {
+		t.Error("-cv registered without addCSVInputFlags")
+	}
+	fs.Parse([]string{"-top", "0"})
+	if err := shared.apply(); err == nil {
+		t.Error("-top 0 accepted")
+	}
+
+	if err := applyEarlyStopReps(0, false); err == nil {
+		t.Error("-early-stop-reps 0 accepted")
+	}
+	if err := applyEarlyStopReps(4, true); err != nil || EarlyStopReps != 0 {
+		t.Errorf("applyEarlyStopReps(4, disabled) = %v, EarlyStopReps %d; want nil, 0", err, EarlyStopReps)
+	}
+}
+
+// TestAsyncPairerManyUnmatchedBegins verifies kernels held behind thousands of leaked
+// begins are still released in order without a scan of every open begin per kernel
+func TestAsyncPairerManyUnmatchedBegins(t *testing.T) {
+	PairAsyncEvents = true
+	defer func() { PairAsyncEvents = false }()
+
+	const n = 50000
+	var sb strings.Builder
+	sb.WriteString(`{"traceEvents": [`)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, `{"name": "leak", "cat": "kernel", "ph": "b", "id": %d, "ts": %d},`, i, i)
+	}
+	fmt.Fprintf(&sb, `{"name": "pair", "cat": "kernel", "ph": "b", "id": "p", "ts": %d},`, n)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, `{"name": "k", "cat": "kernel", "ph": "X", "ts": %d, "dur": 1},`, n+1+i)
+	}
+	fmt.Fprintf(&sb, `{"name": "pair", "cat": "kernel", "ph": "e", "id": "p", "ts": %d}]}`, 2*n+1)
+	path := filepath.Join(t.TempDir(), "leaks.json")
+	if err := os.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	LogOutput = io.Discard
+	defer func() { LogOutput = os.Stderr }()
+	start := time.Now()
+	var names []string
+	var last float64
+	if err := ParseKernelEventsWithCallback(path, func(e KernelEvent) bool {
+		if e.Timestamp < last {
+			t.Fatalf("kernel at ts %v released after ts %v", e.Timestamp, last)
+		}
+		last = e.Timestamp
+		names = append(names, e.Name)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("parsing %d kernels behind %d open begins took %v", n, n, elapsed)
+	}
+	if len(names) != n+1 || names[0] != "pair" {
+		t.Errorf("got %d kernels starting with %q, want %d starting with pair", len(names), names[0], n+1)
+	}
+}
+
+// TestMaxEventsCountsMalformed verifies -max-events bounds array elements and NDJSON
+// lines, including ones that fail to decode
+func TestMaxEventsCountsMalformed(t *testing.T) {
+	defer func() { MaxEvents = 0 }()
+
+	valid := `{"name": "k", "cat": "kernel", "ph": "X", "ts": 1, "dur": 1}`
+	bad := `{"name": 5, "ts": "x"}`
+	elems := []string{valid, valid}
+	for i := 0; i < 20; i++ {
+		elems = append(elems, bad)
+	}
+	dir := t.TempDir()
+	arrayPath := filepath.Join(dir, "bad.json")
+	ndjsonPath := filepath.Join(dir, "bad.ndjson")
+	if err := os.WriteFile(arrayPath, []byte(`{"traceEvents": [`+strings.Join(elems, ",")+`]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ndjsonPath, []byte(strings.Join(elems, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		parse func() error
+	}{
+		{"array", func() error { _, err := ParseKernelEvents(arrayPath); return err }},
+		{"ndjson", func() error { _, err := ParseKernelEvents(ndjsonPath); return err }},
+		{"callback", func() error {
+			return ParseKernelEventsWithCallback(arrayPath, func(KernelEvent) bool { return true })
+		}},
+	}
+	for _, tt := range tests {
+		MaxEvents = 10
+		if err := tt.parse(); err == nil || !strings.Contains(err.Error(), "max events") {
+			t.Errorf("%s: 22 elements with -max-events 10: err = %v, want limit error", tt.name, err)
+		}
+		MaxEvents = 22
+		if err := tt.parse(); err != nil {
+			t.Errorf("%s: 22 elements with -max-events 22: %v", tt.name, err)
+		}
+	}
+}
+
+// TestWriteCompareJSONRoundTrip decodes WriteCompareJSON output and checks the field
+// names, the totals and that change_pct is null without baseline timing
+func TestWriteCompareJSONRoundTrip(t *testing.T) {
+	r := &CompareResult{EagerName: "base", CompiledName: "new", ExactCount: 1, NewOnlyCount: 1, Matches: []KernelMatch{
+		{Index: 0, EagerKernels: []string{"gemm"}, EagerDur: 10, CompiledKernel: "gemm", CompiledDur: 12, MatchType: "exact"},
+		{Index: 1, CompiledKernel: "norm", CompiledDur: 3, MatchType: "new_only"},
+	}}
+	var buf bytes.Buffer
+	if err := r.WriteCompareJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"baseline_name", "new_name", "baseline_iterations", "new_iterations",
+		"baseline_cycle_time_us", "new_cycle_time_us", "baseline_total_us", "new_total_us", "change_pct",
+		"threshold_pct", "baseline_memory_us", "new_memory_us", "match_counts", "matches"} {
+		if _, ok := raw[field]; !ok {
+			t.Errorf("JSON missing field %q", field)
+		}
+	}
+	matches := raw["matches"].([]any)
+	gemm := matches[0].(map[string]any)
+	for _, field := range []string{"index", "baseline_kernels", "new_kernel", "match_type", "baseline_avg_us",
+		"new_avg_us", "change_pct", "status"} {
+		if _, ok := gemm[field]; !ok {
+			t.Errorf("JSON match missing field %q", field)
+		}
+	}
+	if norm := matches[1].(map[string]any); norm["change_pct"] != nil {
+		t.Errorf("new_only change_pct = %v, want null", norm["change_pct"])
+	}
+
+	var doc compareJSON
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.BaselineTotal != 10 || doc.NewTotal != 15 || doc.ChangePct == nil || *doc.ChangePct != 50 {
+		t.Errorf("totals = %v -> %v (%v), want 10 -> 15 (+50%%)", doc.BaselineTotal, doc.NewTotal, doc.ChangePct)
+	}
+	if doc.MatchCounts["exact"] != 1 || doc.MatchCounts["new_only"] != 1 {
+		t.Errorf("match_counts = %v, want exact 1 and new_only 1", doc.MatchCounts)
+	}
+
+	// Without baseline timing the total change is undefined
+	untimed := &CompareResult{Matches: []KernelMatch{
+		{EagerKernels: []string{"gemm"}, CompiledKernel: "gemm", CompiledDur: 12, MatchType: "exact"},
+	}}
+	buf.Reset()
+	if err := untimed.WriteCompareJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	raw = nil
+	if err := json.Unmarshal(buf.Bytes(), &raw); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := raw["change_pct"]; !ok || v != nil {
+		t.Errorf("untimed change_pct = %v (present %v), want null", v, ok)
+	}
+	if m := raw["matches"].([]any)[0].(map[string]any); m["change_pct"] != nil {
+		t.Errorf("untimed match change_pct = %v, want null", m["change_pct"])
+	}
+}
+
+// TestReorderScore verifies each repetition is scored by the fraction of positions
+// whose kernel differs from the first repetition
+func TestReorderScore(t *testing.T) {
+	tests := []struct {
+		name     string
+		reps     [][]string
+		wantAvg  float64
+		wantMax  float64
+		wantEach []float64
+	}{
+		{"stable", [][]string{{"a", "b", "c", "d"}, {"a", "b", "c", "d"}, {"a", "b", "c", "d"}}, 0, 0, []float64{0, 0, 0}},
+		{"one swap", [][]string{{"a", "b", "c", "d"}, {"a", "c", "b", "d"}, {"a", "b", "c", "d"}}, 0.5 / 3, 0.5, []float64{0, 0.5, 0}},
+		{"reversed", [][]string{{"a", "b", "c", "d"}, {"d", "c", "b", "a"}}, 0.5, 1, []float64{0, 1}},
+	}
+	for _, tt := range tests {
+		var events []KernelEvent
+		info := &CycleInfo{CycleLength: 4, NumCycles: len(tt.reps)}
+		for _, rep := range tt.reps {
+			info.CycleIndices = append(info.CycleIndices, len(events))
+			for _, name := range rep {
+				events = append(events, KernelEvent{Name: name, Duration: 1})
+			}
+		}
+		r := ExtractCycle(events, info)
+		if !slices.Equal(r.ReorderScores, tt.wantEach) || !floatClose(r.AvgReorderScore, tt.wantAvg, 1e-9) || r.MaxReorderScore != tt.wantMax {
+			t.Errorf("%s: scores %v avg %v max %v, want %v avg %v max %v", tt.name,
+				r.ReorderScores, r.AvgReorderScore, r.MaxReorderScore, tt.wantEach, tt.wantAvg, tt.wantMax)
+		}
+	}
+}
+
+// TestParseKernelEventsNameFromArg verifies -name-from-arg names kernels after an args
+// key, falling back to the event name when the key is missing, empty or not a string
+func TestParseKernelEventsNameFromArg(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "args.json")
+	data := `{"traceEvents": [
+		{"name": "KernelExecution", "cat": "kernel", "ph": "X", "ts": 1, "dur": 2, "args": {"kernel": "gemm"}},
+		{"name": "KernelExecution", "cat": "kernel", "ph": "X", "ts": 3, "dur": 2, "args": {"kernel": ""}},
+		{"name": "KernelExecution", "cat": "kernel", "ph": "X", "ts": 5, "dur": 2, "args": {"kernel": 7}},
+		{"name": "norm", "cat": "kernel", "ph": "X", "ts": 7, "dur": 2}
+	]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { NameFromArg = "" }()
+
+	for _, tc := range []struct {
+		key  string
+		want []string
+	}{
+		{"", []string{"KernelExecution", "KernelExecution", "KernelExecution", "norm"}},
+		{"kernel", []string{"gemm", "KernelExecution", "KernelExecution", "norm"}},
+	} {
+		NameFromArg = tc.key
+		events, err := ParseKernelEvents(path)
+		if err != nil {
+			t.Fatalf("ParseKernelEvents failed: %v", err)
+		}
+		var names []string
+		for _, e := range events {
+			names = append(names, e.Name)
+		}
+		if !slices.Equal(names, tc.want) {
+			t.Errorf("-name-from-arg %q: names %v, want %v", tc.key, names, tc.want)
+		}
+	}
+}
+
+// writeTestCycleCSV writes a cycle CSV with one kernel per name at the given average
+// duration, for the compare-csv tests
+func writeTestCycleCSV(t *testing.T, names []string, durs []float64) string {
+	t.Helper()
+	r := &CycleResult{CycleLength: len(names), NumCycles: 5, KernelsByName: map[string]int{}}
+	for i, name := range names {
+		r.Kernels = append(r.Kernels, KernelStats{Name: name, IndexInCycle: i, AvgDur: durs[i], MinDur: durs[i], MaxDur: durs[i], Count: 5})
+		r.AvgCycleTime += durs[i]
+	}
+	var buf bytes.Buffer
+	if err := r.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cycle.csv")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestCompareResultMatchCounts verifies the per-type counts stored on CompareResult
+// agree with its Matches and are recomputed from scratch
+func TestCompareResultMatchCounts(t *testing.T) {
+	LogOutput = io.Discard
+	defer func() { LogOutput = os.Stderr }()
+
+	base := writeTestCycleCSV(t, []string{"gemm", "layer_norm", "attn"}, []float64{10, 5, 20})
+	next := writeTestCycleCSV(t, []string{"gemm", "attn", "relu"}, []float64{12, 18, 2})
+	result, err := CompareFromCSV(base, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := countMatchTypes(result.Matches)
+	stored := map[string]int{
+		"exact": result.ExactCount, "similar": result.SimilarCount, "fuzzy": result.FuzzyCount,
+		"removed": result.RemovedCount, "new_only": result.NewOnlyCount, "split": result.SplitCount,
+		"fused": result.FusedCount, "moved": result.MovedCount,
+	}
+	for matchType, n := range stored {
+		if n != counts[matchType] {
+			t.Errorf("%sCount = %d, Matches hold %d", matchType, n, counts[matchType])
+		}
+	}
+	if result.ExactCount != 2 || result.RemovedCount != 1 || result.NewOnlyCount != 1 {
+		t.Errorf("counts exact %d removed %d new_only %d, want 2, 1, 1", result.ExactCount, result.RemovedCount, result.NewOnlyCount)
+	}
+
+	// A re-tally after Matches change does not keep the old counts
+	result.Matches = result.Matches[:0]
+	result.tallyMatchTypes()
+	if result.ExactCount != 0 || result.RemovedCount != 0 || result.NewOnlyCount != 0 {
+		t.Errorf("counts after clearing Matches: exact %d removed %d new_only %d", result.ExactCount, result.RemovedCount, result.NewOnlyCount)
+	}
+}
+
+// TestKernelFilter verifies -filter limits the detailed rows by baseline or new kernel
+// name, as a substring or glob, while the totals still cover every kernel
+func TestKernelFilter(t *testing.T) {
+	r := &CompareResult{Matches: []KernelMatch{
+		{EagerKernels: []string{"flash_attn_fwd"}, CompiledKernel: "flash_attn_fwd", EagerDur: 10, CompiledDur: 12, MatchType: "exact"},
+		{EagerKernels: []string{"rms_norm"}, CompiledKernel: "fused_norm", EagerDur: 5, CompiledDur: 4, MatchType: "similar"},
+		{EagerKernels: []string{"layer_norm"}, CompiledKernel: ".", EagerDur: 3, MatchType: "removed"},
+		{CompiledKernel: "gemm_bf16", CompiledDur: 8, MatchType: "new_only"},
+	}}
+	defer func() { KernelFilter = "" }()
+
+	tests := []struct {
+		filter string
+		want   []string // Shown rows by new kernel
+	}{
+		{"", []string{"flash_attn_fwd", "fused_norm", ".", "gemm_bf16"}},
+		{"attn", []string{"flash_attn_fwd"}},
+		{"*norm", []string{"fused_norm", "."}}, // rms_norm and the removed layer_norm by baseline name
+		{"rms_*", []string{"fused_norm"}},      // Baseline name only
+		{"gemm_*16", []string{"gemm_bf16"}},    // New-only kernel
+		{"*conv*", nil},
+	}
+	for _, tt := range tests {
+		KernelFilter = tt.filter
+		shown, _ := r.outputMatches()
+		var got []string
+		for _, m := range shown {
+			got = append(got, m.CompiledKernel)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("-filter %q: rows %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+
+	KernelFilter = "attn"
+	var buf bytes.Buffer
+	if err := r.WriteCompareCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[1][4] != "18.000" || records[1][8] != "24.000" {
+		t.Errorf("filtered CSV: want header, totals 18 -> 24 over all kernels and one row, got %v", records)
+	}
+}
+
+// TestClassifyChangeNoise verifies a change only counts as improved/regressed when it
+// passes both -threshold and the combined-stddev noise band
+func TestClassifyChangeNoise(t *testing.T) {
+	defer func() { NoiseSigmas = 2 }()
+
+	tests := []struct {
+		name            string
+		base, new       float64
+		baseStd, newStd float64
+		sigmas          float64
+		want            string
+	}{
+		{"6% on a steady kernel", 100, 106, 1, 1, 2, "regressed"},
+		{"6% on a noisy kernel", 100, 106, 20, 20, 2, "neutral"},
+		{"noisy kernel, band off", 100, 106, 20, 20, 0, "regressed"},
+		{"large drop beats the noise", 100, 40, 10, 10, 2, "improved"},
+		{"no stddev data", 100, 94, 0, 0, 2, "improved"},
+		{"under -threshold", 100, 103, 0, 0, 2, "neutral"},
+		{"untimed baseline", 0, 10, 0, 0, 2, ""},
+	}
+	for _, tt := range tests {
+		NoiseSigmas = tt.sigmas
+		m := KernelMatch{EagerKernels: []string{"k"}, CompiledKernel: "k", MatchType: "exact",
+			EagerDur: tt.base, EagerStdDev: tt.baseStd, CompiledDur: tt.new, CompiledStdDev: tt.newStd}
+		if got := classifyChange(m); got != tt.want {
+			t.Errorf("%s: classifyChange = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestHideBelow verifies -hide-below drops timed rows with a small |change| from the
+// detailed output, counts them in a footer, and never hides removed or new rows
+func TestHideBelow(t *testing.T) {
+	r := &CompareResult{Matches: []KernelMatch{
+		{EagerKernels: []string{"gemm"}, CompiledKernel: "gemm", EagerDur: 100, CompiledDur: 101, MatchType: "exact"},
+		{EagerKernels: []string{"attn"}, CompiledKernel: "attn", EagerDur: 100, CompiledDur: 90, MatchType: "exact"},
+		{EagerKernels: []string{"norm"}, CompiledKernel: "norm", EagerDur: 100, CompiledDur: 103, MatchType: "exact"},
+		{EagerKernels: []string{"copy"}, CompiledKernel: ".", EagerDur: 1, MatchType: "removed"},
+		{CompiledKernel: "relu", CompiledDur: 1, MatchType: "new_only"},
+	}}
+	defer func() { HideBelow = 0 }()
+
+	tests := []struct {
+		hideBelow  float64
+		want       []string
+		wantHidden int
+	}{
+		{0, []string{"gemm", "attn", "norm", ".", "relu"}, 0},
+		{2, []string{"attn", "norm", ".", "relu"}, 1},
+		{5, []string{"attn", ".", "relu"}, 2},
+		{50, []string{".", "relu"}, 3},
+	}
+	for _, tt := range tests {
+		HideBelow = tt.hideBelow
+		shown, hidden := r.outputMatches()
+		var got []string
+		for _, m := range shown {
+			got = append(got, m.CompiledKernel)
+		}
+		if !slices.Equal(got, tt.want) || hidden != tt.wantHidden {
+			t.Errorf("-hide-below %v: rows %v (%d hidden), want %v (%d hidden)", tt.hideBelow, got, hidden, tt.want, tt.wantHidden)
+		}
+	}
+
+	HideBelow = 5
+	var buf bytes.Buffer
+	if err := r.WriteCompareCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if footer := records[len(records)-1][0]; footer != "(2 rows hidden: |change| < 5%)" {
+		t.Errorf("CSV footer = %q", footer)
+	}
+	if records[1][4] != "301.000" {
+		t.Errorf("CSV baseline total = %s, want 301.000 including hidden rows", records[1][4])
+	}
+}
+
+// TestReadKernelsFromCSVHeaderDriven verifies columns are found by header name, so
+// reordered, extra and missing optional columns read correctly
+func TestReadKernelsFromCSVHeaderDriven(t *testing.T) {
+	LogOutput = io.Discard
+	defer func() { LogOutput = os.Stderr }()
+
+	tests := []struct {
+		name    string
+		content string
+		want    KernelStats
+		schema  int
+		wantErr bool
+	}{
+		{"extra columns", "# Schema version,99\n" +
+			"p99_us,kernel_name,future_col,avg_duration_us,min_duration_us,max_duration_us,stddev_us,count\n" +
+			"40,gemm,x,30,28,33,1.5,7\n",
+			KernelStats{Name: "gemm", AvgDur: 30, MinDur: 28, MaxDur: 33, StdDev: 1.5}, 99, false},
+		{"reordered, only required columns", "avg_duration_us,kernel_name\n12.5,norm\n",
+			KernelStats{Name: "norm", AvgDur: 12.5}, 0, false},
+		{"short row past optional columns", "kernel_name,avg_duration_us,min_duration_us,stddev_us\nattn,8,7\n",
+			KernelStats{Name: "attn", AvgDur: 8, MinDur: 7}, 0, false},
+		{"missing avg_duration_us", "kernel_name,min_duration_us\ngemm,3\n", KernelStats{}, 0, true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "cycle.csv")
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		data, err := readKernelsFromCSV(path)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(data.Kernels) != 1 || data.SchemaVersion != tt.schema {
+			t.Fatalf("%s: read %d kernels, schema %d", tt.name, len(data.Kernels), data.SchemaVersion)
+		}
+		if k := data.Kernels[0]; k.Name != tt.want.Name || k.AvgDur != tt.want.AvgDur || k.MinDur != tt.want.MinDur ||
+			k.MaxDur != tt.want.MaxDur || k.StdDev != tt.want.StdDev {
+			t.Errorf("%s: read %+v, want %+v", tt.name, k, tt.want)
+		}
+	}
+}
+
+// TestDeltaShare verifies each kernel's share of the total cycle-time change, and that
+// the summary ranks kernels by it rather than by their own percent change
+func TestDeltaShare(t *testing.T) {
+	r := &CompareResult{Matches: []KernelMatch{
+		{EagerKernels: []string{"tiny"}, CompiledKernel: "tiny", EagerDur: 1, CompiledDur: 2, MatchType: "exact"},     // +100%, +1 µs
+		{EagerKernels: []string{"gemm"}, CompiledKernel: "gemm", EagerDur: 500, CompiledDur: 550, MatchType: "exact"}, // +10%, +50 µs
+		{EagerKernels: []string{"attn"}, CompiledKernel: "attn", EagerDur: 100, CompiledDur: 90, MatchType: "exact"},  // -10 µs
+		{EagerKernels: []string{"copy"}, CompiledKernel: ".", EagerDur: 1, MatchType: "removed"},                      // -1 µs
+	}}
+	totalDelta := r.totalDelta()
+	if totalDelta != 40 {
+		t.Fatalf("totalDelta = %v, want 40", totalDelta)
+	}
+
+	tests := []struct {
+		kernel string
+		want   float64
+	}{{"tiny", 2.5}, {"gemm", 125}, {"attn", -25}, {"copy", -2.5}}
+	sum := 0.0
+	for i, tt := range tests {
+		got := deltaShare(r.Matches[i], totalDelta)
+		sum += got
+		if !floatClose(got, tt.want, 1e-9) {
+			t.Errorf("%s share = %v, want %v", tt.kernel, got, tt.want)
+		}
+	}
+	if !floatClose(sum, 100, 1e-9) {
+		t.Errorf("shares sum to %v, want 100", sum)
+	}
+	if got := deltaShare(r.Matches[0], 0); got != 0 {
+		t.Errorf("share with no total change = %v, want 0", got)
+	}
+
+	var buf bytes.Buffer
+	r.writeDeltaShareSummary(&buf, 2)
+	out := buf.String()
+	gemm, tiny := strings.Index(out, "gemm"), strings.Index(out, "tiny")
+	if gemm < 0 || tiny < 0 || gemm > tiny || strings.Contains(out, "attn") {
+		t.Errorf("top-2 contributors should list gemm then tiny:\n%s", out)
+	}
+}
+
+// TestParseKernelEventsPidTid verifies -pid and -tid keep only one device's kernels in
+// both the buffered and the callback parse paths
+func TestParseKernelEventsPidTid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "multi_gpu.json")
+	data := `{"traceEvents": [
+		{"name": "a", "cat": "kernel", "ph": "X", "ts": 1, "dur": 1, "pid": 1, "tid": 7},
+		{"name": "b", "cat": "kernel", "ph": "X", "ts": 2, "dur": 1, "pid": 1, "tid": 8},
+		{"name": "c", "cat": "kernel", "ph": "X", "ts": 3, "dur": 1, "pid": 2, "tid": 7},
+		{"name": "d", "cat": "kernel", "ph": "X", "ts": 4, "dur": 1, "pid": 2, "tid": 8}
+	]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { FilterPid, FilterTid = -1, -1 }()
+
+	tests := []struct {
+		pid, tid int
+		want     []string
+	}{
+		{-1, -1, []string{"a", "b", "c", "d"}},
+		{1, -1, []string{"a", "b"}},
+		{-1, 8, []string{"b", "d"}},
+		{2, 7, []string{"c"}},
+		{3, -1, nil},
+	}
+	for _, tt := range tests {
+		FilterPid, FilterTid = tt.pid, tt.tid
+		events, err := ParseKernelEvents(path)
+		if err != nil {
+			t.Fatalf("ParseKernelEvents failed: %v", err)
+		}
+		var got, streamed []string
+		for _, e := range events {
+			got = append(got, e.Name)
+		}
+		if err := ParseKernelEventsWithCallback(path, func(e KernelEvent) bool {
+			streamed = append(streamed, e.Name)
+			return true
+		}); err != nil {
+			t.Fatalf("ParseKernelEventsWithCallback failed: %v", err)
+		}
+		if !slices.Equal(got, tt.want) || !slices.Equal(streamed, tt.want) {
+			t.Errorf("-pid %d -tid %d: parsed %v, streamed %v, want %v", tt.pid, tt.tid, got, streamed, tt.want)
+		}
+	}
+}
+
+// TestIdleGaps verifies the per-position idle gaps before and after each kernel, with
+// overlapping kernels counting as no gap, and the gap_us column and cycle idle total
+func TestIdleGaps(t *testing.T) {
+	// a (10 µs) then b (5 µs), three repetitions; the last b overlaps a
+	events := []KernelEvent{
+		{Name: "a", Timestamp: 0, Duration: 10}, {Name: "b", Timestamp: 12, Duration: 5},
+		{Name: "a", Timestamp: 20, Duration: 10}, {Name: "b", Timestamp: 36, Duration: 5},
+		{Name: "a", Timestamp: 44, Duration: 10}, {Name: "b", Timestamp: 53, Duration: 5},
+	}
+	r := ExtractCycle(events, &CycleInfo{CycleLength: 2, NumCycles: 3, CycleIndices: []int{0, 2, 4}})
+
+	tests := []struct {
+		name                          string
+		idleBefore, idleAfter, maxAft float64
+	}{
+		{"a", 2, 8.0 / 3, 6}, // Before: none, 3, 3; after: 2, 6, overlap
+		{"b", 8.0 / 3, 2, 3}, // Before: 2, 6, overlap; after: 3, 3, end of trace
+	}
+	for i, tt := range tests {
+		k := r.Kernels[i]
+		if k.Name != tt.name || !floatClose(k.IdleBefore, tt.idleBefore, 1e-9) ||
+			!floatClose(k.IdleAfter, tt.idleAfter, 1e-9) || k.MaxIdleAfter != tt.maxAft {
+			t.Errorf("%s: idle before %v after %v (max %v), want %v, %v (max %v)", tt.name,
+				k.IdleBefore, k.IdleAfter, k.MaxIdleAfter, tt.idleBefore, tt.idleAfter, tt.maxAft)
+		}
+	}
+	if !floatClose(r.AvgIdleTime, 8.0/3+2, 1e-9) {
+		t.Errorf("AvgIdleTime = %v, want %v", r.AvgIdleTime, 8.0/3+2)
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	reader := csv.NewReader(&buf)
+	reader.FieldsPerRecord = -1 // Metadata rows are shorter
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nameCol, col := -1, -1
+	for _, rec := range records {
+		if i := slices.Index(rec, "gap_us"); i >= 0 {
+			nameCol, col = slices.Index(rec, "kernel_name"), i
+			continue
+		}
+		if col >= 0 && rec[nameCol] == "a" && rec[col] != "2.667" {
+			t.Errorf("gap_us for a = %s, want 2.667", rec[col])
+		}
+	}
+	if col < 0 {
+		t.Errorf("CSV has no gap_us column:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// LogOutput receives parse progress and detection diagnostics; embedders can set it
+// to io.Discard (nothing on the parse/detection path calls os.Exit)
+var LogOutput io.Writer = os.Stderr
+
+// LogLevel is how much uplifter writes to LogOutput
+type LogLevel int
+
+const (
+	LogQuiet   LogLevel = iota // Errors only (-quiet)
+	LogNormal                  // Progress, summaries and warnings (default)
+	LogVerbose                 // Also per-candidate detection detail (-verbose)
+)
+
+// Verbosity is the current log level
+var Verbosity = LogNormal
+
+// setLogLevel applies the -quiet and -verbose flags; -verbose also turns on
+// ExplainDetection
+func setLogLevel(quiet, verbose bool) error {
+	switch {
+	case quiet && verbose:
+		return fmt.Errorf("-quiet and -verbose are mutually exclusive")
+	case quiet:
+		Verbosity = LogQuiet
+	case verbose:
+		Verbosity = LogVerbose
+		ExplainDetection = true
+	default:
+		Verbosity = LogNormal
+	}
+	return nil
+}
+
+// logf writes progress and summary output, suppressed by -quiet
+func logf(format string, args ...any) {
+	if Verbosity >= LogNormal {
+		fmt.Fprintf(LogOutput, format, args...)
+	}
+}
+
+// logVerbosef writes detection detail shown only with -verbose
+func logVerbosef(format string, args ...any) {
+	if Verbosity >= LogVerbose {
+		fmt.Fprintf(LogOutput, format, args...)
+	}
+}
+
+// logErrorf writes an error, shown at every level
+func logErrorf(format string, args ...any) {
+	fmt.Fprintf(LogOutput, format, args...)
+}
+
+// logWriter is where summary writers (WriteSummary and friends) should print:
+// LogOutput, or io.Discard under -quiet
+func logWriter() io.Writer {
+	if Verbosity >= LogNormal {
+		return LogOutput
+	}
+	return io.Discard
+}
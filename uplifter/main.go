@@ -1,16 +1,21 @@
 package main
 
 import (
-	"encoding/csv"
+	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"math"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
+
+	"uplifter/detect"
 )
 
 func main() {
@@ -23,6 +28,12 @@ func main() {
 		case "compare-all":
 			runCompareAll(os.Args[2:])
 			return
+		case "compare-multi":
+			runCompareMulti(os.Args[2:])
+			return
+		case "compare-trace":
+			runCompareTrace(os.Args[2:])
+			return
 		case "test-kmer":
 			if len(os.Args) < 3 {
 				fmt.Fprintf(os.Stderr, "Usage: uplifter test-kmer <trace.json.gz>\n")
@@ -44,9 +55,16 @@ func runCompareCSV(args []string) {
 	compareFlags := flag.NewFlagSet("compare-csv", flag.ExitOnError)
 	csv1 := compareFlags.String("baseline", "", "Path to baseline CSV")
 	csv2 := compareFlags.String("new", "", "Path to new/optimized CSV")
-	outputFile := compareFlags.String("output", "", "Output file path (.csv or .xlsx)")
+	outputFile := compareFlags.String("output", "", "Output file path (.csv, .xlsx, .json or .md)")
 	showSummary := compareFlags.Bool("summary", true, "Print summary to stderr")
-	mode := compareFlags.String("mode", "align", "Comparison mode: 'align' (default, position-based with rotation) or 'match' (signature-based, position-independent)")
+	shared := addCompareFlags(compareFlags, "align")
+	shared.addCSVInputFlags(compareFlags)
+	shared.addReportFlags(compareFlags)
+	structural := compareFlags.Bool("structural", false, "Report only added/removed/reordered kernels, without timing (CSV output)")
+	hideBelow := compareFlags.Float64("hide-below", 0, "Omit rows whose absolute change is below this percentage from the detailed output (totals unaffected)")
+	deltaShareFlag := compareFlags.Bool("delta-share", false, "Add each kernel's share of the total cycle-time change as a column and summary section")
+	hierarchy := compareFlags.Bool("hierarchy", false, "Also print a step/layer structure diff (layers per step, kernels per layer)")
+	filter := compareFlags.String("filter", "", "Only list rows whose baseline or new kernel name contains this substring (or matches this glob); totals still cover all kernels")
 
 	compareFlags.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Uplifter Compare - Compare kernel cycles between two traces\n\n")
@@ -66,61 +84,234 @@ func runCompareCSV(args []string) {
 	}
 
 	compareFlags.Parse(args)
+	if err := shared.apply(); err != nil {
+		logErrorf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
 	if *csv1 == "" || *csv2 == "" {
-		fmt.Fprintf(os.Stderr, "Error: -baseline and -new are required\n\n")
+		logErrorf("Error: -baseline and -new are required\n\n")
 		compareFlags.Usage()
 		os.Exit(1)
 	}
 
+	if *structural && strings.HasSuffix(*outputFile, ".xlsx") {
+		logErrorf("Error: -structural writes CSV output only\n")
+		os.Exit(1)
+	}
+
 	startTime := time.Now()
 
-	// Set global comparison mode
-	CompareMode = *mode
+	KernelFilter = *filter
+	HideBelow = *hideBelow
+	EmitDeltaShare = *deltaShareFlag
 
 	result, err := CompareFromCSV(*csv1, *csv2)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error comparing CSVs: %v\n", err)
+		logErrorf("Error comparing CSVs: %v\n", err)
 		os.Exit(1)
 	}
 
-	if *showSummary {
-		result.WriteSummary(os.Stderr)
+	if *showSummary || *shared.summaryOnly {
+		if *structural {
+			result.WriteStructuralSummary(logWriter())
+		} else {
+			result.WriteSummary(logWriter(), *shared.topN)
+		}
+		if *hierarchy {
+			result.WriteHierarchySummary(logWriter())
+		}
 	}
 
-	if *outputFile != "" {
-		if strings.HasSuffix(*outputFile, ".xlsx") {
-			if err := result.WriteCompareXLSX(*outputFile); err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing XLSX: %v\n", err)
-				os.Exit(1)
-			}
-		} else {
+	if *shared.summaryOnly {
+		if *outputFile != "" {
+			logf("\nSummary only: not writing %s\n", *outputFile)
+		}
+	} else if *structural {
+		out := os.Stdout
+		if *outputFile != "" {
 			file, err := os.Create(*outputFile)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating output file: %v\n", err)
+				logErrorf("Error creating output file: %v\n", err)
 				os.Exit(1)
 			}
 			defer file.Close()
+			out = file
+		}
+		if err := result.WriteStructuralCSV(out); err != nil {
+			logErrorf("Error writing CSV: %v\n", err)
+			os.Exit(1)
+		}
+		if *outputFile != "" {
+			logf("\nResults written to: %s\n", *outputFile)
+		}
+	} else if err := writeCompareOutput(result, *outputFile); err != nil {
+		logErrorf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
-			if err := result.WriteCompareCSV(file); err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing CSV: %v\n", err)
-				os.Exit(1)
-			}
+	logf("Total execution time: %v\n", time.Since(startTime))
+}
+
+// writeCompareOutput writes a comparison in the format picked by the output file's
+// extension (.xlsx, .md, .json, otherwise CSV), or as CSV to stdout when it is empty
+func writeCompareOutput(result *CompareResult, outputFile string) error {
+	if outputFile == "" {
+		return result.WriteCompareCSV(os.Stdout)
+	}
+	if strings.HasSuffix(outputFile, ".xlsx") {
+		if err := result.WriteCompareXLSX(outputFile); err != nil {
+			return fmt.Errorf("writing XLSX: %w", err)
 		}
-		fmt.Fprintf(os.Stderr, "\nResults written to: %s\n", *outputFile)
-	} else {
-		result.WriteCompareCSV(os.Stdout)
+		logf("\nResults written to: %s\n", outputFile)
+		return nil
+	}
+
+	file, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("creating output file: %w", err)
+	}
+	defer file.Close()
+	switch {
+	case strings.HasSuffix(outputFile, ".md"):
+		err = result.WriteCompareMarkdown(file)
+	case strings.HasSuffix(outputFile, ".json"):
+		err = result.WriteCompareJSON(file)
+	default:
+		err = result.WriteCompareCSV(file)
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", outputFile, err)
+	}
+	logf("\nResults written to: %s\n", outputFile)
+	return nil
+}
+
+// runCompareTrace runs cycle detection on two raw traces and compares the cycles in
+// one step, without intermediate CSVs
+func runCompareTrace(args []string) {
+	compareFlags := flag.NewFlagSet("compare-trace", flag.ExitOnError)
+	trace1 := compareFlags.String("baseline", "", "Path to baseline trace (.json.gz)")
+	trace2 := compareFlags.String("new", "", "Path to new/optimized trace (.json.gz)")
+	outputFile := compareFlags.String("output", "", "Output file path (.csv, .xlsx, .json or .md)")
+	fullParse := compareFlags.Bool("full", false, "Parse each whole trace instead of stopping once the cycle has repeated -early-stop-reps times")
+	shared := addCompareFlags(compareFlags, "align")
+	shared.addReportFlags(compareFlags)
+	earlyStopReps := compareFlags.Int("early-stop-reps", DefaultEarlyStopReps, "Without -full, stop parsing once the cycle has repeated this many times")
+
+	compareFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Uplifter Compare Trace - Detect and compare the cycles of two raw traces in one step\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: uplifter compare-trace -baseline <baseline.json.gz> -new <new.json.gz> [options]\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		compareFlags.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExample:\n")
+		fmt.Fprintf(os.Stderr, "  uplifter compare-trace -baseline baseline.json.gz -new optimized.json.gz -output compare.xlsx\n")
+	}
+
+	compareFlags.Parse(args)
+	if err := shared.apply(); err != nil {
+		logErrorf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *trace1 == "" || *trace2 == "" {
+		logErrorf("Error: -baseline and -new are required\n\n")
+		compareFlags.Usage()
+		os.Exit(1)
+	}
+	if err := applyEarlyStopReps(*earlyStopReps, false); err != nil {
+		logErrorf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	startTime := time.Now()
+
+	result, err := CompareTraces(*trace1, *trace2, *fullParse)
+	if err != nil {
+		logErrorf("Error comparing traces: %v\n", err)
+		os.Exit(1)
+	}
+
+	result.WriteSummary(logWriter(), *shared.topN)
+	if *shared.summaryOnly {
+		if *outputFile != "" {
+			logf("\nSummary only: not writing %s\n", *outputFile)
+		}
+	} else if err := writeCompareOutput(result, *outputFile); err != nil {
+		logErrorf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	logf("Total execution time: %v\n", time.Since(startTime))
+}
+
+func runCompareMulti(args []string) {
+	compareFlags := flag.NewFlagSet("compare-multi", flag.ExitOnError)
+	var traces traceFlag
+	compareFlags.Var(&traces, "trace", "Cycle CSV as name=path.csv; repeat for each config, baseline first")
+	outputFile := compareFlags.String("output", "", "Output XLSX file")
+	shared := addCompareFlags(compareFlags, "match")
+
+	compareFlags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Uplifter Compare Multi - Compare N cycle CSVs side by side against the first\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: uplifter compare-multi -trace base=a.csv -trace opt1=b.csv [-trace ...] -output sweep.xlsx\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		compareFlags.PrintDefaults()
+	}
+
+	compareFlags.Parse(args)
+	if err := shared.apply(); err != nil {
+		logErrorf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(traces.paths) < 2 || *outputFile == "" {
+		logErrorf("Error: at least two -trace flags and -output are required\n\n")
+		compareFlags.Usage()
+		os.Exit(1)
 	}
 
-	fmt.Fprintf(os.Stderr, "Total execution time: %v\n", time.Since(startTime))
+	result, err := CompareMulti(traces.names, traces.paths)
+	if err != nil {
+		logErrorf("Error comparing CSVs: %v\n", err)
+		os.Exit(1)
+	}
+	if err := result.WriteXLSX(*outputFile); err != nil {
+		logErrorf("Error writing XLSX: %v\n", err)
+		os.Exit(1)
+	}
+	logf("\nResults written to: %s (%d kernels x %d configs)\n", *outputFile, len(result.Rows), len(result.Names))
 }
 
+// Sentinel errors from RunCycleDetection for traces that yield nothing to report
+var (
+	ErrNoKernelEvents  = errors.New("no kernel events found in trace")
+	ErrNoCyclePatterns = errors.New("no cycle patterns found")
+)
+
 func runCycleDetection() {
 	// Define command line flags
-	inputFile := flag.String("input", "", "Path to Perfetto JSON trace file (required)")
+	inputFile := flag.String("input", "", "Path to Perfetto JSON trace file, or - for stdin (required unless -input-dir)")
+	inputDir := flag.String("input-dir", "", "Parse every trace in this directory concurrently and detect cycles on their events concatenated in filename order")
+	parseWorkers := flag.Int("parse-workers", DefaultParseWorkers, "With -input-dir, how many traces to parse at once (each holds a 64MB read buffer)")
 	outputBase := flag.String("output", "", "Output base path for CSV files")
 	showSummary := flag.Bool("summary", true, "Print summary to stderr")
-	mode := flag.String("mode", "all", "Detection mode: 'all' (default, all cycles) or 'llm' (prefill/decode)")
+	mode := flag.String("mode", "all", "Detection mode: 'all' (default, all cycles), 'llm' (prefill/decode) or 'phases' (prefill/decode-1/decode-2/... by temporal band)")
+	parse := addParseFlags(flag.CommandLine)
+	logs := addLogFlags(flag.CommandLine)
+	groupByShape := flag.Bool("group-by-shape", false, "Add a rollup of GEMM kernel time by the M/N/K or tile shape parsed from kernel names to the summary")
+	dualAnchor := flag.Bool("dual-anchor", false, "Require a second periodic kernel at a fixed offset to confirm each cycle (for interleaved traces)")
+	algo := flag.String("algo", "anchor", "Cycle detector: 'anchor' (default), 'kmer', or 'suffix' (shortest period via Z-function, no anchor needed)")
+	overlayFile := flag.String("overlay", "", "Write a Perfetto-loadable JSON marking each detected cycle on its own track")
+	explain := flag.Bool("explain", false, "Print detection diagnostics (e.g. histogram of valid cycle lengths)")
+	seedAnchors := flag.String("seed-anchors", "", "Comma-separated kernel names known to mark iteration boundaries, tried before discovered anchors")
+	nameBySig := flag.Bool("name-by-signature", false, "Name output files <base>_<sig8>.csv by a stable pattern hash instead of <base>_cycle_N.csv")
+	dumpEvents := flag.String("dump-events", "", "Write every parsed kernel event (index, name, ts, dur, pid, tid) to this CSV before detection")
+	keepDurations := flag.Bool("durations", false, "Also write <output>_durations.csv per cycle with every repetition's duration for each position")
+	kernelCategories := flag.String("kernel-categories", "", "File of category=substring rules checked before the built-in kernel categories (category column and summaries)")
+	stream := flag.Bool("stream", false, "Extract one cycle in two streaming passes instead of loading every event (for traces too large for memory)")
+	earlyStopReps := flag.Int("early-stop-reps", DefaultEarlyStopReps, "With -stream, stop reading for the period once a cycle repeats this many times (more = more representative, slower)")
+	noEarlyStop := flag.Bool("no-early-stop", false, "With -stream, read the whole trace to find the period instead of stopping early")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Uplifter - Perfetto trace cycle detector\n\n")
@@ -136,90 +327,253 @@ func runCycleDetection() {
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
 		fmt.Fprintf(os.Stderr, "  %s -input trace.json.gz -output analysis\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -input trace.json.gz -output analysis -mode llm\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -input-dir traces/ -output day\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s compare-csv -baseline cycle_1.csv -new cycle_2.csv -output compare.xlsx\n", os.Args[0])
 	}
 
 	flag.Parse()
+	if err := logs.apply(); err != nil {
+		logErrorf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := parse.apply(); err != nil {
+		logErrorf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := applyKernelCategories(*kernelCategories); err != nil {
+		logErrorf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := applyEarlyStopReps(*earlyStopReps, *noEarlyStop); err != nil {
+		logErrorf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	GroupByShape = *groupByShape
+	KeepDurations = *keepDurations
+	RequireDualAnchor = *dualAnchor
+	NameOutputsBySignature = *nameBySig
+	ExplainDetection = *explain
+	if *seedAnchors != "" {
+		for _, name := range strings.Split(*seedAnchors, ",") {
+			SeedAnchors = append(SeedAnchors, strings.TrimSpace(name))
+		}
+	}
 
 	// Validate required arguments
-	if *inputFile == "" {
-		fmt.Fprintf(os.Stderr, "Error: -input is required\n\n")
+	if (*inputFile == "") == (*inputDir == "") {
+		logErrorf("Error: exactly one of -input and -input-dir is required\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
-
-	// Check if input file exists
-	if _, err := os.Stat(*inputFile); os.IsNotExist(err) {
-		fmt.Fprintf(os.Stderr, "Error: input file does not exist: %s\n", *inputFile)
+	if *parseWorkers < 1 {
+		logErrorf("Error: -parse-workers must be at least 1\n")
 		os.Exit(1)
 	}
+	ParseWorkers = *parseWorkers
+
+	opts := DetectOptions{
+		InputFile:   *inputFile,
+		InputDir:    *inputDir,
+		OutputBase:  *outputBase,
+		Mode:        *mode,
+		ShowSummary: *showSummary,
+		DumpEvents:  *dumpEvents,
+		OverlayFile: *overlayFile,
+		Stream:      *stream,
+		Algo:        *algo,
+	}
+	if err := RunCycleDetection(opts); err != nil {
+		logErrorf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// DetectOptions are the per-run settings of RunCycleDetection; detection tuning
+// (tolerance, cycle bounds, filters) comes from the package globals set by flags
+type DetectOptions struct {
+	InputFile   string // Trace path, or StdinInput
+	InputDir    string // Directory of traces parsed concurrently and concatenated (instead of InputFile)
+	OutputBase  string // Base path for CSV output ("" = first pattern to stdout)
+	Mode        string // "all", "llm" or "phases"
+	ShowSummary bool   // Print per-cycle summaries to stderr
+	DumpEvents  string // Optional CSV of every parsed event
+	OverlayFile string // Optional Perfetto overlay JSON
+	Stream      bool   // Extract one cycle with StreamExtractCycle instead of loading all events
+	Algo        string // Cycle detector: "anchor" (default), "kmer" or "suffix"
+}
+
+// RunCycleDetection runs the parse -> detect -> extract -> write pipeline and returns
+// an error instead of exiting, so it can be driven from tests
+func RunCycleDetection(opts DetectOptions) error {
+	if opts.InputDir != "" {
+		if opts.Stream {
+			return fmt.Errorf("-stream reads a single trace; it cannot be combined with -input-dir")
+		}
+	} else if _, err := os.Stat(opts.InputFile); opts.InputFile != StdinInput && os.IsNotExist(err) {
+		// Check if input file exists
+		return fmt.Errorf("input file does not exist: %s", opts.InputFile)
+	}
+
+	if opts.Stream {
+		return runStreamingExtract(opts)
+	}
 
 	startTime := time.Now()
 
-	// Step 1: Parse kernel events from the trace (always full parse)
-	fmt.Fprintf(os.Stderr, "Parsing trace file: %s\n", *inputFile)
-	events, err := ParseKernelEvents(*inputFile)
+	// Step 1: Parse kernel events from the trace (always full parse), or from every
+	// trace in the input directory
+	var events []KernelEvent
+	var err error
+	if opts.InputDir != "" {
+		events, err = ParseTraceDir(opts.InputDir, ParseWorkers)
+	} else {
+		logf("Parsing trace file: %s\n", opts.InputFile)
+		events, err = ParseKernelEvents(opts.InputFile)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing trace: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("parsing trace: %w", err)
 	}
 
 	parseTime := time.Since(startTime)
-	fmt.Fprintf(os.Stderr, "Parsed %d kernel events in %v\n", len(events), parseTime)
+	logf("Parsed %d kernel events in %v\n", len(events), parseTime)
 
 	if len(events) == 0 {
-		fmt.Fprintf(os.Stderr, "Error: no kernel events found in trace\n")
-		os.Exit(1)
+		return ErrNoKernelEvents
+	}
+
+	if opts.DumpEvents != "" {
+		if err := dumpEventsCSV(opts.DumpEvents, events); err != nil {
+			return fmt.Errorf("writing events dump: %w", err)
+		}
+		logf("Parsed events written to: %s\n", opts.DumpEvents)
+	}
+
+	spanStart, spanEnd := TraceSpan(events)
+	span := spanEnd - spanStart
+	kernelTime := 0.0
+	for _, e := range events {
+		kernelTime += e.Duration
+	}
+	logf("Trace wall-clock span: %.3f ms (first ts to last ts+dur)\n", span/1000)
+	if span > 0 {
+		logf("Total kernel time: %.3f ms (%.1f%% of span)\n", kernelTime/1000, kernelTime/span*100)
 	}
 
 	// Step 2: Detect ALL cycle patterns
-	fmt.Fprintf(os.Stderr, "\n=== Detecting cycle patterns ===\n")
-	patterns := findAllCyclePatterns(events)
+	logf("\n=== Detecting cycle patterns ===\n")
+	patterns, err := detectCyclePatterns(events, opts.Algo)
+	if err != nil {
+		return err
+	}
 
 	if len(patterns) == 0 {
-		fmt.Fprintf(os.Stderr, "Error: no cycle patterns found\n")
-		os.Exit(1)
+		return ErrNoCyclePatterns
 	}
 
 	// Display all patterns
-	fmt.Fprintf(os.Stderr, "Found %d distinct patterns:\n", len(patterns))
+	logf("Found %d distinct patterns:\n", len(patterns))
 	for i, p := range patterns {
-		fmt.Fprintf(os.Stderr, "  %d. length=%d, reps=%d, center=%.1f%%, sig=%s\n",
-			i+1, p.Info.CycleLength, p.Info.NumCycles,
+		logf("  %d. length=%d, reps=%d, confidence=%.2f, center=%.1f%%, sig=%s\n",
+			i+1, p.Info.CycleLength, p.Info.NumCycles, p.Confidence,
 			p.CenterPos/float64(len(events))*100,
-			truncateString(p.Signature, 50))
+			detect.TruncateString(p.Signature, 50))
+	}
+
+	// Report stretches of the trace that no pattern explains
+	if regions := findUncoveredRegions(events, patterns, 10); len(regions) > 0 {
+		logf("Uncovered regions (dead time, >= 10 kernels):\n")
+		for _, r := range regions {
+			logf("  [%d, %d): %d kernels, %.2f ms\n",
+				r.StartIndex, r.EndIndex, r.EndIndex-r.StartIndex, r.TotalDur/1000)
+		}
 	}
 
 	detectTime := time.Since(startTime) - parseTime
-	fmt.Fprintf(os.Stderr, "\nCycle detection completed in %v\n", detectTime)
+	logf("\nCycle detection completed in %v\n", detectTime)
+
+	if opts.OverlayFile != "" {
+		if err := WriteCycleOverlayFile(opts.OverlayFile, events, patterns); err != nil {
+			logErrorf("Error writing overlay: %v\n", err)
+		} else {
+			logf("Cycle overlay written to: %s\n", opts.OverlayFile)
+		}
+	}
 
 	// Step 3: Output based on mode
-	if *mode == "all" {
-		outputAllPatterns(events, patterns, *outputBase, *showSummary)
-	} else {
+	switch opts.Mode {
+	case "all":
+		outputAllPatterns(events, patterns, opts.OutputBase, opts.ShowSummary)
+	case "phases":
+		outputPhases(events, labelPhases(patterns, len(events)), opts.OutputBase, opts.ShowSummary)
+	default:
 		// LLM mode: classify into prefill and decode
 		prefillPattern, decodePattern := classifyPatterns(patterns, len(events))
-		outputResults(events, prefillPattern, decodePattern, *outputBase, *showSummary)
+		outputResults(events, prefillPattern, decodePattern, opts.OutputBase, opts.ShowSummary)
 	}
 
 	totalTime := time.Since(startTime)
-	fmt.Fprintf(os.Stderr, "\nTotal execution time: %v\n", totalTime)
+	logf("\nTotal execution time: %v\n", totalTime)
+	return nil
 }
 
-// classifyPatterns selects prefill and decode patterns from all detected patterns
-// Uses a combination of temporal position AND pattern significance (total events covered)
-func classifyPatterns(patterns []CyclePattern, totalEvents int) (*CyclePattern, *CyclePattern) {
-	if len(patterns) == 0 {
-		return nil, nil
+// runStreamingExtract is the -stream pipeline: one cycle, found and measured without
+// keeping the trace in memory, written as <base>_cycle_1.csv
+func runStreamingExtract(opts DetectOptions) error {
+	startTime := time.Now()
+	maxCycle := MaxCycleLen
+	if maxCycle <= 0 {
+		maxCycle = math.MaxInt
+	}
+	logf("Streaming trace file: %s\n", opts.InputFile)
+	result, err := StreamExtractCycle(opts.InputFile, MinCycleLen, maxCycle)
+	if err != nil {
+		return err
+	}
+
+	if opts.ShowSummary {
+		logf("\n--- Cycle 1 ---\n")
+		logf("Length: %d kernels\n", result.CycleLength)
+		logf("Repetitions: %d\n", result.NumCycles)
+		logf("Event range: [%d, %d)\n", result.StartIndex, result.EndIndex)
+		logf("%s", warmupLine(result.WarmupEvents, result.WarmupKernels))
+		logf("Avg Cycle Time: %.2f µs\n", result.AvgCycleTime)
+		logf("Best-Observed Cycle Time: %.2f µs\n", result.MinCycleTime)
+		logf("Cycle Time CV: %.1f%%\n", result.CycleTimeCV)
+		if result.AvgCycleSpan > 0 {
+			logf("GPU Utilization: %.1f%% of a %.2f µs wall-clock cycle\n", result.GPUUtilization, result.AvgCycleSpan)
+		}
 	}
 
-	// Calculate significance for each pattern (total events covered)
-	type scoredPattern struct {
-		pattern      *CyclePattern
-		significance int // reps * length = total kernel events
-		centerPct    float64
+	if opts.OutputBase == "" {
+		return result.WriteCSV(os.Stdout)
+	}
+	filename := fmt.Sprintf("%s_cycle_1.csv", opts.OutputBase)
+	if err := result.WriteToFile(filename); err != nil {
+		return fmt.Errorf("writing %s: %w", filename, err)
 	}
+	logf("Written: %s\n", filename)
+	if KeepDurations {
+		durationsFile := strings.TrimSuffix(filename, ".csv") + "_durations.csv"
+		if err := writeDurationsFile(result, durationsFile); err != nil {
+			return fmt.Errorf("writing %s: %w", durationsFile, err)
+		}
+		logf("Written: %s\n", durationsFile)
+	}
+	logf("\nTotal execution time: %v\n", time.Since(startTime))
+	return nil
+}
+
+// scoredPattern is a detected pattern with how much of the trace it covers
+type scoredPattern struct {
+	pattern      *CyclePattern
+	significance int // reps * length = total kernel events
+	centerPct    float64
+}
 
+// significantPatterns returns the patterns covering at least 1% of the trace (all of
+// them if none do), in input order
+func significantPatterns(patterns []CyclePattern, totalEvents int) []scoredPattern {
 	var scored []scoredPattern
 	for i := range patterns {
 		p := &patterns[i]
@@ -242,12 +596,22 @@ func classifyPatterns(patterns []CyclePattern, totalEvents int) (*CyclePattern,
 		significant = scored
 	}
 
-	fmt.Fprintf(os.Stderr, "\nSignificant patterns (>1%% of trace):\n")
+	logf("\nSignificant patterns (>1%% of trace):\n")
 	for _, s := range significant {
-		fmt.Fprintf(os.Stderr, "  - length=%d, reps=%d, events=%d, center=%.1f%%\n",
+		logf("  - length=%d, reps=%d, events=%d, center=%.1f%%\n",
 			s.pattern.Info.CycleLength, s.pattern.Info.NumCycles,
 			s.significance, s.centerPct)
 	}
+	return significant
+}
+
+// classifyPatterns selects prefill and decode patterns from all detected patterns
+// Uses a combination of temporal position AND pattern significance (total events covered)
+func classifyPatterns(patterns []CyclePattern, totalEvents int) (*CyclePattern, *CyclePattern) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	significant := significantPatterns(patterns, totalEvents)
 
 	// Find prefill: significant pattern with earliest center
 	var prefill *CyclePattern
@@ -282,12 +646,12 @@ func classifyPatterns(patterns []CyclePattern, totalEvents int) (*CyclePattern,
 	}
 
 	if prefill != nil {
-		fmt.Fprintf(os.Stderr, "\nPREFILL: length=%d, reps=%d, center=%.1f%%\n",
+		logf("\nPREFILL: length=%d, reps=%d, center=%.1f%%\n",
 			prefill.Info.CycleLength, prefill.Info.NumCycles,
 			prefill.CenterPos/float64(totalEvents)*100)
 	}
 	if decode != nil {
-		fmt.Fprintf(os.Stderr, "DECODE:  length=%d, reps=%d, center=%.1f%%\n",
+		logf("DECODE:  length=%d, reps=%d, center=%.1f%%\n",
 			decode.Info.CycleLength, decode.Info.NumCycles,
 			decode.CenterPos/float64(totalEvents)*100)
 	}
@@ -295,22 +659,88 @@ func classifyPatterns(patterns []CyclePattern, totalEvents int) (*CyclePattern,
 	return prefill, decode
 }
 
+// PhasePattern is one significant pattern labeled by its temporal band
+type PhasePattern struct {
+	Label   string // "prefill", "decode-1", "decode-2", ...
+	Pattern *CyclePattern
+}
+
+// labelPhases keeps every significant pattern, ordered by center: the earliest is
+// prefill and later ones are numbered decode phases (e.g. draft and verify loops of
+// speculative decoding). A lone pattern is decode-1
+func labelPhases(patterns []CyclePattern, totalEvents int) []PhasePattern {
+	if len(patterns) == 0 {
+		return nil
+	}
+	significant := significantPatterns(patterns, totalEvents)
+	sort.SliceStable(significant, func(i, j int) bool {
+		return significant[i].centerPct < significant[j].centerPct
+	})
+
+	var phases []PhasePattern
+	for i, s := range significant {
+		label := fmt.Sprintf("decode-%d", i)
+		if len(significant) == 1 {
+			label = "decode-1"
+		} else if i == 0 {
+			label = "prefill"
+		}
+		phases = append(phases, PhasePattern{Label: label, Pattern: s.pattern})
+		logf("%s: length=%d, reps=%d, center=%.1f%%\n",
+			strings.ToUpper(label), s.pattern.Info.CycleLength, s.pattern.Info.NumCycles, s.centerPct)
+	}
+	return phases
+}
+
+// outputPhases writes <base>_<label>.csv for each phase, or the first decode phase
+// to stdout without -output
+func outputPhases(events []KernelEvent, phases []PhasePattern, outputBase string, showSummary bool) {
+	for _, phase := range phases {
+		result := ExtractCycle(events, phase.Pattern.Info)
+		if showSummary {
+			logf("\n=== %s Cycle Summary ===\n", strings.ToUpper(phase.Label))
+			logf("Cycle Length: %d kernels\n", result.CycleLength)
+			logf("Number of Cycles: %d\n", result.NumCycles)
+			logf("%s", warmupLine(result.WarmupEvents, result.WarmupKernels))
+			logf("Average Cycle Time: %.2f µs\n", result.AvgCycleTime)
+		}
+		if outputBase == "" {
+			continue
+		}
+		filename := fmt.Sprintf("%s_%s.csv", outputBase, phase.Label)
+		if err := result.WriteToFile(filename); err != nil {
+			logErrorf("Error writing %s: %v\n", filename, err)
+		} else {
+			logf("Written: %s\n", filename)
+		}
+	}
+
+	if outputBase == "" && len(phases) > 0 {
+		decode := phases[len(phases)-1]
+		if len(phases) > 1 {
+			decode = phases[1]
+		}
+		ExtractCycle(events, decode.Pattern.Info).WriteCSV(os.Stdout)
+	}
+}
+
 func outputResults(events []KernelEvent, prefill, decode *CyclePattern, outputBase string, showSummary bool) {
 	// Extract and write prefill
 	if prefill != nil {
 		prefillResult := ExtractCycle(events, prefill.Info)
 		if showSummary {
-			fmt.Fprintf(os.Stderr, "\n=== PREFILL Cycle Summary ===\n")
-			fmt.Fprintf(os.Stderr, "Cycle Length: %d kernels\n", prefillResult.CycleLength)
-			fmt.Fprintf(os.Stderr, "Number of Cycles: %d\n", prefillResult.NumCycles)
-			fmt.Fprintf(os.Stderr, "Average Cycle Time: %.2f µs\n", prefillResult.AvgCycleTime)
+			logf("\n=== PREFILL Cycle Summary ===\n")
+			logf("Cycle Length: %d kernels\n", prefillResult.CycleLength)
+			logf("Number of Cycles: %d\n", prefillResult.NumCycles)
+			logf("%s", warmupLine(prefillResult.WarmupEvents, prefillResult.WarmupKernels))
+			logf("Average Cycle Time: %.2f µs\n", prefillResult.AvgCycleTime)
 		}
 		if outputBase != "" {
 			prefillFile := outputBase + "_prefill.csv"
 			if err := prefillResult.WriteToFile(prefillFile); err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing prefill CSV: %v\n", err)
+				logErrorf("Error writing prefill CSV: %v\n", err)
 			} else {
-				fmt.Fprintf(os.Stderr, "Prefill results written to: %s\n", prefillFile)
+				logf("Prefill results written to: %s\n", prefillFile)
 			}
 		}
 	}
@@ -319,21 +749,34 @@ func outputResults(events []KernelEvent, prefill, decode *CyclePattern, outputBa
 	if decode != nil {
 		decodeResult := ExtractCycle(events, decode.Info)
 		if showSummary {
-			fmt.Fprintf(os.Stderr, "\n=== DECODE Cycle Summary ===\n")
-			fmt.Fprintf(os.Stderr, "Cycle Length: %d kernels\n", decodeResult.CycleLength)
-			fmt.Fprintf(os.Stderr, "Number of Cycles: %d\n", decodeResult.NumCycles)
-			fmt.Fprintf(os.Stderr, "Average Cycle Time: %.2f µs\n", decodeResult.AvgCycleTime)
+			logf("\n=== DECODE Cycle Summary ===\n")
+			logf("Cycle Length: %d kernels\n", decodeResult.CycleLength)
+			logf("Number of Cycles: %d\n", decodeResult.NumCycles)
+			logf("%s", warmupLine(decodeResult.WarmupEvents, decodeResult.WarmupKernels))
+			logf("Average Cycle Time: %.2f µs\n", decodeResult.AvgCycleTime)
 		}
 		if outputBase != "" {
 			decodeFile := outputBase + "_decode.csv"
 			if err := decodeResult.WriteToFile(decodeFile); err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing decode CSV: %v\n", err)
+				logErrorf("Error writing decode CSV: %v\n", err)
 			} else {
-				fmt.Fprintf(os.Stderr, "Decode results written to: %s\n", decodeFile)
+				logf("Decode results written to: %s\n", decodeFile)
 			}
 		}
 	}
 
+	// Structural fingerprint: kernels per decode step is stable across runs, so a
+	// change here means kernels were added to or removed from the hot loop
+	if showSummary && decode != nil {
+		logf("\n=== Structural Fingerprint ===\n")
+		logf("Decode kernels/step: %d\n", decode.Info.CycleLength)
+		if prefill != nil && prefill != decode {
+			logf("Prefill kernels/step: %d\n", prefill.Info.CycleLength)
+			logf("Prefill/decode ratio: %.2f\n",
+				float64(prefill.Info.CycleLength)/float64(decode.Info.CycleLength))
+		}
+	}
+
 	// If no output specified, write decode to stdout
 	if outputBase == "" && decode != nil {
 		decodeResult := ExtractCycle(events, decode.Info)
@@ -342,38 +785,114 @@ func outputResults(events []KernelEvent, prefill, decode *CyclePattern, outputBa
 }
 
 // outputAllPatterns outputs all detected cycle patterns as separate CSV files
+// NameOutputsBySignature names per-pattern CSVs by a stable signature key instead of
+// their position-sorted cycle number
+var NameOutputsBySignature = false
+
+// patternKey returns an 8-hex-digit key for a cycle that is stable across runs: it
+// hashes the sorted kernel signatures of one repetition, so it doesn't depend on
+// which kernel the cycle was anchored at
+func patternKey(events []KernelEvent, info *CycleInfo) string {
+	end := min(info.StartIndex+info.CycleLength, len(events))
+	sigs := make([]string, 0, info.CycleLength)
+	for _, e := range events[info.StartIndex:end] {
+		sigs = append(sigs, detect.KernelSignature(e.Name))
+	}
+	sort.Strings(sigs)
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(sigs, "|")))
+	return fmt.Sprintf("%016x", h.Sum64())[:8]
+}
+
 func outputAllPatterns(events []KernelEvent, patterns []CyclePattern, outputBase string, showSummary bool) {
 	if len(patterns) == 0 {
-		fmt.Fprintf(os.Stderr, "No patterns to output\n")
+		logf("No patterns to output\n")
 		return
 	}
 
-	// Sort patterns by center position for consistent ordering
-	sort.Slice(patterns, func(i, j int) bool {
-		return patterns[i].CenterPos < patterns[j].CenterPos
-	})
+	// Number files by center position, leaving the caller's ranking untouched
+	patterns = append([]CyclePattern(nil), patterns...)
+	detect.SortPatternsByPosition(patterns)
 
-	fmt.Fprintf(os.Stderr, "\n=== Outputting %d cycle patterns ===\n", len(patterns))
+	logf("\n=== Outputting %d cycle patterns ===\n", len(patterns))
 
+	usedNames := make(map[string]bool)
+	manifest := outputManifest{SchemaVersion: CSVSchemaVersion, TotalEvents: len(events), Cycles: []manifestEntry{}}
 	for i, pattern := range patterns {
 		result := ExtractCycle(events, pattern.Info)
 		centerPct := pattern.CenterPos / float64(len(events)) * 100
 
 		if showSummary {
-			fmt.Fprintf(os.Stderr, "\n--- Cycle %d ---\n", i+1)
-			fmt.Fprintf(os.Stderr, "Length: %d kernels\n", result.CycleLength)
-			fmt.Fprintf(os.Stderr, "Repetitions: %d\n", result.NumCycles)
-			fmt.Fprintf(os.Stderr, "Center: %.1f%% of trace\n", centerPct)
-			fmt.Fprintf(os.Stderr, "Avg Cycle Time: %.2f µs\n", result.AvgCycleTime)
+			logf("\n--- Cycle %d ---\n", i+1)
+			logf("Length: %d kernels\n", result.CycleLength)
+			logf("Repetitions: %d\n", result.NumCycles)
+			logf("Confidence: %.2f\n", pattern.Confidence)
+			logf("Center: %.1f%% of trace\n", centerPct)
+			logf("Event range: [%d, %d)\n", result.StartIndex, result.EndIndex)
+			logf("%s", warmupLine(pattern.WarmupEvents, pattern.WarmupKernels))
+			logf("Avg Cycle Time: %.2f µs\n", result.AvgCycleTime)
+			logf("Best-Observed Cycle Time: %.2f µs\n", result.MinCycleTime)
+			logf("Idle Time Between Kernels: %.2f µs per cycle\n", result.AvgIdleTime)
+			if result.AvgCycleSpan > 0 {
+				logf("GPU Utilization: %.1f%% of a %.2f µs wall-clock cycle\n", result.GPUUtilization, result.AvgCycleSpan)
+			}
+			logf("Reorder Score: avg %.1f%%, max %.1f%%\n", result.AvgReorderScore*100, result.MaxReorderScore*100)
+			if result.MaxReorderScore > ReorderWarnThreshold {
+				logf("Warning: kernel order shuffles between repetitions (nondeterministic scheduling?)\n")
+			}
+			logf("Cycle Time CV: %.1f%%\n", result.CycleTimeCV)
+			if result.CycleTimeCV > CycleTimeCVWarnThreshold {
+				logf("Warning: cycle time varies widely between repetitions (contention or throttling?)\n")
+			}
+			if GroupByShape {
+				writeShapeSummary(logWriter(), result.Kernels, result.AvgCycleTime)
+			}
 		}
 
 		if outputBase != "" {
 			filename := fmt.Sprintf("%s_cycle_%d.csv", outputBase, i+1)
+			if NameOutputsBySignature {
+				filename = fmt.Sprintf("%s_%s.csv", outputBase, patternKey(events, pattern.Info))
+				if usedNames[filename] {
+					// Patterns sharing a key differ in where they start, which is stable
+					// across runs over the same trace
+					filename = fmt.Sprintf("%s_%s_at%d.csv", outputBase, patternKey(events, pattern.Info), pattern.Info.StartIndex)
+				}
+				usedNames[filename] = true
+			}
 			if err := result.WriteToFile(filename); err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", filename, err)
-			} else {
-				fmt.Fprintf(os.Stderr, "Written: %s\n", filename)
+				logErrorf("Error writing %s: %v\n", filename, err)
+				continue
+			}
+			logf("Written: %s\n", filename)
+			entry := manifestEntry{
+				Cycle:        i + 1,
+				File:         filename,
+				Length:       result.CycleLength,
+				Repetitions:  result.NumCycles,
+				CenterPct:    centerPct,
+				AvgCycleTime: result.AvgCycleTime,
+				Signature:    pattern.Signature,
 			}
+			if KeepDurations {
+				durationsFile := strings.TrimSuffix(filename, ".csv") + "_durations.csv"
+				if err := writeDurationsFile(result, durationsFile); err != nil {
+					logErrorf("Error writing %s: %v\n", durationsFile, err)
+				} else {
+					logf("Written: %s\n", durationsFile)
+					entry.DurationsFile = durationsFile
+				}
+			}
+			manifest.Cycles = append(manifest.Cycles, entry)
+		}
+	}
+
+	if outputBase != "" {
+		manifestFile := outputBase + "_manifest.json"
+		if err := writeManifest(manifestFile, manifest); err != nil {
+			logErrorf("Error writing %s: %v\n", manifestFile, err)
+		} else {
+			logf("Written: %s\n", manifestFile)
 		}
 	}
 
@@ -390,6 +909,9 @@ func runCompareAll(args []string) {
 	newDir := compareFlags.String("new", "", "Base path for new CSVs (e.g., /tmp/optimized)")
 	outputFile := compareFlags.String("output", "", "Output XLSX file path")
 	smartMatch := compareFlags.Bool("smart", false, "Use smart matching based on kernel similarity (instead of cycle number)")
+	shared := addCompareFlags(compareFlags, "align")
+	shared.addCSVInputFlags(compareFlags)
+	minSimilarity := compareFlags.Float64("min-similarity", DefaultMinCycleSimilarity, "With -smart, refuse to pair cycles whose weighted kernel similarity (0-1) is below this")
 
 	compareFlags.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Uplifter Compare All - Compare all cycle pairs in one XLSX\n\n")
@@ -397,7 +919,8 @@ func runCompareAll(args []string) {
 		fmt.Fprintf(os.Stderr, "This compares matching cycle files:\n")
 		fmt.Fprintf(os.Stderr, "  <base_path>_cycle_1.csv vs <new_path>_cycle_1.csv\n")
 		fmt.Fprintf(os.Stderr, "  <base_path>_cycle_2.csv vs <new_path>_cycle_2.csv\n")
-		fmt.Fprintf(os.Stderr, "  ...\n\n")
+		fmt.Fprintf(os.Stderr, "  ...\n")
+		fmt.Fprintf(os.Stderr, "or, for files written with -name-by-signature, <base_path>_<sig8>.csv vs <new_path>_<sig8>.csv.\n\n")
 		fmt.Fprintf(os.Stderr, "With -smart, cycles are matched by kernel similarity instead of number.\n\n")
 		fmt.Fprintf(os.Stderr, "Output is a single XLSX with one tab per cycle comparison.\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
@@ -408,81 +931,165 @@ func runCompareAll(args []string) {
 	}
 
 	compareFlags.Parse(args)
+	if err := shared.apply(); err != nil {
+		logErrorf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
 	if *baselineDir == "" || *newDir == "" || *outputFile == "" {
-		fmt.Fprintf(os.Stderr, "Error: -baseline, -new, and -output are required\n\n")
+		logErrorf("Error: -baseline, -new, and -output are required\n\n")
 		compareFlags.Usage()
 		os.Exit(1)
 	}
-
-	// Find all cycle files for baseline
-	var baselineFiles []string
-	for i := 1; ; i++ {
-		f := fmt.Sprintf("%s_cycle_%d.csv", *baselineDir, i)
-		if _, err := os.Stat(f); os.IsNotExist(err) {
-			break
-		}
-		baselineFiles = append(baselineFiles, f)
+	if *minSimilarity < 0 || *minSimilarity > 1 {
+		logErrorf("Error: -min-similarity must be between 0 and 1\n")
+		os.Exit(1)
 	}
 
-	// Find all cycle files for new
-	var newFiles []string
-	for i := 1; ; i++ {
-		f := fmt.Sprintf("%s_cycle_%d.csv", *newDir, i)
-		if _, err := os.Stat(f); os.IsNotExist(err) {
-			break
-		}
-		newFiles = append(newFiles, f)
+	baselineFiles, err := findCycleFiles(*baselineDir)
+	if err != nil {
+		logErrorf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	newFiles, err := findCycleFiles(*newDir)
+	if err != nil {
+		logErrorf("Error: %v\n", err)
+		os.Exit(1)
 	}
 
 	if len(baselineFiles) == 0 || len(newFiles) == 0 {
-		fmt.Fprintf(os.Stderr, "Error: no cycle files found (baseline: %d, new: %d)\n", len(baselineFiles), len(newFiles))
+		logErrorf("Error: no cycle files found (baseline: %d, new: %d)\n", len(baselineFiles), len(newFiles))
 		os.Exit(1)
 	}
 
-	fmt.Fprintf(os.Stderr, "Found %d baseline cycles and %d new cycles\n", len(baselineFiles), len(newFiles))
+	logf("Found %d baseline cycles and %d new cycles\n", len(baselineFiles), len(newFiles))
 
 	var comparisons []*CompareResult
 	var sheetNames []string
 
 	if *smartMatch {
 		// Smart matching: find best pairing based on kernel similarity
-		fmt.Fprintf(os.Stderr, "\n=== Smart Matching Mode ===\n")
-		comparisons, sheetNames = smartMatchCycles(baselineFiles, newFiles)
+		logf("\n=== Smart Matching Mode ===\n")
+		comparisons, sheetNames = smartMatchCycles(baselineFiles, newFiles, *minSimilarity)
 	} else {
-		// Simple matching by cycle number
-		minCycles := len(baselineFiles)
-		if len(newFiles) < minCycles {
-			minCycles = len(newFiles)
+		// Simple matching by cycle number or signature key; gaps on either side are
+		// skipped, not fatal
+		newByKey := make(map[string]string, len(newFiles))
+		for _, f := range newFiles {
+			newByKey[f.key] = f.path
 		}
+		matched := make(map[string]bool)
+		for _, b := range baselineFiles {
+			newPath, ok := newByKey[b.key]
+			if !ok {
+				logf("Warning: no new file for baseline cycle %s (%s); skipping\n", b.key, filepath.Base(b.path))
+				continue
+			}
+			matched[b.key] = true
+			logf("Comparing cycle %s...\n", b.key)
 
-		for i := 0; i < minCycles; i++ {
-			fmt.Fprintf(os.Stderr, "Comparing cycle %d...\n", i+1)
-
-			result, err := CompareFromCSV(baselineFiles[i], newFiles[i])
+			result, err := CompareFromCSV(b.path, newPath)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error comparing cycle %d: %v\n", i+1, err)
+				logErrorf("Error comparing cycle %s: %v\n", b.key, err)
 				continue
 			}
 
 			comparisons = append(comparisons, result)
-			sheetNames = append(sheetNames, fmt.Sprintf("Cycle %d", i+1))
+			sheetNames = append(sheetNames, "Cycle "+b.key)
+		}
+		for _, f := range newFiles {
+			if !matched[f.key] {
+				logf("Warning: new cycle %s (%s) has no baseline counterpart; skipping\n", f.key, filepath.Base(f.path))
+			}
 		}
 	}
 
 	if len(comparisons) == 0 {
-		fmt.Fprintf(os.Stderr, "Error: no valid comparisons\n")
+		logErrorf("Error: no valid comparisons\n")
 		os.Exit(1)
 	}
 
-	fmt.Fprintf(os.Stderr, "\nWriting %d comparisons to %s...\n", len(comparisons), *outputFile)
+	logf("\nWriting %d comparisons to %s...\n", len(comparisons), *outputFile)
 
 	if err := WriteMultiCompareXLSX(*outputFile, comparisons, sheetNames); err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing XLSX: %v\n", err)
+		logErrorf("Error writing XLSX: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Fprintf(os.Stderr, "Done! Created %s with %d tabs\n", *outputFile, len(comparisons))
+	logf("Done! Created %s with %d tabs\n", *outputFile, len(comparisons))
+}
+
+// cycleFile is one per-pattern CSV found by findCycleFiles
+type cycleFile struct {
+	key  string // Cycle number N, or signature key (suffixed -2, -3, ... for collisions)
+	path string
+}
+
+// signatureFileName matches the part after "<base>_" of a -name-by-signature file:
+// the 8-hex-digit pattern key, plus _at<start> when another pattern had the same key
+var signatureFileName = regexp.MustCompile(`^([0-9a-f]{8})(?:_at(\d+))?$`)
+
+// findCycleFiles returns every <base>_cycle_<N>.csv, sorted by N, so a missing cycle
+// in the middle doesn't hide the ones after it, followed by every -name-by-signature
+// <base>_<sig8>.csv sorted by key. Files sharing a key are told apart by start index
+// and keyed <sig8>, <sig8>-2, ... in that order, so both sides pair up the same way.
+// Other files matching the glob (e.g. _cycle_1_durations.csv) are ignored
+func findCycleFiles(base string) ([]cycleFile, error) {
+	paths, err := filepath.Glob(base + "_*.csv")
+	if err != nil {
+		return nil, fmt.Errorf("finding cycle files for %s: %w", base, err)
+	}
+	type numbered struct {
+		num  int
+		path string
+	}
+	type keyed struct {
+		start int // -1 for the file without an _at suffix
+		path  string
+	}
+	var byNum []numbered
+	bySig := make(map[string][]keyed)
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(path, base+"_"), ".csv")
+		if rest, ok := strings.CutPrefix(name, "cycle_"); ok {
+			if num, err := strconv.Atoi(rest); err == nil && num >= 1 {
+				byNum = append(byNum, numbered{num, path})
+			}
+			continue
+		}
+		m := signatureFileName.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		start := -1
+		if m[2] != "" {
+			start, _ = strconv.Atoi(m[2])
+		}
+		bySig[m[1]] = append(bySig[m[1]], keyed{start, path})
+	}
+
+	sort.Slice(byNum, func(i, j int) bool { return byNum[i].num < byNum[j].num })
+	var files []cycleFile
+	for _, f := range byNum {
+		files = append(files, cycleFile{key: strconv.Itoa(f.num), path: f.path})
+	}
+	sigs := make([]string, 0, len(bySig))
+	for sig := range bySig {
+		sigs = append(sigs, sig)
+	}
+	sort.Strings(sigs)
+	for _, sig := range sigs {
+		group := bySig[sig]
+		sort.Slice(group, func(i, j int) bool { return group[i].start < group[j].start })
+		for i, f := range group {
+			key := sig
+			if i > 0 {
+				key = fmt.Sprintf("%s-%d", sig, i+1)
+			}
+			files = append(files, cycleFile{key: key, path: f.path})
+		}
+	}
+	return files, nil
 }
 
 // cycleInfo holds info about a cycle for matching
@@ -493,24 +1100,29 @@ type cycleInfo struct {
 	numKernels int
 }
 
-// smartMatchCycles finds the best pairing between baseline and new cycles
-func smartMatchCycles(baselineFiles, newFiles []string) ([]*CompareResult, []string) {
+// DefaultMinCycleSimilarity is the smart-match cutoff below which two cycles are left
+// unpaired, unless overridden by -min-similarity
+const DefaultMinCycleSimilarity = 0.2
+
+// smartMatchCycles finds the best pairing between baseline and new cycles, leaving
+// pairs less than minSimilarity similar (see computeCycleSimilarity) unmatched
+func smartMatchCycles(baselineFiles, newFiles []cycleFile, minSimilarity float64) ([]*CompareResult, []string) {
 	// Load all cycle info
 	baselineCycles := make([]cycleInfo, len(baselineFiles))
 	newCycles := make([]cycleInfo, len(newFiles))
 
-	fmt.Fprintf(os.Stderr, "Loading baseline cycles...\n")
+	logf("Loading baseline cycles...\n")
 	for i, f := range baselineFiles {
-		baselineCycles[i] = loadCycleInfo(f)
+		baselineCycles[i] = loadCycleInfo(f.path)
 	}
 
-	fmt.Fprintf(os.Stderr, "Loading new cycles...\n")
+	logf("Loading new cycles...\n")
 	for i, f := range newFiles {
-		newCycles[i] = loadCycleInfo(f)
+		newCycles[i] = loadCycleInfo(f.path)
 	}
 
 	// Compute similarity matrix
-	fmt.Fprintf(os.Stderr, "Computing similarity matrix...\n")
+	logf("Computing similarity matrix...\n")
 	similarity := make([][]float64, len(baselineCycles))
 	for i := range similarity {
 		similarity[i] = make([]float64, len(newCycles))
@@ -519,9 +1131,7 @@ func smartMatchCycles(baselineFiles, newFiles []string) ([]*CompareResult, []str
 		}
 	}
 
-	// Greedy matching: pick best pairs iteratively
-	usedBaseline := make(map[int]bool)
-	usedNew := make(map[int]bool)
+	// Optimal assignment maximizing total similarity, then drop weak pairs
 	type match struct {
 		baseIdx int
 		newIdx  int
@@ -529,36 +1139,19 @@ func smartMatchCycles(baselineFiles, newFiles []string) ([]*CompareResult, []str
 	}
 	var matches []match
 
-	for {
-		bestSim := 0.0
-		bestBase, bestNew := -1, -1
-
-		for i := 0; i < len(baselineCycles); i++ {
-			if usedBaseline[i] {
-				continue
-			}
-			for j := 0; j < len(newCycles); j++ {
-				if usedNew[j] {
-					continue
-				}
-				if similarity[i][j] > bestSim {
-					bestSim = similarity[i][j]
-					bestBase = i
-					bestNew = j
-				}
-			}
+	logf("Minimum similarity: %.1f%%\n", minSimilarity*100)
+	for i, j := range optimalAssignment(similarity) {
+		if j < 0 {
+			continue
 		}
-
-		if bestBase < 0 || bestSim < 0.2 { // Minimum 20% similarity threshold
-			break
+		if similarity[i][j] < minSimilarity {
+			logf("  Skipped: baseline cycle %s ↔ new cycle %s (%.1f%% similar, below threshold)\n",
+				baselineFiles[i].key, newFiles[j].key, similarity[i][j]*100)
+			continue
 		}
-
-		usedBaseline[bestBase] = true
-		usedNew[bestNew] = true
-		matches = append(matches, match{bestBase, bestNew, bestSim})
-
-		fmt.Fprintf(os.Stderr, "  Matched: baseline cycle %d ↔ new cycle %d (%.1f%% similar)\n",
-			bestBase+1, bestNew+1, bestSim*100)
+		matches = append(matches, match{i, j, similarity[i][j]})
+		logf("  Matched: baseline cycle %s ↔ new cycle %s (%.1f%% similar)\n",
+			baselineFiles[i].key, newFiles[j].key, similarity[i][j]*100)
 	}
 
 	// Sort matches by baseline cycle number for consistent output
@@ -571,77 +1164,121 @@ func smartMatchCycles(baselineFiles, newFiles []string) ([]*CompareResult, []str
 	var sheetNames []string
 
 	for _, m := range matches {
-		result, err := CompareFromCSV(baselineFiles[m.baseIdx], newFiles[m.newIdx])
+		result, err := CompareFromCSV(baselineFiles[m.baseIdx].path, newFiles[m.newIdx].path)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error comparing: %v\n", err)
+			logErrorf("Error comparing: %v\n", err)
 			continue
 		}
 
 		comparisons = append(comparisons, result)
-		sheetNames = append(sheetNames, fmt.Sprintf("Base%d↔New%d (%.0f%%)", m.baseIdx+1, m.newIdx+1, m.sim*100))
+		baseKey, newKey := baselineFiles[m.baseIdx].key, newFiles[m.newIdx].key
+		name := fmt.Sprintf("Base%s↔New%s (%.0f%%)", baseKey, newKey, m.sim*100)
+		if utf8.RuneCountInString(name) > 31 {
+			// Signature keys are long; drop the prefixes to fit Excel's sheet name limit
+			name = fmt.Sprintf("%s↔%s (%.0f%%)", baseKey, newKey, m.sim*100)
+		}
+		sheetNames = append(sheetNames, name)
 	}
 
 	return comparisons, sheetNames
 }
 
-// loadCycleInfo loads cycle metadata from a CSV file
-func loadCycleInfo(path string) cycleInfo {
-	info := cycleInfo{
-		file:       path,
-		kernelSigs: make(map[string]float64),
-	}
-
-	f, err := os.Open(path)
-	if err != nil {
-		return info
+// optimalAssignment pairs rows with columns to maximize the total similarity
+// (Hungarian algorithm on a square-padded cost matrix); result[i] is the column
+// assigned to row i, or -1 if row i only got padding
+func optimalAssignment(similarity [][]float64) []int {
+	rows := len(similarity)
+	cols := 0
+	if rows > 0 {
+		cols = len(similarity[0])
+	}
+	n := max(rows, cols)
+	cost := func(i, j int) float64 {
+		if i < rows && j < cols {
+			return -similarity[i][j]
+		}
+		return 0
 	}
-	defer f.Close()
-
-	reader := csv.NewReader(f)
-	reader.FieldsPerRecord = -1
 
-	// Skip metadata lines
-	for {
-		record, err := reader.Read()
-		if err != nil {
-			return info
+	// 1-based potentials u (rows), v (columns); p[j] is the row matched to column j
+	u := make([]float64, n+1)
+	v := make([]float64, n+1)
+	p := make([]int, n+1)
+	way := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		minv := make([]float64, n+1)
+		used := make([]bool, n+1)
+		for j := range minv {
+			minv[j] = math.Inf(1)
 		}
-		if len(record) > 0 && !strings.HasPrefix(record[0], "#") && record[0] != "index" {
-			break
-		}
-		// Parse avg cycle time from metadata
-		if len(record) >= 2 && record[0] == "# Avg cycle time (us)" {
-			if v, err := strconv.ParseFloat(record[1], 64); err == nil {
-				info.avgTime = v
+		for p[j0] != 0 {
+			used[j0] = true
+			i0, delta, j1 := p[j0], math.Inf(1), 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				if cur := cost(i0-1, j-1) - u[i0] - v[j]; cur < minv[j] {
+					minv[j], way[j] = cur, j0
+				}
+				if minv[j] < delta {
+					delta, j1 = minv[j], j
+				}
 			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
 		}
 	}
 
-	// Read kernel rows
-	for {
-		record, err := reader.Read()
-		if err != nil {
-			break
-		}
-		if len(record) < 8 {
-			continue
+	result := make([]int, rows)
+	for i := range result {
+		result[i] = -1
+	}
+	for j := 1; j <= n; j++ {
+		if p[j] > 0 && p[j] <= rows && j <= cols {
+			result[p[j]-1] = j - 1
 		}
+	}
+	return result
+}
 
-		name := record[1] // kernel_name
-		sig := getKernelSignature(name)
-		pct := 0.0
-		if v, err := strconv.ParseFloat(record[7], 64); err == nil {
-			pct = v
-		}
+// loadCycleInfo loads cycle metadata from a CSV file
+func loadCycleInfo(path string) cycleInfo {
+	info := cycleInfo{
+		file:       path,
+		kernelSigs: make(map[string]float64),
+	}
 
-		info.kernelSigs[sig] += pct
+	data, err := readKernelsFromCSV(path)
+	if err != nil {
+		return info
+	}
+	info.avgTime = data.AvgCycleTime
+	for i, k := range data.Kernels {
+		info.kernelSigs[matchSignature(k.Name)] += data.PctOfCycle[i]
 		info.numKernels++
 	}
 
 	return info
 }
 
-// computeCycleSimilarity computes similarity between two cycles
+// computeCycleSimilarity returns the weighted Jaccard similarity of two cycles' kernel
+// signatures, weighted by % of cycle time: 0 (nothing shared) to 1 (same signatures
+// with the same time shares)
 func computeCycleSimilarity(a, b cycleInfo) float64 {
 	if len(a.kernelSigs) == 0 || len(b.kernelSigs) == 0 {
 		return 0
@@ -686,42 +1323,70 @@ func removeExt(path string) string {
 
 func runKmerDetection(args []string) {
 	kmerFlags := flag.NewFlagSet("kmer", flag.ExitOnError)
-	inputFile := kmerFlags.String("input", "", "Input Perfetto trace file (.json or .json.gz)")
+	inputFile := kmerFlags.String("input", "", "Input Perfetto trace file (.json or .json.gz), or - for stdin")
 	outputBase := kmerFlags.String("output", "", "Output base path for CSV files")
+	parse := addParseFlags(kmerFlags)
+	logs := addLogFlags(kmerFlags)
+	weightedDedup := kmerFlags.Bool("weighted-dedup", false, "Weight cycle deduplication by kernel duration")
+	anchorKmer := kmerFlags.String("anchor-kmer", "", "Pin the cycle anchor to this comma-separated kernel-name sequence (e.g. 'a,b,c')")
 
 	kmerFlags.Parse(args)
+	if err := logs.apply(); err != nil {
+		logErrorf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := parse.apply(); err != nil {
+		logErrorf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	WeightDedupByDuration = *weightedDedup
+	if *anchorKmer != "" {
+		for _, name := range strings.Split(*anchorKmer, ",") {
+			AnchorKmer = append(AnchorKmer, strings.TrimSpace(name))
+		}
+	}
 
 	if *inputFile == "" {
-		fmt.Fprintf(os.Stderr, "Error: -input is required\n")
+		logErrorf("Error: -input is required\n")
 		kmerFlags.Usage()
 		os.Exit(1)
 	}
 
 	if *outputBase == "" {
+		if *inputFile == StdinInput {
+			logErrorf("Error: -output is required when reading from stdin\n")
+			os.Exit(1)
+		}
 		*outputBase = removeExt(*inputFile)
 	}
 
 	startTime := time.Now()
 
 	// Parse trace
-	fmt.Fprintf(os.Stderr, "Parsing trace file: %s\n", *inputFile)
+	logf("Parsing trace file: %s\n", *inputFile)
 	events, err := ParseKernelEvents(*inputFile)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing trace: %v\n", err)
+		logErrorf("Error parsing trace: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Fprintf(os.Stderr, "Parsed %d kernel events in %v\n\n", len(events), time.Since(startTime))
+	logf("Parsed %d kernel events in %v\n\n", len(events), time.Since(startTime))
 
 	// Detect cycles using k-mer method
-	fmt.Fprintf(os.Stderr, "=== Detecting cycles using k-mer method ===\n")
-	cycles := DetectCyclesKmer(events, 3, 10)
+	logf("=== Detecting cycles using k-mer method ===\n")
+	var cycles []KmerCycle
+	if KmerLenMin == KmerLenMax {
+		cycles = detector().DetectCyclesKmer(events, KmerLenMin, MinCycleLen)
+	} else {
+		cycles = detector().DetectCyclesKmerSweep(events, KmerLenMin, KmerLenMax, MinCycleLen)
+	}
 
 	if len(cycles) == 0 {
-		fmt.Fprintf(os.Stderr, "No cycles detected\n")
+		logErrorf("No cycles detected\n")
 		os.Exit(1)
 	}
 
-	fmt.Fprintf(os.Stderr, "\n=== Outputting %d cycle patterns ===\n", len(cycles))
+	logf("\n=== Outputting %d cycle patterns ===\n", len(cycles))
 
 	// Output each cycle as CSV
 	for i, c := range cycles {
@@ -734,27 +1399,30 @@ func runKmerDetection(args []string) {
 		// Calculate center position
 		centerPos := float64(c.StartIndex+c.Length*c.Repetitions/2) / float64(len(events)) * 100
 
-		fmt.Fprintf(os.Stderr, "\n--- Cycle %d ---\n", i+1)
-		fmt.Fprintf(os.Stderr, "Length: %d kernels\n", c.Length)
-		fmt.Fprintf(os.Stderr, "Repetitions: %d\n", c.Repetitions)
-		fmt.Fprintf(os.Stderr, "Center: %.1f%% of trace\n", centerPos)
-		fmt.Fprintf(os.Stderr, "Avg Cycle Time: %.2f µs\n", cycleResult.AvgCycleTime)
+		logf("\n--- Cycle %d ---\n", i+1)
+		logf("Length: %d kernels\n", c.Length)
+		logf("Repetitions: %d\n", c.Repetitions)
+		logf("K-mer length: %d\n", c.K)
+		logf("Center: %.1f%% of trace\n", centerPos)
+		logf("Event range: [%d, %d)\n", cycleResult.StartIndex, cycleResult.EndIndex)
+		logf("%s", warmupLine(cycleResult.WarmupEvents, cycleResult.WarmupKernels))
+		logf("Avg Cycle Time: %.2f µs\n", cycleResult.AvgCycleTime)
 
 		// Write CSV
 		outPath := fmt.Sprintf("%s_cycle_%d.csv", *outputBase, i+1)
 		f, err := os.Create(outPath)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating file: %v\n", err)
+			logErrorf("Error creating file: %v\n", err)
 			continue
 		}
 		if err := cycleResult.WriteCSV(f); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing CSV: %v\n", err)
+			logErrorf("Error writing CSV: %v\n", err)
 		}
 		f.Close()
-		fmt.Fprintf(os.Stderr, "Written: %s\n", outPath)
+		logf("Written: %s\n", outPath)
 	}
 
-	fmt.Fprintf(os.Stderr, "\nTotal execution time: %v\n", time.Since(startTime))
+	logf("\nTotal execution time: %v\n", time.Since(startTime))
 }
 
 // ExtractCycleStats extracts statistics for a cycle
@@ -777,47 +1445,90 @@ func ExtractCycleStats(events []KernelEvent, start, length, reps int) *CycleResu
 			if s, exists := stats[pos]; exists {
 				s.TotalDur += e.Duration
 				s.Count++
+				s.addIdle(events, idx)
 				if e.Duration < s.MinDur {
 					s.MinDur = e.Duration
+					s.MinAtCycle = rep + 1
 				}
 				if e.Duration > s.MaxDur {
 					s.MaxDur = e.Duration
+					s.MaxAtCycle = rep + 1
 				}
 				s.Durations = append(s.Durations, e.Duration)
 			} else {
 				stats[pos] = &KernelStats{
 					Name:         e.Name,
+					Grid:         [3]int{e.GridX, e.GridY, e.GridZ},
+					Block:        [3]int{e.BlockX, e.BlockY, e.BlockZ},
+					Stream:       e.Stream,
 					TotalDur:     e.Duration,
 					MinDur:       e.Duration,
 					MaxDur:       e.Duration,
 					Count:        1,
 					IndexInCycle: pos,
+					MinAtCycle:   rep + 1,
+					MaxAtCycle:   rep + 1,
 					Durations:    []float64{e.Duration},
 				}
+				stats[pos].addIdle(events, idx)
 			}
 		}
 	}
 
 	// Calculate averages and build result
 	var kernelStats []KernelStats
-	var totalCycleTime float64
+	var totalCycleTime, minCycleTime, idleTime float64
 
 	for pos := 0; pos < length; pos++ {
 		if s, exists := stats[pos]; exists {
 			s.AvgDur = s.TotalDur / float64(s.Count)
 			s.StdDev = calcStdDev(s.Durations, s.AvgDur)
+			s.setPercentiles()
+			s.Durations = nil
+			s.finishIdle()
+			idleTime += s.IdleAfter
 			totalCycleTime += s.AvgDur
+			minCycleTime += s.MinDur
 			kernelStats = append(kernelStats, *s)
 		}
 	}
 
+	memoryTime, computeTime := memoryComputeSplit(kernelStats)
 	return &CycleResult{
 		CycleLength:    length,
 		NumCycles:      reps,
 		Kernels:        kernelStats,
 		AvgCycleTime:   totalCycleTime,
+		MinCycleTime:   minCycleTime,
 		TotalCycleTime: totalCycleTime * float64(reps),
+		StartIndex:     start,
+		EndIndex:       start + length*reps,
+		AvgIdleTime:    idleTime,
+		MemoryTime:     memoryTime,
+		ComputeTime:    computeTime,
+		WarmupEvents:   start,
+		WarmupKernels:  detect.WarmupKernels(events, start),
+	}
+}
+
+// dumpEventsCSV writes the parsed events to a CSV file
+func dumpEventsCSV(filename string, events []KernelEvent) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return WriteEventsCSV(file, events)
+}
+
+// writeDurationsFile writes a cycle's per-repetition durations to a CSV file
+func writeDurationsFile(result *CycleResult, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
 	}
+	defer file.Close()
+	return result.WriteDurationsCSV(file)
 }
 
 // calcStdDev calculates standard deviation
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// outputManifest is <base>_manifest.json: every cycle file default mode wrote, so
+// scripts can enumerate results without globbing and re-parsing the CSVs
+type outputManifest struct {
+	SchemaVersion int             `json:"schema_version"` // CSVSchemaVersion of the listed files
+	TotalEvents   int             `json:"total_events"`
+	Cycles        []manifestEntry `json:"cycles"`
+}
+
+// manifestEntry describes one written cycle CSV
+type manifestEntry struct {
+	Cycle         int     `json:"cycle"`
+	File          string  `json:"file"`
+	DurationsFile string  `json:"durations_file,omitempty"` // With -durations
+	Length        int     `json:"length"`
+	Repetitions   int     `json:"repetitions"`
+	CenterPct     float64 `json:"center_pct"`
+	AvgCycleTime  float64 `json:"avg_cycle_time_us"`
+	Signature     string  `json:"signature"`
+}
+
+// writeManifest writes the manifest as indented JSON
+func writeManifest(filename string, manifest outputManifest) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create manifest: %w", err)
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return err
+	}
+	return f.Close()
+}
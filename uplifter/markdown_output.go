@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// WriteCompareMarkdown writes a GitHub-flavored Markdown report: a summary block with
+// the total speedup and top regressions, then a per-kernel table
+func (r *CompareResult) WriteCompareMarkdown(w io.Writer) error {
+	baselineTotal, newTotal := 0.0, 0.0
+	for _, m := range r.Matches {
+		baselineTotal += m.EagerDur
+		newTotal += m.CompiledDur
+	}
+
+	fmt.Fprintf(w, "## Kernel comparison: %s vs %s\n\n", markdownCode(r.EagerName), markdownCode(r.CompiledName))
+	if baselineTotal > 0 && newTotal > 0 {
+		fmt.Fprintf(w, "**Cycle time:** %.2f µs → %.2f µs (%+.1f%%, %.2fx speedup)\n\n",
+			baselineTotal, newTotal, (newTotal-baselineTotal)/baselineTotal*100, baselineTotal/newTotal)
+	} else {
+		fmt.Fprintf(w, "**Cycle time (new):** %.2f µs\n\n", newTotal)
+	}
+	fmt.Fprintf(w, "**Threshold:** ±%g%%\n\n", ChangeThreshold)
+	fmt.Fprintf(w, "**Matches:** %d exact, %d similar, %d fuzzy, %d split, %d fused, %d moved, %d removed, %d new\n\n",
+		r.ExactCount, r.SimilarCount, r.FuzzyCount, r.SplitCount, r.FusedCount, r.MovedCount, r.RemovedCount, r.NewOnlyCount)
+
+	// Top 3 regressions by absolute time added
+	var regressions []KernelMatch
+	for _, m := range r.Matches {
+		if classifyChange(m) == "regressed" {
+			regressions = append(regressions, m)
+		}
+	}
+	sort.SliceStable(regressions, func(i, j int) bool {
+		return regressions[i].CompiledDur-regressions[i].EagerDur > regressions[j].CompiledDur-regressions[j].EagerDur
+	})
+	if len(regressions) > 0 {
+		fmt.Fprintf(w, "**Top regressions:**\n\n")
+		for i := 0; i < min(3, len(regressions)); i++ {
+			m := regressions[i]
+			change, _ := changePercent(m)
+			fmt.Fprintf(w, "%d. %s: %.2f → %.2f µs (%+.1f%%)\n", i+1,
+				markdownCode(displayName(m.CompiledKernel)), m.EagerDur, m.CompiledDur, change)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+
+	shown, hidden := r.outputMatches()
+	cvHeader, cvAlign := "", ""
+	if EmitCV {
+		cvHeader, cvAlign = " Baseline CV | New CV |", "---:|---:|"
+	}
+	fmt.Fprintf(w, "| # | Baseline kernel | New kernel | Baseline (µs) | New (µs) | Change | Match |%s\n", cvHeader)
+	fmt.Fprintf(w, "|---:|---|---|---:|---:|---:|---|%s\n", cvAlign)
+	for _, m := range shown {
+		baseline := "."
+		if len(m.EagerKernels) > 0 {
+			var names []string
+			for _, name := range m.EagerKernels {
+				names = append(names, markdownEscape(displayName(name)))
+			}
+			baseline = strings.Join(names, "<br>")
+		}
+		fmt.Fprintf(w, "| %d | %s | %s | %s | %s | %s | %s |", m.Index,
+			baseline, markdownEscape(displayName(m.CompiledKernel)),
+			markdownDur(m.EagerDur), markdownDur(m.CompiledDur), markdownChange(m), matchTypeLabel(m))
+		if EmitCV {
+			baseCV, newCV := cvPercents(m)
+			fmt.Fprintf(w, " %s | %s |", markdownPercent(baseCV), markdownPercent(newCV))
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	if hidden > 0 {
+		fmt.Fprintf(w, "\n_%d rows hidden: |change| < %g%%_\n", hidden, HideBelow)
+	}
+	return nil
+}
+
+// markdownChange formats a row's change with ⬇️ (improved) / ⬆️ (regressed), as
+// classified for the XLSX heatmap
+func markdownChange(m KernelMatch) string {
+	change, ok := changePercent(m)
+	if !ok {
+		return ""
+	}
+	switch classifyChange(m) {
+	case "improved":
+		return fmt.Sprintf("⬇️ %+.1f%%", change)
+	case "regressed":
+		return fmt.Sprintf("⬆️ %+.1f%%", change)
+	default:
+		return fmt.Sprintf("%+.1f%%", change)
+	}
+}
+
+// markdownDur formats a duration cell, leaving it blank when there is no timing
+func markdownDur(d float64) string {
+	if d <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", d)
+}
+
+// markdownPercent formats an optional percentage cell, blank when nil
+func markdownPercent(p *float64) string {
+	if p == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.1f%%", *p)
+}
+
+// markdownEscaper escapes the characters that would end a table cell or be read as HTML
+// (template arguments like <float, 128> otherwise vanish as unknown tags)
+var markdownEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "|", `\|`)
+
+// markdownEscape makes a kernel name safe as plain text in a table cell
+func markdownEscape(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// markdownCode wraps s in a code span, which shows it verbatim; the fence is one
+// backtick longer than any run inside s
+func markdownCode(s string) string {
+	longest, run := 0, 0
+	for _, c := range s {
+		if c == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", longest+1)
+	if longest > 0 {
+		return fence + " " + s + " " + fence
+	}
+	return fence + s + fence
+}
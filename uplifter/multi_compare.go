@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// traceFlag collects repeated -trace name=path.csv arguments, in order; a bare path is
+// named after its file
+type traceFlag struct {
+	names []string
+	paths []string
+}
+
+func (t *traceFlag) String() string {
+	var pairs []string
+	for i := range t.names {
+		pairs = append(pairs, t.names[i]+"="+t.paths[i])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (t *traceFlag) Set(value string) error {
+	name, path, ok := strings.Cut(value, "=")
+	if !ok {
+		name, path = multiTraceName(value), value
+	}
+	if name == "" || path == "" {
+		return fmt.Errorf("expected name=path.csv, got %q", value)
+	}
+	t.names = append(t.names, name)
+	t.paths = append(t.paths, path)
+	return nil
+}
+
+// MultiCompareResult lines up N cycle CSVs against the first (the baseline)
+type MultiCompareResult struct {
+	Names []string // Config names, baseline first
+	Rows  []MultiCompareRow
+}
+
+// MultiCompareRow is one kernel across every config
+type MultiCompareRow struct {
+	Kernel    string    // Baseline kernel name, or the new kernel for rows the baseline lacks
+	Durations []float64 // Avg duration (µs) per config, in Names order; 0 = absent
+}
+
+// Totals returns the summed duration of each config
+func (r *MultiCompareResult) Totals() []float64 {
+	totals := make([]float64, len(r.Names))
+	for _, row := range r.Rows {
+		for i, d := range row.Durations {
+			totals[i] += d
+		}
+	}
+	return totals
+}
+
+// CompareMulti matches every config's kernels against the baseline (paths[0]) with
+// matchKernelsBySignature. Baseline kernels keep their order; kernels only a later
+// config has are appended after them, one row per name shared by every config that
+// adds it. A split baseline kernel gets the summed time of its parts, and a fused new
+// kernel is credited to the first kernel it replaced
+func CompareMulti(names, paths []string) (*MultiCompareResult, error) {
+	if len(paths) < 2 {
+		return nil, fmt.Errorf("need at least two traces to compare, got %d", len(paths))
+	}
+
+	baseData, err := readKernelsFromCSV(paths[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", names[0], err)
+	}
+	base := &CycleResult{Kernels: baseData.Kernels, CycleLength: len(baseData.Kernels)}
+
+	result := &MultiCompareResult{Names: names}
+	for _, k := range base.Kernels {
+		row := MultiCompareRow{Kernel: k.Name, Durations: make([]float64, len(names))}
+		row.Durations[0] = k.AvgDur
+		result.Rows = append(result.Rows, row)
+	}
+
+	// Appended rows by kernel name, in order, so a kernel several configs add (maybe
+	// more than once per cycle) lines up in the same rows
+	added := make(map[string][]int)
+	for c := 1; c < len(paths); c++ {
+		data, err := readKernelsFromCSV(paths[c])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", names[c], err)
+		}
+		logf("=== Matching %s (%d kernels) against %s (%d kernels) ===\n",
+			names[c], len(data.Kernels), names[0], len(base.Kernels))
+		matches := matchKernelsBySignature(base, &CycleResult{Kernels: data.Kernels, CycleLength: len(data.Kernels)})
+
+		// Baseline rows still unclaimed for each name, in cycle order
+		unclaimed := make(map[string][]int)
+		for i, k := range base.Kernels {
+			unclaimed[k.Name] = append(unclaimed[k.Name], i)
+		}
+		claim := func(name string) int {
+			rows := unclaimed[name]
+			if len(rows) == 0 {
+				return -1
+			}
+			unclaimed[name] = rows[1:]
+			return rows[0]
+		}
+		splitRow := make(map[string]int)
+		addedUsed := make(map[string]int)
+
+		for _, m := range matches {
+			switch {
+			case m.MatchType == "split_part":
+				if row, ok := splitRow[m.EagerKernels[0]]; ok {
+					result.Rows[row].Durations[c] += m.CompiledDur
+					continue
+				}
+			case len(m.EagerKernels) > 0 && m.EagerKernels[0] != "(none)":
+				row := claim(m.EagerKernels[0])
+				for _, fused := range m.EagerKernels[1:] {
+					claim(fused)
+				}
+				if row < 0 {
+					break
+				}
+				if m.MatchType == "split" {
+					splitRow[m.EagerKernels[0]] = row
+				}
+				if m.CompiledKernel != "." {
+					result.Rows[row].Durations[c] += m.CompiledDur
+				}
+				continue
+			}
+			if m.CompiledKernel == "." || m.CompiledKernel == "" {
+				continue
+			}
+			n := addedUsed[m.CompiledKernel]
+			addedUsed[m.CompiledKernel]++
+			if n < len(added[m.CompiledKernel]) {
+				result.Rows[added[m.CompiledKernel][n]].Durations[c] = m.CompiledDur
+				continue
+			}
+			row := MultiCompareRow{Kernel: m.CompiledKernel, Durations: make([]float64, len(names))}
+			row.Durations[c] = m.CompiledDur
+			added[m.CompiledKernel] = append(added[m.CompiledKernel], len(result.Rows))
+			result.Rows = append(result.Rows, row)
+		}
+	}
+
+	return result, nil
+}
+
+// WriteXLSX writes one sheet with a duration column per config and a change-vs-baseline
+// column for each later config, colored like the pairwise heatmap; a config without
+// the kernel leaves its cells blank
+func (r *MultiCompareResult) WriteXLSX(filename string) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	styles := createStyles(f)
+	const sheetName = "Comparison"
+	f.SetSheetName("Sheet1", sheetName)
+
+	headers := []string{"Kernel", r.Names[0] + " (µs)"}
+	for _, name := range r.Names[1:] {
+		headers = append(headers, name+" (µs)", name+" Δ (%)")
+	}
+	for i, h := range headers {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		f.SetCellValue(sheetName, cell, h)
+		f.SetCellStyle(sheetName, cell, cell, styles.header)
+	}
+	lastCol, _ := excelize.ColumnNumberToName(len(headers))
+	f.SetColWidth(sheetName, "A", "A", 55)
+	f.SetColWidth(sheetName, "B", lastCol, 14)
+
+	// writeRow fills one row: durations, then each change vs the baseline duration
+	writeRow := func(row int, label string, durations []float64) {
+		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), label)
+		cell, _ := excelize.CoordinatesToCellName(2, row)
+		if durations[0] > 0 {
+			f.SetCellValue(sheetName, cell, durations[0])
+		}
+		for c := 1; c < len(durations); c++ {
+			durCell, _ := excelize.CoordinatesToCellName(2*c+1, row)
+			changeCell, _ := excelize.CoordinatesToCellName(2*c+2, row)
+			if durations[c] <= 0 {
+				continue
+			}
+			f.SetCellValue(sheetName, durCell, durations[c])
+			if durations[0] <= 0 {
+				continue
+			}
+			change := (durations[c] - durations[0]) / durations[0] * 100
+			f.SetCellValue(sheetName, changeCell, change)
+			switch {
+			case change < -ChangeThreshold:
+				f.SetCellStyle(sheetName, changeCell, changeCell, styles.improved)
+			case change > ChangeThreshold:
+				f.SetCellStyle(sheetName, changeCell, changeCell, styles.regressed)
+			default:
+				f.SetCellStyle(sheetName, changeCell, changeCell, styles.neutral)
+			}
+		}
+	}
+
+	writeRow(2, fmt.Sprintf("Total (%d kernels)", len(r.Rows)), r.Totals())
+	for i, row := range r.Rows {
+		writeRow(i+3, displayName(row.Kernel), row.Durations)
+	}
+
+	f.AutoFilter(sheetName, fmt.Sprintf("A1:%s%d", lastCol, len(r.Rows)+2), nil)
+	f.SetPanes(sheetName, &excelize.Panes{
+		Freeze:      true,
+		YSplit:      1,
+		TopLeftCell: "A2",
+		ActivePane:  "bottomLeft",
+	})
+	if err := writeLegendSheet(f, styles, 0); err != nil {
+		return err
+	}
+
+	return f.SaveAs(filename)
+}
+
+// multiTraceName names a -trace entry given without name= after its file
+func multiTraceName(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// normalizeRule strips a variable suffix (autotuning config, version, hash) from
+// kernel names that start with prefix
+type normalizeRule struct {
+	name   string
+	prefix string
+	suffix *regexp.Regexp // Anchored at the end of the name
+	// valid, when set, must accept a match before it is stripped (RE2 has no lookahead)
+	valid func(match string) bool
+}
+
+// builtinNormalizeRules are the suffix rules -normalize-suffixes can name
+var builtinNormalizeRules = []normalizeRule{
+	{name: "triton", prefix: "triton_", suffix: regexp.MustCompile(`_\d+$`)},
+	{name: "version", suffix: regexp.MustCompile(`_v\d+$`)},
+	{name: "numeric", suffix: regexp.MustCompile(`_\d+$`)},
+	// Hex runs of hash length with both a digit and a letter, so words and dtype
+	// suffixes such as _bf16 or _fa2 survive
+	{name: "hash", suffix: regexp.MustCompile(`_[0-9a-f]{6,}$`), valid: isMixedHex},
+}
+
+// isMixedHex reports whether s holds both a decimal digit and a letter
+func isMixedHex(s string) bool {
+	return strings.ContainsAny(s, "0123456789") && strings.ContainsAny(s, "abcdef")
+}
+
+// NormalizeRules are applied in order by normalizeKernelName; the default keeps the
+// original behavior of only stripping _N from triton kernels
+var NormalizeRules = builtinNormalizeRules[:1]
+
+// parseNormalizeRules turns a comma-separated -normalize-suffixes list into rules.
+// Entries are built-in rule names (triton, version, numeric, hash); anything else is
+// compiled as a suffix regex for all kernels
+func parseNormalizeRules(spec string) ([]normalizeRule, error) {
+	var rules []normalizeRule
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		found := false
+		for _, rule := range builtinNormalizeRules {
+			if rule.name == entry {
+				rules = append(rules, rule)
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		re, err := regexp.Compile("(?:" + entry + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid normalize suffix %q: %w", entry, err)
+		}
+		rules = append(rules, normalizeRule{name: entry, suffix: re})
+	}
+	return rules, nil
+}
+
+// normalizeKernelName removes variable parts from kernel names by applying each
+// NormalizeRules entry once, in order
+// e.g., "triton_red_fused_something_123" -> "triton_red_fused_something"
+func normalizeKernelName(name string) string {
+	for _, rule := range NormalizeRules {
+		if !strings.HasPrefix(name, rule.prefix) {
+			continue
+		}
+		loc := rule.suffix.FindStringIndex(name)
+		if loc != nil && loc[0] > 0 && (rule.valid == nil || rule.valid(name[loc[0]:])) {
+			name = name[:loc[0]]
+		}
+	}
+	return name
+}
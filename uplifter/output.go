@@ -7,75 +7,324 @@ import (
 	"io"
 	"math"
 	"os"
+	"regexp"
+	"slices"
 	"sort"
 	"strconv"
+	"strings"
+
+	"uplifter/detect"
 )
 
 // CycleResult contains the extracted cycle data with statistics
 type CycleResult struct {
-	CycleLength     int            `json:"cycle_length"`
-	NumCycles       int            `json:"num_cycles"`
-	TotalCycleTime  float64        `json:"total_cycle_time_us"`
-	AvgCycleTime    float64        `json:"avg_cycle_time_us"`
-	Kernels         []KernelStats  `json:"kernels"`
-	KernelsByName   map[string]int `json:"-"` // For quick lookup
+	CycleLength    int            `json:"cycle_length"`
+	NumCycles      int            `json:"num_cycles"`
+	TotalCycleTime float64        `json:"total_cycle_time_us"` // Summed over complete repetitions only
+	AvgCycleTime   float64        `json:"avg_cycle_time_us"`
+	MinCycleTime   float64        `json:"min_cycle_time_us"` // Sum of per-position minimums (best-observed floor)
+	Kernels        []KernelStats  `json:"kernels"`
+	KernelsByName  map[string]int `json:"-"`           // For quick lookup
+	StartIndex     int            `json:"start_index"` // First event index covered by the cycle
+	EndIndex       int            `json:"end_index"`   // One past the last event index covered
+	// Per repetition: fraction of positions whose kernel differs from the first repetition
+	ReorderScores   []float64 `json:"reorder_scores"`
+	AvgReorderScore float64   `json:"avg_reorder_score"`
+	MaxReorderScore float64   `json:"max_reorder_score"`
+	AvgIdleTime     float64   `json:"avg_idle_time_us"`  // Sum of per-position mean gaps after each kernel
+	CycleTimes      []float64 `json:"cycle_times_us"`    // Summed kernel time of each complete repetition
+	CycleTimeCV     float64   `json:"cycle_time_cv_pct"` // Coefficient of variation of CycleTimes (%)
+	// Mean wall-clock span of a complete repetition (first kernel start to last kernel
+	// end) and the share of it kernels were running; 0 when the trace has no timestamps
+	AvgCycleSpan   float64 `json:"avg_cycle_span_us"`
+	GPUUtilization float64 `json:"gpu_utilization_pct"`
+	// Per-cycle time in memory-movement kernels (categorizeKernel's Memory bucket:
+	// copy/fill/reshape/transpose) and in every other kernel
+	MemoryTime  float64 `json:"memory_time_us"`
+	ComputeTime float64 `json:"compute_time_us"`
+	// Kernels before StartIndex that no repetition covers, and the first few names
+	WarmupEvents  int      `json:"warmup_events"`
+	WarmupKernels []string `json:"warmup_kernels,omitempty"`
+}
+
+// warmupLine describes the kernels skipped before a cycle's start, or "" if none were
+func warmupLine(skipped int, names []string) string {
+	if skipped <= 0 {
+		return ""
+	}
+	line := fmt.Sprintf("Skipped %d warmup kernels before cycle start", skipped)
+	if len(names) > 0 {
+		shown := make([]string, len(names))
+		for i, name := range names {
+			shown[i] = detect.TruncateString(displayName(name), 40)
+		}
+		line += " (first: " + strings.Join(shown, ", ")
+		if skipped > len(names) {
+			line += ", ..."
+		}
+		line += ")"
+	}
+	return line + "\n"
+}
+
+// CSVSchemaVersion is written to the cycle CSV metadata and bumped when columns change
+// (1: original columns, 2: adds min_at_cycle/max_at_cycle, 3: adds gap_us, 4: adds
+// p50/p90/p99_us, 5: adds category). Readers look columns up by header name, so older
+// and newer files stay readable.
+const CSVSchemaVersion = 5
+
+// ReorderWarnThreshold is the reorder score above which a cycle's per-position
+// aggregation is flagged as unreliable
+const ReorderWarnThreshold = 0.10
+
+// CycleTimeCVWarnThreshold is the coefficient of variation (%) of per-repetition cycle
+// times above which a cycle is flagged as unstable (contention, throttling)
+const CycleTimeCVWarnThreshold = 10.0
+
+// MemoryBoundWarnThreshold is the share (%) of cycle time in memory-movement kernels
+// above which a cycle is flagged as bandwidth-bound
+const MemoryBoundWarnThreshold = 50.0
+
+// EmitCV adds a coefficient-of-variation column (stddev/avg as a percentage) to outputs
+var EmitCV = false
+
+// EmitLaunchArgs adds grid, block and stream columns (from trace args) to CSV output
+var EmitLaunchArgs = false
+
+// KeepDurations keeps each kernel's per-repetition durations after ExtractCycle
+// (normally cleared to save memory) so WriteDurationsCSV can emit them
+var KeepDurations = false
+
+// GroupByShape adds a per-shape rollup of GEMM kernel time to cycle summaries
+var GroupByShape = false
+
+// formatDims formats launch dimensions as XxYxZ, or "" when unknown
+func formatDims(d [3]int) string {
+	if d == [3]int{} {
+		return ""
+	}
+	return fmt.Sprintf("%dx%dx%d", d[0], d[1], d[2])
+}
+
+// coefficientOfVariation returns stddev as a percentage of the average
+func coefficientOfVariation(stdDev, avg float64) float64 {
+	if avg <= 0 {
+		return 0
+	}
+	return stdDev / avg * 100
+}
+
+// setPercentiles fills P50/P90/P99 from the collected durations (sorting them in place,
+// or a copy when KeepDurations needs the repetition order)
+func (s *KernelStats) setPercentiles() {
+	sorted := s.Durations
+	if KeepDurations {
+		sorted = slices.Clone(s.Durations)
+	}
+	sort.Float64s(sorted)
+	s.P50 = percentile(sorted, 50)
+	s.P90 = percentile(sorted, 90)
+	s.P99 = percentile(sorted, 99)
+}
+
+// cycleTimeCV returns the coefficient of variation (%) of per-repetition cycle times
+func cycleTimeCV(times []float64) float64 {
+	if len(times) < 2 {
+		return 0
+	}
+	mean := 0.0
+	for _, t := range times {
+		mean += t
+	}
+	mean /= float64(len(times))
+	var sumSquares float64
+	for _, t := range times {
+		sumSquares += (t - mean) * (t - mean)
+	}
+	return coefficientOfVariation(math.Sqrt(sumSquares/float64(len(times))), mean)
+}
+
+// percentile returns the p-th percentile of sorted values, interpolating linearly
+// between the closest ranks (0 if empty)
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(rank)
+	if lo+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lo] + (sorted[lo+1]-sorted[lo])*(rank-float64(lo))
+}
+
+// idleGap returns the idle time (µs) between events[idx] ending and the next event
+// starting, or 0 at the end of the trace or when they overlap (e.g. across streams)
+func idleGap(events []KernelEvent, idx int) float64 {
+	if idx < 0 || idx+1 >= len(events) {
+		return 0
+	}
+	end := events[idx].Timestamp + events[idx].Duration
+	return math.Max(0, events[idx+1].Timestamp-end)
+}
+
+// addIdle accumulates the gaps around events[idx]; finishIdle turns the sums into means
+func (s *KernelStats) addIdle(events []KernelEvent, idx int) {
+	after := idleGap(events, idx)
+	s.IdleBefore += idleGap(events, idx-1)
+	s.IdleAfter += after
+	s.MaxIdleAfter = math.Max(s.MaxIdleAfter, after)
+}
+
+func (s *KernelStats) finishIdle() {
+	if s.Count > 0 {
+		s.IdleBefore /= float64(s.Count)
+		s.IdleAfter /= float64(s.Count)
+	}
 }
 
 // ExtractCycle extracts one representative cycle from the events using the detected cycle info
 func ExtractCycle(events []KernelEvent, cycleInfo *CycleInfo) *CycleResult {
-	result := &CycleResult{
-		CycleLength:   cycleInfo.CycleLength,
-		NumCycles:     cycleInfo.NumCycles,
-		Kernels:       make([]KernelStats, 0, cycleInfo.CycleLength),
-		KernelsByName: make(map[string]int),
-	}
-
-	// Aggregate statistics across all detected cycles
-	kernelStats := make(map[int]*KernelStats) // Position -> Stats
-
-	for cycleIdx, cycleStart := range cycleInfo.CycleIndices {
-		cycleTime := 0.0
-		for i := 0; i < cycleInfo.CycleLength && cycleStart+i < len(events); i++ {
-			event := events[cycleStart+i]
-			cycleTime += event.Duration
-
-			if _, exists := kernelStats[i]; !exists {
-				kernelStats[i] = &KernelStats{
-					Name:         event.Name,
-					IndexInCycle: i,
-					MinDur:       event.Duration,
-					MaxDur:       event.Duration,
-					Durations:    make([]float64, 0, cycleInfo.NumCycles),
-				}
-			}
+	acc := newCycleAccumulator(cycleInfo.CycleLength, cycleInfo.NumCycles)
+	acc.result.StartIndex = cycleInfo.StartIndex
+	acc.result.WarmupEvents = cycleInfo.StartIndex
+	acc.result.WarmupKernels = detect.WarmupKernels(events, cycleInfo.StartIndex)
+	acc.result.EndIndex = cycleInfo.StartIndex + cycleInfo.CycleLength
+	if n := len(cycleInfo.CycleIndices); n > 0 {
+		acc.result.EndIndex = min(cycleInfo.CycleIndices[n-1]+cycleInfo.CycleLength, len(events))
+	}
 
-			stats := kernelStats[i]
-			stats.TotalDur += event.Duration
-			stats.Count++
-			stats.Durations = append(stats.Durations, event.Duration)
-			if event.Duration < stats.MinDur {
-				stats.MinDur = event.Duration
-			}
-			if event.Duration > stats.MaxDur {
-				stats.MaxDur = event.Duration
+	// Aggregate statistics across all detected cycles; each repetition is passed with
+	// its neighbouring events so idle gaps at its edges are counted
+	for _, cycleStart := range cycleInfo.CycleIndices {
+		lo := max(0, cycleStart-1)
+		hi := min(len(events), cycleStart+cycleInfo.CycleLength+1)
+		n := min(cycleInfo.CycleLength, len(events)-cycleStart)
+		acc.addRepetition(events[lo:hi], cycleStart-lo, n)
+	}
+
+	return acc.finish()
+}
+
+// cycleAccumulator builds a CycleResult one repetition at a time, so the in-memory
+// and streaming extractors share the same statistics
+type cycleAccumulator struct {
+	result         *CycleResult
+	kernelStats    map[int]*KernelStats // Position -> Stats
+	firstOrder     []string             // Kernel order of the first repetition, to score how much later ones shuffle
+	completeCycles int
+	sizeHint       int     // Expected repetitions, to presize Durations
+	spanSum        float64 // Summed wall-clock spans of complete, timestamped repetitions
+	spanCycles     int
+}
+
+func newCycleAccumulator(cycleLength, sizeHint int) *cycleAccumulator {
+	return &cycleAccumulator{
+		result: &CycleResult{
+			CycleLength:   cycleLength,
+			NumCycles:     sizeHint,
+			Kernels:       make([]KernelStats, 0, cycleLength),
+			KernelsByName: make(map[string]int),
+		},
+		kernelStats: make(map[int]*KernelStats),
+		sizeHint:    sizeHint,
+	}
+}
+
+// addRepetition adds the n kernels at window[offset:]; the events just outside them
+// (if in window) only feed the idle gaps. A repetition shorter than CycleLength was
+// cut off by the end of the trace and is left out of the cycle times
+func (a *cycleAccumulator) addRepetition(window []KernelEvent, offset, n int) {
+	cycleLength := a.result.CycleLength
+	cycleIdx := len(a.result.ReorderScores)
+	cycleTime := 0.0
+	moved := 0
+	var spanStart, spanEnd float64
+	timestamped := false
+	for i := 0; i < n; i++ {
+		event := window[offset+i]
+		cycleTime += event.Duration
+		if i == 0 || event.Timestamp < spanStart {
+			spanStart = event.Timestamp
+		}
+		spanEnd = math.Max(spanEnd, event.Timestamp+event.Duration)
+		timestamped = timestamped || event.Timestamp != 0
+		if cycleIdx == 0 {
+			a.firstOrder = append(a.firstOrder, event.Name)
+		} else if i >= len(a.firstOrder) || a.firstOrder[i] != event.Name {
+			moved++
+		}
+
+		if _, exists := a.kernelStats[i]; !exists {
+			a.kernelStats[i] = &KernelStats{
+				Name:         event.Name,
+				IndexInCycle: i,
+				MinDur:       event.Duration,
+				MaxDur:       event.Duration,
+				MinAtCycle:   cycleIdx + 1,
+				MaxAtCycle:   cycleIdx + 1,
+				Grid:         [3]int{event.GridX, event.GridY, event.GridZ},
+				Block:        [3]int{event.BlockX, event.BlockY, event.BlockZ},
+				Stream:       event.Stream,
+				Durations:    make([]float64, 0, a.sizeHint),
 			}
 		}
 
-		result.TotalCycleTime += cycleTime
-		_ = cycleIdx // Used for potential per-cycle tracking
+		stats := a.kernelStats[i]
+		stats.TotalDur += event.Duration
+		stats.Count++
+		stats.addIdle(window, offset+i)
+		stats.Durations = append(stats.Durations, event.Duration)
+		if event.Duration < stats.MinDur {
+			stats.MinDur = event.Duration
+			stats.MinAtCycle = cycleIdx + 1
+		}
+		if event.Duration > stats.MaxDur {
+			stats.MaxDur = event.Duration
+			stats.MaxAtCycle = cycleIdx + 1
+		}
 	}
 
-	result.AvgCycleTime = result.TotalCycleTime / float64(cycleInfo.NumCycles)
+	// A repetition cut off by the end of the trace would drag the average down
+	if n == cycleLength {
+		a.result.TotalCycleTime += cycleTime
+		a.result.CycleTimes = append(a.result.CycleTimes, cycleTime)
+		a.completeCycles++
+		// A timing-less eager trace has every timestamp at zero, so no span to measure
+		if timestamped {
+			a.spanSum += spanEnd - spanStart
+			a.spanCycles++
+		}
+	}
+	score := float64(moved) / float64(cycleLength)
+	a.result.ReorderScores = append(a.result.ReorderScores, score)
+	a.result.MaxReorderScore = math.Max(a.result.MaxReorderScore, score)
+}
+
+// finish turns the accumulated sums into the per-position statistics
+func (a *cycleAccumulator) finish() *CycleResult {
+	result := a.result
+	for _, score := range result.ReorderScores {
+		result.AvgReorderScore += score / float64(len(result.ReorderScores))
+	}
+	if a.completeCycles > 0 {
+		result.AvgCycleTime = result.TotalCycleTime / float64(a.completeCycles)
+	}
+	result.CycleTimeCV = cycleTimeCV(result.CycleTimes)
+	if a.spanCycles > 0 {
+		result.AvgCycleSpan = a.spanSum / float64(a.spanCycles)
+		result.GPUUtilization = percentOf(result.AvgCycleTime, result.AvgCycleSpan)
+	}
 
 	// Convert map to sorted slice and compute stddev
-	positions := make([]int, 0, len(kernelStats))
-	for pos := range kernelStats {
+	positions := make([]int, 0, len(a.kernelStats))
+	for pos := range a.kernelStats {
 		positions = append(positions, pos)
 	}
 	sort.Ints(positions)
 
 	for _, pos := range positions {
-		stats := kernelStats[pos]
+		stats := a.kernelStats[pos]
 		stats.AvgDur = stats.TotalDur / float64(stats.Count)
 		// Compute standard deviation
 		if len(stats.Durations) > 1 {
@@ -86,11 +335,18 @@ func ExtractCycle(events []KernelEvent, cycleInfo *CycleInfo) *CycleResult {
 			}
 			stats.StdDev = math.Sqrt(sumSquares / float64(len(stats.Durations)))
 		}
-		// Clear durations to save memory (we have stddev now)
-		stats.Durations = nil
+		stats.setPercentiles()
+		// Clear durations to save memory (we have stddev and percentiles now)
+		if !KeepDurations {
+			stats.Durations = nil
+		}
+		stats.finishIdle()
+		result.AvgIdleTime += stats.IdleAfter
 		result.Kernels = append(result.Kernels, *stats)
 		result.KernelsByName[stats.Name] = pos
+		result.MinCycleTime += stats.MinDur
 	}
+	result.MemoryTime, result.ComputeTime = memoryComputeSplit(result.Kernels)
 
 	return result
 }
@@ -103,10 +359,15 @@ func (r *CycleResult) WriteCSV(w io.Writer) error {
 	// Write cycle metadata as comment rows
 	metaRows := [][]string{
 		{"# Cycle Statistics"},
+		{"# Schema version", strconv.Itoa(CSVSchemaVersion)},
 		{"# Iterations", strconv.Itoa(r.NumCycles)},
 		{"# Kernels per cycle", strconv.Itoa(r.CycleLength)},
 		{"# Avg cycle time (us)", fmt.Sprintf("%.3f", r.AvgCycleTime)},
 		{"# Total time (us)", fmt.Sprintf("%.3f", r.TotalCycleTime)},
+		{"# Best-observed cycle time (us)", fmt.Sprintf("%.3f", r.MinCycleTime)},
+		{"# Event index range", strconv.Itoa(r.StartIndex), strconv.Itoa(r.EndIndex)},
+		{"# Reorder score (avg/max)", fmt.Sprintf("%.4f", r.AvgReorderScore), fmt.Sprintf("%.4f", r.MaxReorderScore)},
+		{"# Avg idle time (us)", fmt.Sprintf("%.3f", r.AvgIdleTime)},
 		{}, // Empty row before data
 	}
 	for _, row := range metaRows {
@@ -125,6 +386,22 @@ func (r *CycleResult) WriteCSV(w io.Writer) error {
 		"stddev_us",
 		"count",
 		"pct_of_cycle",
+		"min_at_cycle",
+		"max_at_cycle",
+		"gap_us",
+		"p50_us",
+		"p90_us",
+		"p99_us",
+		"category",
+	}
+	if EmitCV {
+		headers = append(headers, "cv_pct")
+	}
+	if EmitLaunchArgs {
+		headers = append(headers, "grid", "block", "stream")
+	}
+	if DemangleNames {
+		headers = append(headers, "display_name")
 	}
 	if err := writer.Write(headers); err != nil {
 		return err
@@ -132,7 +409,7 @@ func (r *CycleResult) WriteCSV(w io.Writer) error {
 
 	// Write kernel rows
 	for _, k := range r.Kernels {
-		pctOfCycle := (k.AvgDur / r.AvgCycleTime) * 100
+		pctOfCycle := percentOf(k.AvgDur, r.AvgCycleTime)
 		row := []string{
 			strconv.Itoa(k.IndexInCycle),
 			k.Name,
@@ -142,6 +419,22 @@ func (r *CycleResult) WriteCSV(w io.Writer) error {
 			fmt.Sprintf("%.3f", k.StdDev),
 			strconv.Itoa(k.Count),
 			fmt.Sprintf("%.4f", pctOfCycle),
+			strconv.Itoa(k.MinAtCycle),
+			strconv.Itoa(k.MaxAtCycle),
+			fmt.Sprintf("%.3f", k.IdleAfter),
+			fmt.Sprintf("%.3f", k.P50),
+			fmt.Sprintf("%.3f", k.P90),
+			fmt.Sprintf("%.3f", k.P99),
+			categorizeKernel(k.Name),
+		}
+		if EmitCV {
+			row = append(row, fmt.Sprintf("%.2f", coefficientOfVariation(k.StdDev, k.AvgDur)))
+		}
+		if EmitLaunchArgs {
+			row = append(row, formatDims(k.Grid), formatDims(k.Block), strconv.Itoa(k.Stream))
+		}
+		if DemangleNames {
+			row = append(row, simplifyKernelName(k.Name))
 		}
 		if err := writer.Write(row); err != nil {
 			return err
@@ -151,6 +444,58 @@ func (r *CycleResult) WriteCSV(w io.Writer) error {
 	return nil
 }
 
+// WriteEventsCSV writes the parsed kernel events, one row per event, for debugging detection
+func WriteEventsCSV(w io.Writer, events []KernelEvent) error {
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	if err := writer.Write([]string{"index", "kernel_name", "ts_us", "dur_us", "pid", "tid"}); err != nil {
+		return err
+	}
+	for i, e := range events {
+		row := []string{
+			strconv.Itoa(i),
+			e.Name,
+			strconv.FormatFloat(e.Timestamp, 'f', -1, 64),
+			strconv.FormatFloat(e.Duration, 'f', -1, 64),
+			strconv.Itoa(e.Pid),
+			strconv.Itoa(e.Tid),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteDurationsCSV writes one row per (position, repetition) with the individual
+// kernel duration; it needs a result extracted with KeepDurations set
+func (r *CycleResult) WriteDurationsCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	if err := writer.Write([]string{"index", "kernel_name", "iteration", "duration_us"}); err != nil {
+		return err
+	}
+	for _, k := range r.Kernels {
+		if k.Count > 0 && len(k.Durations) == 0 {
+			return fmt.Errorf("no per-repetition durations for %s (extract with KeepDurations)", k.Name)
+		}
+		for iter, d := range k.Durations {
+			row := []string{
+				strconv.Itoa(k.IndexInCycle),
+				k.Name,
+				strconv.Itoa(iter + 1),
+				fmt.Sprintf("%.3f", d),
+			}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // WriteJSON writes the cycle result to JSON format
 func (r *CycleResult) WriteJSON(w io.Writer) error {
 	encoder := json.NewEncoder(w)
@@ -158,68 +503,254 @@ func (r *CycleResult) WriteJSON(w io.Writer) error {
 	return encoder.Encode(r)
 }
 
-// WriteSummary writes a human-readable summary
-func (r *CycleResult) WriteSummary(w io.Writer) {
+// DefaultTopN is how many kernels the summaries list when not overridden by -top
+const DefaultTopN = 10
+
+// WriteSummary writes a human-readable summary listing the topN longest kernels
+func (r *CycleResult) WriteSummary(w io.Writer, topN int) {
 	fmt.Fprintf(w, "\n=== Cycle Analysis Summary ===\n")
 	fmt.Fprintf(w, "Cycle Length: %d kernels\n", r.CycleLength)
 	fmt.Fprintf(w, "Number of Cycles: %d\n", r.NumCycles)
+	fmt.Fprint(w, warmupLine(r.WarmupEvents, r.WarmupKernels))
 	fmt.Fprintf(w, "Average Cycle Time: %.2f µs (%.4f ms)\n", r.AvgCycleTime, r.AvgCycleTime/1000)
 	fmt.Fprintf(w, "Total Measured Time: %.2f µs (%.4f ms)\n", r.TotalCycleTime, r.TotalCycleTime/1000)
+	if r.MinCycleTime > 0 && r.AvgCycleTime > 0 {
+		fmt.Fprintf(w, "Best-Observed Cycle Time: %.2f µs (avg is %.1f%% above)\n",
+			r.MinCycleTime, (r.AvgCycleTime-r.MinCycleTime)/r.MinCycleTime*100)
+	}
+	fmt.Fprintf(w, "Kernel Order Reorder Score: avg %.1f%%, max %.1f%% of positions differ from the first repetition\n",
+		r.AvgReorderScore*100, r.MaxReorderScore*100)
+	if r.MaxReorderScore > ReorderWarnThreshold {
+		fmt.Fprintf(w, "Warning: kernel order shuffles between repetitions; per-position stats may mix different kernels\n")
+	}
+	fmt.Fprintf(w, "Cycle Time Variation: CV %.1f%% across %d repetitions\n", r.CycleTimeCV, len(r.CycleTimes))
+	if r.CycleTimeCV > CycleTimeCVWarnThreshold {
+		fmt.Fprintf(w, "Warning: cycle time varies widely between repetitions (contention or throttling?)\n")
+	}
+	if wall := r.AvgCycleTime + r.AvgIdleTime; wall > 0 {
+		fmt.Fprintf(w, "Idle Time Between Kernels: %.2f µs per cycle (%.1f%% of %.2f µs wall time)\n",
+			r.AvgIdleTime, r.AvgIdleTime/wall*100, wall)
+	}
+	if r.AvgCycleSpan > 0 {
+		fmt.Fprintf(w, "GPU Utilization: %.1f%% (%.2f µs of kernels in a %.2f µs wall-clock cycle)\n",
+			r.GPUUtilization, r.AvgCycleTime, r.AvgCycleSpan)
+	}
+	if total := r.MemoryTime + r.ComputeTime; total > 0 {
+		memoryPct := percentOf(r.MemoryTime, total)
+		fmt.Fprintf(w, "Memory vs Compute: %.2f µs memory (%.1f%%), %.2f µs compute (%.1f%%)\n",
+			r.MemoryTime, memoryPct, r.ComputeTime, 100-memoryPct)
+		if memoryPct > MemoryBoundWarnThreshold {
+			fmt.Fprintf(w, "Warning: memory-movement kernels dominate this cycle (likely bandwidth-bound)\n")
+		}
+	}
 	fmt.Fprintf(w, "\n")
 
-	// Top 10 kernels by duration
-	fmt.Fprintf(w, "=== Top 10 Kernels by Average Duration ===\n")
+	// Top N kernels by duration
+	fmt.Fprintf(w, "=== Top %d Kernels by Average Duration ===\n", topN)
 	sorted := make([]KernelStats, len(r.Kernels))
 	copy(sorted, r.Kernels)
 	sort.Slice(sorted, func(i, j int) bool {
 		return sorted[i].AvgDur > sorted[j].AvgDur
 	})
 
-	for i := 0; i < min(10, len(sorted)); i++ {
+	for i := 0; i < min(topN, len(sorted)); i++ {
 		k := sorted[i]
-		pct := (k.AvgDur / r.AvgCycleTime) * 100
-		fmt.Fprintf(w, "%2d. [%4d] %s\n", i+1, k.IndexInCycle, truncateString(k.Name, 80))
+		pct := percentOf(k.AvgDur, r.AvgCycleTime)
+		fmt.Fprintf(w, "%2d. [%4d] %s\n", i+1, k.IndexInCycle, detect.TruncateString(k.Name, 80))
 		fmt.Fprintf(w, "          Avg: %.2f µs | Min: %.2f | Max: %.2f | StdDev: %.2f  (%.2f%% of cycle)\n",
 			k.AvgDur, k.MinDur, k.MaxDur, k.StdDev, pct)
+		fmt.Fprintf(w, "          %s %.0f%%\n", percentBar(pct, 40), pct)
 	}
 	fmt.Fprintf(w, "\n")
 
 	// Kernel type distribution
 	fmt.Fprintf(w, "=== Kernel Type Distribution ===\n")
-	typeCounts := make(map[string]struct {
-		count int
-		dur   float64
-	})
+	for _, t := range kernelTypeDistribution(r.Kernels) {
+		pct := percentOf(t.dur, r.AvgCycleTime)
+		fmt.Fprintf(w, "  %-20s: %4d kernels, %.2f µs (%.1f%%)\n", t.name, t.count, t.dur, pct)
+	}
 
-	for _, k := range r.Kernels {
+	if GroupByShape {
+		fmt.Fprintf(w, "\n")
+		writeShapeSummary(w, r.Kernels, r.AvgCycleTime)
+	}
+}
+
+// kernelTypeTotal is the kernel count and summed duration of one categorizeKernel type
+type kernelTypeTotal struct {
+	name  string
+	count int
+	dur   float64
+}
+
+// kernelTypeDistribution groups kernels by categorizeKernel, longest total first
+func kernelTypeDistribution(kernels []KernelStats) []kernelTypeTotal {
+	typeCounts := make(map[string]*kernelTypeTotal)
+	var types []kernelTypeTotal
+	for _, k := range kernels {
 		kernelType := categorizeKernel(k.Name)
 		entry := typeCounts[kernelType]
+		if entry == nil {
+			entry = &kernelTypeTotal{name: kernelType}
+			typeCounts[kernelType] = entry
+		}
 		entry.count++
 		entry.dur += k.AvgDur
-		typeCounts[kernelType] = entry
 	}
+	for _, t := range typeCounts {
+		types = append(types, *t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].dur > types[j].dur
+	})
+	return types
+}
 
-	type typeInfo struct {
+// writeShapeSummary rolls GEMM kernels up by the problem/tile shape parsed from their
+// names; GEMMs whose shape can't be parsed go to an "unknown shape" bucket
+func writeShapeSummary(w io.Writer, kernels []KernelStats, cycleTime float64) {
+	fmt.Fprintf(w, "=== GEMM Time by Shape ===\n")
+	type shapeInfo struct {
 		name  string
 		count int
 		dur   float64
 	}
-	var types []typeInfo
-	for name, info := range typeCounts {
-		types = append(types, typeInfo{name, info.count, info.dur})
+	byShape := make(map[string]*shapeInfo)
+	var shapes []*shapeInfo
+	for _, k := range kernels {
+		if !isGemmKernel(k.Name) {
+			continue
+		}
+		shape, ok := gemmShape(k.Name)
+		if !ok {
+			shape = "unknown shape"
+		}
+		info := byShape[shape]
+		if info == nil {
+			info = &shapeInfo{name: shape}
+			byShape[shape] = info
+			shapes = append(shapes, info)
+		}
+		info.count++
+		info.dur += k.AvgDur
 	}
-	sort.Slice(types, func(i, j int) bool {
-		return types[i].dur > types[j].dur
+	if len(shapes) == 0 {
+		fmt.Fprintf(w, "  (no GEMM kernels)\n")
+		return
+	}
+	sort.SliceStable(shapes, func(i, j int) bool {
+		return shapes[i].dur > shapes[j].dur
 	})
 
-	for _, t := range types {
-		pct := (t.dur / r.AvgCycleTime) * 100
-		fmt.Fprintf(w, "  %-20s: %4d kernels, %.2f µs (%.1f%%)\n", t.name, t.count, t.dur, pct)
+	for _, s := range shapes {
+		pct := percentOf(s.dur, cycleTime)
+		fmt.Fprintf(w, "  %-24s: %4d kernels, %.2f µs (%.1f%%)\n", s.name, s.count, s.dur, pct)
 	}
 }
 
+// isGemmKernel reports whether a kernel falls in the GEMM/BLAS bucket or names a GEMM
+func isGemmKernel(name string) bool {
+	return categorizeKernel(name) == "GEMM/BLAS" || containsIgnoreCase(name, "gemm")
+}
+
+var (
+	gemmMNKPattern  = regexp.MustCompile(`(?i)(?:^|[_\W])M_?(\d+)_?N_?(\d+)_?K_?(\d+)(?:$|[_\W])`)
+	gemmTilePattern = regexp.MustCompile(`_MT(\d+x\d+x\d+)`)
+	gemmDimsPattern = regexp.MustCompile(`_(\d+x\d+(?:x\d+)?)(?:_|$)`)
+)
+
+// gemmShape extracts a shape label from known GEMM naming schemes: explicit M/N/K
+// tokens (gemm_M4096_N4096_K512), Tensile/hipBLASLt macro tiles (Cijk_..._MT128x128x64_...)
+// and cuBLAS tile suffixes (ampere_sgemm_128x64_tn)
+func gemmShape(name string) (string, bool) {
+	if m := gemmMNKPattern.FindStringSubmatch(name); m != nil {
+		return fmt.Sprintf("M=%s N=%s K=%s", m[1], m[2], m[3]), true
+	}
+	if m := gemmTilePattern.FindStringSubmatch(name); m != nil {
+		return "tile " + m[1], true
+	}
+	if m := gemmDimsPattern.FindStringSubmatch(name); m != nil {
+		return "tile " + m[1], true
+	}
+	return "", false
+}
+
+// percentOf returns part as a percentage of whole, or 0 when whole is zero (e.g. an
+// eager trace without timing) so outputs never show NaN or Inf
+func percentOf(part, whole float64) float64 {
+	if whole <= 0 {
+		return 0
+	}
+	return part / whole * 100
+}
+
+// percentBar renders pct (0-100) as a block bar of up to width characters
+func percentBar(pct float64, width int) string {
+	n := int(math.Round(pct / 100 * float64(width)))
+	n = max(0, min(n, width))
+	if n == 0 && pct > 0 {
+		n = 1 // Keep tiny shares visible
+	}
+	return strings.Repeat("█", n)
+}
+
+// categoryRule assigns category to kernels whose name contains substr (case-insensitive)
+type categoryRule struct {
+	substr   string
+	category string
+}
+
+// CustomCategories are checked before the built-in categorizeKernel patterns, so teams
+// can classify their own kernels (empty = built-in patterns only)
+var CustomCategories []categoryRule
+
+// loadCategoryRules reads category=substring rules, one per line (blank lines and
+// # comments are skipped), in the same layout as a -vendor-map file
+func loadCategoryRules(path string) ([]categoryRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kernel categories: %w", err)
+	}
+	var rules []categoryRule
+	for lineNum, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		category, substr, ok := strings.Cut(line, "=")
+		category, substr = strings.TrimSpace(category), strings.TrimSpace(substr)
+		if !ok || category == "" || substr == "" {
+			return nil, fmt.Errorf("kernel categories line %d: expected category=substring, got %q", lineNum+1, line)
+		}
+		rules = append(rules, categoryRule{substr: substr, category: category})
+	}
+	return rules, nil
+}
+
+// memoryCategory is the categorizeKernel category of memory-movement kernels
+const memoryCategory = "Memory"
+
+// memoryComputeSplit sums the average duration of memory-movement kernels and of
+// everything else (compute)
+func memoryComputeSplit(kernels []KernelStats) (memory, compute float64) {
+	for _, k := range kernels {
+		if categorizeKernel(k.Name) == memoryCategory {
+			memory += k.AvgDur
+		} else {
+			compute += k.AvgDur
+		}
+	}
+	return memory, compute
+}
+
 // categorizeKernel attempts to categorize a kernel by its name
 func categorizeKernel(name string) string {
+	for _, rule := range CustomCategories {
+		if containsIgnoreCase(name, rule.substr) {
+			return rule.category
+		}
+	}
+
 	// Check for common patterns
 	patterns := []struct {
 		substr   string
@@ -235,10 +766,10 @@ func categorizeKernel(name string) string {
 		{"norm", "Normalization"},
 		{"softmax", "Softmax"},
 		{"embedding", "Embedding"},
-		{"copy", "Memory"},
-		{"fill", "Memory"},
-		{"reshape", "Memory"},
-		{"transpose", "Memory"},
+		{"copy", memoryCategory},
+		{"fill", memoryCategory},
+		{"reshape", memoryCategory},
+		{"transpose", memoryCategory},
 		{"rocprim", "ROCm Primitives"},
 		{"ck_tile", "Composable Kernel"},
 	}
@@ -288,14 +819,19 @@ func (r *CycleResult) WriteToFile(filename string) error {
 	}
 	defer file.Close()
 
-	if len(filename) > 5 && filename[len(filename)-5:] == ".json" {
+	if strings.HasSuffix(filename, ".speedscope.json") {
+		return r.WriteSpeedscope(file)
+	} else if len(filename) > 5 && filename[len(filename)-5:] == ".json" {
 		return r.WriteJSON(file)
+	} else if len(filename) > 5 && filename[len(filename)-5:] == ".html" {
+		return r.WriteHTML(file)
+	} else if strings.HasSuffix(filename, ".folded") {
+		return r.WriteFolded(file)
 	} else if len(filename) > 4 && filename[len(filename)-4:] == ".csv" {
 		return r.WriteCSV(file)
 	} else {
 		// Default to summary
-		r.WriteSummary(file)
+		r.WriteSummary(file, DefaultTopN)
 		return nil
 	}
 }
-
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// overlayEvent is a Chrome trace event written to the cycle overlay
+type overlayEvent struct {
+	Name      string                 `json:"name"`
+	Category  string                 `json:"cat,omitempty"`
+	Phase     string                 `json:"ph"`
+	Timestamp float64                `json:"ts"`
+	Duration  float64                `json:"dur,omitempty"`
+	Pid       int                    `json:"pid"`
+	Tid       int                    `json:"tid"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+// WriteCycleOverlay writes a Chrome trace JSON with one slice per detected cycle
+// repetition, timed from the original events, on a dedicated "uplifter" process
+// (one thread per pattern) so it can be loaded next to the real trace in Perfetto
+func WriteCycleOverlay(w io.Writer, events []KernelEvent, patterns []CyclePattern) error {
+	// Pick a pid that doesn't collide with the trace's own processes
+	pid := 0
+	for _, e := range events {
+		pid = max(pid, e.Pid)
+	}
+	pid++
+
+	traceEvents := []overlayEvent{{
+		Name:  "process_name",
+		Phase: "M",
+		Pid:   pid,
+		Args:  map[string]interface{}{"name": "uplifter cycles"},
+	}}
+
+	for p, pattern := range patterns {
+		tid := p + 1
+		traceEvents = append(traceEvents, overlayEvent{
+			Name:  "thread_name",
+			Phase: "M",
+			Pid:   pid,
+			Tid:   tid,
+			Args:  map[string]interface{}{"name": fmt.Sprintf("pattern %d (length %d)", tid, pattern.Info.CycleLength)},
+		})
+
+		for rep, start := range pattern.Info.CycleIndices {
+			end := min(start+pattern.Info.CycleLength, len(events))
+			if start >= end {
+				continue
+			}
+			first, last := events[start], events[end-1]
+			traceEvents = append(traceEvents, overlayEvent{
+				Name:      fmt.Sprintf("cycle %d", rep+1),
+				Category:  "uplifter",
+				Phase:     "X",
+				Timestamp: first.Timestamp,
+				Duration:  last.Timestamp + last.Duration - first.Timestamp,
+				Pid:       pid,
+				Tid:       tid,
+				Args: map[string]interface{}{
+					"start_index":  start,
+					"end_index":    end,
+					"first_kernel": first.Name,
+				},
+			})
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(map[string]interface{}{"traceEvents": traceEvents})
+}
+
+// WriteCycleOverlayFile writes the cycle overlay to filename
+func WriteCycleOverlayFile(filename string, events []KernelEvent, patterns []CyclePattern) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return WriteCycleOverlay(file, events, patterns)
+}
@@ -2,24 +2,22 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"compress/gzip"
+	"container/heap"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
-)
 
-// KernelEvent represents a GPU kernel execution event from the trace
-type KernelEvent struct {
-	Name      string  `json:"name"`
-	Category  string  `json:"cat"`
-	Phase     string  `json:"ph"`
-	Timestamp float64 `json:"ts"`
-	Duration  float64 `json:"dur"`
-	Pid       int     `json:"pid"`
-	Tid       int     `json:"tid"`
-}
+	"github.com/klauspost/compress/zstd"
+)
 
 // TraceEvent is the raw event from the JSON trace
 type TraceEvent struct {
@@ -31,33 +29,547 @@ type TraceEvent struct {
 	Pid       int                    `json:"pid"`
 	Tid       int                    `json:"tid"`
 	Args      map[string]interface{} `json:"args,omitempty"`
+	ID        interface{}            `json:"id,omitempty"` // Async slice id (number or string) pairing "b"/"e" events
+}
+
+// NameFromArg, when set, names kernels after the given args key instead of the
+// top-level "name" field (for traces where "name" is a generic slice label)
+var NameFromArg = ""
+
+// MaxEvents bounds the number of trace events parsed; exceeding it is an error (0 = unlimited)
+var MaxEvents = 0
+
+// FilterPid and FilterTid restrict parsing to kernels on one process/thread (-1 = all),
+// e.g. to keep a single GPU's kernels from a multi-device trace
+var (
+	FilterPid = -1
+	FilterTid = -1
+)
+
+// KernelCategories lists the event categories treated as GPU kernels (e.g. "gpu" or
+// "hip_kernel" in some ROCm traces)
+var KernelCategories = []string{"kernel"}
+
+// KernelPhase is the event phase treated as a kernel slice ("X" = complete event)
+var KernelPhase = "X"
+
+// PairAsyncEvents synthesizes kernels from async begin/end ("b"/"e") event pairs
+var PairAsyncEvents = false
+
+// isKernelEvent reports whether a trace event is a kernel slice passing the category,
+// phase and pid/tid filters
+func isKernelEvent(event *TraceEvent) bool {
+	return event.Phase == KernelPhase && passesEventFilters(event)
+}
+
+// passesEventFilters applies the category and pid/tid filters, ignoring the phase
+func passesEventFilters(event *TraceEvent) bool {
+	if !slices.Contains(KernelCategories, event.Category) {
+		return false
+	}
+	if FilterPid >= 0 && event.Pid != FilterPid {
+		return false
+	}
+	return FilterTid < 0 || event.Tid == FilterTid
+}
+
+// asyncKey identifies an async slice for pairing its begin and end events
+type asyncKey struct {
+	pid, tid int
+	id, name string
+}
+
+// asyncPairer matches async begin/end events during a streaming pass
+type asyncPairer struct {
+	open    map[asyncKey][]KernelEvent // Unmatched begins per key (stack for nested slices)
+	begins  beginHeap                  // Every begin added, earliest first; paired ones are dropped lazily
+	paired  map[int]bool               // Seq of paired begins still in begins
+	pending []KernelEvent              // Kernels held back by an earlier open begin, in eventBefore order
+}
+
+// beginHeap is a container/heap of begin events ordered by eventBefore
+type beginHeap []KernelEvent
+
+func (h beginHeap) Len() int           { return len(h) }
+func (h beginHeap) Less(i, j int) bool { return eventBefore(h[i], h[j]) }
+func (h beginHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *beginHeap) Push(x any)        { *h = append(*h, x.(KernelEvent)) }
+func (h *beginHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// earliestOpen returns the earliest begin not yet paired, if any
+func (p *asyncPairer) earliestOpen() (KernelEvent, bool) {
+	for len(p.begins) > 0 {
+		if top := p.begins[0]; !p.paired[top.Seq] {
+			return top, true
+		}
+		delete(p.paired, p.begins[0].Seq)
+		heap.Pop(&p.begins)
+	}
+	return KernelEvent{}, false
+}
+
+// eventBefore is the order SortEventsByTimestamp produces: start time, then trace position
+func eventBefore(a, b KernelEvent) bool {
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp < b.Timestamp
+	}
+	return a.Seq < b.Seq
+}
+
+// release passes kernel k on to callback in begin order: a paired slice only completes
+// at its end event, so k and any later kernels are held until every begin before them
+// has been paired (or the input ends, see flush). Without pairing k goes straight
+// through. It returns false once callback asks to stop
+func (p *asyncPairer) release(k KernelEvent, callback func(KernelEvent) bool) bool {
+	if p == nil {
+		return callback(k)
+	}
+	// Kernels arrive mostly in order, so appending is the common case
+	if n := len(p.pending); n == 0 || !eventBefore(k, p.pending[n-1]) {
+		p.pending = append(p.pending, k)
+	} else {
+		i := sort.Search(n, func(i int) bool { return eventBefore(k, p.pending[i]) })
+		p.pending = slices.Insert(p.pending, i, k)
+	}
+
+	ready := len(p.pending)
+	if earliest, ok := p.earliestOpen(); ok {
+		ready = sort.Search(len(p.pending), func(i int) bool { return !eventBefore(p.pending[i], earliest) })
+	}
+	for _, e := range p.pending[:ready] {
+		if !callback(e) {
+			return false
+		}
+	}
+	p.pending = p.pending[ready:]
+	return true
 }
 
+// flush passes every held kernel on to callback at the end of the input, when begins
+// still open will never be paired
+func (p *asyncPairer) flush(callback func(KernelEvent) bool) {
+	if p == nil {
+		return
+	}
+	for _, e := range p.pending {
+		if !callback(e) {
+			break
+		}
+	}
+	p.pending = nil
+}
+
+func newAsyncPairer() *asyncPairer {
+	if !PairAsyncEvents {
+		return nil
+	}
+	return &asyncPairer{open: make(map[asyncKey][]KernelEvent), paired: make(map[int]bool)}
+}
+
+// add records a begin event, or completes the matching begin on an end event and
+// returns the synthesized kernel (timestamp and seq of the begin, duration to the end)
+func (p *asyncPairer) add(event *TraceEvent, seq int) (KernelEvent, bool) {
+	if p == nil || (event.Phase != "b" && event.Phase != "e") || !passesEventFilters(event) {
+		return KernelEvent{}, false
+	}
+	key := asyncKey{pid: event.Pid, tid: event.Tid, id: fmt.Sprint(event.ID), name: event.Name}
+	if event.Phase == "b" {
+		k := newKernelEvent(event, seq)
+		k.Phase = "X"
+		p.open[key] = append(p.open[key], k)
+		heap.Push(&p.begins, k)
+		return KernelEvent{}, false
+	}
+	stack := p.open[key]
+	if len(stack) == 0 {
+		return KernelEvent{}, false
+	}
+	k := stack[len(stack)-1]
+	if len(stack) == 1 {
+		delete(p.open, key)
+	} else {
+		p.open[key] = stack[:len(stack)-1]
+	}
+	p.paired[k.Seq] = true
+	k.Duration = event.Timestamp - k.Timestamp
+	return k, true
+}
+
+// warnUnmatched reports begin events that never saw their end
+func (p *asyncPairer) warnUnmatched() {
+	if p == nil {
+		return
+	}
+	unmatched := 0
+	for _, stack := range p.open {
+		unmatched += len(stack)
+	}
+	if unmatched > 0 {
+		logf("Warning: dropped %d async begin events with no matching end\n", unmatched)
+	}
+}
+
+// kernelName returns the kernel name for a trace event, honoring NameFromArg
+func kernelName(event *TraceEvent) string {
+	if NameFromArg != "" {
+		if v, ok := event.Args[NameFromArg].(string); ok && v != "" {
+			return v
+		}
+	}
+	return event.Name
+}
+
+// newKernelEvent converts a kernel trace event at position seq into a KernelEvent
+func newKernelEvent(event *TraceEvent, seq int) KernelEvent {
+	k := KernelEvent{
+		Name:      kernelName(event),
+		Category:  event.Category,
+		Phase:     event.Phase,
+		Timestamp: event.Timestamp,
+		Duration:  event.Duration,
+		Pid:       event.Pid,
+		Tid:       event.Tid,
+		Seq:       seq,
+	}
+	k.GridX, k.GridY, k.GridZ = argDims(event.Args, "grid")
+	k.BlockX, k.BlockY, k.BlockZ = argDims(event.Args, "block")
+	k.Stream = int(argNumber(event.Args, "stream", "stream id"))
+	k.CorrelationID = int64(argNumber(event.Args, "correlation", "correlation_id", "correlation id"))
+	return k
+}
+
+// argNumber returns the first of keys present in args as a number (0 if absent)
+func argNumber(args map[string]interface{}, keys ...string) float64 {
+	for _, key := range keys {
+		switch v := args[key].(type) {
+		case float64:
+			return v
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		}
+	}
+	return 0
+}
+
+// argDims reads a 3D launch dimension stored either as an array under key
+// (e.g. "grid": [128, 1, 1]) or as per-axis keys (e.g. "grid_x")
+func argDims(args map[string]interface{}, key string) (x, y, z int) {
+	if dims, ok := args[key].([]interface{}); ok {
+		var d [3]int
+		for i := 0; i < len(dims) && i < 3; i++ {
+			if f, ok := dims[i].(float64); ok {
+				d[i] = int(f)
+			}
+		}
+		return d[0], d[1], d[2]
+	}
+	return int(argNumber(args, key+"_x")), int(argNumber(args, key+"_y")), int(argNumber(args, key+"_z"))
+}
+
+// utf8BOM is the byte order mark some exporters prepend to JSON files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// skipBOM drops a leading UTF-8 byte order mark so the JSON decoder sees the
+// first real token (leading whitespace is already handled by the decoder)
+func skipBOM(r io.Reader) *bufio.Reader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	if prefix, err := br.Peek(len(utf8BOM)); err == nil && bytes.Equal(prefix, utf8BOM) {
+		br.Discard(len(utf8BOM))
+	}
+	return br
+}
+
+// isBareArray reports whether the JSON document starts with '[' (a bare array of
+// trace events) rather than an object with a traceEvents key; it only peeks
+func isBareArray(br *bufio.Reader) bool {
+	for n := 1; ; n++ {
+		buf, _ := br.Peek(n)
+		if len(buf) < n {
+			return false
+		}
+		switch c := buf[n-1]; c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		default:
+			return c == '['
+		}
+	}
+}
+
+// StdinInput is the -input value that reads the trace from standard input
+const StdinInput = "-"
+
+// openInput opens the trace file, or stdin for StdinInput; closing the returned reader
+// leaves stdin itself open. size is the file size for progress reporting (0 if unknown)
+func openInput(filename string) (io.ReadCloser, int64, error) {
+	if filename == StdinInput {
+		return io.NopCloser(os.Stdin), 0, nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	var size int64
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+	return file, size, nil
+}
+
+// Magic numbers identifying compressed streams when there is no file suffix to go by
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// sniffCompression returns the suffix (".gz", ".zst" or "") matching the stream's
+// leading magic bytes, peeked without consuming them
+func sniffCompression(br *bufio.Reader) string {
+	prefix, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(prefix, gzipMagic):
+		return ".gz"
+	case bytes.HasPrefix(prefix, zstdMagic):
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// openDecompressed wraps r in a 64MB buffered reader, decompressing by file suffix
+// (.gz or .zst), or by magic bytes for stdin; the returned close function releases
+// the decompressor
+func openDecompressed(r io.Reader, filename string) (io.Reader, func(), error) {
+	if filename == StdinInput {
+		br := bufio.NewReader(r)
+		r, filename = br, sniffCompression(br)
+	}
+	switch {
+	case strings.HasSuffix(filename, ".gz"):
+		gzReader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		return bufio.NewReaderSize(gzReader, 64*1024*1024), func() { gzReader.Close() }, nil
+	case strings.HasSuffix(filename, ".zst"):
+		zstReader, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create zstd reader: %w", err)
+		}
+		return bufio.NewReaderSize(zstReader, 64*1024*1024), zstReader.Close, nil
+	default:
+		return bufio.NewReaderSize(r, 64*1024*1024), func() {}, nil // 64MB buffer
+	}
+}
+
+// isNDJSON reports whether the file holds one JSON event per line (.ndjson or .jsonl,
+// optionally compressed)
+func isNDJSON(filename string) bool {
+	name := strings.TrimSuffix(strings.TrimSuffix(filename, ".gz"), ".zst")
+	return strings.HasSuffix(name, ".ndjson") || strings.HasSuffix(name, ".jsonl")
+}
+
+// isNDJSONInput is isNDJSON by suffix for files, and by content for stdin, which has
+// no name to go by
+func isNDJSONInput(filename string, br *bufio.Reader) bool {
+	if filename == StdinInput {
+		return sniffNDJSON(br)
+	}
+	return isNDJSON(filename)
+}
+
+// ndjsonSniffLimit is how far into the stream sniffNDJSON looks for the first event's end
+const ndjsonSniffLimit = 1 << 20
+
+// sniffNDJSON reports whether the stream, peeked without consuming it, holds one event
+// per line: a JSON object, a newline, then another object
+func sniffNDJSON(br *bufio.Reader) bool {
+	buf, _ := br.Peek(ndjsonSniffLimit)
+	i := 0
+	for i < len(buf) && (buf[i] == ' ' || buf[i] == '\t' || buf[i] == '\r' || buf[i] == '\n') {
+		i++
+	}
+	if i >= len(buf) || buf[i] != '{' {
+		return false
+	}
+
+	// Find the end of the first object, skipping braces inside strings
+	depth, inString, escaped := 0, false, false
+	for ; i < len(buf); i++ {
+		c := buf[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	if depth != 0 {
+		return false
+	}
+
+	newline := false
+	for i++; i < len(buf); i++ {
+		switch buf[i] {
+		case '\n':
+			newline = true
+		case ' ', '\t', '\r':
+		default:
+			return newline && buf[i] == '{'
+		}
+	}
+	return false
+}
+
+// scanNDJSON reads newline-delimited trace events and calls callback for each kernel
+// Blank lines and lines that fail to decode (e.g. a truncated final line) are skipped
+func scanNDJSON(ctx context.Context, r io.Reader, progress *parseProgress, callback func(KernelEvent) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+
+	pairer := newAsyncPairer()
+	eventCount := 0
+	kernelCount := 0
+	position := 0 // Non-blank lines so far, malformed ones included
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		pos := position
+		position++
+		// Malformed elements count too, so a corrupt trace cannot slip past the limit
+		if MaxEvents > 0 && position > MaxEvents {
+			return fmt.Errorf("trace exceeds max events limit (%d)", MaxEvents)
+		}
+		if position%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		var event TraceEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			continue
+		}
+		eventCount++
+
+		if isKernelEvent(&event) {
+			kernelCount++
+			if !pairer.release(newKernelEvent(&event, pos), callback) {
+				return nil
+			}
+		} else if k, ok := pairer.add(&event, pos); ok {
+			kernelCount++
+			if !pairer.release(k, callback) {
+				return nil
+			}
+		}
+
+		if eventCount%500000 == 0 {
+			if pct := progress.percent(); pct >= 0 {
+				logf("\rProcessed %d events (%.0f%%), found %d kernels...", eventCount, pct, kernelCount)
+			} else {
+				logf("\rProcessed %d events, found %d kernels...", eventCount, kernelCount)
+			}
+		}
+	}
+
+	if eventCount > 500000 {
+		logf("\rProcessed %d events, found %d kernels. Done.\n", eventCount, kernelCount)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read line %d: %w", eventCount+1, err)
+	}
+	pairer.warnUnmatched()
+	pairer.flush(callback)
+	return nil
+}
+
+// ctxCheckInterval is how many trace events the streaming parsers read between
+// checks for context cancellation
+const ctxCheckInterval = 10000
+
 // ParseKernelEvents streams through a Perfetto JSON trace file and extracts kernel events
 // It uses streaming JSON parsing to handle large files efficiently
-// Supports both .json and .json.gz files
+// Supports .json, .json.gz and .json.zst files, as an object with traceEvents or a bare array,
+// and newline-delimited events in .ndjson/.jsonl files
 func ParseKernelEvents(filename string) ([]KernelEvent, error) {
-	file, err := os.Open(filename)
+	return ParseKernelEventsCtx(context.Background(), filename)
+}
+
+// ParseKernelEventsCtx is ParseKernelEvents with cancellation: it stops within a few
+// thousand events of ctx being done and returns an error wrapping ctx.Err()
+func ParseKernelEventsCtx(ctx context.Context, filename string) ([]KernelEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	file, size, err := openInput(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
-	var reader io.Reader
+	// Track bytes read from disk for a progress percentage (for gzip this is
+	// compressed bytes vs compressed size, which is a fair approximation)
+	progress := &parseProgress{counter: &countingReader{r: file}, total: size}
+
+	reader, closeReader, err := openDecompressed(progress.counter, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer closeReader()
+
+	br := skipBOM(reader)
 
-	// Check if gzipped
-	if strings.HasSuffix(filename, ".gz") {
-		gzReader, err := gzip.NewReader(file)
+	if isNDJSONInput(filename, br) {
+		var events []KernelEvent
+		err := scanNDJSON(ctx, br, progress, func(e KernelEvent) bool {
+			events = append(events, e)
+			return true
+		})
 		if err != nil {
-			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+			return nil, fmt.Errorf("failed to parse ndjson trace: %w", err)
 		}
-		defer gzReader.Close()
-		reader = bufio.NewReaderSize(gzReader, 64*1024*1024)
-	} else {
-		reader = bufio.NewReaderSize(file, 64*1024*1024) // 64MB buffer
+		if PairAsyncEvents {
+			SortEventsByTimestamp(events)
+		}
+		return events, nil
 	}
 
-	decoder := json.NewDecoder(reader)
+	decoder := json.NewDecoder(br)
+
+	// Bare-array form: the whole document is the events array
+	if isBareArray(br) {
+		events, err := parseTraceEventsArray(ctx, decoder, progress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trace events: %w", err)
+		}
+		return events, nil
+	}
 
 	// Find the start of the JSON object
 	token, err := decoder.Token()
@@ -84,7 +596,7 @@ func ParseKernelEvents(filename string) ([]KernelEvent, error) {
 
 		if key == "traceEvents" {
 			// Found the traceEvents array - stream through it
-			events, err := parseTraceEventsArray(decoder)
+			events, err := parseTraceEventsArray(ctx, decoder, progress)
 			if err != nil {
 				return nil, fmt.Errorf("failed to parse traceEvents: %w", err)
 			}
@@ -101,8 +613,34 @@ func ParseKernelEvents(filename string) ([]KernelEvent, error) {
 	return kernelEvents, nil
 }
 
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// parseProgress reports how far through the input file parsing has read
+type parseProgress struct {
+	counter *countingReader
+	total   int64 // File size in bytes (0 if unknown)
+}
+
+// percent returns the share of the file consumed so far, or -1 if unknown
+func (p *parseProgress) percent() float64 {
+	if p == nil || p.total <= 0 {
+		return -1
+	}
+	return math.Min(float64(p.counter.n)/float64(p.total)*100, 100)
+}
+
 // parseTraceEventsArray streams through the traceEvents array and extracts kernel events
-func parseTraceEventsArray(decoder *json.Decoder) ([]KernelEvent, error) {
+func parseTraceEventsArray(ctx context.Context, decoder *json.Decoder, progress *parseProgress) ([]KernelEvent, error) {
 	// Expect array start
 	token, err := decoder.Token()
 	if err != nil {
@@ -113,11 +651,24 @@ func parseTraceEventsArray(decoder *json.Decoder) ([]KernelEvent, error) {
 	}
 
 	var kernelEvents []KernelEvent
+	pairer := newAsyncPairer()
 	eventCount := 0
 	kernelCount := 0
+	position := 0 // Array elements so far, malformed ones included
 
 	// Stream through array elements
 	for decoder.More() {
+		pos := position
+		position++
+		// Malformed elements count too, so a corrupt trace cannot slip past the limit
+		if MaxEvents > 0 && position > MaxEvents {
+			return nil, fmt.Errorf("trace exceeds max events limit (%d)", MaxEvents)
+		}
+		if position%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
 		var event TraceEvent
 		if err := decoder.Decode(&event); err != nil {
 			// Skip malformed events
@@ -126,27 +677,26 @@ func parseTraceEventsArray(decoder *json.Decoder) ([]KernelEvent, error) {
 		eventCount++
 
 		// Filter for kernel events only
-		if event.Category == "kernel" && event.Phase == "X" {
-			kernelEvents = append(kernelEvents, KernelEvent{
-				Name:      event.Name,
-				Category:  event.Category,
-				Phase:     event.Phase,
-				Timestamp: event.Timestamp,
-				Duration:  event.Duration,
-				Pid:       event.Pid,
-				Tid:       event.Tid,
-			})
+		if isKernelEvent(&event) {
+			kernelEvents = append(kernelEvents, newKernelEvent(&event, pos))
+			kernelCount++
+		} else if k, ok := pairer.add(&event, pos); ok {
+			kernelEvents = append(kernelEvents, k)
 			kernelCount++
 		}
 
 		// Progress indicator for large files
 		if eventCount%500000 == 0 {
-			fmt.Fprintf(os.Stderr, "\rProcessed %d events, found %d kernels...", eventCount, kernelCount)
+			if pct := progress.percent(); pct >= 0 {
+				logf("\rProcessed %d events (%.0f%%), found %d kernels...", eventCount, pct, kernelCount)
+			} else {
+				logf("\rProcessed %d events, found %d kernels...", eventCount, kernelCount)
+			}
 		}
 	}
 
 	if eventCount > 500000 {
-		fmt.Fprintf(os.Stderr, "\rProcessed %d events, found %d kernels. Done.\n", eventCount, kernelCount)
+		logf("\rProcessed %d events, found %d kernels. Done.\n", eventCount, kernelCount)
 	}
 
 	// Read array end
@@ -155,34 +705,70 @@ func parseTraceEventsArray(decoder *json.Decoder) ([]KernelEvent, error) {
 		return nil, fmt.Errorf("failed to read array end: %w", err)
 	}
 
+	// Paired slices are emitted at their end; restore begin-time order
+	if pairer != nil {
+		pairer.warnUnmatched()
+		SortEventsByTimestamp(kernelEvents)
+	}
+
 	return kernelEvents, nil
 }
 
+// SortEventsByTimestamp orders events by start time, breaking ties between
+// identical timestamps by their original position in the trace
+func SortEventsByTimestamp(events []KernelEvent) {
+	sort.SliceStable(events, func(i, j int) bool { return eventBefore(events[i], events[j]) })
+}
+
+// TraceSpan returns the wall-clock extent of the events in µs, from the earliest
+// start to the latest end (ts+dur), without assuming the events are sorted
+func TraceSpan(events []KernelEvent) (start, end float64) {
+	if len(events) == 0 {
+		return 0, 0
+	}
+	start, end = events[0].Timestamp, events[0].Timestamp+events[0].Duration
+	for _, e := range events[1:] {
+		start = math.Min(start, e.Timestamp)
+		end = math.Max(end, e.Timestamp+e.Duration)
+	}
+	return start, end
+}
+
 // ParseKernelEventsWithCallback streams through the trace and calls callback for each kernel
 // This is more memory efficient for very large traces
-// Supports both .json and .json.gz files
+// Supports .json, .json.gz and .json.zst files, as an object with traceEvents or a bare array
 func ParseKernelEventsWithCallback(filename string, callback func(KernelEvent) bool) error {
-	file, err := os.Open(filename)
+	return ParseKernelEventsWithCallbackCtx(context.Background(), filename, callback)
+}
+
+// ParseKernelEventsWithCallbackCtx is ParseKernelEventsWithCallback with cancellation,
+// returning ctx.Err() within a few thousand events of ctx being done
+func ParseKernelEventsWithCallbackCtx(ctx context.Context, filename string, callback func(KernelEvent) bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	file, _, err := openInput(filename)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
-	var reader io.Reader
+	reader, closeReader, err := openDecompressed(file, filename)
+	if err != nil {
+		return err
+	}
+	defer closeReader()
 
-	// Check if gzipped
-	if strings.HasSuffix(filename, ".gz") {
-		gzReader, err := gzip.NewReader(file)
-		if err != nil {
-			return fmt.Errorf("failed to create gzip reader: %w", err)
-		}
-		defer gzReader.Close()
-		reader = bufio.NewReaderSize(gzReader, 64*1024*1024)
-	} else {
-		reader = bufio.NewReaderSize(file, 64*1024*1024)
+	br := skipBOM(reader)
+	if isNDJSONInput(filename, br) {
+		return scanNDJSON(ctx, br, nil, callback)
 	}
 
-	decoder := json.NewDecoder(reader)
+	decoder := json.NewDecoder(br)
+
+	if isBareArray(br) {
+		return streamTraceEvents(ctx, decoder, callback)
+	}
 
 	// Find the start of the JSON object
 	token, err := decoder.Token()
@@ -206,7 +792,7 @@ func ParseKernelEventsWithCallback(filename string, callback func(KernelEvent) b
 		}
 
 		if key == "traceEvents" {
-			return streamTraceEvents(decoder, callback)
+			return streamTraceEvents(ctx, decoder, callback)
 		} else {
 			var skip json.RawMessage
 			if err := decoder.Decode(&skip); err != nil {
@@ -218,7 +804,7 @@ func ParseKernelEventsWithCallback(filename string, callback func(KernelEvent) b
 	return nil
 }
 
-func streamTraceEvents(decoder *json.Decoder, callback func(KernelEvent) bool) error {
+func streamTraceEvents(ctx context.Context, decoder *json.Decoder, callback func(KernelEvent) bool) error {
 	token, err := decoder.Token()
 	if err != nil {
 		return fmt.Errorf("failed to read array start: %w", err)
@@ -227,34 +813,57 @@ func streamTraceEvents(decoder *json.Decoder, callback func(KernelEvent) bool) e
 		return fmt.Errorf("expected array start, got %v", token)
 	}
 
+	pairer := newAsyncPairer()
+	position := 0 // Array elements so far, malformed ones included
 	for decoder.More() {
+		pos := position
+		position++
+		// Malformed elements count too, so a corrupt trace cannot slip past the limit
+		if MaxEvents > 0 && position > MaxEvents {
+			return fmt.Errorf("trace exceeds max events limit (%d)", MaxEvents)
+		}
+		if position%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
 		var event TraceEvent
 		if err := decoder.Decode(&event); err != nil {
 			continue
 		}
 
-		if event.Category == "kernel" && event.Phase == "X" {
-			shouldContinue := callback(KernelEvent{
-				Name:      event.Name,
-				Category:  event.Category,
-				Phase:     event.Phase,
-				Timestamp: event.Timestamp,
-				Duration:  event.Duration,
-				Pid:       event.Pid,
-				Tid:       event.Tid,
-			})
-			if !shouldContinue {
+		if isKernelEvent(&event) {
+			if !pairer.release(newKernelEvent(&event, pos), callback) {
+				return nil
+			}
+		} else if k, ok := pairer.add(&event, pos); ok {
+			if !pairer.release(k, callback) {
 				return nil
 			}
 		}
 	}
 
+	pairer.warnUnmatched()
+	pairer.flush(callback)
 	return nil
 }
 
-// ParseWithEarlyStop streams through the trace and stops parsing once a cycle is detected
+// DefaultEarlyStopReps is how many repetitions ParseWithEarlyStop waits for by default
+const DefaultEarlyStopReps = 10
+
+// EarlyStopReps is how many repetitions of a cycle ParseWithEarlyStop must see before
+// it stops reading (0 = never stop early). Stopping early is faster but the sampled
+// repetitions may not be representative, so min/max/stddev are less accurate
+var EarlyStopReps = DefaultEarlyStopReps
+
+// ParseWithEarlyStop streams through the trace and stops parsing once a cycle with
+// EarlyStopReps repetitions is detected
 // This is more efficient for large traces with repeating patterns
 func ParseWithEarlyStop(filename string, minCycle, maxCycle int) ([]KernelEvent, error) {
+	if EarlyStopReps <= 0 {
+		return ParseKernelEvents(filename)
+	}
+
 	var events []KernelEvent
 	kernelCount := 0
 	checkInterval := 10000 // Check for cycles every N kernels
@@ -266,16 +875,16 @@ func ParseWithEarlyStop(filename string, minCycle, maxCycle int) ([]KernelEvent,
 
 		// Progress indicator
 		if kernelCount%50000 == 0 {
-			fmt.Fprintf(os.Stderr, "\rCollected %d kernels, checking for cycles...", kernelCount)
+			logf("\rCollected %d kernels, checking for cycles...", kernelCount)
 		}
 
 		// Periodically check if we've found a cycle
 		if kernelCount >= minEventsForDetection && kernelCount%checkInterval == 0 {
 			// Try to detect a cycle in what we have so far
-			cycleInfo := tryEarlyDetection(events, minCycle, min(maxCycle, len(events)/3))
-			if cycleInfo != nil && cycleInfo.NumCycles >= 10 {
-				// Found a confident cycle with 10+ reps (skip warmup patterns), we can stop
-				fmt.Fprintf(os.Stderr, "\rEarly stop: detected cycle of length %d with %d repetitions (at %d kernels)\n",
+			cycleInfo := detector().DetectCycleQuick(events, minCycle, min(maxCycle, len(events)/3))
+			if cycleInfo != nil && cycleInfo.NumCycles >= EarlyStopReps {
+				// Found a confident cycle with enough reps (skip warmup patterns), we can stop
+				logf("\rEarly stop: detected cycle of length %d with %d repetitions (at %d kernels)\n",
 					cycleInfo.CycleLength, cycleInfo.NumCycles, kernelCount)
 				return false // Stop parsing
 			}
@@ -289,101 +898,8 @@ func ParseWithEarlyStop(filename string, minCycle, maxCycle int) ([]KernelEvent,
 	}
 
 	if kernelCount > 50000 {
-		fmt.Fprintf(os.Stderr, "\rCollected %d kernels. Done.\n", kernelCount)
+		logf("\rCollected %d kernels. Done.\n", kernelCount)
 	}
 
 	return events, nil
 }
-
-// tryEarlyDetection attempts a quick cycle detection for early stopping
-func tryEarlyDetection(events []KernelEvent, minCycle, maxCycle int) *CycleInfo {
-	if len(events) < minCycle*3 {
-		return nil
-	}
-
-	// Use a fast heuristic: look for frequently repeating kernels at regular intervals
-	counts := make(map[string][]int)
-	for i, e := range events {
-		counts[e.Name] = append(counts[e.Name], i)
-	}
-
-	// Find the most promising anchor (appears at regular intervals)
-	for _, positions := range counts {
-		if len(positions) < 5 {
-			continue
-		}
-
-		// Check if positions are evenly spaced
-		gaps := make([]int, len(positions)-1)
-		for i := 1; i < len(positions); i++ {
-			gaps[i-1] = positions[i] - positions[i-1]
-		}
-
-		// Find the most common gap
-		gapCounts := make(map[int]int)
-		for _, gap := range gaps {
-			if gap >= minCycle && gap <= maxCycle {
-				gapCounts[gap]++
-			}
-		}
-
-		for gap, count := range gapCounts {
-			if count >= 4 { // At least 4 consistent repetitions
-				// Verify this is a real cycle
-				info := verifyCycleQuick(events, gap, positions[0])
-				if info != nil && info.NumCycles >= 5 {
-					return info
-				}
-			}
-		}
-	}
-
-	return nil
-}
-
-// verifyCycleQuick does a quick cycle verification for early stopping
-func verifyCycleQuick(events []KernelEvent, cycleLen, startIdx int) *CycleInfo {
-	if startIdx+cycleLen*3 > len(events) {
-		return nil
-	}
-
-	// Hash the first cycle
-	firstCycleHashes := make([]uint64, cycleLen)
-	for i := 0; i < cycleLen && startIdx+i < len(events); i++ {
-		firstCycleHashes[i] = hashString(events[startIdx+i].Name)
-	}
-
-	// Check how many cycles match
-	matches := 1
-	cycleIndices := []int{startIdx}
-	
-	for pos := startIdx + cycleLen; pos+cycleLen <= len(events); pos += cycleLen {
-		matchCount := 0
-		for i := 0; i < cycleLen; i++ {
-			h := hashString(events[pos+i].Name)
-			if h == firstCycleHashes[i] {
-				matchCount++
-			}
-		}
-
-		// Require 90% match for early detection
-		if float64(matchCount)/float64(cycleLen) >= 0.90 {
-			matches++
-			cycleIndices = append(cycleIndices, pos)
-		} else {
-			break
-		}
-	}
-
-	if matches >= 5 {
-		return &CycleInfo{
-			StartIndex:   startIdx,
-			CycleLength:  cycleLen,
-			NumCycles:    matches,
-			CycleIndices: cycleIndices,
-		}
-	}
-
-	return nil
-}
-
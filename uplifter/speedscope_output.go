@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// speedscopeSchema identifies the file format to https://www.speedscope.app
+const speedscopeSchema = "https://www.speedscope.app/file-format-schema.json"
+
+type speedscopeFile struct {
+	Schema             string              `json:"$schema"`
+	Shared             speedscopeShared    `json:"shared"`
+	Profiles           []speedscopeProfile `json:"profiles"`
+	Name               string              `json:"name"`
+	ActiveProfileIndex int                 `json:"activeProfileIndex"`
+	Exporter           string              `json:"exporter"`
+}
+
+type speedscopeShared struct {
+	Frames []speedscopeFrame `json:"frames"`
+}
+
+type speedscopeFrame struct {
+	Name string `json:"name"`
+}
+
+type speedscopeProfile struct {
+	Type       string            `json:"type"`
+	Name       string            `json:"name"`
+	Unit       string            `json:"unit"`
+	StartValue float64           `json:"startValue"`
+	EndValue   float64           `json:"endValue"`
+	Events     []speedscopeEvent `json:"events"`
+}
+
+type speedscopeEvent struct {
+	Type  string  `json:"type"` // "O" (open) or "C" (close)
+	Frame int     `json:"frame"`
+	At    float64 `json:"at"`
+}
+
+// WriteSpeedscope writes the cycle as a speedscope "evented" profile: the kernels in
+// execution order, each open for its average duration and separated by its average
+// idle gap, so one repetition can be zoomed through like a timeline. Kernels sharing
+// a name share a frame, which speedscope's left-heavy view then aggregates
+func (r *CycleResult) WriteSpeedscope(w io.Writer) error {
+	var frames []speedscopeFrame
+	frameIndex := make(map[string]int)
+	events := make([]speedscopeEvent, 0, 2*len(r.Kernels))
+	at := 0.0
+	for i, k := range r.Kernels {
+		frame, ok := frameIndex[k.Name]
+		if !ok {
+			frame = len(frames)
+			frameIndex[k.Name] = frame
+			frames = append(frames, speedscopeFrame{Name: k.Name})
+		}
+		if i > 0 {
+			at += k.IdleBefore
+		}
+		events = append(events, speedscopeEvent{Type: "O", Frame: frame, At: at})
+		at += k.AvgDur
+		events = append(events, speedscopeEvent{Type: "C", Frame: frame, At: at})
+	}
+
+	name := fmt.Sprintf("Cycle of %d kernels (%d repetitions)", r.CycleLength, r.NumCycles)
+	file := speedscopeFile{
+		Schema: speedscopeSchema,
+		Shared: speedscopeShared{Frames: frames},
+		Profiles: []speedscopeProfile{{
+			Type:     "evented",
+			Name:     name,
+			Unit:     "microseconds",
+			EndValue: at,
+			Events:   events,
+		}},
+		Name:     name,
+		Exporter: "uplifter",
+	}
+	return json.NewEncoder(w).Encode(file)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+
+	"uplifter/detect"
+)
+
+// StreamExtractCycle extracts one cycle without holding the whole trace in memory.
+// Pass 1 reads only as far as the early-stop heuristic needs to find the cycle length
+// and start offset; pass 2 re-reads the trace and feeds each repetition to the same
+// accumulator as ExtractCycle, keeping just one repetition of events at a time.
+// Repetitions continue until one no longer matches the first (or the trace ends),
+// so the result equals ExtractCycle on the run VerifyCycleQuick finds in the full trace
+func StreamExtractCycle(filename string, minCycle, maxCycle int) (*CycleResult, error) {
+	if filename == StdinInput {
+		return nil, fmt.Errorf("streaming extraction reads the trace twice and cannot use stdin")
+	}
+
+	// Pass 1: detect the period and start offset from a prefix of the trace
+	prefix, err := ParseWithEarlyStop(filename, minCycle, maxCycle)
+	if err != nil {
+		return nil, fmt.Errorf("detecting cycle: %w", err)
+	}
+	if len(prefix) == 0 {
+		return nil, ErrNoKernelEvents
+	}
+	d := detector()
+	info := d.DetectCycleQuick(prefix, minCycle, min(maxCycle, len(prefix)/3))
+	if info == nil {
+		return nil, ErrNoCyclePatterns
+	}
+	cycleLength, start := info.CycleLength, info.StartIndex
+	repetitionMatches := d.RepetitionMatcher(prefix[start : start+cycleLength])
+	warmup := detect.WarmupKernels(prefix, start)
+	prefix = nil
+
+	// Pass 2: window holds the event before the current repetition (if any), the
+	// repetition itself and, once it is full, the event after it
+	acc := newCycleAccumulator(cycleLength, info.NumCycles)
+	acc.result.StartIndex = start
+	acc.result.WarmupEvents = start
+	acc.result.WarmupKernels = warmup
+	window := make([]KernelEvent, 0, cycleLength+2)
+	offset := 0
+	if start > 0 {
+		offset = 1
+	}
+	index := 0
+	repetitions := 0
+	stopped := false
+	err = ParseKernelEventsWithCallback(filename, func(event KernelEvent) bool {
+		idx := index
+		index++
+		if idx < start-1 {
+			return true
+		}
+		window = append(window, event)
+		if idx < start {
+			return true
+		}
+		n := len(window) - offset
+		if n == cycleLength && !repetitionMatches(window[offset:]) {
+			stopped = true
+			return false
+		}
+		if n <= cycleLength {
+			return true
+		}
+		acc.addRepetition(window, offset, cycleLength)
+		repetitions++
+		// Keep the last kernel of this repetition and the first of the next
+		window = append(window[:0], window[len(window)-2:]...)
+		offset = 1
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("extracting cycle: %w", err)
+	}
+	if !stopped && len(window)-offset == cycleLength && repetitionMatches(window[offset:]) {
+		acc.addRepetition(window, offset, cycleLength)
+		repetitions++
+	}
+	if repetitions == 0 {
+		return nil, ErrNoCyclePatterns
+	}
+
+	acc.result.NumCycles = repetitions
+	acc.result.EndIndex = start + repetitions*cycleLength
+	return acc.finish(), nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"uplifter/detect"
+)
+
+// vendorRule maps kernel names matching pattern to a vendor-neutral canonical name
+type vendorRule struct {
+	pattern   *regexp.Regexp
+	canonical string
+}
+
+// VendorMap normalizes kernel names before signature comparison so equivalent
+// CUDA and ROCm kernels align (empty = off)
+var VendorMap []vendorRule
+
+// builtinVendorMap covers common cuBLAS/cuDNN vs hipBLASLt/rocBLAS/MIOpen names,
+// as canonical=regex lines in the -vendor-map file format
+const builtinVendorMap = `
+gemm=^(ampere|volta|turing|sm\d+|hopper)_.*gemm
+gemm=(?i)cutlass.*gemm|gemm.*cutlass
+gemm=^Cijk_
+gemm=(?i)^(void )?(ck::)?.*device_?gemm
+conv=(?i)^(implicit_convolve|.*cudnn.*conv|miopen.*conv|naive_conv)
+softmax=(?i)softmax
+layernorm=(?i)layer_?norm
+rmsnorm=(?i)rms_?norm
+flash_attention=(?i)(flash_fwd|fmha_fwd|flash_attn)
+`
+
+// loadVendorMap reads canonical=regex rules, one per line (blank lines and # comments
+// are skipped), from path, or returns the built-in CUDA/ROCm table for "builtin"
+func loadVendorMap(path string) ([]vendorRule, error) {
+	text := builtinVendorMap
+	if path != "builtin" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vendor map: %w", err)
+		}
+		text = string(data)
+	}
+
+	var rules []vendorRule
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		canonical, pattern, ok := strings.Cut(line, "=")
+		if !ok || canonical == "" || pattern == "" {
+			return nil, fmt.Errorf("vendor map line %d: expected canonical=regex, got %q", lineNum, line)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("vendor map line %d: %w", lineNum, err)
+		}
+		rules = append(rules, vendorRule{pattern: re, canonical: strings.TrimSpace(canonical)})
+	}
+	return rules, nil
+}
+
+// normalizeVendorName returns the canonical name of the first VendorMap rule matching
+// name, or name unchanged
+func normalizeVendorName(name string) string {
+	for _, rule := range VendorMap {
+		if rule.pattern.MatchString(name) {
+			return rule.canonical
+		}
+	}
+	return name
+}
+
+// matchSignature is the signature kernels are compared on across traces: the
+// kernel signature after vendor normalization
+func matchSignature(name string) string {
+	return detect.KernelSignature(normalizeVendorName(name))
+}
@@ -2,6 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/xuri/excelize/v2"
 )
@@ -13,49 +17,113 @@ type xlsxStyles struct {
 	similar   int
 	removed   int
 	newOnly   int
+	moved     int
 	improved  int
 	regressed int
 	neutral   int
 }
 
+// defaultXLSXColors is the built-in fill color of each XLSX style, by style name
+var defaultXLSXColors = map[string]string{
+	"header":    "#4472C4",
+	"exact":     "#E2EFDA",
+	"similar":   "#DDEBF7",
+	"removed":   "#FFC7CE",
+	"new_only":  "#FFEB9C",
+	"moved":     "#E4DFEC",
+	"improved":  "#00B050",
+	"regressed": "#FF0000",
+	"neutral":   "#FFC000",
+}
+
+// XLSXColors overrides defaultXLSXColors by style name, e.g. to match a team's house
+// style (set with -colors)
+var XLSXColors = map[string]string{}
+
+// hexColor matches an RRGGBB color, with or without a leading #
+var hexColor = regexp.MustCompile(`^#?[0-9A-Fa-f]{6}$`)
+
+// xlsxColor returns the active fill color of a style: its XLSXColors override, if
+// any, else the default
+func xlsxColor(name string) string {
+	if c, ok := XLSXColors[name]; ok {
+		return c
+	}
+	return defaultXLSXColors[name]
+}
+
+// parseColorOverrides parses "improved=#1B9E77,regressed=D95F02" into style name ->
+// #RRGGBB, rejecting unknown style names and malformed colors
+func parseColorOverrides(spec string) (map[string]string, error) {
+	colors := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, color, ok := strings.Cut(entry, "=")
+		name, color = strings.TrimSpace(name), strings.TrimSpace(color)
+		if !ok {
+			return nil, fmt.Errorf("expected style=#RRGGBB, got %q", entry)
+		}
+		if _, known := defaultXLSXColors[name]; !known {
+			names := make([]string, 0, len(defaultXLSXColors))
+			for n := range defaultXLSXColors {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown style %q (want one of %s)", name, strings.Join(names, ", "))
+		}
+		if !hexColor.MatchString(color) {
+			return nil, fmt.Errorf("invalid color %q for %s (want #RRGGBB)", color, name)
+		}
+		colors[name] = "#" + strings.ToUpper(strings.TrimPrefix(color, "#"))
+	}
+	return colors, nil
+}
+
 // createStyles creates all styles for the XLSX file
 func createStyles(f *excelize.File) xlsxStyles {
 	headerStyle, _ := f.NewStyle(&excelize.Style{
 		Font:      &excelize.Font{Bold: true, Size: 11, Color: "#FFFFFF"},
-		Fill:      excelize.Fill{Type: "pattern", Color: []string{"#4472C4"}, Pattern: 1},
+		Fill:      excelize.Fill{Type: "pattern", Color: []string{xlsxColor("header")}, Pattern: 1},
 		Alignment: &excelize.Alignment{Horizontal: "center", Vertical: "center"},
 	})
 
 	exactStyle, _ := f.NewStyle(&excelize.Style{
-		Fill: excelize.Fill{Type: "pattern", Color: []string{"#E2EFDA"}, Pattern: 1},
+		Fill: excelize.Fill{Type: "pattern", Color: []string{xlsxColor("exact")}, Pattern: 1},
 	})
 
 	similarStyle, _ := f.NewStyle(&excelize.Style{
-		Fill: excelize.Fill{Type: "pattern", Color: []string{"#DDEBF7"}, Pattern: 1},
+		Fill: excelize.Fill{Type: "pattern", Color: []string{xlsxColor("similar")}, Pattern: 1},
 	})
 
 	removedStyle, _ := f.NewStyle(&excelize.Style{
-		Fill: excelize.Fill{Type: "pattern", Color: []string{"#FFC7CE"}, Pattern: 1},
+		Fill: excelize.Fill{Type: "pattern", Color: []string{xlsxColor("removed")}, Pattern: 1},
 	})
 
 	newOnlyStyle, _ := f.NewStyle(&excelize.Style{
-		Fill: excelize.Fill{Type: "pattern", Color: []string{"#FFEB9C"}, Pattern: 1},
+		Fill: excelize.Fill{Type: "pattern", Color: []string{xlsxColor("new_only")}, Pattern: 1},
+	})
+
+	movedStyle, _ := f.NewStyle(&excelize.Style{
+		Fill: excelize.Fill{Type: "pattern", Color: []string{xlsxColor("moved")}, Pattern: 1},
 	})
 
 	improvedStyle, _ := f.NewStyle(&excelize.Style{
-		Fill:      excelize.Fill{Type: "pattern", Color: []string{"#00B050"}, Pattern: 1},
+		Fill:      excelize.Fill{Type: "pattern", Color: []string{xlsxColor("improved")}, Pattern: 1},
 		Font:      &excelize.Font{Bold: true, Color: "#FFFFFF"},
 		Alignment: &excelize.Alignment{Horizontal: "center"},
 	})
 
 	regressedStyle, _ := f.NewStyle(&excelize.Style{
-		Fill:      excelize.Fill{Type: "pattern", Color: []string{"#FF0000"}, Pattern: 1},
+		Fill:      excelize.Fill{Type: "pattern", Color: []string{xlsxColor("regressed")}, Pattern: 1},
 		Font:      &excelize.Font{Bold: true, Color: "#FFFFFF"},
 		Alignment: &excelize.Alignment{Horizontal: "center"},
 	})
 
 	neutralStyle, _ := f.NewStyle(&excelize.Style{
-		Fill:      excelize.Fill{Type: "pattern", Color: []string{"#FFC000"}, Pattern: 1},
+		Fill:      excelize.Fill{Type: "pattern", Color: []string{xlsxColor("neutral")}, Pattern: 1},
 		Font:      &excelize.Font{Bold: true},
 		Alignment: &excelize.Alignment{Horizontal: "center"},
 	})
@@ -66,12 +134,105 @@ func createStyles(f *excelize.File) xlsxStyles {
 		similar:   similarStyle,
 		removed:   removedStyle,
 		newOnly:   newOnlyStyle,
+		moved:     movedStyle,
 		improved:  improvedStyle,
 		regressed: regressedStyle,
 		neutral:   neutralStyle,
 	}
 }
 
+// legendSheet is the name of the sheet explaining the XLSX colors and thresholds
+const legendSheet = "Legend"
+
+// writeLegendSheet adds a sheet describing what each fill color means, with the
+// colors and improved/regressed thresholds in effect for this workbook; noiseSigmas is
+// the noise band its change cells were colored with (0 = ChangeThreshold only)
+func writeLegendSheet(f *excelize.File, styles xlsxStyles, noiseSigmas float64) error {
+	if _, err := f.NewSheet(legendSheet); err != nil {
+		return fmt.Errorf("failed to create sheet %s: %w", legendSheet, err)
+	}
+
+	noise := ""
+	if noiseSigmas > 0 {
+		noise = fmt.Sprintf(" and by more than %g combined stddevs", noiseSigmas)
+	}
+	entries := []struct {
+		name    string
+		style   int
+		meaning string
+	}{
+		{"exact", styles.exact, "Row: identical kernel names"},
+		{"similar", styles.similar, "Row: same kernel signature, different name (also fuzzy, split and fused rows)"},
+		{"moved", styles.moved, "Row: the same kernel at a different position"},
+		{"new_only", styles.newOnly, "Row: kernel only in the new trace"},
+		{"removed", styles.removed, "Row: kernel only in the baseline"},
+		{"improved", styles.improved, fmt.Sprintf("Change (%%): faster by more than %g%%%s; also REMOVED", ChangeThreshold, noise)},
+		{"regressed", styles.regressed, fmt.Sprintf("Change (%%): slower by more than %g%%%s", ChangeThreshold, noise)},
+		{"neutral", styles.neutral, fmt.Sprintf("Change (%%): within ±%g%% or the noise band; also NEW and SPLIT", ChangeThreshold)},
+	}
+
+	for i, h := range []string{"Style", "Color", "Meaning"} {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		f.SetCellValue(legendSheet, cell, h)
+		f.SetCellStyle(legendSheet, cell, cell, styles.header)
+	}
+	row := 2
+	for _, e := range entries {
+		f.SetCellValue(legendSheet, fmt.Sprintf("A%d", row), e.name)
+		colorCell := fmt.Sprintf("B%d", row)
+		f.SetCellValue(legendSheet, colorCell, xlsxColor(e.name))
+		f.SetCellStyle(legendSheet, colorCell, colorCell, e.style)
+		f.SetCellValue(legendSheet, fmt.Sprintf("C%d", row), e.meaning)
+		row++
+	}
+
+	row++
+	f.SetCellValue(legendSheet, fmt.Sprintf("A%d", row), "Change threshold (%)")
+	f.SetCellValue(legendSheet, fmt.Sprintf("B%d", row), ChangeThreshold)
+	row++
+	f.SetCellValue(legendSheet, fmt.Sprintf("A%d", row), "Noise band (stddevs)")
+	f.SetCellValue(legendSheet, fmt.Sprintf("B%d", row), noiseSigmas)
+
+	f.SetColWidth(legendSheet, "A", "A", 22)
+	f.SetColWidth(legendSheet, "B", "B", 12)
+	f.SetColWidth(legendSheet, "C", "C", 80)
+	return nil
+}
+
+// ChangeThreshold is the |change| (%) a kernel must exceed to count as improved or
+// regressed in the XLSX, Markdown and JSON outputs
+var ChangeThreshold = 5.0
+
+// NoiseSigmas is how many combined standard deviations a kernel's change must exceed
+// to be colored improved/regressed rather than neutral (0 = ChangeThreshold only)
+var NoiseSigmas = 2.0
+
+// classifyChange returns "improved", "regressed" or "neutral" for a timed match using
+// ChangeThreshold and the noise band, or "" when either side lacks timing
+func classifyChange(m KernelMatch) string {
+	change, ok := changePercent(m)
+	switch {
+	case !ok:
+		return ""
+	case change < -ChangeThreshold && exceedsNoise(m):
+		return "improved"
+	case change > ChangeThreshold && exceedsNoise(m):
+		return "regressed"
+	default:
+		return "neutral"
+	}
+}
+
+// exceedsNoise reports whether the duration change is larger than NoiseSigmas times
+// the combined stddev of both sides; kernels without stddev data always pass
+func exceedsNoise(m KernelMatch) bool {
+	combined := math.Sqrt(m.EagerStdDev*m.EagerStdDev + m.CompiledStdDev*m.CompiledStdDev)
+	if NoiseSigmas <= 0 || combined == 0 {
+		return true
+	}
+	return math.Abs(m.CompiledDur-m.EagerDur) > NoiseSigmas*combined
+}
+
 // writeComparisonToSheet writes a comparison result to a specific sheet
 func writeComparisonToSheet(f *excelize.File, sheetName string, r *CompareResult, styles xlsxStyles) error {
 	// Write headers
@@ -80,6 +241,20 @@ func writeComparisonToSheet(f *excelize.File, sheetName string, r *CompareResult
 		"New Kernel", "New Avg (µs)", "New Min", "New Max", "New StdDev",
 		"Change (%)", "Match Type",
 	}
+	lastCol := "L"
+	if EmitCV {
+		headers = append(headers, "Base CV (%)", "New CV (%)")
+		lastCol = "N"
+	}
+	shareCol := ""
+	if EmitDeltaShare {
+		headers = append(headers, "Share of Δ (%)")
+		shareCol, _ = excelize.ColumnNumberToName(len(headers))
+		lastCol = shareCol
+	}
+	headers = append(headers, "Category")
+	categoryCol, _ := excelize.ColumnNumberToName(len(headers))
+	lastCol = categoryCol
 	for i, h := range headers {
 		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
 		f.SetCellValue(sheetName, cell, h)
@@ -93,8 +268,21 @@ func writeComparisonToSheet(f *excelize.File, sheetName string, r *CompareResult
 	f.SetColWidth(sheetName, "G", "J", 12)
 	f.SetColWidth(sheetName, "K", "K", 12)
 	f.SetColWidth(sheetName, "L", "L", 15)
+	if EmitCV {
+		f.SetColWidth(sheetName, "M", "N", 12)
+	}
+	if shareCol != "" {
+		f.SetColWidth(sheetName, shareCol, shareCol, 14)
+	}
+	f.SetColWidth(sheetName, categoryCol, categoryCol, 18)
 
-	// Write summary row with cycle stats
+	// Write summary row with cycle stats and the memory/compute split of each side
+	baselineTotal, newTotal := 0.0, 0.0
+	for _, m := range r.Matches {
+		baselineTotal += m.EagerDur
+		newTotal += m.CompiledDur
+	}
+	baselineMemory, newMemory := r.memoryTimes()
 	baselineInfo := fmt.Sprintf("Baseline: %d kernels", r.EagerCycle)
 	if r.BaselineIters > 0 {
 		baselineInfo += fmt.Sprintf(" × %d iters", r.BaselineIters)
@@ -102,6 +290,7 @@ func writeComparisonToSheet(f *excelize.File, sheetName string, r *CompareResult
 	if r.BaselineCycleTime > 0 {
 		baselineInfo += fmt.Sprintf(", %.1f µs/cycle", r.BaselineCycleTime)
 	}
+	baselineInfo += memorySplitLabel(baselineMemory, baselineTotal)
 	f.SetCellValue(sheetName, "A2", baselineInfo)
 
 	newInfo := fmt.Sprintf("New: %d kernels", r.CompiledCycle)
@@ -111,6 +300,7 @@ func writeComparisonToSheet(f *excelize.File, sheetName string, r *CompareResult
 	if r.NewCycleTime > 0 {
 		newInfo += fmt.Sprintf(", %.1f µs/cycle", r.NewCycleTime)
 	}
+	newInfo += memorySplitLabel(newMemory, newTotal)
 	f.SetCellValue(sheetName, "F2", newInfo)
 	f.SetCellValue(sheetName, "G2", r.TotalTime)
 
@@ -118,9 +308,9 @@ func writeComparisonToSheet(f *excelize.File, sheetName string, r *CompareResult
 	if r.BaselineCycleTime > 0 && r.NewCycleTime > 0 {
 		changePercent := ((r.NewCycleTime - r.BaselineCycleTime) / r.BaselineCycleTime) * 100
 		f.SetCellValue(sheetName, "K2", changePercent)
-		if changePercent < -5 {
+		if changePercent < -ChangeThreshold {
 			f.SetCellStyle(sheetName, "K2", "K2", styles.improved)
-		} else if changePercent > 5 {
+		} else if changePercent > ChangeThreshold {
 			f.SetCellStyle(sheetName, "K2", "K2", styles.regressed)
 		} else {
 			f.SetCellStyle(sheetName, "K2", "K2", styles.neutral)
@@ -129,13 +319,15 @@ func writeComparisonToSheet(f *excelize.File, sheetName string, r *CompareResult
 
 	// Write data rows
 	row := 3
-	for _, m := range r.Matches {
+	totalDelta := r.totalDelta()
+	shown, hidden := r.outputMatches()
+	for _, m := range shown {
 		baselineStr := "(none)"
 		if len(m.EagerKernels) > 0 && m.EagerKernels[0] != "(none)" {
-			baselineStr = m.EagerKernels[0]
+			baselineStr = displayName(m.EagerKernels[0])
 		}
 
-		newStr := m.CompiledKernel
+		newStr := displayName(m.CompiledKernel)
 
 		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), baselineStr)
 
@@ -157,15 +349,15 @@ func writeComparisonToSheet(f *excelize.File, sheetName string, r *CompareResult
 
 		// Column K: Change (%)
 		changeCell := fmt.Sprintf("K%d", row)
-		if m.EagerDur > 0 && m.CompiledDur > 0 {
-			changePercent := ((m.CompiledDur - m.EagerDur) / m.EagerDur) * 100
+		if changePercent, ok := changePercent(m); ok {
 			f.SetCellValue(sheetName, changeCell, changePercent)
 
-			if changePercent < -5 {
+			switch classifyChange(m) {
+			case "improved":
 				f.SetCellStyle(sheetName, changeCell, changeCell, styles.improved)
-			} else if changePercent > 5 {
+			case "regressed":
 				f.SetCellStyle(sheetName, changeCell, changeCell, styles.regressed)
-			} else {
+			default:
 				f.SetCellStyle(sheetName, changeCell, changeCell, styles.neutral)
 			}
 		} else if m.MatchType == "new_only" {
@@ -174,21 +366,41 @@ func writeComparisonToSheet(f *excelize.File, sheetName string, r *CompareResult
 		} else if m.MatchType == "removed" {
 			f.SetCellValue(sheetName, changeCell, "REMOVED")
 			f.SetCellStyle(sheetName, changeCell, changeCell, styles.improved)
+		} else if m.MatchType == "split" {
+			f.SetCellValue(sheetName, changeCell, "SPLIT")
+			f.SetCellStyle(sheetName, changeCell, changeCell, styles.neutral)
 		}
 
-		f.SetCellValue(sheetName, fmt.Sprintf("L%d", row), m.MatchType)
+		f.SetCellValue(sheetName, fmt.Sprintf("L%d", row), matchTypeLabel(m))
+
+		if EmitCV {
+			baseCV, newCV := cvPercents(m)
+			if baseCV != nil {
+				f.SetCellValue(sheetName, fmt.Sprintf("M%d", row), *baseCV)
+			}
+			if newCV != nil {
+				f.SetCellValue(sheetName, fmt.Sprintf("N%d", row), *newCV)
+			}
+		}
+		if shareCol != "" && totalDelta != 0 {
+			f.SetCellValue(sheetName, fmt.Sprintf("%s%d", shareCol, row), deltaShare(m, totalDelta))
+		}
+		f.SetCellValue(sheetName, fmt.Sprintf("%s%d", categoryCol, row), matchCategory(m))
 
 		// Apply row style
 		switch m.MatchType {
 		case "exact":
 			f.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("J%d", row), styles.exact)
 			f.SetCellStyle(sheetName, fmt.Sprintf("L%d", row), fmt.Sprintf("L%d", row), styles.exact)
-		case "similar":
+		case "similar", "fuzzy", "split", "split_part", "fused":
 			f.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("J%d", row), styles.similar)
 			f.SetCellStyle(sheetName, fmt.Sprintf("L%d", row), fmt.Sprintf("L%d", row), styles.similar)
 		case "removed":
 			f.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("J%d", row), styles.removed)
 			f.SetCellStyle(sheetName, fmt.Sprintf("L%d", row), fmt.Sprintf("L%d", row), styles.removed)
+		case "moved":
+			f.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("J%d", row), styles.moved)
+			f.SetCellStyle(sheetName, fmt.Sprintf("L%d", row), fmt.Sprintf("L%d", row), styles.moved)
 		case "new_only":
 			f.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("J%d", row), styles.newOnly)
 			f.SetCellStyle(sheetName, fmt.Sprintf("L%d", row), fmt.Sprintf("L%d", row), styles.newOnly)
@@ -196,17 +408,25 @@ func writeComparisonToSheet(f *excelize.File, sheetName string, r *CompareResult
 
 		row++
 
+		extraType, extraStyle := "removed", styles.removed
+		if m.MatchType == "fused" {
+			extraType, extraStyle = "fused", styles.similar
+		}
 		for i := 1; i < len(m.EagerKernels); i++ {
-			f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), m.EagerKernels[i])
+			f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), displayName(m.EagerKernels[i]))
 			f.SetCellValue(sheetName, fmt.Sprintf("F%d", row), ".")
-			f.SetCellValue(sheetName, fmt.Sprintf("L%d", row), "removed")
-			f.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("L%d", row), styles.removed)
+			f.SetCellValue(sheetName, fmt.Sprintf("L%d", row), extraType)
+			f.SetCellValue(sheetName, fmt.Sprintf("%s%d", categoryCol, row), categorizeKernel(m.EagerKernels[i]))
+			f.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("L%d", row), extraStyle)
 			row++
 		}
 	}
 
 	// Add auto-filter and freeze
-	f.AutoFilter(sheetName, fmt.Sprintf("A1:L%d", row-1), nil)
+	f.AutoFilter(sheetName, fmt.Sprintf("A1:%s%d", lastCol, row-1), nil)
+	if hidden > 0 {
+		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row+1), fmt.Sprintf("(%d rows hidden: |change| < %g%%)", hidden, HideBelow))
+	}
 	f.SetPanes(sheetName, &excelize.Panes{
 		Freeze:      true,
 		Split:       false,
@@ -236,12 +456,15 @@ func (r *CompareResult) WriteCompareXLSX(filename string) error {
 	if err := writeComparisonToSheet(f, sheetName, r, styles); err != nil {
 		return err
 	}
+	if err := writeLegendSheet(f, styles, NoiseSigmas); err != nil {
+		return err
+	}
 
 	return f.SaveAs(filename)
 }
 
 // WriteMultiCompareXLSX writes multiple comparison results to a single Excel file
-// Each comparison is written to a separate sheet
+// Each comparison is written to a separate sheet, after a leading "Summary" sheet
 func WriteMultiCompareXLSX(filename string, comparisons []*CompareResult, sheetNames []string) error {
 	if len(comparisons) == 0 {
 		return fmt.Errorf("no comparisons to write")
@@ -255,16 +478,14 @@ func WriteMultiCompareXLSX(filename string, comparisons []*CompareResult, sheetN
 
 	styles := createStyles(f)
 
+	// Rename the default sheet to hold the overview
+	const summarySheet = "Summary"
+	f.SetSheetName("Sheet1", summarySheet)
+
 	for i, result := range comparisons {
 		sheetName := sheetNames[i]
-		if i == 0 {
-			// Rename the default sheet
-			f.SetSheetName("Sheet1", sheetName)
-		} else {
-			_, err := f.NewSheet(sheetName)
-			if err != nil {
-				return fmt.Errorf("failed to create sheet %s: %v", sheetName, err)
-			}
+		if _, err := f.NewSheet(sheetName); err != nil {
+			return fmt.Errorf("failed to create sheet %s: %v", sheetName, err)
 		}
 
 		if err := writeComparisonToSheet(f, sheetName, result, styles); err != nil {
@@ -272,10 +493,76 @@ func WriteMultiCompareXLSX(filename string, comparisons []*CompareResult, sheetN
 		}
 	}
 
-	// Set first sheet as active
-	if idx, err := f.GetSheetIndex(sheetNames[0]); err == nil {
+	writeSummarySheet(f, summarySheet, comparisons, sheetNames, styles)
+	if err := writeLegendSheet(f, styles, NoiseSigmas); err != nil {
+		return err
+	}
+
+	// Set summary sheet as active
+	if idx, err := f.GetSheetIndex(summarySheet); err == nil {
 		f.SetActiveSheet(idx)
 	}
 
 	return f.SaveAs(filename)
 }
+
+// writeSummarySheet lists one row per comparison (totals, overall change and match
+// counts), each linking to its sheet, so regressed cycles stand out at a glance
+func writeSummarySheet(f *excelize.File, sheetName string, comparisons []*CompareResult, sheetNames []string, styles xlsxStyles) {
+	headers := []string{
+		"Sheet", "Base Total (µs)", "New Total (µs)", "Change (%)",
+		"Exact", "Similar", "Removed", "New Only", "Base Memory (%)", "New Memory (%)",
+	}
+	for i, h := range headers {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		f.SetCellValue(sheetName, cell, h)
+	}
+	f.SetCellStyle(sheetName, "A1", "J1", styles.header)
+
+	for i, r := range comparisons {
+		row := i + 2
+		baselineTotal, newTotal := 0.0, 0.0
+		for _, m := range r.Matches {
+			baselineTotal += m.EagerDur
+			newTotal += m.CompiledDur
+		}
+
+		nameCell := fmt.Sprintf("A%d", row)
+		f.SetCellValue(sheetName, nameCell, sheetNames[i])
+		f.SetCellHyperLink(sheetName, nameCell, fmt.Sprintf("'%s'!A1", sheetNames[i]), "Location")
+		f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), baselineTotal)
+		f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), newTotal)
+
+		if baselineTotal > 0 && newTotal > 0 {
+			changeCell := fmt.Sprintf("D%d", row)
+			changePercent := (newTotal - baselineTotal) / baselineTotal * 100
+			f.SetCellValue(sheetName, changeCell, changePercent)
+			if changePercent < -ChangeThreshold {
+				f.SetCellStyle(sheetName, changeCell, changeCell, styles.improved)
+			} else if changePercent > ChangeThreshold {
+				f.SetCellStyle(sheetName, changeCell, changeCell, styles.regressed)
+			} else {
+				f.SetCellStyle(sheetName, changeCell, changeCell, styles.neutral)
+			}
+		}
+
+		f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), r.ExactCount)
+		f.SetCellValue(sheetName, fmt.Sprintf("F%d", row), r.SimilarCount)
+		f.SetCellValue(sheetName, fmt.Sprintf("G%d", row), r.RemovedCount)
+		f.SetCellValue(sheetName, fmt.Sprintf("H%d", row), r.NewOnlyCount)
+
+		// Memory-movement share of each side, to spot a compute/memory bottleneck shift
+		baselineMemory, newMemory := r.memoryTimes()
+		if baselineTotal > 0 {
+			f.SetCellValue(sheetName, fmt.Sprintf("I%d", row), percentOf(baselineMemory, baselineTotal))
+		}
+		if newTotal > 0 {
+			f.SetCellValue(sheetName, fmt.Sprintf("J%d", row), percentOf(newMemory, newTotal))
+		}
+	}
+
+	f.SetColWidth(sheetName, "A", "A", 30)
+	f.SetColWidth(sheetName, "B", "D", 15)
+	f.SetColWidth(sheetName, "E", "H", 10)
+	f.SetColWidth(sheetName, "I", "J", 16)
+}